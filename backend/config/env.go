@@ -1,41 +1,478 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Storage backends, see Environment.StorageBackend.
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// Environment is the configuration of the backend. It is loaded in layers: Default values,
+// then the YAML file named by CONFIG_FILE (if set), then environment variables; see LoadEnvironment.
+// YAML keys are given in tags, environment variables are listed in envVars.
 type Environment struct {
-	ServerAddress string
-	DBHost        string
-	DBPort        string
-	DBUser        string
-	DBPassword    string
-	DBName        string
-	OllamaHost    string
-	OllamaModel   string
-	MediaFsPath   string
-	MediaBaseUrl  string
-	RunningInCI   bool
-}
-
-func LoadEnvironment() Environment {
+	ServerAddress string `yaml:"server_address"`
+	// TrustedProxies are addresses or CIDR ranges of reverse proxies, whose X-Forwarded-For and X-Real-IP headers
+	// give the IP address of the client. Empty list trusts none, so the address of the connection is used.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// StorageBackend is either StoragePostgres (default) or StorageMemory.
+	// With StorageMemory, the server runs without a database and loses all data on exit.
+	StorageBackend string      `yaml:"storage_backend"`
+	Postgres       Postgres    `yaml:"postgres"`
+	Ollama         Ollama      `yaml:"ollama"`
+	Media          Media       `yaml:"media"`
+	RunningInCI    bool        `yaml:"ci"`
+	RequestsLog    RequestsLog `yaml:"requests_log"`
+	Logging        Logging     `yaml:"logging"`
+	// TracingExporter is where OpenTelemetry spans are sent: "otlp", "stdout" or "" to disable tracing.
+	TracingExporter string `yaml:"tracing_exporter"`
+	// AutoMigrate enables applying pending migrations on startup.
+	AutoMigrate bool     `yaml:"auto_migrate"`
+	Timeouts    Timeouts `yaml:"timeouts"`
+	// ShutdownTimeout is the grace period for in-flight requests and background tasks on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// SettingsRefreshInterval is how often settings are reloaded from the database, in addition to reloading
+	// on notifications about changes made by other instances. Zero value disables periodic reloading.
+	SettingsRefreshInterval time.Duration `yaml:"settings_refresh_interval"`
+	Ads                     Ads           `yaml:"ads"`
+}
+
+type Postgres struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	// MaxOpenConns limits the connections of the pool. Zero value means no limit.
+	MaxOpenConns int `yaml:"max_open_conns"`
+	// MaxIdleConns is how many connections are kept open between requests.
+	MaxIdleConns int `yaml:"max_idle_conns"`
+	// ConnMaxLifetime and ConnMaxIdleTime close connections which are too old or unused for too long,
+	// so that the load is spread after failovers and restarts of the database. Zero value means no limit.
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// SslMode is one of SslModes. Certificates are paths to PEM files, SslCert and SslKey enable client authentication.
+	SslMode     string `yaml:"ssl_mode"`
+	SslRootCert string `yaml:"ssl_root_cert"`
+	SslCert     string `yaml:"ssl_cert"`
+	SslKey      string `yaml:"ssl_key"`
+	// ReplicaHost is the address of a read replica, which serves read-only queries of GET requests.
+	// It is accessed with the same credentials and TLS settings. Empty value disables routing to a replica.
+	ReplicaHost string `yaml:"replica_host"`
+	// ReplicaPort defaults to Port.
+	ReplicaPort int `yaml:"replica_port"`
+}
+
+// SslModes are the supported values of Postgres.SslMode, see https://www.postgresql.org/docs/current/libpq-ssl.html.
+var SslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+type Ollama struct {
+	Host  string `yaml:"host"`
+	Model string `yaml:"model"`
+	// SuggestionsQueueSize and QueueSize are capacities of queues of AI tasks: of suggestions of ad texts,
+	// which users wait for, and of the other tasks. When a queue is full, submitting a task blocks in background.
+	SuggestionsQueueSize int `yaml:"suggestions_queue_size"`
+	QueueSize            int `yaml:"queue_size"`
+}
+
+type Media struct {
+	// FsPath is the directory where uploaded images are stored. It must exist.
+	FsPath string `yaml:"fs_path"`
+	// BaseUrl is the prefix of image URLs returned by the API.
+	BaseUrl string `yaml:"base_url"`
+	// MaxUploadSize is the limit of the size of uploaded images, in bytes.
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+}
+
+// RequestsLog configures writing API requests to the api_requests table, in addition to the metrics exposed on /metrics.
+type RequestsLog struct {
+	Enabled bool `yaml:"enabled"`
+	// SampleRate is the share of requests written, from 0 to 1. Requests with errors are always written.
+	SampleRate float64 `yaml:"sample_rate"`
+	// Retention is how long requests are kept. Zero value keeps them forever.
+	Retention time.Duration `yaml:"retention"`
+	// QueueSize is the capacity of the queue of requests waiting to be written. When it is full, requests are dropped.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// Logging configures the minimum levels of log records.
+type Logging struct {
+	// Level applies to subsystems not listed in Subsystems.
+	Level slog.Level `yaml:"level"`
+	// Subsystems overrides Level for some subsystems, like "ads" or "ai".
+	Subsystems map[string]slog.Level `yaml:"subsystems"`
+}
+
+// Timeouts are deadlines of request handling for groups of endpoints. Zero value means no deadline.
+type Timeouts struct {
+	// Default applies to endpoints not covered by other fields.
+	Default time.Duration `yaml:"default"`
+	// Ads applies to /ads endpoints (ad serving and clicks).
+	Ads time.Duration `yaml:"ads"`
+	// Stats applies to /stats endpoints.
+	Stats time.Duration `yaml:"stats"`
+	// Upload applies to campaign image endpoints.
+	Upload time.Duration `yaml:"upload"`
+}
+
+// Ads configures ad serving.
+type Ads struct {
+	// LimitsThreshold is how much campaigns may exceed their impressions and clicks limits, as a share of the limit
+	// (1.04 allows 4% more impressions). Campaigns over the threshold are not shown.
+	LimitsThreshold float64 `yaml:"limits_threshold"`
+	Ranking         Ranking `yaml:"ranking"`
+	// CacheTtl is how long active campaigns and data of clients, which ad candidates are selected from,
+	// are cached in memory. Writes made through other instances become visible after it at most.
+	// Zero value disables the cache.
+	CacheTtl time.Duration `yaml:"cache_ttl"`
+	// ClickAttributionWindow is how many days after the impression a click is accepted; 0 accepts only clicks
+	// made on the day of the impression. Later clicks are rejected and are not charged.
+	ClickAttributionWindow int            `yaml:"click_attribution_window"`
+	InvalidTraffic         InvalidTraffic `yaml:"invalid_traffic"`
+}
+
+// InvalidTraffic configures detection of clicks made by bots or click farms. Flagged clicks are recorded
+// as non-billable. Clicks are counted over all instances, as they are read from the database.
+type InvalidTraffic struct {
+	Enabled bool `yaml:"enabled"`
+	// MinClickDelay is the least time between an impression and its click which a human needs.
+	MinClickDelay time.Duration `yaml:"min_click_delay"`
+	// Window is the period of recent clicks which rates and sources are checked over.
+	Window time.Duration `yaml:"window"`
+	// History is the period, including Window, which the usual click rate of a client is computed over.
+	History time.Duration `yaml:"history"`
+	// RateFactor is how many times the clicks of a client in Window may exceed its usual number in such a period.
+	RateFactor float64 `yaml:"rate_factor"`
+	// RateMinClicks is the least number of clicks in Window which is flagged by rate, so that a few clicks
+	// of clients without history, whose usual rate is zero, are accepted.
+	RateMinClicks int `yaml:"rate_min_clicks"`
+	// MaxClientsPerSource is how many clients may click from one IP address in Window.
+	MaxClientsPerSource int `yaml:"max_clients_per_source"`
+}
+
+// Ranking is the relative weights of criteria of choosing an ad among candidates.
+// Only their ratio matters, as they are normalized by their sum.
+type Ranking struct {
+	// ImpressionRevenue favors ads with higher cost per impression which the client has not seen yet.
+	ImpressionRevenue float64 `yaml:"impression_revenue"`
+	// ClickRevenue favors ads with higher cost per click which the client has not clicked yet.
+	ClickRevenue float64 `yaml:"click_revenue"`
+	// MlScore favors ads of advertisers with higher ML score for the client.
+	MlScore float64 `yaml:"ml_score"`
+}
+
+var DefaultRequestsLog = RequestsLog{
+	SampleRate: 1,
+	Retention:  7 * 24 * time.Hour,
+	QueueSize:  5000,
+}
+
+var DefaultTimeouts = Timeouts{
+	Default: 10 * time.Second,
+	Ads:     3 * time.Second,
+	Stats:   30 * time.Second,
+	Upload:  60 * time.Second,
+}
+
+var DefaultAds = Ads{
+	LimitsThreshold: 1.04,
+	Ranking:         Ranking{ImpressionRevenue: 0.25, ClickRevenue: 0.25, MlScore: 0.25},
+	CacheTtl:        2 * time.Second,
+
+	ClickAttributionWindow: 7,
+	InvalidTraffic: InvalidTraffic{
+		Enabled:             true,
+		MinClickDelay:       time.Second,
+		Window:              time.Minute,
+		History:             24 * time.Hour,
+		RateFactor:          5,
+		RateMinClicks:       5,
+		MaxClientsPerSource: 20,
+	},
+}
+
+const (
+	DefaultShutdownTimeout         = 15 * time.Second
+	DefaultSettingsRefreshInterval = 30 * time.Second
+)
+
+// Default returns the configuration used for settings which are not set in the file or environment.
+func Default() Environment {
 	return Environment{
-		ServerAddress: os.Getenv("SERVER_ADDRESS"),
-		DBHost:        os.Getenv("POSTGRES_HOST"),
-		DBPort:        os.Getenv("POSTGRES_PORT"),
-		DBUser:        os.Getenv("POSTGRES_USERNAME"),
-		DBPassword:    os.Getenv("POSTGRES_PASSWORD"),
-		DBName:        os.Getenv("POSTGRES_DATABASE"),
-		OllamaHost:    os.Getenv("OLLAMA_HOST"),
-		OllamaModel:   os.Getenv("OLLAMA_MODEL"),
-		MediaFsPath:   os.Getenv("MEDIA_FS_PATH"),
-		MediaBaseUrl:  os.Getenv("MEDIA_BASE_URL"),
-		RunningInCI:   os.Getenv("CI") == "true",
+		ServerAddress:  "0.0.0.0:8080",
+		StorageBackend: StoragePostgres,
+		Postgres: Postgres{
+			Host:         "localhost",
+			Port:         5432,
+			User:         "postgres",
+			Database:     "postgres",
+			MaxOpenConns: 20,
+			MaxIdleConns: 10,
+
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			SslMode:         "disable",
+		},
+		Ollama: Ollama{
+			Host:                 "http://localhost:11434",
+			Model:                "gemma2:2b",
+			SuggestionsQueueSize: 1000,
+			QueueSize:            5000,
+		},
+		Media: Media{
+			BaseUrl:       "/media",
+			MaxUploadSize: 5 << 20,
+		},
+		RequestsLog: DefaultRequestsLog,
+		Logging:     Logging{Level: slog.LevelInfo},
+		AutoMigrate: true,
+		Timeouts:    DefaultTimeouts,
+
+		ShutdownTimeout:         DefaultShutdownTimeout,
+		SettingsRefreshInterval: DefaultSettingsRefreshInterval,
+		Ads:                     DefaultAds,
 	}
 }
 
+// envVars maps environment variables to the settings they override.
+func (c *Environment) envVars() map[string]any {
+	return map[string]any{
+		"SERVER_ADDRESS":          &c.ServerAddress,
+		"STORAGE_BACKEND":         &c.StorageBackend,
+		"POSTGRES_HOST":           &c.Postgres.Host,
+		"POSTGRES_PORT":           &c.Postgres.Port,
+		"POSTGRES_USERNAME":       &c.Postgres.User,
+		"POSTGRES_PASSWORD":       &c.Postgres.Password,
+		"POSTGRES_DATABASE":       &c.Postgres.Database,
+		"POSTGRES_MAX_OPEN_CONNS": &c.Postgres.MaxOpenConns,
+		"POSTGRES_MAX_IDLE_CONNS": &c.Postgres.MaxIdleConns,
+
+		"POSTGRES_CONN_MAX_LIFETIME":  &c.Postgres.ConnMaxLifetime,
+		"POSTGRES_CONN_MAX_IDLE_TIME": &c.Postgres.ConnMaxIdleTime,
+		"POSTGRES_SSL_MODE":           &c.Postgres.SslMode,
+		"POSTGRES_SSL_ROOT_CERT":      &c.Postgres.SslRootCert,
+		"POSTGRES_SSL_CERT":           &c.Postgres.SslCert,
+		"POSTGRES_SSL_KEY":            &c.Postgres.SslKey,
+		"POSTGRES_REPLICA_HOST":       &c.Postgres.ReplicaHost,
+		"POSTGRES_REPLICA_PORT":       &c.Postgres.ReplicaPort,
+
+		"OLLAMA_HOST":                   &c.Ollama.Host,
+		"OLLAMA_MODEL":                  &c.Ollama.Model,
+		"OLLAMA_SUGGESTIONS_QUEUE_SIZE": &c.Ollama.SuggestionsQueueSize,
+		"OLLAMA_QUEUE_SIZE":             &c.Ollama.QueueSize,
+
+		"MEDIA_FS_PATH":         &c.Media.FsPath,
+		"MEDIA_BASE_URL":        &c.Media.BaseUrl,
+		"MEDIA_MAX_UPLOAD_SIZE": &c.Media.MaxUploadSize,
+
+		"TRUSTED_PROXIES":          &c.TrustedProxies,
+		"CI":                       &c.RunningInCI,
+		"REQUESTS_LOG":             &c.RequestsLog.Enabled,
+		"REQUESTS_LOG_SAMPLE_RATE": &c.RequestsLog.SampleRate,
+		"REQUESTS_LOG_RETENTION":   &c.RequestsLog.Retention,
+		"REQUESTS_LOG_QUEUE_SIZE":  &c.RequestsLog.QueueSize,
+		"LOG_LEVEL":                &c.Logging.Level,
+		"LOG_LEVELS":               &c.Logging.Subsystems,
+		"TRACING_EXPORTER":         &c.TracingExporter,
+		"AUTO_MIGRATE":             &c.AutoMigrate,
+
+		"REQUEST_TIMEOUT":           &c.Timeouts.Default,
+		"ADS_REQUEST_TIMEOUT":       &c.Timeouts.Ads,
+		"STATS_REQUEST_TIMEOUT":     &c.Timeouts.Stats,
+		"UPLOAD_REQUEST_TIMEOUT":    &c.Timeouts.Upload,
+		"SHUTDOWN_TIMEOUT":          &c.ShutdownTimeout,
+		"SETTINGS_REFRESH_INTERVAL": &c.SettingsRefreshInterval,
+
+		"ADS_LIMITS_THRESHOLD":          &c.Ads.LimitsThreshold,
+		"ADS_RANKING_IMPRESSION_WEIGHT": &c.Ads.Ranking.ImpressionRevenue,
+		"ADS_RANKING_CLICK_WEIGHT":      &c.Ads.Ranking.ClickRevenue,
+		"ADS_RANKING_ML_SCORE_WEIGHT":   &c.Ads.Ranking.MlScore,
+		"ADS_CACHE_TTL":                 &c.Ads.CacheTtl,
+		"ADS_CLICK_ATTRIBUTION_WINDOW":  &c.Ads.ClickAttributionWindow,
+
+		"INVALID_TRAFFIC":                        &c.Ads.InvalidTraffic.Enabled,
+		"INVALID_TRAFFIC_MIN_CLICK_DELAY":        &c.Ads.InvalidTraffic.MinClickDelay,
+		"INVALID_TRAFFIC_WINDOW":                 &c.Ads.InvalidTraffic.Window,
+		"INVALID_TRAFFIC_HISTORY":                &c.Ads.InvalidTraffic.History,
+		"INVALID_TRAFFIC_RATE_FACTOR":            &c.Ads.InvalidTraffic.RateFactor,
+		"INVALID_TRAFFIC_RATE_MIN_CLICKS":        &c.Ads.InvalidTraffic.RateMinClicks,
+		"INVALID_TRAFFIC_MAX_CLIENTS_PER_SOURCE": &c.Ads.InvalidTraffic.MaxClientsPerSource,
+	}
+}
+
+// LoadEnvironment loads the configuration: Default, overridden by the YAML file named by CONFIG_FILE,
+// overridden by environment variables which are set to non-empty values. The result is validated.
+func LoadEnvironment() (Environment, error) {
+	env := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := env.loadFile(path); err != nil {
+			return Environment{}, fmt.Errorf("load %s: %w", path, err)
+		}
+	}
+	for name, dst := range env.envVars() {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := parseValue(value, dst); err != nil {
+			return Environment{}, fmt.Errorf("parse %s: %w", name, err)
+		}
+	}
+	if err := env.Validate(); err != nil {
+		return Environment{}, err
+	}
+	return env, nil
+}
+
+// loadFile overrides settings present in the YAML file. Unknown keys are errors, so that typos are not ignored.
+func (c *Environment) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// parseBool accepts the values of strconv.ParseBool and also yes/no and on/off in any case,
+// as CI systems and docker-compose files set flags like CI=yes.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func parseValue(value string, dst any) error {
+	var err error
+	switch dst := dst.(type) {
+	case *string:
+		*dst = value
+	case *bool:
+		*dst, err = parseBool(value)
+	case *int:
+		*dst, err = strconv.Atoi(value)
+	case *int64:
+		*dst, err = strconv.ParseInt(value, 10, 64)
+	case *float64:
+		*dst, err = strconv.ParseFloat(value, 64)
+	case *time.Duration:
+		// in format of time.ParseDuration, like "500ms" or "1m"
+		*dst, err = time.ParseDuration(value)
+	case *slog.Level:
+		err = dst.UnmarshalText([]byte(value))
+	case *[]string:
+		// comma-separated, like "10.0.0.0/8,192.168.0.1"
+		*dst = strings.Split(value, ",")
+		for i := range *dst {
+			(*dst)[i] = strings.TrimSpace((*dst)[i])
+		}
+	case *map[string]slog.Level:
+		*dst, err = parseLogLevels(value)
+	default:
+		panic(fmt.Sprintf("unsupported type %T", dst))
+	}
+	return err
+}
+
+// parseLogLevels parses levels of subsystems in format "ads=debug,ai=warn".
+func parseLogLevels(value string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, item := range strings.Split(value, ",") {
+		subsystem, level, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || subsystem == "" {
+			return nil, fmt.Errorf("%q must be in format subsystem=level", item)
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("subsystem %s: %w", subsystem, err)
+		}
+		levels[subsystem] = l
+	}
+	return levels, nil
+}
+
+// Redacted returns a copy of the configuration with secrets replaced, safe to be printed or logged.
+func (c Environment) Redacted() Environment {
+	if c.Postgres.Password != "" {
+		c.Postgres.Password = "REDACTED"
+	}
+	return c
+}
+
+// YAML formats the configuration in the format of the configuration file.
+func (c Environment) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// BuildDsn returns the connection string of the primary database.
 func (c Environment) BuildDsn() string {
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		c.DBHost, c.DBPort, c.DBUser, c.DBPassword, c.DBName)
+	return c.Postgres.dsn(c.Postgres.Host, c.Postgres.Port)
+}
+
+// BuildReplicaDsn returns the connection string of the read replica, or "" if it is not configured.
+func (c Environment) BuildReplicaDsn() string {
+	if c.Postgres.ReplicaHost == "" {
+		return ""
+	}
+	port := c.Postgres.ReplicaPort
+	if port == 0 {
+		port = c.Postgres.Port
+	}
+	return c.Postgres.dsn(c.Postgres.ReplicaHost, port)
+}
+
+func (p Postgres) dsn(host string, port int) string {
+	sslMode := p.SslMode
+	if sslMode == "" {
+		// lib/pq would default to "require"
+		sslMode = "disable"
+	}
+	params := []struct{ key, value string }{
+		{"host", host},
+		{"port", strconv.Itoa(port)},
+		{"user", p.User},
+		{"password", p.Password},
+		{"dbname", p.Database},
+		{"sslmode", sslMode},
+		{"sslrootcert", p.SslRootCert},
+		{"sslcert", p.SslCert},
+		{"sslkey", p.SslKey},
+	}
+	var parts []string
+	for _, param := range params {
+		if param.value == "" && strings.HasPrefix(param.key, "ssl") {
+			continue
+		}
+		parts = append(parts, param.key+"="+quoteDsnValue(param.value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteDsnValue quotes values which are empty or contain spaces, quotes or backslashes,
+// as required by the key=value format of connection strings.
+func quoteDsnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
 }
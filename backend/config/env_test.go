@@ -1,36 +1,287 @@
 package config
 
 import (
+	"log/slog"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
+// setRequired sets the environment variables which have no defaults.
+func setRequired(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("MEDIA_FS_PATH", dir)
+	return dir
+}
+
 func TestEnvironment_BuildDsn(t *testing.T) {
 	env := Environment{
 		ServerAddress: "srv",
-		DBHost:        "host",
-		DBPort:        "port",
-		DBUser:        "user",
-		DBPassword:    "pass",
-		DBName:        "name",
+		Postgres: Postgres{
+			Host:     "host",
+			Port:     5433,
+			User:     "user",
+			Password: "pass",
+			Database: "name",
+		},
 	}
-	want := "host=host port=port user=user password=pass dbname=name sslmode=disable"
+	want := "host=host port=5433 user=user password=pass dbname=name sslmode=disable"
 	if got := env.BuildDsn(); got != want {
 		t.Errorf("BuildDsn() = %v, want %v", got, want)
 	}
 }
 
+func TestEnvironment_BuildDsn_TLS(t *testing.T) {
+	env := Default()
+	env.Postgres.Password = "p@ss word's"
+	env.Postgres.SslMode = "verify-full"
+	env.Postgres.SslRootCert = "/certs/ca.pem"
+	want := `host=localhost port=5432 user=postgres password='p@ss word\'s' dbname=postgres sslmode=verify-full sslrootcert=/certs/ca.pem`
+	if got := env.BuildDsn(); got != want {
+		t.Errorf("BuildDsn() = %v, want %v", got, want)
+	}
+}
+
+func TestEnvironment_BuildReplicaDsn(t *testing.T) {
+	env := Default()
+	if got := env.BuildReplicaDsn(); got != "" {
+		t.Errorf("BuildReplicaDsn() without replica = %v, want empty", got)
+	}
+
+	env.Postgres.ReplicaHost = "replica"
+	want := "host=replica port=5432 user=postgres password='' dbname=postgres sslmode=disable"
+	if got := env.BuildReplicaDsn(); got != want {
+		t.Errorf("BuildReplicaDsn() = %v, want %v", got, want)
+	}
+	env.Postgres.ReplicaPort = 5433
+	if got := env.BuildReplicaDsn(); !strings.Contains(got, "port=5433") {
+		t.Errorf("BuildReplicaDsn() = %v, want port 5433", got)
+	}
+}
+
 func TestLoadEnvironment(t *testing.T) {
-	_ = os.Setenv("SERVER_ADDRESS", "srv")
-	_ = os.Setenv("POSTGRES_HOST", "host")
-	_ = os.Setenv("CI", "true")
-	want := Environment{
-		ServerAddress: "srv",
-		DBHost:        "host",
-		RunningInCI:   true,
+	dir := setRequired(t)
+	t.Setenv("SERVER_ADDRESS", "srv")
+	t.Setenv("POSTGRES_HOST", "host")
+	t.Setenv("CI", "yes")
+	t.Setenv("ADS_REQUEST_TIMEOUT", "500ms")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.0.1")
+	want := Default()
+	want.ServerAddress = "srv"
+	want.Postgres.Host = "host"
+	want.RunningInCI = true
+	want.Media.FsPath = dir
+	want.Timeouts.Ads = 500 * time.Millisecond
+	want.TrustedProxies = []string{"10.0.0.0/8", "192.168.0.1"}
+	if got, err := LoadEnvironment(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadEnvironment() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestLoadEnvironment_File(t *testing.T) {
+	setRequired(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+server_address: 127.0.0.1:9000
+postgres:
+  host: db
+  max_open_conns: 50
+timeouts:
+  stats: 1m
+logging:
+  level: debug
+  subsystems:
+    ai: warn
+ads:
+  limits_threshold: 1.1
+  ranking:
+    ml_score: 0.5
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	// environment overrides the file
+	t.Setenv("POSTGRES_HOST", "env-db")
+
+	got, err := LoadEnvironment()
+	if err != nil {
+		t.Fatalf("LoadEnvironment() error = %v", err)
+	}
+	want := Default()
+	want.ServerAddress = "127.0.0.1:9000"
+	want.Postgres.Host = "env-db"
+	want.Postgres.MaxOpenConns = 50
+	want.Media.FsPath = got.Media.FsPath
+	want.Timeouts.Stats = time.Minute
+	want.Logging = Logging{Level: slog.LevelDebug, Subsystems: map[string]slog.Level{"ai": slog.LevelWarn}}
+	want.Ads.LimitsThreshold = 1.1
+	want.Ads.Ranking.MlScore = 0.5
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadEnvironment() = %+v, want %+v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("postgres:\n  hots: db\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadEnvironment(); err == nil {
+		t.Errorf("LoadEnvironment() with unknown key in file: expected error")
+	}
+}
+
+func TestLoadEnvironment_StorageBackend(t *testing.T) {
+	setRequired(t)
+	t.Setenv("STORAGE_BACKEND", StorageMemory)
+	if got, err := LoadEnvironment(); err != nil || got.StorageBackend != StorageMemory {
+		t.Errorf("LoadEnvironment() = %v, %v, want memory storage backend", got.StorageBackend, err)
+	}
+
+	t.Setenv("STORAGE_BACKEND", "sqlite")
+	if _, err := LoadEnvironment(); err == nil {
+		t.Errorf("LoadEnvironment() with unknown storage backend: expected error")
+	}
+}
+
+func TestLoadEnvironment_TracingExporter(t *testing.T) {
+	setRequired(t)
+	t.Setenv("TRACING_EXPORTER", "otlp")
+	if got, err := LoadEnvironment(); err != nil || got.TracingExporter != "otlp" {
+		t.Errorf("LoadEnvironment() = %v, %v, want otlp tracing exporter", got.TracingExporter, err)
+	}
+
+	t.Setenv("TRACING_EXPORTER", "jaeger")
+	if _, err := LoadEnvironment(); err == nil {
+		t.Errorf("LoadEnvironment() with unknown tracing exporter: expected error")
+	}
+}
+
+func TestLoadEnvironment_InvalidTimeout(t *testing.T) {
+	setRequired(t)
+	for _, value := range []string{"10", "-1s"} {
+		t.Setenv("STATS_REQUEST_TIMEOUT", value)
+		if _, err := LoadEnvironment(); err == nil {
+			t.Errorf("LoadEnvironment() with STATS_REQUEST_TIMEOUT=%s: expected error", value)
+		}
+	}
+}
+
+func TestLoadEnvironment_RequestsLog(t *testing.T) {
+	setRequired(t)
+	t.Setenv("REQUESTS_LOG", "true")
+	t.Setenv("REQUESTS_LOG_SAMPLE_RATE", "0.25")
+	t.Setenv("REQUESTS_LOG_RETENTION", "0")
+	want := RequestsLog{Enabled: true, SampleRate: 0.25, QueueSize: DefaultRequestsLog.QueueSize}
+	if got, err := LoadEnvironment(); err != nil || got.RequestsLog != want {
+		t.Errorf("LoadEnvironment() = %v, %v, want %v", got.RequestsLog, err, want)
+	}
+
+	for _, value := range []string{"half", "1.5", "-0.1"} {
+		t.Setenv("REQUESTS_LOG_SAMPLE_RATE", value)
+		if _, err := LoadEnvironment(); err == nil {
+			t.Errorf("LoadEnvironment() with REQUESTS_LOG_SAMPLE_RATE=%s: expected error", value)
+		}
+	}
+}
+
+func TestLoadEnvironment_Logging(t *testing.T) {
+	setRequired(t)
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_LEVELS", "ads=debug, ai=ERROR")
+	got, err := LoadEnvironment()
+	want := Logging{Level: slog.LevelWarn, Subsystems: map[string]slog.Level{"ads": slog.LevelDebug, "ai": slog.LevelError}}
+	if err != nil || !reflect.DeepEqual(got.Logging, want) {
+		t.Errorf("LoadEnvironment() = %v, %v, want %v", got.Logging, err, want)
+	}
+
+	for _, value := range []string{"debug", "ads=", "=debug", "ads=verbose"} {
+		t.Setenv("LOG_LEVELS", value)
+		if _, err := LoadEnvironment(); err == nil {
+			t.Errorf("LoadEnvironment() with LOG_LEVELS=%s: expected error", value)
+		}
+	}
+}
+
+func TestEnvironment_Validate(t *testing.T) {
+	valid := Default()
+	valid.Media.FsPath = t.TempDir()
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate() of valid config = %v", err)
+	}
+
+	type testCase struct {
+		name   string
+		modify func(c *Environment)
+		want   string
+	}
+	tests := []testCase{
+		{"media path missing", func(c *Environment) { c.Media.FsPath = "" }, "media.fs_path: must be set"},
+		{"media path not a directory", func(c *Environment) { c.Media.FsPath = filepath.Join(c.Media.FsPath, "missing") }, "media.fs_path"},
+		{"port", func(c *Environment) { c.Postgres.Port = 0 }, "postgres.port"},
+		{"queue size", func(c *Environment) { c.Ollama.QueueSize = 0 }, "ollama.queue_size"},
+		{"upload size", func(c *Environment) { c.Media.MaxUploadSize = -1 }, "media.max_upload_size"},
+		{"limits threshold", func(c *Environment) { c.Ads.LimitsThreshold = 0 }, "ads.limits_threshold"},
+		{"negative weight", func(c *Environment) { c.Ads.Ranking.MlScore = -1 }, "ads.ranking.ml_score"},
+		{"negative attribution window", func(c *Environment) { c.Ads.ClickAttributionWindow = -1 }, "ads.click_attribution_window"},
+		{"invalid traffic history", func(c *Environment) { c.Ads.InvalidTraffic.History = time.Minute }, "ads.invalid_traffic.history"},
+		{"trusted proxy", func(c *Environment) { c.TrustedProxies = []string{"nginx"} }, "trusted_proxies"},
+		{"ssl mode", func(c *Environment) { c.Postgres.SslMode = "prefer" }, "postgres.ssl_mode"},
+		{"ssl cert without key", func(c *Environment) { c.Postgres.SslCert = "client.pem" }, "postgres.ssl_cert"},
+		{"conn lifetime", func(c *Environment) { c.Postgres.ConnMaxLifetime = -time.Second }, "postgres.conn_max_lifetime"},
+		{"zero weights", func(c *Environment) { c.Ads.Ranking = Ranking{} }, "ads.ranking: at least one weight must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			err := c.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// all problems are reported at once
+	c := valid
+	c.Postgres.Host, c.Postgres.User = "", ""
+	if err := c.Validate(); err == nil || strings.Count(err.Error(), "must be set") != 2 {
+		t.Errorf("Validate() = %v, want 2 errors", err)
+	}
+	// database settings are not needed without database
+	c.StorageBackend = StorageMemory
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() with memory storage = %v", err)
+	}
+}
+
+func TestEnvironment_Redacted(t *testing.T) {
+	env := Default()
+	env.Postgres.Password = "secret"
+	out, err := env.Redacted().YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "secret") || !strings.Contains(string(out), "password: REDACTED") {
+		t.Errorf("YAML() of redacted config = %s", out)
+	}
+	if env.Postgres.Password != "secret" {
+		t.Errorf("Redacted() modified the original config")
+	}
+	if !strings.Contains(string(out), "shutdown_timeout: 15s") {
+		t.Errorf("YAML() = %s, want durations in format of time.Duration", out)
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for value, want := range map[string]bool{"true": true, "1": true, "TRUE": true, "yes": true, "Yes": true, "on": true,
+		"false": false, "0": false, "no": false, "OFF": false} {
+		if got, err := parseBool(value); err != nil || got != want {
+			t.Errorf("parseBool(%q) = %v, %v, want %v", value, got, err, want)
+		}
 	}
-	if got := LoadEnvironment(); !reflect.DeepEqual(got, want) {
-		t.Errorf("LoadEnvironment() = %v, want %v", got, want)
+	if _, err := parseBool("maybe"); err == nil {
+		t.Errorf("parseBool(%q) error = nil, want error", "maybe")
 	}
 }
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Validate checks the configuration, so that mistakes are reported on startup instead of when
+// the setting is first used. All found problems are returned joined.
+func (c Environment) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.ServerAddress != "", "server_address", "must be set")
+	check(c.StorageBackend == StoragePostgres || c.StorageBackend == StorageMemory,
+		"storage_backend", "must be one of: %s %s, got %q", StoragePostgres, StorageMemory, c.StorageBackend)
+	if c.StorageBackend == StoragePostgres {
+		check(c.Postgres.Host != "", "postgres.host", "must be set")
+		check(c.Postgres.Port > 0 && c.Postgres.Port < 65536, "postgres.port", "must be from 1 to 65535")
+		check(c.Postgres.User != "", "postgres.user", "must be set")
+		check(c.Postgres.Database != "", "postgres.database", "must be set")
+	}
+	check(c.Postgres.MaxOpenConns >= 0, "postgres.max_open_conns", "must not be negative")
+	check(c.Postgres.MaxIdleConns >= 0, "postgres.max_idle_conns", "must not be negative")
+	check(slices.Contains(SslModes, c.Postgres.SslMode),
+		"postgres.ssl_mode", "must be one of: %s, got %q", strings.Join(SslModes, " "), c.Postgres.SslMode)
+	check((c.Postgres.SslCert == "") == (c.Postgres.SslKey == ""), "postgres.ssl_cert", "must be set together with postgres.ssl_key")
+	check(c.Postgres.ReplicaPort >= 0 && c.Postgres.ReplicaPort < 65536, "postgres.replica_port", "must be from 0 to 65535")
+
+	if !c.RunningInCI {
+		check(c.Ollama.Host != "", "ollama.host", "must be set")
+		check(c.Ollama.Model != "", "ollama.model", "must be set")
+	}
+	check(c.Ollama.SuggestionsQueueSize > 0, "ollama.suggestions_queue_size", "must be positive")
+	check(c.Ollama.QueueSize > 0, "ollama.queue_size", "must be positive")
+
+	if c.Media.FsPath == "" {
+		check(false, "media.fs_path", "must be set")
+	} else if info, err := os.Stat(c.Media.FsPath); err != nil || !info.IsDir() {
+		check(false, "media.fs_path", "%s must be an existing directory", c.Media.FsPath)
+	}
+	check(c.Media.MaxUploadSize > 0, "media.max_upload_size", "must be positive")
+
+	check(c.RequestsLog.SampleRate >= 0 && c.RequestsLog.SampleRate <= 1, "requests_log.sample_rate", "must be from 0 to 1")
+	check(c.RequestsLog.QueueSize > 0, "requests_log.queue_size", "must be positive")
+	check(c.TracingExporter == "" || c.TracingExporter == "otlp" || c.TracingExporter == "stdout",
+		"tracing_exporter", "must be one of: otlp stdout, got %q", c.TracingExporter)
+
+	durations := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"postgres.conn_max_lifetime", c.Postgres.ConnMaxLifetime},
+		{"postgres.conn_max_idle_time", c.Postgres.ConnMaxIdleTime},
+		{"timeouts.default", c.Timeouts.Default},
+		{"timeouts.ads", c.Timeouts.Ads},
+		{"timeouts.stats", c.Timeouts.Stats},
+		{"timeouts.upload", c.Timeouts.Upload},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"settings_refresh_interval", c.SettingsRefreshInterval},
+		{"requests_log.retention", c.RequestsLog.Retention},
+		{"ads.cache_ttl", c.Ads.CacheTtl},
+		{"ads.invalid_traffic.min_click_delay", c.Ads.InvalidTraffic.MinClickDelay},
+	}
+	for _, d := range durations {
+		check(d.d >= 0, d.key, "duration must not be negative")
+	}
+
+	check(c.Ads.LimitsThreshold > 0, "ads.limits_threshold", "must be positive")
+	check(c.Ads.ClickAttributionWindow >= 0, "ads.click_attribution_window", "must not be negative")
+	if traffic := c.Ads.InvalidTraffic; traffic.Enabled {
+		check(traffic.Window > 0, "ads.invalid_traffic.window", "must be positive")
+		check(traffic.History > traffic.Window, "ads.invalid_traffic.history", "must be longer than window")
+		check(traffic.RateFactor > 0, "ads.invalid_traffic.rate_factor", "must be positive")
+		check(traffic.RateMinClicks > 0, "ads.invalid_traffic.rate_min_clicks", "must be positive")
+		check(traffic.MaxClientsPerSource > 0, "ads.invalid_traffic.max_clients_per_source", "must be positive")
+	}
+	for _, proxy := range c.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "trusted_proxies", "%q is neither an IP address nor a CIDR range", proxy)
+	}
+	ranking := c.Ads.Ranking
+	check(ranking.ImpressionRevenue >= 0, "ads.ranking.impression_revenue", "must not be negative")
+	check(ranking.ClickRevenue >= 0, "ads.ranking.click_revenue", "must not be negative")
+	check(ranking.MlScore >= 0, "ads.ranking.ml_score", "must not be negative")
+	check(ranking.ImpressionRevenue+ranking.ClickRevenue+ranking.MlScore > 0, "ads.ranking", "at least one weight must be positive")
+
+	return errors.Join(errs...)
+}
@@ -43,13 +43,13 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -86,13 +86,13 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -100,6 +100,7 @@ const docTemplate = `{
         },
         "/ads/{adId}/click": {
             "post": {
+                "description": "The click is charged if the client has viewed the ad, the campaign is active, the impression was made\nnot more than ADS_CLICK_ATTRIBUTION_WINDOW days ago and the clicks limit is not reached.\nOtherwise 409 is returned with the reason in code, and the click is counted in stats as non-billable.\nClicks flagged as invalid traffic are answered with 204, but are not charged either; see\nGET /stats/campaigns/{campaignId}/invalid-traffic.",
                 "produces": [
                     "application/json"
                 ],
@@ -132,19 +133,19 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "409": {
                         "description": "Conflict",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -186,7 +187,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -220,13 +221,13 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -234,8 +235,11 @@ const docTemplate = `{
         },
         "/advertisers/{advertiserId}/campaigns": {
             "get": {
+                "description": "Exported as CSV or XLSX without size and page, all campaigns are returned.",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
                     "Campaigns"
@@ -249,6 +253,17 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
                         "description": "size",
@@ -275,7 +290,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -311,18 +326,24 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/model.Campaign"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "version of the campaign"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "409": {
                         "description": "Conflict",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -330,6 +351,7 @@ const docTemplate = `{
         },
         "/advertisers/{advertiserId}/campaigns/{campaignId}": {
             "get": {
+                "description": "ETag header contains the version of the campaign, which is required to update or delete it",
                 "produces": [
                     "application/json"
                 ],
@@ -358,17 +380,24 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/model.Campaign"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "version of the campaign"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             },
             "put": {
+                "description": "If-Match header must contain ETag of the campaign (or \"*\" to skip the check)",
                 "produces": [
                     "application/json"
                 ],
@@ -391,6 +420,13 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "ETag of the campaign",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
+                    },
                     {
                         "description": "request",
                         "name": "request",
@@ -406,23 +442,42 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/model.Campaign"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "new version of the campaign"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "409": {
                         "description": "Conflict",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             },
             "delete": {
+                "description": "If-Match header must contain ETag of the campaign (or \"*\" to skip the check)",
                 "produces": [
                     "application/json"
                 ],
@@ -444,6 +499,13 @@ const docTemplate = `{
                         "name": "campaignId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the campaign",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -453,7 +515,19 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -461,7 +535,7 @@ const docTemplate = `{
         },
         "/advertisers/{advertiserId}/campaigns/{campaignId}/image": {
             "put": {
-                "description": "Only .jpg and .png files up to 5 MB are allowed. This method won't fail if the campaign already has an image.",
+                "description": "Only .jpg and .png files up to the configured size (5 MiB by default) are allowed. This method won't fail if the campaign already has an image.\nIf-Match header must contain ETag of the campaign (or \"*\" to skip the check).",
                 "produces": [
                     "application/json"
                 ],
@@ -484,6 +558,13 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "ETag of the campaign",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
+                    },
                     {
                         "type": "file",
                         "description": "image",
@@ -497,18 +578,42 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/model.Campaign"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "new version of the campaign"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Fails if the campaign does not have an image",
+                "description": "Fails if the campaign does not have an image.\nIf-Match header must contain ETag of the campaign (or \"*\" to skip the check).",
                 "produces": [
                     "application/json"
                 ],
@@ -530,6 +635,13 @@ const docTemplate = `{
                         "name": "campaignId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the campaign",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -537,18 +649,36 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/model.Campaign"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "new version of the campaign"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -592,7 +722,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -643,7 +773,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -685,7 +815,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -720,7 +850,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -762,7 +892,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -796,7 +926,30 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Does not check dependencies, see /readyz.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Check that the server is alive",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     }
                 }
@@ -829,7 +982,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -857,11 +1010,39 @@ const docTemplate = `{
                 }
             }
         },
-        "/stats/advertisers/{advertiserId}/campaigns": {
+        "/readyz": {
             "get": {
+                "description": "Checks the database connection, applied migrations, writability of the media directory and the LLM model.\nResponds with 503 if a critical component fails; failures of other components make the status \"degraded\".",
                 "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Check that the server is ready to handle requests",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Health"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.Health"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/advertisers/{advertiserId}/campaigns": {
+            "get": {
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
                 "tags": [
                     "Stats"
                 ],
@@ -873,6 +1054,29 @@ const docTemplate = `{
                         "name": "advertiserId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -882,24 +1086,33 @@ const docTemplate = `{
                             "$ref": "#/definitions/model.CampaignStats"
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             }
         },
-        "/stats/advertisers/{advertiserId}/campaigns/daily": {
+        "/stats/advertisers/{advertiserId}/campaigns/breakdown": {
             "get": {
+                "description": "Campaigns without impressions in the period are included with zero stats. Total is computed over all campaigns, not only the page.\nExported as CSV or XLSX without size and page, all campaigns are returned.",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
                     "Stats"
                 ],
-                "summary": "Get daily stats for all campaigns of this advertiser",
+                "summary": "Get stats of each campaign of this advertiser",
                 "parameters": [
                     {
                         "type": "string",
@@ -907,77 +1120,210 @@ const docTemplate = `{
                         "name": "advertiserId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "spent_total",
+                            "conversion",
+                            "impressions_count"
+                        ],
+                        "type": "string",
+                        "default": "spent_total",
+                        "description": "sort key",
+                        "name": "sort_by",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "desc",
+                        "description": "sort order",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 1,
+                        "description": "page number, starting from 1",
+                        "name": "page",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/model.CampaignStats"
-                            }
+                            "$ref": "#/definitions/model.StatsBreakdown"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             }
         },
-        "/stats/campaigns/{campaignId}": {
+        "/stats/advertisers/{advertiserId}/campaigns/daily": {
             "get": {
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
                     "Stats"
                 ],
-                "summary": "Get stats for campaign",
+                "summary": "Get daily stats for all campaigns of this advertiser",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "campaignId",
-                        "name": "campaignId",
+                        "description": "advertiserId",
+                        "name": "advertiserId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.CampaignStats"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.CampaignStats"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             }
         },
-        "/stats/campaigns/{campaignId}/daily": {
+        "/stats/advertisers/{advertiserId}/campaigns/report": {
             "get": {
+                "description": "Without group_by, a single group with the totals is returned (or none if there were no impressions).",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
                     "Stats"
                 ],
-                "summary": "Get daily stats for campaign",
+                "summary": "Get stats for all campaigns of this advertiser grouped by dimensions",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "campaignId",
-                        "name": "campaignId",
+                        "description": "advertiserId",
+                        "name": "advertiserId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "dimensions: date, campaign, gender, age_bucket, location, creative",
+                        "name": "group_by",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -986,45 +1332,331 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/model.CampaignStats"
+                                "$ref": "#/definitions/model.StatsGroup"
                             }
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             }
         },
-        "/time": {
+        "/stats/campaigns/{campaignId}": {
             "get": {
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
-                    "Time"
+                    "Stats"
+                ],
+                "summary": "Get stats for campaign",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "campaignId",
+                        "name": "campaignId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get current date",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.CurrentDate"
+                            "$ref": "#/definitions/model.CampaignStats"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
             }
         },
-        "/time/advance": {
-            "post": {
+        "/stats/campaigns/{campaignId}/daily": {
+            "get": {
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
                 ],
                 "tags": [
-                    "Time"
+                    "Stats"
+                ],
+                "summary": "Get daily stats for campaign",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "campaignId",
+                        "name": "campaignId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.CampaignStats"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/campaigns/{campaignId}/invalid-traffic": {
+            "get": {
+                "description": "Clicks made too soon after the impression, at a rate far above the usual rate of the client, or from\na source shared by many clients are accepted by POST /ads/{adId}/click, but are not charged.\nThey are listed here, the most recent first. Total and by_reason are computed over all pages.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Stats"
+                ],
+                "summary": "Get clicks on the ad of the campaign flagged as invalid traffic",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "campaignId",
+                        "name": "campaignId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "page size",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 1,
+                        "description": "page number, starting from 1",
+                        "name": "page",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.InvalidTrafficReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/campaigns/{campaignId}/report": {
+            "get": {
+                "description": "Without group_by, a single group with the totals is returned (or none if there were no impressions).",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "Stats"
+                ],
+                "summary": "Get stats for campaign grouped by dimensions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "campaignId",
+                        "name": "campaignId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "response format, overrides Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "first day, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "last day, inclusive",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "dimensions: date, campaign, gender, age_bucket, location, creative",
+                        "name": "group_by",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.StatsGroup"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/time": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Time"
+                ],
+                "summary": "Get current date",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.CurrentDate"
+                        }
+                    }
+                }
+            }
+        },
+        "/time/advance": {
+            "post": {
+                "description": "Scheduled jobs are run in background for each day that has begun since the previous date.\nThe date can be moved backwards only while the sandbox is active.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Time"
                 ],
                 "summary": "Update current date",
                 "parameters": [
@@ -1048,7 +1680,80 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/ginerr.ErrorResp"
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/time/sandbox": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Time"
+                ],
+                "summary": "Get sandbox state",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Sandbox"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Saves a snapshot of all the data. While the sandbox is active, the date can be moved backwards.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Time"
+                ],
+                "summary": "Start sandbox",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Sandbox"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Restores all the data, including the date, from the snapshot saved on sandbox start.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Time"
+                ],
+                "summary": "Discard sandbox",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Sandbox"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/ginerr.Problem"
                         }
                     }
                 }
@@ -1056,14 +1761,61 @@ const docTemplate = `{
         }
     },
     "definitions": {
-        "ginerr.ErrorResp": {
+        "ginerr.Problem": {
             "type": "object",
             "properties": {
-                "message": {
-                    "type": "string"
+                "code": {
+                    "description": "Code is a stable machine-readable identifier of the problem, e.g. \"validation_failed\" or \"client_not_found\".",
+                    "type": "string",
+                    "example": "validation_failed"
+                },
+                "detail": {
+                    "type": "string",
+                    "example": "request validation failed"
+                },
+                "errors": {
+                    "description": "Errors lists every violation when Code is \"validation_failed\".",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ginerr.Violation"
+                    }
+                },
+                "request_id": {
+                    "description": "RequestId identifies the request in server logs.",
+                    "type": "string",
+                    "example": "7f0e5d3a-45e1-4b8e-9b8e-1d2c3b4a5f60"
                 },
                 "status": {
-                    "type": "string"
+                    "type": "integer",
+                    "example": 400
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Bad Request"
+                },
+                "type": {
+                    "description": "Type is always \"about:blank\", meaning that the problem has no additional semantics beyond the status code.",
+                    "type": "string",
+                    "example": "about:blank"
+                }
+            }
+        },
+        "ginerr.Violation": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is a stable machine-readable identifier of the violated rule, e.g. \"required\", \"gte\" or \"uuid\".",
+                    "type": "string",
+                    "example": "oneof"
+                },
+                "field": {
+                    "description": "Field is a path to the field in JSON body (like \"targeting.gender\" or \"[2].client_id\"),\nor a name of query or path parameter. It is empty if the violation relates to the whole request.",
+                    "type": "string",
+                    "example": "targeting.gender"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "must be one of: MALE FEMALE ALL"
                 }
             }
         },
@@ -1353,6 +2105,46 @@ const docTemplate = `{
                 "impressions_count": {
                     "type": "integer"
                 },
+                "non_billable_clicks_count": {
+                    "description": "NonBillableClicksCount is the number of rejected clicks, which are not included in ClicksCount and spent.",
+                    "type": "integer"
+                },
+                "spent_clicks": {
+                    "type": "number"
+                },
+                "spent_impressions": {
+                    "type": "number"
+                },
+                "spent_total": {
+                    "type": "number"
+                }
+            }
+        },
+        "model.CampaignStatsItem": {
+            "type": "object",
+            "properties": {
+                "ad_title": {
+                    "type": "string"
+                },
+                "campaign_id": {
+                    "type": "string"
+                },
+                "clicks_count": {
+                    "type": "integer"
+                },
+                "conversion": {
+                    "type": "number"
+                },
+                "date": {
+                    "type": "integer"
+                },
+                "impressions_count": {
+                    "type": "integer"
+                },
+                "non_billable_clicks_count": {
+                    "description": "NonBillableClicksCount is the number of rejected clicks, which are not included in ClicksCount and spent.",
+                    "type": "integer"
+                },
                 "spent_clicks": {
                     "type": "number"
                 },
@@ -1418,6 +2210,42 @@ const docTemplate = `{
                 }
             }
         },
+        "model.ComponentHealth": {
+            "type": "object",
+            "properties": {
+                "critical": {
+                    "description": "Critical components make the backend not ready when they fail.",
+                    "type": "boolean"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "number"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "ok",
+                        "fail"
+                    ]
+                }
+            }
+        },
+        "model.Creative": {
+            "type": "object",
+            "properties": {
+                "ad_text": {
+                    "type": "string"
+                },
+                "ad_title": {
+                    "type": "string"
+                },
+                "image_path": {
+                    "type": "string"
+                }
+            }
+        },
         "model.CurrentDate": {
             "type": "object",
             "required": [
@@ -1430,6 +2258,47 @@ const docTemplate = `{
                 }
             }
         },
+        "model.Health": {
+            "type": "object",
+            "properties": {
+                "components": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/model.ComponentHealth"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "ok",
+                        "degraded",
+                        "fail"
+                    ]
+                }
+            }
+        },
+        "model.InvalidTrafficReport": {
+            "type": "object",
+            "properties": {
+                "by_reason": {
+                    "description": "ByReason counts flagged clicks on all pages by the reason.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "clicks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.NonBillableClick"
+                    }
+                },
+                "total": {
+                    "description": "Total is the number of flagged clicks on all pages.",
+                    "type": "integer"
+                }
+            }
+        },
         "model.MlScore": {
             "type": "object",
             "required": [
@@ -1448,6 +2317,101 @@ const docTemplate = `{
                     "type": "integer"
                 }
             }
+        },
+        "model.NonBillableClick": {
+            "type": "object",
+            "properties": {
+                "campaign_id": {
+                    "type": "string"
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "date": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.Sandbox": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.StatsBreakdown": {
+            "type": "object",
+            "properties": {
+                "campaigns": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.CampaignStatsItem"
+                    }
+                },
+                "campaigns_count": {
+                    "description": "CampaignsCount is the number of campaigns on all pages.",
+                    "type": "integer"
+                },
+                "total": {
+                    "$ref": "#/definitions/model.CampaignStats"
+                }
+            }
+        },
+        "model.StatsGroup": {
+            "type": "object",
+            "properties": {
+                "age_bucket": {
+                    "type": "string",
+                    "example": "25-34"
+                },
+                "campaign_id": {
+                    "type": "string"
+                },
+                "clicks_count": {
+                    "type": "integer"
+                },
+                "conversion": {
+                    "type": "number"
+                },
+                "creative": {
+                    "$ref": "#/definitions/model.Creative"
+                },
+                "date": {
+                    "type": "integer"
+                },
+                "gender": {
+                    "type": "string"
+                },
+                "impressions_count": {
+                    "type": "integer"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "non_billable_clicks_count": {
+                    "description": "NonBillableClicksCount is the number of rejected clicks, which are not included in ClicksCount and spent.",
+                    "type": "integer"
+                },
+                "spent_clicks": {
+                    "type": "number"
+                },
+                "spent_impressions": {
+                    "type": "number"
+                },
+                "spent_total": {
+                    "type": "number"
+                }
+            }
         }
     }
 }`
@@ -1,40 +1,46 @@
 package handler
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/repo"
+	"backend/internal/service"
 	"backend/pkg/ginerr"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"log/slog"
 )
 
 // @Summary Suggest an ad for a client
 // @Produce json
 // @Success 200 {object} model.Ad
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param clientId query string true "client_id"
 // @Tags Ads
 // @Router /ads [get]
 func (h *Handler) getAd(c *gin.Context) {
 	clientId, err := uuid.Parse(c.Query("client_id"))
 	if err != nil {
-		c.JSON(400, ginerr.Build("client_id must be uuid"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "client_id", Code: "uuid", Message: "must be a valid UUID"})
 		return
 	}
-	client, err := h.clientSvc.GetById(clientId)
+	client, err := h.adSvc.GetClient(c.Request.Context(), clientId)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("client not found"))
+		ginerr.Abort(c, 404, "client_not_found", "client not found")
 		return
 	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	c.Set("client", client)
+	middleware.AddLogAttrs(c, slog.String("client_id", client.Id.String()))
 
-	ad, err := h.adSvc.GetAd(client)
+	ad, err := h.adSvc.GetAd(c.Request.Context(), client)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("no relevant ad found"))
+		ginerr.Abort(c, 404, "ad_not_found", "no relevant ad found")
 		return
 	}
 	if err != nil {
@@ -47,28 +53,30 @@ func (h *Handler) getAd(c *gin.Context) {
 // @Summary For testing: get all ad candidates, sorted in the order of priority
 // @Produce json
 // @Success 200 {object} []model.AdCandidate
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param clientId query string true "client_id"
 // @Tags Ads
 // @Router /ads/candidates [get]
 func (h *Handler) getAdCandidates(c *gin.Context) {
 	clientId, err := uuid.Parse(c.Query("client_id"))
 	if err != nil {
-		c.JSON(400, ginerr.Build("client_id must be uuid"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "client_id", Code: "uuid", Message: "must be a valid UUID"})
 		return
 	}
-	client, err := h.clientSvc.GetById(clientId)
+	client, err := h.clientSvc.GetById(c.Request.Context(), clientId)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("client not found"))
+		ginerr.Abort(c, 404, "client_not_found", "client not found")
 		return
 	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	c.Set("client", client)
+	middleware.AddLogAttrs(c, slog.String("client_id", client.Id.String()))
 
-	candidates, err := h.adSvc.GetAdCandidates(client)
+	candidates, err := h.adSvc.GetAdCandidates(c.Request.Context(), client)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
@@ -76,16 +84,28 @@ func (h *Handler) getAdCandidates(c *gin.Context) {
 	c.JSON(200, candidates)
 }
 
+var clickRejectionMessages = map[string]string{
+	model.ClickRejectedNotViewed:          "ad was not viewed",
+	model.ClickRejectedCampaignNotActive:  "campaign is not active",
+	model.ClickRejectedAttributionExpired: "attribution window of the impression has expired",
+	model.ClickRejectedLimitReached:       "clicks limit of the campaign is reached",
+}
+
 type adClickRequest struct {
 	ClientId uuid.UUID `json:"client_id" binding:"required,uuid"`
 }
 
 // @Summary Notify that the ad was clicked
+// @Description The click is charged if the client has viewed the ad, the campaign is active, the impression was made
+// @Description not more than ADS_CLICK_ATTRIBUTION_WINDOW days ago and the clicks limit is not reached.
+// @Description Otherwise 409 is returned with the reason in code, and the click is counted in stats as non-billable.
+// @Description Clicks flagged as invalid traffic are answered with 204, but are not charged either; see
+// @Description GET /stats/campaigns/{campaignId}/invalid-traffic.
 // @Produce json
 // @Success 204
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 404 {object} ginerr.ErrorResp
-// @Failure 409 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
+// @Failure 409 {object} ginerr.Problem
 // @Param adId path string true "adId"
 // @Param request body adClickRequest true "request"
 // @Tags Ads
@@ -93,33 +113,31 @@ type adClickRequest struct {
 func (h *Handler) clickAd(c *gin.Context) {
 	var req adClickRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
 	campaign := c.MustGet("campaign").(model.Campaign)
 
-	viewed, err := h.adSvc.IsAdViewed(req.ClientId, campaign.Id)
-	if err != nil {
-		ginerr.Handle500(c, err)
-		return
-	}
-	if !viewed {
-		c.JSON(409, ginerr.Build("ad was not viewed"))
-		return
-	}
-
-	client, err := h.clientSvc.GetById(req.ClientId)
+	client, err := h.clientSvc.GetById(c.Request.Context(), req.ClientId)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("client not found"))
+		ginerr.Abort(c, 404, "client_not_found", "client not found")
 		return
 	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	c.Set("client", client)
+	middleware.AddLogAttrs(c, slog.String("client_id", client.Id.String()))
 
-	if err := h.adSvc.ClickAd(client, campaign); err != nil {
+	err = h.adSvc.ClickAd(c.Request.Context(), client, campaign, c.ClientIP())
+	var rejected *service.ClickRejectedError
+	if errors.As(err, &rejected) {
+		ginerr.Abort(c, 409, rejected.Reason, clickRejectionMessages[rejected.Reason])
+		return
+	}
+	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"backend/config"
+	"backend/internal/repo/memory"
+	"backend/internal/service"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter creates the router over the in-memory storage; configure may adjust the default config.
+func newTestRouter(t *testing.T, configure ...func(env *config.Environment)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	env := config.Default()
+	env.StorageBackend = config.StorageMemory
+	env.RunningInCI = true
+	env.Media.FsPath = t.TempDir()
+	for _, f := range configure {
+		f(&env)
+	}
+	services, err := service.NewServices(memory.NewRepositories(), env, slog.Default())
+	require.NoError(t, err)
+	return NewHandler(services, slog.Default()).GetRouter(env)
+}
+
+func doRequest(t *testing.T, router http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	var reader bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&reader).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createTestCampaign creates an advertiser with a campaign and returns their IDs.
+func createTestCampaign(t *testing.T, router http.Handler) (advertiserId, campaignId uuid.UUID) {
+	advertiserId = uuid.New()
+	w := doRequest(t, router, http.MethodPost, "/advertisers/bulk", []gin.H{{"advertiser_id": advertiserId, "name": "adv"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = doRequest(t, router, http.MethodPost, fmt.Sprintf("/advertisers/%s/campaigns", advertiserId), gin.H{
+		"impressions_limit": 10, "clicks_limit": 5, "cost_per_impression": 1, "cost_per_click": 2,
+		"ad_title": "title", "ad_text": "text", "start_date": 0, "end_date": 5,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var campaign struct {
+		Id uuid.UUID `json:"campaign_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &campaign))
+	return advertiserId, campaign.Id
+}
+
+// TestAds_ConcurrentLimits hammers ad serving and clicks concurrently and checks that the limits are not exceeded.
+func TestAds_ConcurrentLimits(t *testing.T) {
+	const clientsCount, impressionsLimit, clicksLimit = 100, 10, 3
+	router := newTestRouter(t, func(env *config.Environment) {
+		// the clicks are made right after the impressions from a single address
+		env.Ads.InvalidTraffic.Enabled = false
+	})
+
+	advertiserId := uuid.New()
+	w := doRequest(t, router, http.MethodPost, "/advertisers/bulk", []gin.H{{"advertiser_id": advertiserId, "name": "adv"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	clients := make([]gin.H, clientsCount)
+	for i := range clients {
+		clients[i] = gin.H{"client_id": uuid.New(), "login": fmt.Sprintf("user%d", i), "age": 20, "location": "Moscow", "gender": "MALE"}
+	}
+	w = doRequest(t, router, http.MethodPost, "/clients/bulk", clients)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = doRequest(t, router, http.MethodPost, fmt.Sprintf("/advertisers/%s/campaigns", advertiserId), gin.H{
+		"impressions_limit": impressionsLimit, "clicks_limit": clicksLimit, "cost_per_impression": 1, "cost_per_click": 2,
+		"ad_title": "title", "ad_text": "text", "start_date": 0, "end_date": 5,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var campaign struct {
+		Id uuid.UUID `json:"campaign_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &campaign))
+
+	// every client requests an ad several times, so that cached candidates are used too
+	var mu sync.Mutex
+	var viewers []any
+	statuses := make(map[int]int)
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/ads?client_id=%s", client["client_id"]), nil)
+				mu.Lock()
+				defer mu.Unlock()
+				statuses[w.Code]++
+				if w.Code == http.StatusOK && !slices.Contains(viewers, client["client_id"]) {
+					viewers = append(viewers, client["client_id"])
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	assert.Len(t, viewers, impressionsLimit)
+	assert.Equal(t, 3*clientsCount, statuses[http.StatusOK]+statuses[http.StatusNotFound], "statuses: %v", statuses)
+
+	statuses = make(map[int]int)
+	for _, viewer := range viewers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doRequest(t, router, http.MethodPost, fmt.Sprintf("/ads/%s/click", campaign.Id), gin.H{"client_id": viewer})
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[w.Code]++
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[int]int{http.StatusNoContent: clicksLimit, http.StatusConflict: impressionsLimit - clicksLimit}, statuses)
+
+	w = doRequest(t, router, http.MethodGet, fmt.Sprintf("/stats/campaigns/%s", campaign.Id), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var stats struct {
+		ImpressionsCount int `json:"impressions_count"`
+		ClicksCount      int `json:"clicks_count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, impressionsLimit, stats.ImpressionsCount)
+	assert.Equal(t, clicksLimit, stats.ClicksCount)
+}
@@ -10,8 +10,8 @@ import (
 // @Summary Get advertiser by id
 // @Produce json
 // @Success 200 {object} model.Advertiser
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Tags Advertisers
 // @Router /advertisers/{advertiserId} [get]
@@ -23,18 +23,18 @@ func (h *Handler) getAdvertiser(c *gin.Context) {
 // @Summary Upsert many advertisers at once
 // @Produce json
 // @Success 200 {object} []model.Advertiser
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param request body []model.Advertiser true "request"
 // @Tags Advertisers
 // @Router /advertisers/bulk [post]
 func (h *Handler) postAdvertisersBulk(c *gin.Context) {
 	var req []model.Advertiser
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
-	res, err := h.advertiserSvc.AddBulk(req)
+	res, err := h.advertiserSvc.AddBulk(c.Request.Context(), req)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
@@ -46,20 +46,20 @@ func (h *Handler) postAdvertisersBulk(c *gin.Context) {
 // @Summary Add ML score for client-advertiser pair
 // @Produce json
 // @Success 200
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param request body model.MlScore true "request"
 // @Tags Advertisers
 // @Router /ml-scores [post]
 func (h *Handler) postMlScore(c *gin.Context) {
 	var req model.MlScore
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
-	err := h.advertiserSvc.AddMlScore(req)
+	err := h.advertiserSvc.AddMlScore(c.Request.Context(), req)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("client or advertiser not found"))
+		ginerr.Abort(c, 404, "client_or_advertiser_not_found", "client or advertiser not found")
 		return
 	}
 	if err != nil {
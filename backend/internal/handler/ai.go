@@ -12,20 +12,20 @@ import (
 // @Description Use short-polling with interval of 2 seconds to be notified when the task is completed
 // @Produce json
 // @Success 200 {object} model.AiTaskResponse
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param taskId path string true "taskId"
 // @Tags AI
 // @Router /ai/tasks/{taskId} [get]
 func (h *Handler) aiGetTask(c *gin.Context) {
 	taskId, err := uuid.Parse(c.Param("taskId"))
 	if err != nil {
-		c.JSON(400, ginerr.Build("taskId must be uuid"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "taskId", Code: "uuid", Message: "must be a valid UUID"})
 		return
 	}
 
-	task, err := h.aiSvc.GetTask(taskId)
+	task, err := h.aiSvc.GetTask(c.Request.Context(), taskId)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("task not found"))
+		ginerr.Abort(c, 404, "task_not_found", "task not found")
 		return
 	}
 	if err != nil {
@@ -49,7 +49,7 @@ type aiSuggestTextResponse struct {
 // @Description Create a task to suggest ad texts given by advertiser name and ad title. Returns task id to use in /ai/tasks/{taskId}
 // @Produce json
 // @Success 200 {object} aiSuggestTextResponse
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param request body aiSuggestTextRequest true "request"
 // @Param advertiserId path string true "advertiserId"
 // @Tags AI
@@ -57,13 +57,13 @@ type aiSuggestTextResponse struct {
 func (h *Handler) aiSuggestText(c *gin.Context) {
 	var req aiSuggestTextRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
 	adv := c.MustGet("advertiser").(model.Advertiser)
 
-	taskId, err := h.aiSvc.SubmitSuggestText(adv.Name, req.AdTitle, req.Comment)
+	taskId, err := h.aiSvc.SubmitSuggestText(c.Request.Context(), adv.Name, req.AdTitle, req.Comment)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
@@ -74,7 +74,7 @@ func (h *Handler) aiSuggestText(c *gin.Context) {
 // @Summary Get campaigns list with failed moderation
 // @Produce json
 // @Success 200 {object} []model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param size query int false "size"
 // @Param page query int false "page"
 // @Tags Moderation
@@ -82,7 +82,7 @@ func (h *Handler) aiSuggestText(c *gin.Context) {
 func (h *Handler) aiGetModerationFailed(c *gin.Context) {
 	var req model.GetCampaignsRequest
 	if err := c.ShouldBindQuery(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
@@ -93,7 +93,7 @@ func (h *Handler) aiGetModerationFailed(c *gin.Context) {
 		req.Page = 1
 	}
 
-	campaigns, err := h.campaignSvc.GetModerationFailed(req.Size, req.Page)
+	campaigns, err := h.campaignSvc.GetModerationFailed(c.Request.Context(), req.Size, req.Page)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
@@ -120,18 +120,18 @@ func (h *Handler) aiModerationStatusGet(c *gin.Context) {
 // @Description When disabled, moderation_result field will be null
 // @Produce json
 // @Success 204
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param request body moderationStatus true "request"
 // @Tags Moderation
 // @Router /ai/moderation/enabled [post]
 func (h *Handler) aiModerationStatusUpdate(c *gin.Context) {
 	var req moderationStatus
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
-	err := h.settingsSvc.SetModerationEnabled(*req.Enabled)
+	err := h.settingsSvc.SetModerationEnabled(c.Request.Context(), *req.Enabled)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
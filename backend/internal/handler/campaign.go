@@ -2,17 +2,19 @@ package handler
 
 import (
 	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/pkg/etag"
 	"backend/pkg/ginerr"
-	"fmt"
+	"backend/pkg/tabular"
 	"github.com/gin-gonic/gin"
-	"time"
 )
 
 // @Summary Create campaign
 // @Produce json
 // @Success 200 {object} model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 409 {object} ginerr.ErrorResp
+// @Header 200 {string} ETag "version of the campaign"
+// @Failure 400 {object} ginerr.Problem
+// @Failure 409 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Param request body model.CampaignCreateRequest true "request"
 // @Tags Campaigns
@@ -20,38 +22,38 @@ import (
 func (h *Handler) createCampaign(c *gin.Context) {
 	var req model.CampaignCreateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 	if *req.StartDate > *req.EndDate {
-		c.JSON(400, ginerr.Build("start date is after end date"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "start_date", Code: "ltefield", Message: "must not be after end_date"})
 		return
 	}
 
 	date := h.settingsSvc.Date()
 	if *req.StartDate < date || *req.EndDate < date {
-		c.JSON(409, ginerr.Build("either start or end date are in past"))
+		ginerr.Abort(c, 409, "date_in_past", "either start or end date are in past")
 		return
 	}
 
 	adv := c.MustGet("advertiser").(model.Advertiser)
 
-	start := time.Now()
-	campaign, err := h.campaignSvc.Create(adv.Id, req)
+	campaign, err := h.campaignSvc.Create(c.Request.Context(), adv.Id, req)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
-	fmt.Println(time.Since(start))
-
+	c.Header("ETag", etag.Format(campaign.Version))
 	c.JSON(200, campaign)
 }
 
 // @Summary Get campaigns list
-// @Produce json
+// @Description Exported as CSV or XLSX without size and page, all campaigns are returned.
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {object} []model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
 // @Param size query int false "size"
 // @Param page query int false "page"
 // @Tags Campaigns
@@ -61,7 +63,16 @@ func (h *Handler) getCampaigns(c *gin.Context) {
 
 	var req model.GetCampaignsRequest
 	if err := c.ShouldBindQuery(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
+		return
+	}
+
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	if format != "" && req.Size == 0 && req.Page == 0 {
+		h.exportCampaigns(c, adv, format)
 		return
 	}
 
@@ -72,45 +83,89 @@ func (h *Handler) getCampaigns(c *gin.Context) {
 		req.Page = 1
 	}
 
-	campaigns, err := h.campaignSvc.GetPaginated(adv.Id, req.Size, req.Page)
+	campaigns, err := h.campaignSvc.GetPaginated(c.Request.Context(), adv.Id, req.Size, req.Page)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
 
+	if format != "" {
+		h.writeTable(c, format, "campaigns-"+adv.Id.String(), campaignColumns, func(w tabular.Writer) error {
+			return writeCampaigns(w, campaigns)
+		})
+		return
+	}
 	c.JSON(200, campaigns)
 }
 
+// exportPageSize is the number of campaigns read at once while exporting all campaigns of an advertiser or their stats.
+const exportPageSize = 1000
+
+// exportCampaigns streams all campaigns of the advertiser page by page. Pages are read separately,
+// so a campaign deleted during the export may shift the following ones and make one of them skipped.
+func (h *Handler) exportCampaigns(c *gin.Context, adv model.Advertiser, format tabular.Format) {
+	// the first page is read before the status is sent, so that a failure still results in an error response
+	campaigns, err := h.campaignSvc.GetPaginated(c.Request.Context(), adv.Id, exportPageSize, 1)
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+
+	h.writeTable(c, format, "campaigns-"+adv.Id.String(), campaignColumns, func(w tabular.Writer) error {
+		for page := 2; ; page++ {
+			if err := writeCampaigns(w, campaigns); err != nil {
+				return err
+			}
+			if len(campaigns) < exportPageSize {
+				return nil
+			}
+			campaigns, err = h.campaignSvc.GetPaginated(c.Request.Context(), adv.Id, exportPageSize, page)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
 // @Summary Get campaign by id
+// @Description ETag header contains the version of the campaign, which is required to update or delete it
 // @Produce json
 // @Success 200 {object} model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
+// @Header 200 {string} ETag "version of the campaign"
+// @Failure 400 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Param campaignId path string true "campaignId"
 // @Tags Campaigns
 // @Router /advertisers/{advertiserId}/campaigns/{campaignId} [get]
 func (h *Handler) getCampaignById(c *gin.Context) {
-	c.JSON(200, c.MustGet("campaign"))
+	campaign := c.MustGet("campaign").(model.Campaign)
+	c.Header("ETag", etag.Format(campaign.Version))
+	c.JSON(200, campaign)
 }
 
 // @Summary Update campaign
+// @Description If-Match header must contain ETag of the campaign (or "*" to skip the check)
 // @Produce json
 // @Success 200 {object} model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 409 {object} ginerr.ErrorResp
+// @Header 200 {string} ETag "new version of the campaign"
+// @Failure 400 {object} ginerr.Problem
+// @Failure 409 {object} ginerr.Problem
+// @Failure 412 {object} ginerr.Problem
+// @Failure 428 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Param campaignId path string true "campaignId"
+// @Param If-Match header string true "ETag of the campaign"
 // @Param request body model.CampaignCreateRequest true "request"
 // @Tags Campaigns
 // @Router /advertisers/{advertiserId}/campaigns/{campaignId} [put]
 func (h *Handler) updateCampaign(c *gin.Context) {
 	var req model.CampaignCreateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 	if *req.StartDate > *req.EndDate {
-		c.JSON(400, ginerr.Build("start date is after end date"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "start_date", Code: "ltefield", Message: "must not be after end_date"})
 		return
 	}
 
@@ -118,11 +173,11 @@ func (h *Handler) updateCampaign(c *gin.Context) {
 	date := h.settingsSvc.Date()
 
 	if *req.StartDate != *campaign.StartDate && *req.StartDate < date {
-		c.JSON(409, ginerr.Build("changed start date is in the past"))
+		ginerr.Abort(c, 409, "date_in_past", "changed start date is in the past")
 		return
 	}
 	if *req.EndDate != *campaign.EndDate && *req.EndDate < date {
-		c.JSON(409, ginerr.Build("changed end date is in the past"))
+		ginerr.Abort(c, 409, "date_in_past", "changed end date is in the past")
 		return
 	}
 
@@ -130,30 +185,43 @@ func (h *Handler) updateCampaign(c *gin.Context) {
 		*campaign.EndDate != *req.EndDate ||
 		*campaign.ImpressionsLimit != *req.ImpressionsLimit ||
 		*campaign.ClicksLimit != *req.ClicksLimit) {
-		c.JSON(409, ginerr.Build("some of the updated fields can't be changed after campaign start"))
+		ginerr.Abort(c, 409, "campaign_already_started", "some of the updated fields can't be changed after campaign start")
 		return
 	}
 
-	err := h.campaignSvc.Update(&campaign, req)
+	err := h.campaignSvc.Update(c.Request.Context(), &campaign, req)
+	if repo.IsVersionConflict(err) {
+		ginerr.Abort(c, 412, "version_conflict", "campaign was modified, fetch it again and retry")
+		return
+	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
 
+	c.Header("ETag", etag.Format(campaign.Version))
 	c.JSON(200, campaign)
 }
 
 // @Summary Delete campaign
+// @Description If-Match header must contain ETag of the campaign (or "*" to skip the check)
 // @Produce json
 // @Success 204
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 412 {object} ginerr.Problem
+// @Failure 428 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Param campaignId path string true "campaignId"
+// @Param If-Match header string true "ETag of the campaign"
 // @Tags Campaigns
 // @Router /advertisers/{advertiserId}/campaigns/{campaignId} [delete]
 func (h *Handler) deleteCampaign(c *gin.Context) {
 	campaign := c.MustGet("campaign").(model.Campaign)
-	err := h.campaignSvc.Delete(campaign.Id)
+	err := h.campaignSvc.Delete(c.Request.Context(), campaign.Id, campaign.Version)
+	if repo.IsVersionConflict(err) {
+		ginerr.Abort(c, 412, "version_conflict", "campaign was modified, fetch it again and retry")
+		return
+	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
@@ -1,36 +1,40 @@
 package handler
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/repo"
 	"backend/pkg/ginerr"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"log/slog"
 )
 
 // @Summary Get client by id
 // @Produce json
 // @Success 200 {object} model.Client
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param clientId path string true "clientId"
 // @Tags Clients
 // @Router /clients/{clientId} [get]
 func (h *Handler) getClient(c *gin.Context) {
 	clientId, err := uuid.Parse(c.Param("clientId"))
 	if err != nil {
-		c.JSON(400, ginerr.Build("clientId must be uuid"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "clientId", Code: "uuid", Message: "must be a valid UUID"})
 		return
 	}
 
-	client, err := h.clientSvc.GetById(clientId)
+	client, err := h.clientSvc.GetById(c.Request.Context(), clientId)
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("client not found"))
+		ginerr.Abort(c, 404, "client_not_found", "client not found")
 		return
 	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	c.Set("client", client)
+	middleware.AddLogAttrs(c, slog.String("client_id", client.Id.String()))
 
 	c.JSON(200, client)
 }
@@ -38,18 +42,18 @@ func (h *Handler) getClient(c *gin.Context) {
 // @Summary Upsert many clients at once
 // @Produce json
 // @Success 200 {object} []model.Client
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
 // @Param request body []model.Client true "request"
 // @Tags Clients
 // @Router /clients/bulk [post]
 func (h *Handler) postClientsBulk(c *gin.Context) {
 	var req []model.Client
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
-	res, err := h.clientSvc.AddBulk(req)
+	res, err := h.clientSvc.AddBulk(c.Request.Context(), req)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
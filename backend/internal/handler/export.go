@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"backend/internal/service"
+	"backend/pkg/ginerr"
+	"backend/pkg/tabular"
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+// Column headers of exported tables. They are a part of the API: columns may be appended, but not renamed or reordered.
+var (
+	statsColumns = []string{"impressions_count", "clicks_count", "conversion", "spent_impressions", "spent_clicks", "spent_total",
+		"non_billable_clicks_count"}
+
+	dailyStatsColumns = append([]string{"date"}, statsColumns...)
+
+	// groupColumns contain all dimensions, so that the header does not depend on group_by
+	groupColumns = append([]string{
+		"date", "campaign_id", "gender", "age_bucket", "location", "ad_title", "ad_text", "image_path",
+	}, statsColumns...)
+
+	breakdownColumns = append([]string{"campaign_id", "ad_title"}, statsColumns...)
+
+	campaignColumns = []string{
+		"campaign_id", "created_at", "advertiser_id", "ad_title", "ad_text", "image_path",
+		"impressions_limit", "clicks_limit", "cost_per_impression", "cost_per_click", "start_date", "end_date",
+		"targeting_gender", "targeting_age_from", "targeting_age_to", "targeting_location",
+	}
+)
+
+const (
+	mimeCSV  = "text/csv"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportFormat returns the format requested by the format query parameter or, without it, by the Accept header.
+// The format is empty if the response should be JSON. If the format is unknown, the request is aborted and false is returned.
+func exportFormat(c *gin.Context) (tabular.Format, bool) {
+	switch format := c.Query("format"); format {
+	case "":
+	case "json":
+		return "", true
+	case string(tabular.CSV), string(tabular.XLSX):
+		return tabular.Format(format), true
+	default:
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "format", Code: "oneof", Message: "must be one of: json csv xlsx"})
+		return "", false
+	}
+
+	switch c.NegotiateFormat(gin.MIMEJSON, mimeCSV, mimeXLSX) {
+	case mimeCSV:
+		return tabular.CSV, true
+	case mimeXLSX:
+		return tabular.XLSX, true
+	default:
+		return "", true
+	}
+}
+
+// writeTable streams the header with columns and rows produced by write as an attachment named name.
+// The status is sent before the rows, so if write fails, the error is only logged and the table is cut short.
+func (h *Handler) writeTable(c *gin.Context, format tabular.Format, name string, columns []string, write func(w tabular.Writer) error) {
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Status(200)
+
+	err := func() error {
+		w, err := tabular.NewWriter(c.Writer, format)
+		if err != nil {
+			return err
+		}
+		header := make([]any, len(columns))
+		for i, column := range columns {
+			header[i] = column
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		if err := write(w); err != nil {
+			return err
+		}
+		return w.Close()
+	}()
+	if err != nil {
+		_ = c.Error(err)
+		h.log.ErrorContext(c.Request.Context(), "export failed", "name", name, "error", err)
+	}
+}
+
+func statsCells(stats model.CampaignStats) []any {
+	return []any{stats.ImpressionsCount, stats.ClicksCount, stats.Conversion, stats.SpentImpressions, stats.SpentClicks, stats.SpentTotal,
+		stats.NonBillableClicksCount}
+}
+
+func writeStats(w tabular.Writer, stats ...model.CampaignStats) error {
+	for _, s := range stats {
+		if err := w.Write(statsCells(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDailyStats(w tabular.Writer, stats []model.CampaignStats) error {
+	for _, s := range stats {
+		if err := w.Write(append([]any{s.Date}, statsCells(s)...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStatsGroups(w tabular.Writer, groups service.StatsGroups) error {
+	return groups.Each(func(g model.StatsGroup) error {
+		var creative model.Creative
+		if g.Creative != nil {
+			creative = *g.Creative
+		}
+		var campaignId any
+		if g.CampaignId != nil {
+			campaignId = *g.CampaignId
+		}
+		row := []any{g.Date, campaignId, g.Gender, g.AgeBucket, g.Location, creative.AdTitle, creative.AdText, creative.ImagePath}
+		return w.Write(append(row, statsCells(g.CampaignStats)...))
+	})
+}
+
+func writeBreakdown(w tabular.Writer, items []model.CampaignStatsItem) error {
+	for _, item := range items {
+		if err := w.Write(append([]any{item.CampaignId, item.AdTitle}, statsCells(item.CampaignStats)...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCampaigns(w tabular.Writer, campaigns []model.Campaign) error {
+	for _, campaign := range campaigns {
+		err := w.Write([]any{
+			campaign.Id, campaign.CreatedAt, campaign.AdvertiserId, campaign.AdTitle, campaign.AdText, campaign.ImagePath,
+			campaign.ImpressionsLimit, campaign.ClicksLimit, campaign.CostPerImpression, campaign.CostPerClick,
+			campaign.StartDate, campaign.EndDate,
+			campaign.Gender, campaign.AgeFrom, campaign.AgeTo, campaign.Location,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
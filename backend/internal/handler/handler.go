@@ -3,11 +3,18 @@ package handler
 import (
 	"backend/config"
 	"backend/docs"
+	"backend/internal/logging"
 	"backend/internal/middleware"
 	"backend/internal/service"
+	"backend/pkg/ginerr"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"log/slog"
+	"slices"
 )
 
 type Handler struct {
@@ -17,12 +24,16 @@ type Handler struct {
 	apiSvc        *service.ApiService
 	campaignSvc   *service.CampaignService
 	clientSvc     *service.ClientService
+	healthSvc     *service.HealthService
 	imageSvc      *service.ImageService
+	sandboxSvc    *service.SandboxService
+	schedulerSvc  *service.SchedulerService
 	settingsSvc   *service.SettingsService
 	statsSvc      *service.StatsService
+	log           *slog.Logger
 }
 
-func NewHandler(services *service.Services) *Handler {
+func NewHandler(services *service.Services, logger *slog.Logger) *Handler {
 	return &Handler{
 		adSvc:         services.Ad,
 		advertiserSvc: services.Advertiser,
@@ -30,21 +41,40 @@ func NewHandler(services *service.Services) *Handler {
 		apiSvc:        services.Api,
 		campaignSvc:   services.Campaign,
 		clientSvc:     services.Client,
+		healthSvc:     services.Health,
 		imageSvc:      services.Image,
+		sandboxSvc:    services.Sandbox,
+		schedulerSvc:  services.Scheduler,
 		settingsSvc:   services.Settings,
 		statsSvc:      services.Stats,
+		log:           logging.For(logger, logging.SubsystemHttp),
 	}
 }
 
 func (h *Handler) GetRouter(env config.Environment) *gin.Engine {
-	router := gin.Default()
-	router.MaxMultipartMemory = 5 << 20 // limit for uploads: 5 MiB
-	_ = router.SetTrustedProxies(nil)
+	ginerr.ConfigureBinding()
+
+	router := gin.New()
+	router.Use(middleware.RequestId)
+	router.Use(otelgin.Middleware("backend", otelgin.WithGinFilter(traced)))
+	router.Use(middleware.NewAccessLogMiddleware(h.log, monitoringRoutes...).Callback)
+	router.Use(middleware.Metrics)
+	router.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+		ginerr.Handle500(c, fmt.Errorf("panic: %v", err))
+	}))
+	router.NoRoute(func(c *gin.Context) {
+		ginerr.Abort(c, 404, "route_not_found", "no such endpoint")
+	})
+	// larger uploads are buffered in temporary files; the size is limited by addCampaignImage
+	router.MaxMultipartMemory = env.Media.MaxUploadSize
+	// the client IP is the source of clicks for detection of invalid traffic, so forwarding headers are trusted
+	// only from the proxies in front of the service; the entries are checked by env.Validate
+	_ = router.SetTrustedProxies(env.TrustedProxies)
 
 	api := router.Group("")
-	loggerMiddleware := middleware.NewRequestsLoggerMiddleware(h.apiSvc)
-	if !env.RunningInCI {
-		api.Use(loggerMiddleware.Callback)
+	api.Use(middleware.NewTimeoutMiddleware(env.Timeouts).Callback)
+	if env.RequestsLog.Enabled {
+		api.Use(middleware.NewRequestsLoggerMiddleware(h.apiSvc).Callback)
 	}
 
 	advMiddleware := middleware.NewAdvertiserMiddleware(h.advertiserSvc)
@@ -54,8 +84,14 @@ func (h *Handler) GetRouter(env config.Environment) *gin.Engine {
 	apiAdv.Use(advMiddleware.Callback)
 	apiCampaign := api.Group("")
 	apiCampaign.Use(campaignMiddleware.Callback)
+	apiCampaignWrite := apiCampaign.Group("")
+	apiCampaignWrite.Use(campaignMiddleware.RequireIfMatch)
 
 	api.GET("/ping", h.ping)
+	api.GET("/healthz", h.healthz)
+	api.GET("/readyz", h.readyz)
+	// not a part of the API, so the timeout does not apply; nginx does not expose it
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	api.GET("/clients/:clientId", h.getClient)
 	api.POST("/clients/bulk", h.postClientsBulk)
@@ -67,23 +103,30 @@ func (h *Handler) GetRouter(env config.Environment) *gin.Engine {
 	apiAdv.POST("/advertisers/:advertiserId/campaigns", h.createCampaign)
 	apiAdv.GET("/advertisers/:advertiserId/campaigns", h.getCampaigns)
 	apiCampaign.GET("/advertisers/:advertiserId/campaigns/:campaignId", h.getCampaignById)
-	apiCampaign.PUT("/advertisers/:advertiserId/campaigns/:campaignId", h.updateCampaign)
-	apiCampaign.DELETE("/advertisers/:advertiserId/campaigns/:campaignId", h.deleteCampaign)
+	apiCampaignWrite.PUT("/advertisers/:advertiserId/campaigns/:campaignId", h.updateCampaign)
+	apiCampaignWrite.DELETE("/advertisers/:advertiserId/campaigns/:campaignId", h.deleteCampaign)
 
 	api.GET("/ads", h.getAd)
 	api.GET("/ads/candidates", h.getAdCandidates)
 	apiCampaign.POST("/ads/:campaignId/click", h.clickAd)
 
-	apiCampaign.GET("/stats/campaigns/:campaignId", h.getStatsCampaign)
-	apiAdv.GET("/stats/advertisers/:advertiserId/campaigns", h.getStatsAdvertiser)
-	apiCampaign.GET("/stats/campaigns/:campaignId/daily", h.getStatsCampaignDaily)
-	apiAdv.GET("/stats/advertisers/:advertiserId/campaigns/daily", h.getStatsAdvertiserDaily)
+	apiCampaign.GET("/stats/campaigns/:campaignId", middleware.ReadReplica, h.getStatsCampaign)
+	apiAdv.GET("/stats/advertisers/:advertiserId/campaigns", middleware.ReadReplica, h.getStatsAdvertiser)
+	apiCampaign.GET("/stats/campaigns/:campaignId/daily", middleware.ReadReplica, h.getStatsCampaignDaily)
+	apiAdv.GET("/stats/advertisers/:advertiserId/campaigns/daily", middleware.ReadReplica, h.getStatsAdvertiserDaily)
+	apiCampaign.GET("/stats/campaigns/:campaignId/report", middleware.ReadReplica, h.getStatsCampaignReport)
+	apiCampaign.GET("/stats/campaigns/:campaignId/invalid-traffic", middleware.ReadReplica, h.getStatsCampaignInvalidTraffic)
+	apiAdv.GET("/stats/advertisers/:advertiserId/campaigns/report", middleware.ReadReplica, h.getStatsAdvertiserReport)
+	apiAdv.GET("/stats/advertisers/:advertiserId/campaigns/breakdown", middleware.ReadReplica, h.getStatsAdvertiserBreakdown)
 
 	api.GET("/time", h.timeGet)
 	api.POST("/time/advance", h.timeAdvance)
+	api.GET("/time/sandbox", h.sandboxGet)
+	api.POST("/time/sandbox", h.sandboxStart)
+	api.DELETE("/time/sandbox", h.sandboxDiscard)
 
-	apiCampaign.PUT("/advertisers/:advertiserId/campaigns/:campaignId/image", h.addCampaignImage)
-	apiCampaign.DELETE("/advertisers/:advertiserId/campaigns/:campaignId/image", h.deleteCampaignImage)
+	apiCampaignWrite.PUT("/advertisers/:advertiserId/campaigns/:campaignId/image", h.addCampaignImage)
+	apiCampaignWrite.DELETE("/advertisers/:advertiserId/campaigns/:campaignId/image", h.deleteCampaignImage)
 
 	apiAdv.POST("/ai/advertisers/:advertiserId/suggestText", h.aiSuggestText)
 	api.GET("/ai/tasks/:taskId", h.aiGetTask)
@@ -97,6 +140,14 @@ func (h *Handler) GetRouter(env config.Environment) *gin.Engine {
 	return router
 }
 
+// monitoringRoutes are polled by monitoring, so they are excluded from tracing and logged only at debug level.
+var monitoringRoutes = []string{"/metrics", "/ping", "/healthz", "/readyz"}
+
+// traced excludes requests of monitoring from tracing.
+func traced(c *gin.Context) bool {
+	return !slices.Contains(monitoringRoutes, c.FullPath())
+}
+
 // @Summary Ping the server
 // @Produce json
 // @Success 200 {object} map[string]string
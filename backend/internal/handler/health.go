@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// @Summary Check that the server is alive
+// @Description Does not check dependencies, see /readyz.
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Tags Health
+// @Router /healthz [get]
+func (h *Handler) healthz(c *gin.Context) {
+	c.JSON(200, gin.H{"status": model.HealthOk})
+}
+
+// @Summary Check that the server is ready to handle requests
+// @Description Checks the database connection, applied migrations, writability of the media directory and the LLM model.
+// @Description Responds with 503 if a critical component fails; failures of other components make the status "degraded".
+// @Produce json
+// @Success 200 {object} model.Health
+// @Failure 503 {object} model.Health
+// @Tags Health
+// @Router /readyz [get]
+func (h *Handler) readyz(c *gin.Context) {
+	health := h.healthSvc.Check(c.Request.Context())
+	status := http.StatusOK
+	if health.Status == model.HealthFail {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, health)
+}
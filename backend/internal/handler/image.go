@@ -2,64 +2,98 @@ package handler
 
 import (
 	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/pkg/etag"
 	"backend/pkg/ginerr"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"strings"
 )
 
 // @Summary Upload image to campaign
-// @Description Only .jpg and .png files up to 5 MB are allowed. This method won't fail if the campaign already has an image.
+// @Description Only .jpg and .png files up to the configured size (5 MiB by default) are allowed. This method won't fail if the campaign already has an image.
+// @Description If-Match header must contain ETag of the campaign (or "*" to skip the check).
 // @Produce json
 // @Success 200 {object} model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
+// @Header 200 {string} ETag "new version of the campaign"
+// @Failure 400 {object} ginerr.Problem
+// @Failure 412 {object} ginerr.Problem
+// @Failure 413 {object} ginerr.Problem
+// @Failure 428 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Param campaignId path string true "campaignId"
+// @Param If-Match header string true "ETag of the campaign"
 // @Param file formData file true "image"
 // @Tags Images
 // @Router /advertisers/{advertiserId}/campaigns/{campaignId}/image [put]
 func (h *Handler) addCampaignImage(c *gin.Context) {
+	limit := h.imageSvc.MaxUploadSize()
+	// leave room for the other parts of the form, the size of the file is checked below
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
 	file, err := c.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || err == nil && file.Size > limit {
+		ginerr.Abort(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("image must not exceed %d bytes", limit))
+		return
+	}
 	if err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
 	if !strings.HasSuffix(file.Filename, ".png") && !strings.HasSuffix(file.Filename, ".jpg") {
-		c.JSON(400, ginerr.Build("image must be either png or jpg"))
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "file", Code: "file_type", Message: "image must be either png or jpg"})
 		return
 	}
 
 	campaign := c.MustGet("campaign").(model.Campaign)
-	campaign, err = h.imageSvc.AddCampaignImage(campaign, file)
+	campaign, err = h.imageSvc.AddCampaignImage(c.Request.Context(), campaign, file)
+	if repo.IsVersionConflict(err) {
+		ginerr.Abort(c, 412, "version_conflict", "campaign was modified, fetch it again and retry")
+		return
+	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
 
+	c.Header("ETag", etag.Format(campaign.Version))
 	c.JSON(200, campaign)
 }
 
 // @Summary Delete image from campaign
-// @Description Fails if the campaign does not have an image
+// @Description Fails if the campaign does not have an image.
+// @Description If-Match header must contain ETag of the campaign (or "*" to skip the check).
 // @Produce json
 // @Success 200 {object} model.Campaign
-// @Failure 400 {object} ginerr.ErrorResp
-// @Failure 404 {object} ginerr.ErrorResp
+// @Header 200 {string} ETag "new version of the campaign"
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
+// @Failure 412 {object} ginerr.Problem
+// @Failure 428 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
 // @Param campaignId path string true "campaignId"
+// @Param If-Match header string true "ETag of the campaign"
 // @Tags Images
 // @Router /advertisers/{advertiserId}/campaigns/{campaignId}/image [delete]
 func (h *Handler) deleteCampaignImage(c *gin.Context) {
 	campaign := c.MustGet("campaign").(model.Campaign)
 	if campaign.ImagePath == "" {
-		c.JSON(404, ginerr.Build("campaign does not have an image"))
+		ginerr.Abort(c, 404, "image_not_found", "campaign does not have an image")
 		return
 	}
 
-	campaign, err := h.imageSvc.DeleteCampaignImage(campaign)
+	campaign, err := h.imageSvc.DeleteCampaignImage(c.Request.Context(), campaign)
+	if repo.IsVersionConflict(err) {
+		ginerr.Abort(c, 412, "version_conflict", "campaign was modified, fetch it again and retry")
+		return
+	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	c.Header("ETag", etag.Format(campaign.Version))
 	c.JSON(200, campaign)
 }
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"backend/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadImage sends a png image of the given size as the campaign image.
+func uploadImage(t *testing.T, router http.Handler, path string, size int) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "image.png")
+	require.NoError(t, err)
+	_, err = part.Write(make([]byte, size))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPut, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAddCampaignImage_TooLarge(t *testing.T) {
+	const limit = 1024
+	router := newTestRouter(t, func(env *config.Environment) {
+		env.Media.MaxUploadSize = limit
+	})
+	advertiserId, campaignId := createTestCampaign(t, router)
+	path := fmt.Sprintf("/advertisers/%s/campaigns/%s/image", advertiserId, campaignId)
+
+	for name, size := range map[string]int{
+		// the body fits into the limit of the request, but the file doesn't
+		"file over limit": limit + 1,
+		// the request is cut off while the form is being read
+		"body over limit": limit + 2<<20,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := uploadImage(t, router, path, size)
+			require.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+			var problem struct {
+				Code string `json:"code"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, "file_too_large", problem.Code)
+		})
+	}
+
+	w := uploadImage(t, router, path, limit)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
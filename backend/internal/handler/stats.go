@@ -3,73 +3,392 @@ package handler
 import (
 	"backend/internal/model"
 	"backend/pkg/ginerr"
+	"backend/pkg/tabular"
 	"github.com/gin-gonic/gin"
+	"slices"
+	"strings"
 )
 
 // @Summary Get stats for campaign
-// @Produce json
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {object} model.CampaignStats
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param campaignId path string true "campaignId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
 // @Tags Stats
 // @Router /stats/campaigns/{campaignId} [get]
 func (h *Handler) getStatsCampaign(c *gin.Context) {
+	var req model.StatsRequest
+	if !bindStatsRequest(c, &req) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
 	campaign := c.MustGet("campaign").(model.Campaign)
-	stats, err := h.statsSvc.GetStatsCampaign(campaign)
+	stats, err := h.statsSvc.GetStatsCampaign(c.Request.Context(), campaign, req.StatsPeriod)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	if format != "" {
+		h.writeTable(c, format, "stats-campaign-"+campaign.Id.String(), statsColumns, func(w tabular.Writer) error {
+			return writeStats(w, stats)
+		})
+		return
+	}
 	c.JSON(200, stats)
 }
 
 // @Summary Get stats for all campaigns of this advertiser
-// @Produce json
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {object} model.CampaignStats
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
 // @Tags Stats
 // @Router /stats/advertisers/{advertiserId}/campaigns [get]
 func (h *Handler) getStatsAdvertiser(c *gin.Context) {
+	var req model.StatsRequest
+	if !bindStatsRequest(c, &req) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
 	adv := c.MustGet("advertiser").(model.Advertiser)
-	stats, err := h.statsSvc.GetStatsAdvertiser(adv)
+	stats, err := h.statsSvc.GetStatsAdvertiser(c.Request.Context(), adv, req.StatsPeriod)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	if format != "" {
+		h.writeTable(c, format, "stats-advertiser-"+adv.Id.String(), statsColumns, func(w tabular.Writer) error {
+			return writeStats(w, stats)
+		})
+		return
+	}
 	c.JSON(200, stats)
 }
 
 // @Summary Get daily stats for campaign
-// @Produce json
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {object} []model.CampaignStats
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param campaignId path string true "campaignId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
 // @Tags Stats
 // @Router /stats/campaigns/{campaignId}/daily [get]
 func (h *Handler) getStatsCampaignDaily(c *gin.Context) {
+	var req model.StatsRequest
+	if !bindStatsRequest(c, &req) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
 	campaign := c.MustGet("campaign").(model.Campaign)
-	stats, err := h.statsSvc.GetStatsCampaignDaily(campaign)
+	stats, err := h.statsSvc.GetStatsCampaignDaily(c.Request.Context(), campaign, req.StatsPeriod)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	if format != "" {
+		h.writeTable(c, format, "stats-campaign-"+campaign.Id.String()+"-daily", dailyStatsColumns, func(w tabular.Writer) error {
+			return writeDailyStats(w, stats)
+		})
+		return
+	}
 	c.JSON(200, stats)
 }
 
 // @Summary Get daily stats for all campaigns of this advertiser
-// @Produce json
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {object} []model.CampaignStats
-// @Failure 404 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
 // @Param advertiserId path string true "advertiserId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
 // @Tags Stats
 // @Router /stats/advertisers/{advertiserId}/campaigns/daily [get]
 func (h *Handler) getStatsAdvertiserDaily(c *gin.Context) {
+	var req model.StatsRequest
+	if !bindStatsRequest(c, &req) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	adv := c.MustGet("advertiser").(model.Advertiser)
+	stats, err := h.statsSvc.GetStatsAdvertiserDaily(c.Request.Context(), adv, req.StatsPeriod)
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+	if format != "" {
+		h.writeTable(c, format, "stats-advertiser-"+adv.Id.String()+"-daily", dailyStatsColumns, func(w tabular.Writer) error {
+			return writeDailyStats(w, stats)
+		})
+		return
+	}
+	c.JSON(200, stats)
+}
+
+// @Summary Get stats for campaign grouped by dimensions
+// @Description Without group_by, a single group with the totals is returned (or none if there were no impressions).
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Success 200 {object} []model.StatsGroup
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
+// @Param campaignId path string true "campaignId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
+// @Param group_by query []string false "dimensions: date, campaign, gender, age_bucket, location, creative" collectionFormat(csv)
+// @Tags Stats
+// @Router /stats/campaigns/{campaignId}/report [get]
+func (h *Handler) getStatsCampaignReport(c *gin.Context) {
+	var req model.StatsRequest
+	if !bindStatsRequest(c, &req) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	campaign := c.MustGet("campaign").(model.Campaign)
+	stats, err := h.statsSvc.GetStatsCampaignGrouped(c.Request.Context(), campaign, req)
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+	if format != "" {
+		h.writeTable(c, format, "stats-campaign-"+campaign.Id.String()+"-report", groupColumns, func(w tabular.Writer) error {
+			return writeStatsGroups(w, stats)
+		})
+		return
+	}
+	c.JSON(200, stats.All())
+}
+
+// @Summary Get stats for all campaigns of this advertiser grouped by dimensions
+// @Description Without group_by, a single group with the totals is returned (or none if there were no impressions).
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Success 200 {object} []model.StatsGroup
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
+// @Param advertiserId path string true "advertiserId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
+// @Param group_by query []string false "dimensions: date, campaign, gender, age_bucket, location, creative" collectionFormat(csv)
+// @Tags Stats
+// @Router /stats/advertisers/{advertiserId}/campaigns/report [get]
+func (h *Handler) getStatsAdvertiserReport(c *gin.Context) {
+	var req model.StatsRequest
+	if !bindStatsRequest(c, &req) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	adv := c.MustGet("advertiser").(model.Advertiser)
+	stats, err := h.statsSvc.GetStatsAdvertiserGrouped(c.Request.Context(), adv, req)
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+	if format != "" {
+		h.writeTable(c, format, "stats-advertiser-"+adv.Id.String()+"-report", groupColumns, func(w tabular.Writer) error {
+			return writeStatsGroups(w, stats)
+		})
+		return
+	}
+	c.JSON(200, stats.All())
+}
+
+// @Summary Get stats of each campaign of this advertiser
+// @Description Campaigns without impressions in the period are included with zero stats. Total is computed over all campaigns, not only the page.
+// @Description Exported as CSV or XLSX without size and page, all campaigns are returned.
+// @Produce json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Success 200 {object} model.StatsBreakdown
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
+// @Param advertiserId path string true "advertiserId"
+// @Param format query string false "response format, overrides Accept header" Enums(json, csv, xlsx)
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
+// @Param sort_by query string false "sort key" Enums(spent_total, conversion, impressions_count) default(spent_total)
+// @Param order query string false "sort order" Enums(asc, desc) default(desc)
+// @Param size query int false "page size" default(100)
+// @Param page query int false "page number, starting from 1" default(1)
+// @Tags Stats
+// @Router /stats/advertisers/{advertiserId}/campaigns/breakdown [get]
+func (h *Handler) getStatsAdvertiserBreakdown(c *gin.Context) {
+	req := model.StatsBreakdownRequest{SortBy: model.StatsSortSpentTotal, Order: "desc"}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		ginerr.AbortBinding(c, err)
+		return
+	}
+	if !validatePeriod(c, req.StatsPeriod) {
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
 	adv := c.MustGet("advertiser").(model.Advertiser)
-	stats, err := h.statsSvc.GetStatsAdvertiserDaily(adv)
+	if format != "" && req.Size == 0 && req.Page == 0 {
+		h.exportBreakdown(c, adv, req, format)
+		return
+	}
+	if req.Size == 0 {
+		req.Size = 100
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+
+	stats, err := h.statsSvc.GetStatsAdvertiserBreakdown(c.Request.Context(), adv, req)
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
+	if format != "" {
+		h.writeTable(c, format, "stats-advertiser-"+adv.Id.String()+"-breakdown", breakdownColumns, func(w tabular.Writer) error {
+			return writeBreakdown(w, stats.Campaigns)
+		})
+		return
+	}
 	c.JSON(200, stats)
 }
+
+// exportBreakdown streams the stats of all campaigns of the advertiser page by page, like exportCampaigns.
+// Pages are read separately, so a campaign whose stats change during the export may move to another page
+// and be skipped or written twice.
+func (h *Handler) exportBreakdown(c *gin.Context, adv model.Advertiser, req model.StatsBreakdownRequest, format tabular.Format) {
+	req.Size, req.Page = exportPageSize, 1
+	// the first page is read before the status is sent, so that a failure still results in an error response
+	stats, err := h.statsSvc.GetStatsAdvertiserBreakdown(c.Request.Context(), adv, req)
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+
+	h.writeTable(c, format, "stats-advertiser-"+adv.Id.String()+"-breakdown", breakdownColumns, func(w tabular.Writer) error {
+		for {
+			if err := writeBreakdown(w, stats.Campaigns); err != nil {
+				return err
+			}
+			if len(stats.Campaigns) < exportPageSize {
+				return nil
+			}
+			req.Page++
+			stats, err = h.statsSvc.GetStatsAdvertiserBreakdown(c.Request.Context(), adv, req)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// @Summary Get clicks on the ad of the campaign flagged as invalid traffic
+// @Description Clicks made too soon after the impression, at a rate far above the usual rate of the client, or from
+// @Description a source shared by many clients are accepted by POST /ads/{adId}/click, but are not charged.
+// @Description They are listed here, the most recent first. Total and by_reason are computed over all pages.
+// @Produce json
+// @Success 200 {object} model.InvalidTrafficReport
+// @Failure 400 {object} ginerr.Problem
+// @Failure 404 {object} ginerr.Problem
+// @Param campaignId path string true "campaignId"
+// @Param from query int false "first day, inclusive"
+// @Param to query int false "last day, inclusive"
+// @Param size query int false "page size" default(100)
+// @Param page query int false "page number, starting from 1" default(1)
+// @Tags Stats
+// @Router /stats/campaigns/{campaignId}/invalid-traffic [get]
+func (h *Handler) getStatsCampaignInvalidTraffic(c *gin.Context) {
+	var req model.InvalidTrafficRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		ginerr.AbortBinding(c, err)
+		return
+	}
+	if !validatePeriod(c, req.StatsPeriod) {
+		return
+	}
+	if req.Size == 0 {
+		req.Size = 100
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+
+	campaign := c.MustGet("campaign").(model.Campaign)
+	report, err := h.statsSvc.GetInvalidTrafficReport(c.Request.Context(), campaign, req)
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+	c.JSON(200, report)
+}
+
+// validatePeriod aborts the request and returns false if from is after to.
+func validatePeriod(c *gin.Context, period model.StatsPeriod) bool {
+	if period.From != nil && period.To != nil && *period.From > *period.To {
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "from", Code: "ltefield", Message: "must not be after to"})
+		return false
+	}
+	return true
+}
+
+// bindStatsRequest binds and validates query parameters, splitting comma-separated group_by.
+// If the request is invalid, it is aborted and false is returned.
+func bindStatsRequest(c *gin.Context, req *model.StatsRequest) bool {
+	if err := c.ShouldBindQuery(req); err != nil {
+		ginerr.AbortBinding(c, err)
+		return false
+	}
+	if !validatePeriod(c, req.StatsPeriod) {
+		return false
+	}
+
+	var groupBy []string
+	for _, value := range req.GroupBy {
+		for _, dimension := range strings.Split(value, ",") {
+			dimension = strings.TrimSpace(dimension)
+			if !slices.Contains(model.StatsDimensions, dimension) {
+				ginerr.AbortInvalid(c, ginerr.Violation{
+					Field:   "group_by",
+					Code:    "oneof",
+					Message: "must be one of: " + strings.Join(model.StatsDimensions, " "),
+				})
+				return false
+			}
+			groupBy = append(groupBy, dimension)
+		}
+	}
+	req.GroupBy = groupBy
+	return true
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCampaignInvalidTraffic(t *testing.T) {
+	router := newTestRouter(t)
+	_, campaignId := createTestCampaign(t, router)
+
+	// both clients click right after the impression, so the clicks are flagged
+	clientIds := []uuid.UUID{uuid.New(), uuid.New()}
+	for i, clientId := range clientIds {
+		client := gin.H{"client_id": clientId, "login": fmt.Sprintf("user%d", i), "age": 20, "location": "Moscow", "gender": "MALE"}
+		w := doRequest(t, router, http.MethodPost, "/clients/bulk", []gin.H{client})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = doRequest(t, router, http.MethodGet, fmt.Sprintf("/ads?client_id=%s", clientId), nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = doRequest(t, router, http.MethodPost, fmt.Sprintf("/ads/%s/click", campaignId), gin.H{"client_id": clientId})
+		require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	type report struct {
+		Total    int            `json:"total"`
+		ByReason map[string]int `json:"by_reason"`
+		Clicks   []struct {
+			ClientId   uuid.UUID `json:"client_id"`
+			CampaignId uuid.UUID `json:"campaign_id"`
+			Reason     string    `json:"reason"`
+			Date       int       `json:"date"`
+			Source     string    `json:"source"`
+		} `json:"clicks"`
+	}
+	getReport := func(query string) report {
+		t.Helper()
+		w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/stats/campaigns/%s/invalid-traffic%s", campaignId, query), nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var got report
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		return got
+	}
+
+	got := getReport("")
+	assert.Equal(t, 2, got.Total)
+	assert.Equal(t, map[string]int{"click_too_soon": 2}, got.ByReason)
+	require.Len(t, got.Clicks, 2)
+	// the newest click comes first
+	assert.Equal(t, clientIds[1], got.Clicks[0].ClientId)
+	assert.Equal(t, campaignId, got.Clicks[0].CampaignId)
+	assert.Equal(t, "click_too_soon", got.Clicks[0].Reason)
+	assert.Equal(t, 0, got.Clicks[0].Date)
+	assert.Equal(t, "192.0.2.1", got.Clicks[0].Source)
+
+	// the first page is returned by default, and the counts include all pages
+	got = getReport("?size=1")
+	assert.Equal(t, 2, got.Total)
+	require.Len(t, got.Clicks, 1)
+	assert.Equal(t, clientIds[1], got.Clicks[0].ClientId)
+	got = getReport("?size=1&page=2")
+	require.Len(t, got.Clicks, 1)
+	assert.Equal(t, clientIds[0], got.Clicks[0].ClientId)
+	got = getReport("?page=2")
+	assert.Equal(t, 2, got.Total)
+	assert.Empty(t, got.Clicks)
+	// the period selects clicks by the date
+	got = getReport("?from=1&to=5")
+	assert.Zero(t, got.Total)
+	assert.Empty(t, got.Clicks)
+
+	for _, query := range []string{"?from=3&to=1", "?size=-1", "?page=-1", "?from=x"} {
+		w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/stats/campaigns/%s/invalid-traffic%s", campaignId, query), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/stats/campaigns/%s/invalid-traffic", uuid.New()), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var problem struct {
+		Code string `json:"code"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "campaign_not_found", problem.Code)
+}
+
+func TestStatsAdvertiserBreakdown_Export(t *testing.T) {
+	router := newTestRouter(t)
+	advertiserId, _ := createTestCampaign(t, router)
+	w := doRequest(t, router, http.MethodPost, fmt.Sprintf("/advertisers/%s/campaigns", advertiserId), gin.H{
+		"impressions_limit": 10, "clicks_limit": 5, "cost_per_impression": 1, "cost_per_click": 2,
+		"ad_title": "second", "ad_text": "text", "start_date": 0, "end_date": 5,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	export := func(query string) []string {
+		t.Helper()
+		w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/stats/advertisers/%s/campaigns/breakdown?format=csv%s", advertiserId, query), nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	}
+
+	// without size and page, all campaigns are exported
+	lines := export("")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "campaign_id,ad_title,"))
+	assert.Len(t, export("&size=1"), 2)
+	assert.Len(t, export("&size=1&page=3"), 1)
+}
@@ -2,7 +2,10 @@ package handler
 
 import (
 	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/internal/service"
 	"backend/pkg/ginerr"
+	"errors"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,24 +20,84 @@ func (h *Handler) timeGet(c *gin.Context) {
 }
 
 // @Summary Update current date
+// @Description Scheduled jobs are run in background for each day that has begun since the previous date.
+// @Description The date can be moved backwards only while the sandbox is active.
 // @Produce json
 // @Success 200 {object} model.CurrentDate
-// @Failure 400 {object} ginerr.ErrorResp
+// @Failure 400 {object} ginerr.Problem
+// @Failure 409 {object} ginerr.Problem
 // @Param request body model.CurrentDate true "request"
 // @Tags Time
 // @Router /time/advance [post]
 func (h *Handler) timeAdvance(c *gin.Context) {
 	var req model.CurrentDate
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, ginerr.Build(err.Error()))
+		ginerr.AbortBinding(c, err)
 		return
 	}
 
-	err := h.settingsSvc.SetDate(*req.CurrentDate)
+	err := h.settingsSvc.SetDate(c.Request.Context(), *req.CurrentDate)
+	if errors.Is(err, service.ErrDateBackwards) {
+		ginerr.Abort(c, 409, "date_backwards", "date can't be moved backwards, start a sandbox to rewind it")
+		return
+	}
 	if err != nil {
 		ginerr.Handle500(c, err)
 		return
 	}
 
+	h.schedulerSvc.Trigger()
+
 	c.JSON(200, req)
 }
+
+// @Summary Get sandbox state
+// @Produce json
+// @Success 200 {object} model.Sandbox
+// @Tags Time
+// @Router /time/sandbox [get]
+func (h *Handler) sandboxGet(c *gin.Context) {
+	c.JSON(200, model.Sandbox{Active: h.sandboxSvc.Active()})
+}
+
+// @Summary Start sandbox
+// @Description Saves a snapshot of all the data. While the sandbox is active, the date can be moved backwards.
+// @Produce json
+// @Success 200 {object} model.Sandbox
+// @Failure 409 {object} ginerr.Problem
+// @Tags Time
+// @Router /time/sandbox [post]
+func (h *Handler) sandboxStart(c *gin.Context) {
+	err := h.sandboxSvc.Start(c.Request.Context())
+	if errors.Is(err, repo.ErrSandboxActive) {
+		ginerr.Abort(c, 409, "sandbox_active", "sandbox is already active")
+		return
+	}
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+
+	c.JSON(200, model.Sandbox{Active: true})
+}
+
+// @Summary Discard sandbox
+// @Description Restores all the data, including the date, from the snapshot saved on sandbox start.
+// @Produce json
+// @Success 200 {object} model.Sandbox
+// @Failure 409 {object} ginerr.Problem
+// @Tags Time
+// @Router /time/sandbox [delete]
+func (h *Handler) sandboxDiscard(c *gin.Context) {
+	err := h.sandboxSvc.Discard(c.Request.Context())
+	if errors.Is(err, repo.ErrSandboxInactive) {
+		ginerr.Abort(c, 409, "sandbox_not_active", "sandbox is not active")
+		return
+	}
+	if err != nil {
+		ginerr.Handle500(c, err)
+		return
+	}
+
+	c.JSON(200, model.Sandbox{Active: false})
+}
@@ -0,0 +1,103 @@
+// Package logging provides a JSON logger with levels configured per subsystem, which adds
+// the request ID, trace ID and IDs of the entities of the request to every record.
+package logging
+
+import (
+	"backend/config"
+	"backend/pkg/requestid"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SubsystemKey is the attribute which selects the level of a logger, see For.
+const SubsystemKey = "subsystem"
+
+// Subsystems of the backend.
+const (
+	SubsystemApp   = "app"
+	SubsystemHttp  = "http"
+	SubsystemAds   = "ads"
+	SubsystemAi    = "ai"
+	SubsystemRepo  = "repo"
+	SubsystemStats = "stats"
+)
+
+var subsystems = []string{SubsystemApp, SubsystemHttp, SubsystemAds, SubsystemAi, SubsystemRepo, SubsystemStats}
+
+// New creates a logger which writes JSON records to w. Records of a subsystem logger (see For)
+// are filtered by the level of that subsystem, other records by the default level.
+func New(w io.Writer, cfg config.Logging) (*slog.Logger, error) {
+	for subsystem := range cfg.Subsystems {
+		if !slices.Contains(subsystems, subsystem) {
+			return nil, fmt.Errorf("unknown subsystem %q, must be one of: %s", subsystem, strings.Join(subsystems, " "))
+		}
+	}
+	// the inner handler lets everything through, filtering is done by handler.Enabled
+	inner := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.Level(-100)})
+	return slog.New(&handler{inner: inner, cfg: cfg, level: cfg.Level}), nil
+}
+
+// For returns a logger of the subsystem.
+func For(logger *slog.Logger, subsystem string) *slog.Logger {
+	return logger.With(SubsystemKey, subsystem)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx with attrs added to every record logged with it,
+// in addition to the attributes added earlier.
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return context.WithValue(ctx, contextKey{}, append(slices.Clip(prev), attrs...))
+}
+
+type handler struct {
+	inner slog.Handler
+	cfg   config.Logging
+	level slog.Level
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := requestid.FromContext(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+			r.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+		}
+		if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+			r.AddAttrs(attrs...)
+		}
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if attr.Key == SubsystemKey {
+			clone.level = h.cfg.Level
+			if level, ok := h.cfg.Subsystems[attr.Value.String()]; ok {
+				clone.level = level
+			}
+		}
+	}
+	return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithGroup(name)
+	return &clone
+}
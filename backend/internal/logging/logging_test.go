@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"backend/config"
+	"backend/pkg/requestid"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var result []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		result = append(result, record)
+	}
+	return result
+}
+
+func TestNew_SubsystemLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, config.Logging{
+		Level:      slog.LevelInfo,
+		Subsystems: map[string]slog.Level{SubsystemAds: slog.LevelDebug, SubsystemAi: slog.LevelError},
+	})
+	require.NoError(t, err)
+
+	logger.Debug("default debug")
+	logger.Info("default info")
+	For(logger, SubsystemAds).Debug("ads debug")
+	For(logger, SubsystemAi).Warn("ai warn")
+	For(logger, SubsystemAi).Error("ai error")
+	For(logger, SubsystemRepo).Debug("repo debug")
+
+	var messages []string
+	for _, record := range records(t, &buf) {
+		messages = append(messages, record["msg"].(string))
+	}
+	assert.Equal(t, []string{"default info", "ads debug", "ai error"}, messages)
+}
+
+func TestNew_UnknownSubsystem(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, config.Logging{Subsystems: map[string]slog.Level{"db": slog.LevelDebug}})
+	assert.Error(t, err)
+}
+
+func TestNewContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, config.Logging{})
+	require.NoError(t, err)
+
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	ctx = NewContext(ctx, slog.String("advertiser_id", "adv"))
+	campaignCtx := NewContext(ctx, slog.String("campaign_id", "camp"))
+	clientCtx := NewContext(ctx, slog.String("client_id", "cl"))
+
+	For(logger, SubsystemHttp).InfoContext(campaignCtx, "campaign", "key", "value")
+	logger.InfoContext(clientCtx, "client")
+	logger.Info("no context")
+
+	got := records(t, &buf)
+	require.Len(t, got, 3)
+	assert.Equal(t, "req-1", got[0]["request_id"])
+	assert.Equal(t, "adv", got[0]["advertiser_id"])
+	assert.Equal(t, "camp", got[0]["campaign_id"])
+	assert.Equal(t, "value", got[0]["key"])
+	assert.Equal(t, SubsystemHttp, got[0][SubsystemKey])
+	// contexts derived from the same parent do not share attributes
+	assert.Equal(t, "cl", got[1]["client_id"])
+	assert.NotContains(t, got[1], "campaign_id")
+	assert.NotContains(t, got[2], "request_id")
+}
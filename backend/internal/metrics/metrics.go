@@ -0,0 +1,87 @@
+// Package metrics defines Prometheus metrics of the backend. They are registered in the default registry
+// and exposed on /metrics along with the Go runtime and process metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ads"
+
+// Outcomes of ad serving, see AdServes.
+const (
+	OutcomeServed   = "served"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// ClickAccepted is the result of a charged click, see AdClicks; rejected clicks are labeled by the reason.
+const ClickAccepted = "accepted"
+
+// Results of cache lookups, see AdCacheRequests.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Results of AI tasks, see AiTaskDuration.
+const (
+	AiTaskDone        = "done"
+	AiTaskFailed      = "failed"
+	AiTaskInterrupted = "interrupted"
+)
+
+var (
+	// HttpRequestDuration is labeled by the route pattern rather than the path, so that ids do not blow up cardinality.
+	HttpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	AdServes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ad_serves_total",
+		Help:      "Number of ad requests by outcome: served, not_found or error.",
+	}, []string{"outcome"})
+
+	AdCandidates = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ad_candidates",
+		Help:      "Number of ad candidates found for a client on ad request.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500},
+	})
+
+	AdClicks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ad_clicks_total",
+		Help:      "Number of ad clicks by result: accepted or the reason of rejection.",
+	}, []string{"result"})
+
+	AdCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ad_cache_requests_total",
+		Help:      "Number of lookups of ad candidates of a client in the cache by result: hit or miss.",
+	}, []string{"result"})
+
+	AiQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ai_queue_depth",
+		Help:      "Number of AI tasks waiting in the queue.",
+	}, []string{"queue"})
+
+	AiTaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ai_task_duration_seconds",
+		Help:      "Duration of AI tasks by type and result, including retries.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"type", "result"})
+
+	ApiRequestsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_log_dropped_total",
+		Help:      "Number of requests not written to the api_requests table because its queue was full.",
+	})
+)
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"backend/internal/logging"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+type AccessLogMiddleware struct {
+	log         *slog.Logger
+	debugRoutes []string
+}
+
+// NewAccessLogMiddleware creates a middleware which logs requests to debugRoutes with level debug,
+// so that frequent requests of monitoring do not flood the log.
+func NewAccessLogMiddleware(log *slog.Logger, debugRoutes ...string) *AccessLogMiddleware {
+	return &AccessLogMiddleware{log, debugRoutes}
+}
+
+// Callback logs each request after it is handled. The record carries the request ID and the attributes
+// added with AddLogAttrs. Server errors are logged with level error.
+func (m *AccessLogMiddleware) Callback(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	level := slog.LevelInfo
+	switch {
+	case c.Writer.Status() >= 500:
+		level = slog.LevelError
+	case slices.Contains(m.debugRoutes, c.FullPath()):
+		level = slog.LevelDebug
+	}
+	attrs := []slog.Attr{
+		slog.String("method", c.Request.Method),
+		slog.String("route", c.FullPath()),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", c.Writer.Status()),
+		slog.Float64("duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+		slog.Int("size", max(c.Writer.Size(), 0)),
+		slog.String("client_ip", c.ClientIP()),
+	}
+	if len(c.Errors) > 0 {
+		attrs = append(attrs, slog.String("error", c.Errors.String()))
+	}
+	m.log.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
+
+// AddLogAttrs adds attrs to every record logged with the request context for the rest of the request,
+// including the access log record.
+func AddLogAttrs(c *gin.Context, attrs ...slog.Attr) {
+	c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), attrs...))
+}
@@ -3,9 +3,11 @@ package middleware
 import (
 	"backend/internal/repo"
 	"backend/internal/service"
+	"backend/internal/tracing"
 	"backend/pkg/ginerr"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"log/slog"
 )
 
 type AdvertiserMiddleware struct {
@@ -19,23 +21,23 @@ func NewAdvertiserMiddleware(advertiserSvc *service.AdvertiserService) *Advertis
 func (m *AdvertiserMiddleware) Callback(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("advertiserId"))
 	if err != nil {
-		c.JSON(400, ginerr.Build("advertiserId must be uuid"))
-		c.Abort()
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "advertiserId", Code: "uuid", Message: "must be a valid UUID"})
 		return
 	}
 
-	adv, err := m.advertiserSvc.GetById(id)
+	ctx, span := tracing.Tracer().Start(c.Request.Context(), "AdvertiserMiddleware")
+	adv, err := m.advertiserSvc.GetById(ctx, id)
+	span.End()
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("advertiser not found"))
-		c.Abort()
+		ginerr.Abort(c, 404, "advertiser_not_found", "advertiser not found")
 		return
 	}
 	if err != nil {
 		ginerr.Handle500(c, err)
-		c.Abort()
 		return
 	}
 
 	c.Set("advertiser", adv)
+	AddLogAttrs(c, slog.String("advertiser_id", adv.Id.String()))
 	c.Next()
 }
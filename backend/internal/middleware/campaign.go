@@ -1,11 +1,15 @@
 package middleware
 
 import (
+	"backend/internal/model"
 	"backend/internal/repo"
 	"backend/internal/service"
+	"backend/internal/tracing"
+	"backend/pkg/etag"
 	"backend/pkg/ginerr"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"log/slog"
 )
 
 type CampaignMiddleware struct {
@@ -19,32 +23,51 @@ func NewCampaignMiddleware(campaignSvc *service.CampaignService) *CampaignMiddle
 func (m *CampaignMiddleware) Callback(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("campaignId"))
 	if err != nil {
-		c.JSON(400, ginerr.Build("campaignId must be uuid"))
-		c.Abort()
+		ginerr.AbortInvalid(c, ginerr.Violation{Field: "campaignId", Code: "uuid", Message: "must be a valid UUID"})
 		return
 	}
 
-	campaign, err := m.campaignSvc.GetById(id)
+	ctx, span := tracing.Tracer().Start(c.Request.Context(), "CampaignMiddleware")
+	campaign, err := m.campaignSvc.GetById(ctx, id)
+	span.End()
 	if repo.IsNotFound(err) {
-		c.JSON(404, ginerr.Build("campaign not found"))
-		c.Abort()
+		ginerr.Abort(c, 404, "campaign_not_found", "campaign not found")
 		return
 	}
 	if err != nil {
 		ginerr.Handle500(c, err)
-		c.Abort()
 		return
 	}
 
 	if advIdRaw := c.Param("advertiserId"); advIdRaw != "" && c.Query("testAdvertiserValidation") != "skip" {
 		advId, err := uuid.Parse(advIdRaw)
 		if err != nil || advId != campaign.AdvertiserId {
-			c.JSON(403, ginerr.Build("this campaign does not belong to given advertiserId"))
-			c.Abort()
+			ginerr.Abort(c, 403, "campaign_advertiser_mismatch", "this campaign does not belong to given advertiserId")
 			return
 		}
 	}
 
 	c.Set("campaign", campaign)
+	AddLogAttrs(c, slog.String("campaign_id", campaign.Id.String()), slog.String("advertiser_id", campaign.AdvertiserId.String()))
+	c.Next()
+}
+
+// RequireIfMatch rejects modifications of a campaign unless the client proves that it has seen
+// the latest version of the campaign by sending its ETag in the If-Match header.
+// It must be used after Callback.
+func (m *CampaignMiddleware) RequireIfMatch(c *gin.Context) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		ginerr.Abort(c, 428, "if_match_required", "If-Match header is required, fetch the campaign to obtain its ETag")
+		return
+	}
+
+	campaign := c.MustGet("campaign").(model.Campaign)
+	if !etag.Match(header, campaign.Version) {
+		c.Header("ETag", etag.Format(campaign.Version))
+		ginerr.Abort(c, 412, "version_conflict", "campaign was modified, fetch it again and retry")
+		return
+	}
+
 	c.Next()
 }
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"backend/internal/metrics"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"time"
+)
+
+// Metrics observes the duration of each request in metrics.HttpRequestDuration.
+// Requests which did not match any route are labeled with route "unmatched".
+func Metrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.HttpRequestDuration.
+		WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+		Observe(time.Since(start).Seconds())
+}
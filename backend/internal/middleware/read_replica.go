@@ -0,0 +1,13 @@
+package middleware
+
+import (
+	"backend/internal/repo"
+	"github.com/gin-gonic/gin"
+)
+
+// ReadReplica routes the reads of the request to the read replica, if it is configured. It is used only by
+// stats and report endpoints, which tolerate the lag; other requests, and ad serving above all, read the primary.
+func ReadReplica(c *gin.Context) {
+	c.Request = c.Request.WithContext(repo.WithReplica(c.Request.Context()))
+	c.Next()
+}
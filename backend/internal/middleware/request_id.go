@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"backend/pkg/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestId assigns an ID to each request, so that the response and server logs can be correlated.
+// The ID is taken from X-Request-ID header if it is valid, otherwise a new one is generated.
+// It is stored in the request context and is returned in X-Request-ID response header.
+func RequestId(c *gin.Context) {
+	id := c.GetHeader(requestid.Header)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+	c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+	c.Header(requestid.Header, id)
+	c.Next()
+}
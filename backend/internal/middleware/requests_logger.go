@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"backend/internal/model"
 	"backend/internal/service"
 	"github.com/gin-gonic/gin"
+	"strings"
 	"time"
 )
 
@@ -14,8 +16,39 @@ func NewRequestsLoggerMiddleware(apiSvc *service.ApiService) *RequestsLoggerMidd
 	return &RequestsLoggerMiddleware{apiSvc}
 }
 
+// Callback logs the request after it is handled, along with the advertiser, campaign and client
+// stored in the context by middlewares and handlers, and the errors attached with c.Error.
 func (m *RequestsLoggerMiddleware) Callback(c *gin.Context) {
 	start := time.Now()
 	c.Next()
-	m.apiSvc.LogRequest(c.Request.Method, c.FullPath(), time.Since(start))
+
+	request := model.ApiRequest{
+		CreatedAt:    start,
+		Endpoint:     c.Request.Method + " " + c.FullPath(),
+		DurationMs:   float64(time.Since(start)) / float64(time.Millisecond),
+		Status:       c.Writer.Status(),
+		ResponseSize: max(c.Writer.Size(), 0),
+	}
+	if campaign, ok := c.Get("campaign"); ok {
+		campaign := campaign.(model.Campaign)
+		request.CampaignId = &campaign.Id
+		request.AdvertiserId = &campaign.AdvertiserId
+	}
+	if adv, ok := c.Get("advertiser"); ok {
+		id := adv.(model.Advertiser).Id
+		request.AdvertiserId = &id
+	}
+	if client, ok := c.Get("client"); ok {
+		id := client.(model.Client).Id
+		request.ClientId = &id
+	}
+	if len(c.Errors) > 0 {
+		messages := make([]string, len(c.Errors))
+		for i, err := range c.Errors {
+			messages[i] = err.Error()
+		}
+		message := strings.Join(messages, "; ")
+		request.Error = &message
+	}
+	m.apiSvc.LogRequest(request)
 }
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"backend/config"
+	"context"
+	"github.com/gin-gonic/gin"
+	"strings"
+	"time"
+)
+
+type TimeoutMiddleware struct {
+	timeouts config.Timeouts
+}
+
+func NewTimeoutMiddleware(timeouts config.Timeouts) *TimeoutMiddleware {
+	return &TimeoutMiddleware{timeouts}
+}
+
+// Callback sets a deadline on the request context, chosen by the matched route.
+// The request context is also cancelled when the client disconnects,
+// so handlers passing it down to services abort the DB queries in both cases.
+func (m *TimeoutMiddleware) Callback(c *gin.Context) {
+	timeout := m.timeoutFor(c.FullPath())
+	if timeout == 0 {
+		c.Next()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+func (m *TimeoutMiddleware) timeoutFor(path string) time.Duration {
+	switch {
+	case strings.HasPrefix(path, "/ads"):
+		return m.timeouts.Ads
+	case strings.HasPrefix(path, "/stats/"):
+		return m.timeouts.Stats
+	case strings.HasSuffix(path, "/image"):
+		return m.timeouts.Upload
+	default:
+		return m.timeouts.Default
+	}
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ApiRequest is an entry of the requests log.
+type ApiRequest struct {
+	CreatedAt time.Time `db:"created_at"`
+	// Endpoint is the method and the route pattern, like "GET /stats/campaigns/:campaignId".
+	Endpoint     string     `db:"endpoint"`
+	DurationMs   float64    `db:"duration_ms"`
+	Status       int        `db:"status"`
+	ResponseSize int        `db:"response_size"`
+	AdvertiserId *uuid.UUID `db:"advertiser_id"`
+	CampaignId   *uuid.UUID `db:"campaign_id"`
+	ClientId     *uuid.UUID `db:"client_id"`
+	// Error is the message of errors attached to the request by handlers, if any.
+	Error *string `db:"error"`
+	// SampleRate is the probability with which requests like this one are logged,
+	// so each entry stands for 1/SampleRate requests.
+	SampleRate float64 `db:"sample_rate"`
+}
@@ -12,6 +12,22 @@ type CampaignTargeting struct {
 	Location *string `json:"location" db:"targeting_location"`
 }
 
+// Matches reports whether the client is in the target audience: each specified criterion must match.
+// A client without age does not match a targeting by age.
+func (t CampaignTargeting) Matches(client Client) bool {
+	switch {
+	case t.Gender != nil && *t.Gender != "ALL" && *t.Gender != client.Gender:
+		return false
+	case t.AgeFrom != nil && (client.Age == nil || *client.Age < *t.AgeFrom):
+		return false
+	case t.AgeTo != nil && (client.Age == nil || *client.Age > *t.AgeTo):
+		return false
+	case t.Location != nil && *t.Location != client.Location:
+		return false
+	}
+	return true
+}
+
 type CampaignCreateRequest struct {
 	ImpressionsLimit  *int     `json:"impressions_limit" db:"impressions_limit" binding:"required,gte=0"`
 	ClicksLimit       *int     `json:"clicks_limit" db:"clicks_limit" binding:"required,gte=0"`
@@ -32,6 +48,8 @@ type Campaign struct {
 	ImagePath        string              `json:"image_path" db:"image_path"`
 	ModerationTaskId *uuid.UUID          `json:"-" db:"moderation_task_id"`
 	ModerationResult *AiModerationResult `json:"moderation_result" db:"moderation_result"`
+	// Version is incremented on each update and is exposed to API clients through ETag header.
+	Version int `json:"-" db:"version"`
 }
 
 type GetCampaignsRequest struct {
@@ -47,6 +65,8 @@ type CampaignStats struct {
 	SpentClicks      float64 `json:"spent_clicks" db:"spent_clicks"`
 	SpentTotal       float64 `json:"spent_total" db:"spent_total"`
 	Date             *int    `json:"date,omitempty" db:"date"`
+	// NonBillableClicksCount is the number of rejected clicks, which are not included in ClicksCount and spent.
+	NonBillableClicksCount int `json:"non_billable_clicks_count" db:"non_billable_clicks_count"`
 }
 
 type Ad struct {
@@ -70,11 +90,26 @@ type AdCandidate struct {
 	Clicked           bool    `json:"clicked" db:"clicked"`
 }
 
+// ActiveCampaign is a campaign which is active on the current date, with the counts of its impressions and clicks.
+// Ad candidates of a client are selected from active campaigns by CampaignTargeting.Matches and the limits;
+// the fields of AdCandidate which depend on the client are not set.
+type ActiveCampaign struct {
+	AdCandidate
+	CampaignTargeting
+}
+
+// ViewedAd is a campaign the ad of which the client has viewed, and possibly clicked.
+type ViewedAd struct {
+	CampaignId uuid.UUID `db:"campaign_id"`
+	Clicked    bool      `db:"clicked"`
+}
+
 type AdImpression struct {
 	ClientId   uuid.UUID `json:"client_id" db:"client_id"`
 	CampaignId uuid.UUID `json:"campaign_id" db:"campaign_id"`
 	Spent      float64   `json:"spent" db:"spent"`
 	Date       int       `json:"date" db:"date"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
 
 type AdClick struct {
@@ -82,4 +117,57 @@ type AdClick struct {
 	CampaignId uuid.UUID `json:"campaign_id" db:"campaign_id"`
 	Spent      float64   `json:"spent" db:"spent"`
 	Date       int       `json:"date" db:"date"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	// Source is the IP address the click was made from.
+	Source string `json:"source" db:"source"`
+}
+
+// Reasons of rejecting a click, which are also the codes of API errors.
+const (
+	ClickRejectedNotViewed          = "ad_not_viewed"
+	ClickRejectedCampaignNotActive  = "campaign_not_active"
+	ClickRejectedAttributionExpired = "attribution_window_expired"
+	ClickRejectedLimitReached       = "clicks_limit_reached"
+)
+
+// Reasons of flagging a click as invalid traffic. Such clicks are accepted by the API, so that bots do not learn
+// about the detection, but are recorded as non-billable.
+const (
+	ClickInvalidTooSoon      = "click_too_soon"
+	ClickInvalidRateSpike    = "click_rate_spike"
+	ClickInvalidSharedSource = "shared_source"
+)
+
+var InvalidTrafficReasons = []string{ClickInvalidTooSoon, ClickInvalidRateSpike, ClickInvalidSharedSource}
+
+// NonBillableClick is a click which was rejected for Reason. It is counted in stats, but is not charged.
+type NonBillableClick struct {
+	ClientId   uuid.UUID `json:"client_id" db:"client_id"`
+	CampaignId uuid.UUID `json:"campaign_id" db:"campaign_id"`
+	Reason     string    `json:"reason" db:"reason"`
+	Date       int       `json:"date" db:"date"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	Source     string    `json:"source" db:"source"`
+}
+
+// ClickActivityQuery selects recent clicks for detection of invalid traffic.
+type ClickActivityQuery struct {
+	ClientId uuid.UUID
+	// Source is the address the click is made from. If it is unknown, other clients are not counted.
+	Source string
+	// WindowStart is the start of the period of recent clicks.
+	WindowStart time.Time
+	// HistoryStart is the start of the period before WindowStart, which the usual activity of the client is taken from.
+	HistoryStart time.Time
+}
+
+// ClickActivity counts billable and non-billable clicks selected by ClickActivityQuery.
+type ClickActivity struct {
+	// ClientClicks is the number of clicks of the client since WindowStart.
+	ClientClicks int `db:"client_clicks"`
+	// ClientHistoryClicks is the number of clicks of the client between HistoryStart and WindowStart.
+	ClientHistoryClicks int `db:"client_history_clicks"`
+	// SourceClients is the number of other clients which have clicked from the source since WindowStart,
+	// or zero if the source is unknown.
+	SourceClients int `db:"source_clients"`
 }
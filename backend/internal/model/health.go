@@ -0,0 +1,22 @@
+package model
+
+// Statuses of readiness of the backend and of its components.
+const (
+	HealthOk = "ok"
+	// HealthDegraded means that only non-critical components failed, so the backend is still ready.
+	HealthDegraded = "degraded"
+	HealthFail     = "fail"
+)
+
+type Health struct {
+	Status     string                     `json:"status" enums:"ok,degraded,fail"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+type ComponentHealth struct {
+	Status string `json:"status" enums:"ok,fail"`
+	// Critical components make the backend not ready when they fail.
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
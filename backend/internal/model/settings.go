@@ -3,4 +3,6 @@ package model
 type Settings struct {
 	CurrentDate       int  `db:"current_date"`
 	ModerationEnabled bool `db:"moderation_enabled"`
+	// Sandbox is set while a snapshot of the data is kept, see repo.Sandbox
+	Sandbox bool `db:"sandbox"`
 }
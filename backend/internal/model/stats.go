@@ -0,0 +1,122 @@
+package model
+
+import "github.com/google/uuid"
+
+// Dimensions which stats can be grouped by.
+const (
+	StatsByDate      = "date"
+	StatsByCampaign  = "campaign"
+	StatsByGender    = "gender"
+	StatsByAgeBucket = "age_bucket"
+	StatsByLocation  = "location"
+	StatsByCreative  = "creative"
+)
+
+var StatsDimensions = []string{StatsByDate, StatsByCampaign, StatsByGender, StatsByAgeBucket, StatsByLocation, StatsByCreative}
+
+// StatsPeriod limits stats to the days between From and To, both inclusive. Nil bounds are open.
+type StatsPeriod struct {
+	From *int `form:"from" binding:"omitempty,gte=0"`
+	To   *int `form:"to" binding:"omitempty,gte=0"`
+}
+
+type StatsRequest struct {
+	StatsPeriod
+	// GroupBy lists dimensions from StatsDimensions, as repeated or comma-separated parameters.
+	GroupBy []string `form:"group_by"`
+}
+
+// Sort keys of campaigns in the stats breakdown.
+const (
+	StatsSortSpentTotal       = "spent_total"
+	StatsSortConversion       = "conversion"
+	StatsSortImpressionsCount = "impressions_count"
+)
+
+type StatsBreakdownRequest struct {
+	StatsPeriod
+	SortBy string `form:"sort_by" binding:"omitempty,oneof=spent_total conversion impressions_count"`
+	Order  string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Size   int    `form:"size" binding:"gte=0"`
+	Page   int    `form:"page" binding:"gte=0"`
+}
+
+// StatsFilter selects rows of stats. If CampaignId is uuid.Nil, all campaigns of the advertiser are selected.
+type StatsFilter struct {
+	AdvertiserId uuid.UUID
+	CampaignId   uuid.UUID
+	StatsPeriod
+	// ByClient requests rows split by gender, age and location of clients, which is slower.
+	ByClient bool
+}
+
+// StatsRow is impressions and clicks of a campaign on a single day, pre-aggregated by the repository.
+// Client fields are set only if StatsFilter.ByClient is set.
+type StatsRow struct {
+	CampaignId             uuid.UUID `db:"campaign_id"`
+	Date                   int       `db:"date"`
+	Gender                 *string   `db:"gender"`
+	Age                    *int      `db:"age"`
+	Location               *string   `db:"location"`
+	AdTitle                string    `db:"ad_title"`
+	AdText                 string    `db:"ad_text"`
+	ImagePath              string    `db:"image_path"`
+	ImpressionsCount       int       `db:"impressions_count"`
+	ClicksCount            int       `db:"clicks_count"`
+	NonBillableClicksCount int       `db:"non_billable_clicks_count"`
+	SpentImpressions       float64   `db:"spent_impressions"`
+	SpentClicks            float64   `db:"spent_clicks"`
+}
+
+// Creative is the ad shown by a campaign. Campaigns with equal ads share the creative.
+type Creative struct {
+	AdTitle   string `json:"ad_title"`
+	AdText    string `json:"ad_text"`
+	ImagePath string `json:"image_path,omitempty"`
+}
+
+// StatsGroup is stats of a group; only the fields of requested dimensions are set.
+type StatsGroup struct {
+	CampaignId *uuid.UUID `json:"campaign_id,omitempty"`
+	Gender     *string    `json:"gender,omitempty"`
+	AgeBucket  *string    `json:"age_bucket,omitempty" example:"25-34"`
+	Location   *string    `json:"location,omitempty"`
+	Creative   *Creative  `json:"creative,omitempty"`
+	CampaignStats
+}
+
+type CampaignStatsItem struct {
+	CampaignId uuid.UUID `json:"campaign_id"`
+	AdTitle    string    `json:"ad_title"`
+	CampaignStats
+}
+
+// StatsBreakdown is stats of all campaigns of an advertiser together with a page of stats of each campaign.
+type StatsBreakdown struct {
+	Total     CampaignStats       `json:"total"`
+	Campaigns []CampaignStatsItem `json:"campaigns"`
+	// CampaignsCount is the number of campaigns on all pages.
+	CampaignsCount int `json:"campaigns_count"`
+}
+
+type InvalidTrafficRequest struct {
+	StatsPeriod
+	Size int `form:"size" binding:"gte=0"`
+	Page int `form:"page" binding:"gte=0"`
+}
+
+// NonBillableClicksFilter selects non-billable clicks of a campaign made for any of Reasons in the period.
+type NonBillableClicksFilter struct {
+	CampaignId uuid.UUID
+	Reasons    []string
+	StatsPeriod
+}
+
+// InvalidTrafficReport lists clicks of a campaign flagged as invalid traffic, the most recent first.
+type InvalidTrafficReport struct {
+	// Total is the number of flagged clicks on all pages.
+	Total int `json:"total"`
+	// ByReason counts flagged clicks on all pages by the reason.
+	ByReason map[string]int     `json:"by_reason"`
+	Clicks   []NonBillableClick `json:"clicks"`
+}
@@ -3,3 +3,7 @@ package model
 type CurrentDate struct {
 	CurrentDate *int `json:"current_date" binding:"required,gte=0"`
 }
+
+type Sandbox struct {
+	Active bool `json:"active"`
+}
@@ -0,0 +1,24 @@
+package repo
+
+import (
+	"errors"
+)
+
+// ErrLimitReached is returned when an impression or a click would exceed the limit of the campaign.
+var ErrLimitReached = errors.New("campaign limit reached")
+
+func IsLimitReached(err error) bool {
+	return errors.Is(err, ErrLimitReached)
+}
+
+// WithinLimit reports whether one more event fits in the limit, given count events recorded before.
+// The limit may be exceeded by limitsThreshold - 1 of it (1.04 allows 4% more events); zero limit allows no events.
+func WithinLimit(count, limit int, limitsThreshold float64) bool {
+	return limit > 0 && float64(count+1)/float64(limit) <= limitsThreshold
+}
+
+// ClicksExhausted reports whether the campaign has got all clicks it pays for, so that it is not shown anymore.
+// Campaigns with zero clicks limit pay only for impressions, so they are shown until the impressions limit is reached.
+func ClicksExhausted(count, limit int, limitsThreshold float64) bool {
+	return limit > 0 && !WithinLimit(count, limit, limitsThreshold)
+}
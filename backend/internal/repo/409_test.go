@@ -0,0 +1,51 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestIsLimitReached(t *testing.T) {
+	if !IsLimitReached(ErrLimitReached) {
+		t.Errorf("IsLimitReached() = false, want true")
+	}
+	if IsLimitReached(nil) {
+		t.Errorf("IsLimitReached() = true, want false")
+	}
+	if IsLimitReached(ErrNotFound) {
+		t.Errorf("IsLimitReached() = true, want false")
+	}
+}
+
+func TestWithinLimit(t *testing.T) {
+	type testCase struct {
+		count, limit int
+		threshold    float64
+		want         bool
+	}
+	tests := []testCase{
+		{0, 1, 1, true},
+		{1, 1, 1, false},
+		{9, 10, 1, true},
+		{10, 10, 1, false},
+		{10, 10, 1.1, true},
+		{11, 10, 1.1, false},
+		{0, 0, 2, false},
+	}
+	for _, tt := range tests {
+		if got := WithinLimit(tt.count, tt.limit, tt.threshold); got != tt.want {
+			t.Errorf("WithinLimit(%d, %d, %v) = %v, want %v", tt.count, tt.limit, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestClicksExhausted(t *testing.T) {
+	if !ClicksExhausted(3, 3, 1) {
+		t.Errorf("ClicksExhausted(3, 3, 1) = false, want true")
+	}
+	if ClicksExhausted(2, 3, 1) {
+		t.Errorf("ClicksExhausted(2, 3, 1) = true, want false")
+	}
+	if ClicksExhausted(0, 0, 1) {
+		t.Errorf("ClicksExhausted(0, 0, 1) = true, want false")
+	}
+}
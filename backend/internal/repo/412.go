@@ -0,0 +1,11 @@
+package repo
+
+import (
+	"errors"
+)
+
+var ErrVersionConflict = errors.New("entity version does not match")
+
+func IsVersionConflict(err error) bool {
+	return errors.Is(err, ErrVersionConflict)
+}
@@ -0,0 +1,17 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestIsVersionConflict(t *testing.T) {
+	if !IsVersionConflict(ErrVersionConflict) {
+		t.Errorf("IsVersionConflict() = false, want true")
+	}
+	if IsVersionConflict(nil) {
+		t.Errorf("IsVersionConflict() = true, want false")
+	}
+	if IsVersionConflict(ErrNotFound) {
+		t.Errorf("IsVersionConflict() = true, want false")
+	}
+}
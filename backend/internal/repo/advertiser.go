@@ -2,29 +2,29 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
 type AdvertiserRepo struct {
-	db *sqlx.DB
+	db *DB
 }
 
-func (r *AdvertiserRepo) GetById(id uuid.UUID) (adv model.Advertiser, err error) {
-	err = r.db.Get(&adv, "SELECT * FROM advertisers WHERE id = $1", id)
+func (r *AdvertiserRepo) GetById(ctx context.Context, id uuid.UUID) (adv model.Advertiser, err error) {
+	err = r.db.Reader(ctx).GetContext(ctx, &adv, "SELECT * FROM advertisers WHERE id = $1", id)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrNotFound
 	}
 	return
 }
 
-func (r *AdvertiserRepo) GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Advertiser, error) {
+func (r *AdvertiserRepo) GetMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Advertiser, error) {
 	var slice []model.Advertiser
-	if err := r.db.Select(&slice, "SELECT * FROM advertisers WHERE id = ANY($1::uuid[])", pq.Array(ids)); err != nil {
+	if err := r.db.Reader(ctx).SelectContext(ctx, &slice, "SELECT * FROM advertisers WHERE id = ANY($1::uuid[])", pq.Array(ids)); err != nil {
 		return nil, err
 	}
 
@@ -35,13 +35,13 @@ func (r *AdvertiserRepo) GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Advertise
 	return advs, nil
 }
 
-func (r *AdvertiserRepo) UpsertMany(advertisers []model.Advertiser) error {
-	tx, err := r.db.Beginx()
+func (r *AdvertiserRepo) UpsertMany(ctx context.Context, advertisers []model.Advertiser) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin transaction: %w", err)
 	}
 
-	stmt, err := tx.Prepare(`INSERT INTO advertisers (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = $2`)
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO advertisers (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = $2`)
 	if err != nil {
 		return fmt.Errorf("prepare statement: %w", err)
 	}
@@ -49,7 +49,7 @@ func (r *AdvertiserRepo) UpsertMany(advertisers []model.Advertiser) error {
 		_ = stmt.Close()
 	}(stmt)
 	for _, adv := range advertisers {
-		_, err = stmt.Exec(adv.Id, adv.Name)
+		_, err = stmt.ExecContext(ctx, adv.Id, adv.Name)
 		if err != nil {
 			return fmt.Errorf("exec statement: %w", err)
 		}
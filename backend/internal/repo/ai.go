@@ -2,6 +2,7 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/google/uuid"
@@ -12,35 +13,35 @@ type AiRepo struct {
 	db *sqlx.DB
 }
 
-func (r *AiRepo) AddTask(task model.AiTask) (err error) {
-	_, err = r.db.Exec(`INSERT INTO ai_tasks (id, created_at, type, prompt, "format") 
+func (r *AiRepo) AddTask(ctx context.Context, task model.AiTask) (err error) {
+	_, err = r.db.ExecContext(ctx, `INSERT INTO ai_tasks (id, created_at, type, prompt, "format") 
 		VALUES ($1, $2, $3, $4, $5)`,
 		task.Id, task.CreatedAt, task.Type, task.Prompt, task.Format)
 	return
 }
 
-func (r *AiRepo) GetTask(id uuid.UUID) (task model.AiTask, err error) {
-	err = r.db.Get(&task, `SELECT * FROM ai_tasks WHERE id = $1`, id)
+func (r *AiRepo) GetTask(ctx context.Context, id uuid.UUID) (task model.AiTask, err error) {
+	err = r.db.GetContext(ctx, &task, `SELECT * FROM ai_tasks WHERE id = $1`, id)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrNotFound
 	}
 	return
 }
 
-func (r *AiRepo) GetIncompleteTasks() ([]model.AiTask, error) {
+func (r *AiRepo) GetIncompleteTasks(ctx context.Context) ([]model.AiTask, error) {
 	tasks := make([]model.AiTask, 0)
-	err := r.db.Select(&tasks, `SELECT * FROM ai_tasks WHERE id NOT IN (SELECT task_id FROM ai_task_results)`)
+	err := r.db.SelectContext(ctx, &tasks, `SELECT * FROM ai_tasks WHERE id NOT IN (SELECT task_id FROM ai_task_results)`)
 	return tasks, err
 }
 
-func (r *AiRepo) AddResult(result model.AiTaskResult) (err error) {
-	_, err = r.db.Exec(`INSERT INTO ai_task_results (task_id, created_at, answer) VALUES ($1, $2, $3)`,
+func (r *AiRepo) AddResult(ctx context.Context, result model.AiTaskResult) (err error) {
+	_, err = r.db.ExecContext(ctx, `INSERT INTO ai_task_results (task_id, created_at, answer) VALUES ($1, $2, $3)`,
 		result.TaskId, result.CreatedAt, result.Answer)
 	return
 }
 
-func (r *AiRepo) GetResult(taskId uuid.UUID) (res model.AiTaskResult, err error) {
-	err = r.db.Get(&res, `SELECT * FROM ai_task_results WHERE task_id = $1`, taskId)
+func (r *AiRepo) GetResult(ctx context.Context, taskId uuid.UUID) (res model.AiTaskResult, err error) {
+	err = r.db.GetContext(ctx, &res, `SELECT * FROM ai_task_results WHERE task_id = $1`, taskId)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrNotFound
 	}
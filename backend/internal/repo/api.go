@@ -1,14 +1,29 @@
 package repo
 
 import (
+	"backend/internal/model"
+	"context"
 	"github.com/jmoiron/sqlx"
+	"time"
 )
 
 type ApiRepo struct {
 	db *sqlx.DB
 }
 
-func (r *ApiRepo) AddRequest(endpoint string, durationMs float64) error {
-	_, err := r.db.Exec(`INSERT INTO api_requests (endpoint, duration_ms) VALUES ($1, $2)`, endpoint, durationMs)
+func (r *ApiRepo) AddRequest(ctx context.Context, request model.ApiRequest) error {
+	_, err := r.db.NamedExecContext(ctx, `
+		INSERT INTO api_requests (created_at, endpoint, duration_ms, status, response_size,
+		                          advertiser_id, campaign_id, client_id, error, sample_rate)
+		VALUES (:created_at, :endpoint, :duration_ms, :status, :response_size,
+		        :advertiser_id, :campaign_id, :client_id, :error, :sample_rate)`, request)
 	return err
 }
+
+func (r *ApiRepo) DeleteRequestsBefore(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM api_requests WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
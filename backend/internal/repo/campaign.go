@@ -2,6 +2,7 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,217 +11,107 @@ import (
 )
 
 type CampaignRepo struct {
-	db *sqlx.DB
+	db *DB
 }
 
-func (r *CampaignRepo) Add(campaign model.Campaign) (err error) {
-	_, err = r.db.Exec(
+func (r *CampaignRepo) Add(ctx context.Context, campaign model.Campaign) (err error) {
+	_, err = r.db.ExecContext(ctx,
 		`INSERT INTO campaigns (id, advertiser_id, ad_title, ad_text, start_date, end_date, targeting_gender,
                        targeting_age_from, targeting_age_to, targeting_location, cost_per_impression, 
-                       impressions_limit, cost_per_click, clicks_limit, image_path, moderation_task_id) 
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+                       impressions_limit, cost_per_click, clicks_limit, image_path, moderation_task_id, version) 
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
 		campaign.Id, campaign.AdvertiserId, campaign.AdTitle, campaign.AdText, campaign.StartDate,
 		campaign.EndDate, campaign.CampaignTargeting.Gender, campaign.CampaignTargeting.AgeFrom,
 		campaign.CampaignTargeting.AgeTo, campaign.CampaignTargeting.Location, campaign.CostPerImpression,
 		campaign.ImpressionsLimit, campaign.CostPerClick, campaign.ClicksLimit, campaign.ImagePath,
-		campaign.ModerationTaskId,
+		campaign.ModerationTaskId, campaign.Version,
 	)
 	return
 }
 
-func (r *CampaignRepo) GetById(id uuid.UUID) (res model.Campaign, err error) {
-	err = r.db.Get(&res, `SELECT * FROM campaigns_moderation WHERE id = $1`, id)
+func (r *CampaignRepo) GetById(ctx context.Context, id uuid.UUID) (res model.Campaign, err error) {
+	err = r.db.Reader(ctx).GetContext(ctx, &res, `SELECT * FROM campaigns_moderation WHERE id = $1`, id)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrNotFound
 	}
 	return
 }
 
-func (r *CampaignRepo) GetList(advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error) {
+func (r *CampaignRepo) GetList(ctx context.Context, advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error) {
 	offset := (page - 1) * size
 	campaigns := make([]model.Campaign, 0)
-	err := r.db.Select(&campaigns,
+	err := r.db.Reader(ctx).SelectContext(ctx, &campaigns,
 		`SELECT * FROM campaigns_moderation WHERE advertiser_id = $1 ORDER BY created_at LIMIT $2 OFFSET $3`,
 		advertiserId, size, offset)
 	return campaigns, err
 }
 
-func (r *CampaignRepo) Update(campaign model.Campaign) error {
-	res, err := r.db.Exec(
+// Update overwrites the campaign if its version in the database equals to campaign.Version,
+// and increments the version. If the versions differ, ErrVersionConflict is returned.
+func (r *CampaignRepo) Update(ctx context.Context, campaign model.Campaign) error {
+	res, err := r.db.ExecContext(ctx,
 		`UPDATE campaigns SET ad_title = $1, ad_text = $2, start_date = $3, end_date = $4, 
 					   targeting_gender = $5, targeting_age_from = $6, targeting_age_to = $7, 
 					   targeting_location = $8, cost_per_impression = $9, impressions_limit = $10, 
-					   cost_per_click = $11, clicks_limit = $12, image_path = $13, moderation_task_id = $14
-				WHERE id = $15`,
+					   cost_per_click = $11, clicks_limit = $12, image_path = $13, moderation_task_id = $14,
+					   version = version + 1
+				WHERE id = $15 AND version = $16`,
 		campaign.AdTitle, campaign.AdText, campaign.StartDate, campaign.EndDate,
 		campaign.CampaignTargeting.Gender, campaign.CampaignTargeting.AgeFrom, campaign.CampaignTargeting.AgeTo,
 		campaign.CampaignTargeting.Location, campaign.CostPerImpression, campaign.ImpressionsLimit,
 		campaign.CostPerClick, campaign.ClicksLimit, campaign.ImagePath, campaign.ModerationTaskId, campaign.Id,
+		campaign.Version,
 	)
 	if err != nil {
 		return fmt.Errorf("run query: %w", err)
 	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("fetch affected rows: %w", err)
-	}
-	if affected == 0 {
-		return ErrNotFound
-	}
-	return nil
+	return r.checkAffected(ctx, res, campaign.Id)
 }
 
-func (r *CampaignRepo) Delete(id uuid.UUID) error {
-	res, err := r.db.Exec(`DELETE FROM campaigns WHERE id = $1`, id)
+func (r *CampaignRepo) SetModerationTask(ctx context.Context, id uuid.UUID, version int, taskId uuid.UUID) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE campaigns SET moderation_task_id = $1, version = version + 1 WHERE id = $2 AND version = $3`,
+		taskId, id, version)
 	if err != nil {
 		return fmt.Errorf("run query: %w", err)
 	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("fetch affected rows: %w", err)
-	}
-	if affected == 0 {
-		return ErrNotFound
-	}
-	return nil
+	return r.checkAffected(ctx, res, id)
 }
 
-// GetStats aggregates impressions and clicks over all time.
-// If campaignId is uuid.Nil, it is omitted from the query.
-func (r *CampaignRepo) GetStats(advertiserId uuid.UUID, campaignId uuid.UUID) (stats model.CampaignStats, err error) {
-	query := `SELECT
-    COUNT(ai.spent) AS impressions_count,
-    COUNT(ac.spent) AS clicks_count,
-    COALESCE(SUM(ai.spent), 0) AS spent_impressions,
-    COALESCE(SUM(ac.spent), 0) AS spent_clicks,
-    COALESCE(SUM(ai.spent), 0) + COALESCE(SUM(ac.spent), 0) AS spent_total
-FROM ad_impressions ai
-JOIN campaigns c on ai.campaign_id = c.id
-LEFT JOIN ad_clicks ac
-    ON ai.client_id = ac.client_id AND ai.campaign_id = ac.campaign_id
-WHERE c.advertiser_id = $1`
-	args := []any{advertiserId}
-
-	// I wanted to omit advertiserId when it equals uuid.Nil, but because their ids are set
-	// by the API caller (and it's possible to set an ID that equals to uuid.Nil), there is a rare case
-	// when the query will be incorrect.
-	if campaignId != uuid.Nil {
-		query += ` AND c.id = $2`
-		args = append(args, campaignId)
-	}
-
-	err = r.db.Get(&stats, query, args...)
-	if err == nil && stats.ImpressionsCount > 0 {
-		stats.Conversion = float64(stats.ClicksCount) / float64(stats.ImpressionsCount) * 100
+// Delete removes the campaign if its version in the database equals to version.
+// If the versions differ, ErrVersionConflict is returned.
+func (r *CampaignRepo) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM campaigns WHERE id = $1 AND version = $2`, id, version)
+	if err != nil {
+		return fmt.Errorf("run query: %w", err)
 	}
-	return
+	return r.checkAffected(ctx, res, id)
 }
 
-// GetStatsDaily aggregates impressions and clicks, grouped by each day.
-// If campaignId is uuid.Nil, it is omitted from the query.
-func (r *CampaignRepo) GetStatsDaily(advertiserId uuid.UUID, campaignId uuid.UUID) ([]model.CampaignStats, error) {
-	where := "c.advertiser_id = $1"
-	args := []any{advertiserId}
-	if campaignId != uuid.Nil {
-		where += ` AND c.id = $2`
-		args = append(args, campaignId)
-	}
-
-	var impressions []model.CampaignStats
-	err := r.db.Select(&impressions, fmt.Sprintf(`
-SELECT
-	COUNT(spent) AS impressions_count,
-	COALESCE(SUM(spent), 0) AS spent_impressions,
-	date
-FROM ad_impressions
-JOIN campaigns c on campaign_id = c.id
-WHERE %s
-GROUP BY date
-ORDER BY date ASC
-`, where), args...)
+// checkAffected tells apart a missing campaign from a version conflict when a conditional
+// statement has not affected any rows.
+func (r *CampaignRepo) checkAffected(ctx context.Context, res sql.Result, id uuid.UUID) error {
+	affected, err := res.RowsAffected()
 	if err != nil {
-		return nil, fmt.Errorf("get impressions stats: %w", err)
+		return fmt.Errorf("fetch affected rows: %w", err)
 	}
-
-	var clicks []model.CampaignStats
-	err = r.db.Select(&clicks, fmt.Sprintf(`
-SELECT
-	COUNT(spent) AS clicks_count,
-	COALESCE(SUM(spent), 0) AS spent_clicks,
-	date
-FROM ad_clicks
-JOIN campaigns c on campaign_id = c.id
-WHERE %s
-GROUP BY date
-ORDER BY date ASC
-`, where), args...)
-	if err != nil {
-		return nil, fmt.Errorf("get clicks stats: %w", err)
+	if affected > 0 {
+		return nil
 	}
 
-	// Two-pointer approach to merge impressions and clicks
-
-	stats := make([]model.CampaignStats, 0, len(impressions))
-	for i, j := 0, 0; i < len(impressions) || j < len(clicks); {
-		if j == len(clicks) || *impressions[i].Date < *clicks[j].Date {
-			// clicks exhausted or ai.date < ac.date
-			// => N impressions, 0 clicks for this date
-
-			stats = append(stats, model.CampaignStats{
-				ImpressionsCount: impressions[i].ImpressionsCount,
-				ClicksCount:      0,
-				Conversion:       0,
-				SpentImpressions: impressions[i].SpentImpressions,
-				SpentClicks:      0,
-				SpentTotal:       impressions[i].SpentImpressions,
-				Date:             impressions[i].Date,
-			})
-			i++
-		} else if i == len(impressions) || *clicks[j].Date < *impressions[i].Date {
-			// impressions exhausted or ai.date > ac.date
-			// => 0 impressions, N clicks for this date
-
-			stats = append(stats, model.CampaignStats{
-				ImpressionsCount: 0,
-				ClicksCount:      clicks[j].ClicksCount,
-				Conversion:       0,
-				SpentImpressions: 0,
-				SpentClicks:      clicks[j].SpentClicks,
-				SpentTotal:       clicks[j].SpentClicks,
-				Date:             clicks[j].Date,
-			})
-		} else {
-			// ai.date == ac.date
-			if *impressions[i].Date != *clicks[j].Date {
-				panic("assert failed: impression.Date != click.Date")
-			}
-
-			conversion := 0.0
-			if impressions[i].ImpressionsCount != 0 {
-				conversion = float64(clicks[j].ClicksCount) / float64(impressions[i].ImpressionsCount) * 100
-			}
-
-			stats = append(stats, model.CampaignStats{
-				ImpressionsCount: impressions[i].ImpressionsCount,
-				ClicksCount:      clicks[j].ClicksCount,
-				Conversion:       conversion,
-				SpentImpressions: impressions[i].SpentImpressions,
-				SpentClicks:      clicks[j].SpentClicks,
-				SpentTotal:       impressions[i].SpentImpressions + clicks[j].SpentClicks,
-				Date:             impressions[i].Date,
-			})
-			i++
-			j++
-		}
+	var exists bool
+	if err := r.db.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM campaigns WHERE id = $1)`, id); err != nil {
+		return fmt.Errorf("check existence: %w", err)
 	}
-
-	return stats, nil
+	if exists {
+		return ErrVersionConflict
+	}
+	return ErrNotFound
 }
 
-func (r *CampaignRepo) GetModerationFailed(size int, page int) ([]model.Campaign, error) {
+func (r *CampaignRepo) GetModerationFailed(ctx context.Context, size int, page int) ([]model.Campaign, error) {
 	offset := (page - 1) * size
 	campaigns := make([]model.Campaign, 0)
-	err := r.db.Select(&campaigns,
+	err := r.db.Reader(ctx).SelectContext(ctx, &campaigns,
 		`SELECT * FROM campaigns_moderation WHERE moderation_result->>'acceptable' = 'false'
             ORDER BY created_at LIMIT $1 OFFSET $2`, size, offset)
 	return campaigns, err
@@ -229,83 +120,280 @@ func (r *CampaignRepo) GetModerationFailed(size int, page int) ([]model.Campaign
 // GetAdCandidates fetches campaigns that could be a candidate for an ad for the specified client.
 // The following criteria are applied:
 // 1. The current date must be between campaign's start_date and end_date, inclusive.
-// 2. The campaign must not exceed impressions_limit or clicks_limit.
+// 2. The campaign must not exceed impressions_limit or a positive clicks_limit, see ClicksExhausted.
 // 3. If campaign has targeting by gender, the gender should match.
 // 4. If campaign has targeting by age_from, the client age must be greater than or equal to it.
 // 5. If campaign has targeting by age_to, the client age must be less than or equal to it.
 // 6. If campaign has targeting by location, the client location must match.
 // It includes data from ml_scores, ad_impressions and ad_clicks tables as described in model.AdCandidate.
 // The result is ordered by the date of creation in ascending order.
-func (r *CampaignRepo) GetAdCandidates(clientId uuid.UUID, limitsThreshold float64) ([]model.AdCandidate, error) {
+func (r *CampaignRepo) GetAdCandidates(ctx context.Context, clientId uuid.UUID, limitsThreshold float64) ([]model.AdCandidate, error) {
 	query := `
 SELECT
-    ad_id, ad_title, ad_text, ms.advertiser_id, image_path,
-    cost_per_impression, impressions_count, impressions_limit, viewed,
-    cost_per_click, clicks_count, clicks_limit, clicked,
-    COALESCE(ms.score, 0) ml_score
-FROM (
-    SELECT
-        c.id AS ad_id,
-        c.created_at,
-        c.ad_title,
-        c.ad_text,
-        c.advertiser_id,
-        c.image_path,
-        c.cost_per_impression,
-        COUNT(ai.spent) AS impressions_count,
-        c.impressions_limit,
-        MAX(CASE WHEN ai.client_id = $1 THEN 1 ELSE 0 END) AS viewed,
-        c.cost_per_click,
-        COUNT(ac.spent) AS clicks_count,
-        c.clicks_limit,
-        MAX(CASE WHEN ac.client_id = $1 THEN 1 ELSE 0 END) AS clicked
-    FROM
-        campaigns c
-            CROSS JOIN (SELECT "current_date" FROM settings) s
-            CROSS JOIN (SELECT gender, age, location FROM clients WHERE id = $1) cl
-            LEFT JOIN ad_impressions ai ON c.id = ai.campaign_id
-            LEFT JOIN ad_clicks ac ON c.id = ac.campaign_id AND ai.client_id = ac.client_id
-    WHERE
-        (c.start_date <= s."current_date" AND s."current_date" <= c.end_date)
-      AND (c.targeting_gender = 'ALL' OR c.targeting_gender IS NULL OR c.targeting_gender::TEXT = cl.gender::TEXT)
-      AND (c.targeting_age_from IS NULL OR cl.age >= c.targeting_age_from)
-      AND (c.targeting_age_to IS NULL OR cl.age <= c.targeting_age_to)
-      AND (c.targeting_location IS NULL OR cl.location = c.targeting_location)
-    GROUP BY c.id
-    ) as cc
-LEFT JOIN ml_scores ms ON cc.advertiser_id = ms.advertiser_id AND ms.client_id = $1
+    c.id AS ad_id, c.ad_title, c.ad_text, c.advertiser_id, c.image_path,
+    c.cost_per_impression, c.impressions_count, c.impressions_limit,
+    EXISTS(SELECT 1 FROM ad_impressions ai WHERE ai.client_id = $1 AND ai.campaign_id = c.id) AS viewed,
+    c.cost_per_click, c.clicks_count, c.clicks_limit,
+    EXISTS(SELECT 1 FROM ad_clicks ac WHERE ac.client_id = $1 AND ac.campaign_id = c.id) AS clicked,
+    COALESCE(ms.score, 0) AS ml_score
+FROM
+    campaigns c
+        CROSS JOIN (SELECT "current_date" FROM settings) s
+        CROSS JOIN (SELECT gender, age, location FROM clients WHERE id = $1) cl
+        LEFT JOIN ml_scores ms ON ms.advertiser_id = c.advertiser_id AND ms.client_id = $1
 WHERE
-	(cc.impressions_limit > 0 AND ((cc.impressions_count::float + 1) / cc.impressions_limit::float) <= $2)
-ORDER BY cc.created_at
+    (c.start_date <= s."current_date" AND s."current_date" <= c.end_date)
+  AND (c.targeting_gender = 'ALL' OR c.targeting_gender IS NULL OR c.targeting_gender::TEXT = cl.gender::TEXT)
+  AND (c.targeting_age_from IS NULL OR cl.age >= c.targeting_age_from)
+  AND (c.targeting_age_to IS NULL OR cl.age <= c.targeting_age_to)
+  AND (c.targeting_location IS NULL OR cl.location = c.targeting_location)
+  AND (c.impressions_limit > 0 AND ((c.impressions_count::float + 1) / c.impressions_limit::float) <= $2)
+  AND (c.clicks_limit = 0 OR ((c.clicks_count::float + 1) / c.clicks_limit::float) <= $2)
+ORDER BY c.created_at
 `
 
 	campaigns := make([]model.AdCandidate, 0)
-	err := r.db.Select(&campaigns, query, clientId, limitsThreshold)
+	// the counts are checked against the limits and feed the reservations, so they are read from the primary
+	err := r.db.SelectContext(ctx, &campaigns, query, clientId, limitsThreshold)
 	return campaigns, err
 }
 
-// AddAdImpression adds a record that the ad was viewed.
-// This function is idempotent - if the record already exists, no error is returned.
-func (r *CampaignRepo) AddAdImpression(impression model.AdImpression) error {
-	_, err := r.db.Exec(`INSERT INTO ad_impressions (client_id, campaign_id, spent, date)
-						VALUES ($1, $2, $3, $4) ON CONFLICT (client_id, campaign_id) DO NOTHING`,
-		impression.ClientId, impression.CampaignId, impression.Spent, impression.Date)
-	return err
+// GetActiveCampaigns fetches campaigns which are active on the date, i.e. the date is between their start_date
+// and end_date inclusive, with the counts of their impressions and clicks.
+// The result is ordered by the date of creation in ascending order.
+func (r *CampaignRepo) GetActiveCampaigns(ctx context.Context, date int) ([]model.ActiveCampaign, error) {
+	query := `
+SELECT
+    c.id AS ad_id, c.ad_title, c.ad_text, c.advertiser_id, c.image_path,
+    c.cost_per_impression, c.impressions_limit, c.cost_per_click, c.clicks_limit,
+    c.targeting_gender, c.targeting_age_from, c.targeting_age_to, c.targeting_location,
+    c.impressions_count, c.clicks_count
+FROM campaigns c
+WHERE c.start_date <= $1 AND $1 <= c.end_date
+ORDER BY c.created_at
+`
+
+	campaigns := make([]model.ActiveCampaign, 0)
+	// the counts are checked against the limits, so they are read from the primary
+	err := r.db.SelectContext(ctx, &campaigns, query, date)
+	return campaigns, err
+}
+
+// GetViewedAds fetches campaigns the ads of which the client has viewed, in no particular order.
+func (r *CampaignRepo) GetViewedAds(ctx context.Context, clientId uuid.UUID) ([]model.ViewedAd, error) {
+	query := `
+SELECT ai.campaign_id, ac.client_id IS NOT NULL AS clicked
+FROM ad_impressions ai
+    LEFT JOIN ad_clicks ac ON ac.client_id = ai.client_id AND ac.campaign_id = ai.campaign_id
+WHERE ai.client_id = $1
+`
+
+	viewed := make([]model.ViewedAd, 0)
+	err := r.db.SelectContext(ctx, &viewed, query, clientId)
+	return viewed, err
 }
 
-func (r *CampaignRepo) GetAdImpression(clientId uuid.UUID, campaignId uuid.UUID) (res model.AdImpression, err error) {
-	err = r.db.Get(&res, `SELECT * FROM ad_impressions WHERE client_id = $1 AND campaign_id = $2`, clientId, campaignId)
+// AddAdImpression adds a record that the ad was viewed and counts it in campaign_stats_daily.
+// If the impression would exceed the impressions limit with limitsThreshold tolerance, ErrLimitReached is returned.
+// This function is idempotent - if the record already exists, no error is returned and nothing is counted.
+func (r *CampaignRepo) AddAdImpression(ctx context.Context, impression model.AdImpression, limitsThreshold float64) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		return addEvent(ctx, tx, "impressions", impression.ClientId, impression.CampaignId, limitsThreshold, `WITH inserted AS (
+    INSERT INTO ad_impressions (client_id, campaign_id, spent, date, created_at)
+    VALUES ($1, $2, $3, $4, $5) ON CONFLICT (client_id, campaign_id) DO NOTHING
+    RETURNING campaign_id, date, spent
+)
+INSERT INTO campaign_stats_daily (campaign_id, date, impressions_count, spent_impressions)
+SELECT campaign_id, date, 1, spent FROM inserted
+ON CONFLICT (campaign_id, date) DO UPDATE SET
+    impressions_count = campaign_stats_daily.impressions_count + 1,
+    spent_impressions = campaign_stats_daily.spent_impressions + EXCLUDED.spent_impressions`,
+			impression.ClientId, impression.CampaignId, impression.Spent, impression.Date, impression.CreatedAt)
+	})
+}
+
+func (r *CampaignRepo) GetAdImpression(ctx context.Context, clientId uuid.UUID, campaignId uuid.UUID) (res model.AdImpression, err error) {
+	err = r.db.GetContext(ctx, &res, `SELECT * FROM ad_impressions WHERE client_id = $1 AND campaign_id = $2`, clientId, campaignId)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrNotFound
 	}
 	return
 }
 
-// AddAdClick adds a record that the ad was clicked.
-// This function is idempotent - if the record already exists, no error is returned.
-func (r *CampaignRepo) AddAdClick(click model.AdClick) error {
-	_, err := r.db.Exec(`INSERT INTO ad_clicks (client_id, campaign_id, spent, date)
-						VALUES ($1, $2, $3, $4) ON CONFLICT (client_id, campaign_id) DO NOTHING`,
-		click.ClientId, click.CampaignId, click.Spent, click.Date)
+// AddAdClick adds a record that the ad was clicked and counts it in campaign_stats_daily.
+// If the click would exceed the clicks limit with limitsThreshold tolerance, ErrLimitReached is returned.
+// This function is idempotent - if the record already exists, no error is returned and nothing is counted.
+func (r *CampaignRepo) AddAdClick(ctx context.Context, click model.AdClick, limitsThreshold float64) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		return addAdClick(ctx, tx, click, limitsThreshold)
+	})
+}
+
+// AddAdClickWithDetection serializes clicks of the client and clicks from the source by advisory locks, which are
+// held until the click is recorded, so that the activity includes clicks made concurrently through other instances.
+func (r *CampaignRepo) AddAdClickWithDetection(ctx context.Context, click model.AdClick, limitsThreshold float64,
+	query model.ClickActivityQuery, detect func(model.ClickActivity) string) (reason string, err error) {
+	err = r.inTx(ctx, func(tx *sqlx.Tx) error {
+		// the locks are always taken in the same order, so that concurrent clicks do not deadlock;
+		// clicks without a source are not serialized with each other, as they are not checked by the source
+		keys := []string{"click_client:" + click.ClientId.String()}
+		if click.Source != "" {
+			keys = append(keys, "click_source:"+click.Source)
+		}
+		for _, key := range keys {
+			if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+				return fmt.Errorf("lock %s: %w", key, err)
+			}
+		}
+		var exists bool
+		err := tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM ad_clicks WHERE client_id = $1 AND campaign_id = $2)`,
+			click.ClientId, click.CampaignId)
+		if err != nil {
+			return fmt.Errorf("check existence: %w", err)
+		}
+		if exists {
+			return nil
+		}
+
+		activity, err := getClickActivity(ctx, tx, query)
+		if err != nil {
+			return fmt.Errorf("get click activity: %w", err)
+		}
+		if reason = detect(activity); reason == "" {
+			return addAdClick(ctx, tx, click, limitsThreshold)
+		}
+		return addNonBillableClick(ctx, tx, model.NonBillableClick{
+			ClientId:   click.ClientId,
+			CampaignId: click.CampaignId,
+			Reason:     reason,
+			Date:       click.Date,
+			CreatedAt:  click.CreatedAt,
+			Source:     click.Source,
+		})
+	})
+	return reason, err
+}
+
+func addAdClick(ctx context.Context, tx *sqlx.Tx, click model.AdClick, limitsThreshold float64) error {
+	return addEvent(ctx, tx, "clicks", click.ClientId, click.CampaignId, limitsThreshold, `WITH inserted AS (
+    INSERT INTO ad_clicks (client_id, campaign_id, spent, date, created_at, source)
+    VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (client_id, campaign_id) DO NOTHING
+    RETURNING campaign_id, date, spent
+)
+INSERT INTO campaign_stats_daily (campaign_id, date, clicks_count, spent_clicks)
+SELECT campaign_id, date, 1, spent FROM inserted
+ON CONFLICT (campaign_id, date) DO UPDATE SET
+    clicks_count = campaign_stats_daily.clicks_count + 1,
+    spent_clicks = campaign_stats_daily.spent_clicks + EXCLUDED.spent_clicks`,
+		click.ClientId, click.CampaignId, click.Spent, click.Date, click.CreatedAt, click.Source)
+}
+
+// AddNonBillableClick adds a record of the rejected click and counts it in campaign_stats_daily.
+// Repeated clicks of the client on the campaign with the same reason on the same date are recorded and counted once.
+func (r *CampaignRepo) AddNonBillableClick(ctx context.Context, click model.NonBillableClick) error {
+	return addNonBillableClick(ctx, r.db, click)
+}
+
+func addNonBillableClick(ctx context.Context, db sqlx.ExecerContext, click model.NonBillableClick) error {
+	_, err := db.ExecContext(ctx, `WITH inserted AS (
+    INSERT INTO ad_non_billable_clicks (client_id, campaign_id, reason, date, created_at, source)
+    VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (client_id, campaign_id, reason, date) DO NOTHING
+    RETURNING campaign_id, date
+)
+INSERT INTO campaign_stats_daily (campaign_id, date, non_billable_clicks_count)
+SELECT campaign_id, date, 1 FROM inserted
+ON CONFLICT (campaign_id, date) DO UPDATE SET
+    non_billable_clicks_count = campaign_stats_daily.non_billable_clicks_count + 1`,
+		click.ClientId, click.CampaignId, click.Reason, click.Date, click.CreatedAt, click.Source)
 	return err
 }
+
+// getClickActivity counts billable and non-billable clicks of the client and of other clients from the source.
+// Clicks are selected by the indexes on (client_id, created_at) and (source, created_at), so that only recent
+// clicks are read. Clicks without a source are not counted as ones from the same source.
+func getClickActivity(ctx context.Context, db sqlx.QueryerContext, query model.ClickActivityQuery) (model.ClickActivity, error) {
+	var activity model.ClickActivity
+	err := sqlx.GetContext(ctx, db, &activity, `WITH clicks AS (
+    SELECT client_id, source, created_at FROM ad_clicks
+    WHERE (client_id = $1 AND created_at >= $3) OR (source = $2 AND $2 <> '' AND created_at >= $4)
+    UNION ALL
+    SELECT client_id, source, created_at FROM ad_non_billable_clicks
+    WHERE (client_id = $1 AND created_at >= $3) OR (source = $2 AND $2 <> '' AND created_at >= $4)
+)
+SELECT
+    COUNT(*) FILTER (WHERE client_id = $1 AND created_at >= $4) AS client_clicks,
+    COUNT(*) FILTER (WHERE client_id = $1 AND created_at < $4) AS client_history_clicks,
+    COUNT(DISTINCT client_id) FILTER (WHERE source = $2 AND $2 <> '' AND client_id <> $1 AND created_at >= $4) AS source_clients
+FROM clicks`, query.ClientId, query.Source, query.HistoryStart, query.WindowStart)
+	return activity, err
+}
+
+// addEvent runs insert of an impression or a click (as given by event) if the campaign has capacity left for it.
+// The count of events is kept in the campaign row, which is locked until the end of the transaction, so that
+// concurrent reservations of its capacity are serialized. A reservation waiting for the lock reads the row
+// as updated by the previous one, even under READ COMMITTED, so the count includes all committed events.
+// insert must affect rows only if the event has been added, as then the count is incremented.
+func addEvent(ctx context.Context, tx *sqlx.Tx, event string, clientId, campaignId uuid.UUID, limitsThreshold float64,
+	insert string, args ...any) error {
+	var capacity struct {
+		Limit int `db:"limit"`
+		Count int `db:"count"`
+	}
+	err := tx.GetContext(ctx, &capacity,
+		fmt.Sprintf(`SELECT %[1]s_limit AS "limit", %[1]s_count AS "count" FROM campaigns WHERE id = $1 FOR NO KEY UPDATE`, event),
+		campaignId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("lock campaign: %w", err)
+	}
+	if !WithinLimit(capacity.Count, capacity.Limit, limitsThreshold) {
+		// a repeated event is not counted, so it does not need capacity
+		var exists bool
+		err := tx.GetContext(ctx, &exists,
+			fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM ad_%s WHERE client_id = $1 AND campaign_id = $2)`, event), clientId, campaignId)
+		if err != nil {
+			return fmt.Errorf("check existence: %w", err)
+		}
+		if exists {
+			return nil
+		}
+		return ErrLimitReached
+	}
+
+	res, err := tx.ExecContext(ctx, insert, args...)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if inserted > 0 {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE campaigns SET %[1]s_count = %[1]s_count + 1 WHERE id = $1`, event), campaignId)
+		if err != nil {
+			return fmt.Errorf("count %s: %w", event, err)
+		}
+	}
+	return nil
+}
+
+// inTx runs f in a transaction, which is committed if f succeeds.
+func (r *CampaignRepo) inTx(ctx context.Context, f func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
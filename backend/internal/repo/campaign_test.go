@@ -0,0 +1,236 @@
+package repo
+
+import (
+	"backend/internal/model"
+	"backend/migrations"
+	"backend/pkg/migrate"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// testDB connects to the database given by TEST_POSTGRES_DSN and migrates it to the latest version.
+// Tests which need Postgres are skipped without it.
+func testDB(t *testing.T) *DB {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	m, err := migrate.New(db.DB, migrations.FS)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewDB(db, nil)
+}
+
+// addTestCampaign adds a campaign with 5 impressions and 3 clicks limits and clients of its advertiser,
+// which are deleted after the test.
+func addTestCampaign(t *testing.T, db *DB, clientsCount int) (model.Campaign, []model.Client) {
+	ctx := context.Background()
+	repos := NewRepositories(db)
+
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "advertiser"}
+	if err := repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}); err != nil {
+		t.Fatalf("add advertiser: %v", err)
+	}
+	clients := make([]model.Client, clientsCount)
+	ids := make([]uuid.UUID, clientsCount)
+	age := 20
+	for i := range clients {
+		clients[i] = model.Client{Id: uuid.New(), Login: fmt.Sprintf("client%d", i), Age: &age, Location: "Moscow", Gender: "MALE"}
+		ids[i] = clients[i].Id
+	}
+	if err := repos.Client.UpsertMany(ctx, clients); err != nil {
+		t.Fatalf("add clients: %v", err)
+	}
+	impressionsLimit, clicksLimit, cost, startDate, endDate := 5, 3, 1.0, 0, 5
+	campaign := model.Campaign{
+		Id:           uuid.New(),
+		AdvertiserId: advertiser.Id,
+		CampaignCreateRequest: model.CampaignCreateRequest{
+			ImpressionsLimit:  &impressionsLimit,
+			ClicksLimit:       &clicksLimit,
+			CostPerImpression: &cost,
+			CostPerClick:      &cost,
+			AdTitle:           "title",
+			AdText:            "text",
+			StartDate:         &startDate,
+			EndDate:           &endDate,
+		},
+	}
+	if err := repos.Campaign.Add(ctx, campaign); err != nil {
+		t.Fatalf("add campaign: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec(`DELETE FROM campaigns WHERE id = $1`, campaign.Id)
+		_, _ = db.Exec(`DELETE FROM clients WHERE id = ANY($1::uuid[])`, pq.Array(ids))
+		_, _ = db.Exec(`DELETE FROM advertisers WHERE id = $1`, advertiser.Id)
+	})
+	return campaign, clients
+}
+
+func TestCampaignRepo_ConcurrentLimits(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	repos := NewRepositories(db)
+	campaign, clients := addTestCampaign(t, db, 20)
+	impressionsLimit, clicksLimit, cost := *campaign.ImpressionsLimit, *campaign.ClicksLimit, *campaign.CostPerClick
+
+	// each client adds an event concurrently, and only the events within the limit are added;
+	// returns the clients whose events have been added
+	run := func(clients []model.Client, add func(client model.Client) error) []model.Client {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		added := make([]model.Client, 0)
+		for _, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := add(client)
+				if err != nil && !IsLimitReached(err) {
+					t.Errorf("add event of client %s: %v", client.Id, err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					added = append(added, client)
+				}
+			}()
+		}
+		wg.Wait()
+		return added
+	}
+
+	viewed := run(clients, func(client model.Client) error {
+		return repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id, Spent: cost}, 1)
+	})
+	if len(viewed) != impressionsLimit {
+		t.Errorf("added impressions = %d, want %d", len(viewed), impressionsLimit)
+	}
+	// a click needs an impression, so only the clients who have viewed the ad click
+	clicked := run(viewed, func(client model.Client) error {
+		return repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: client.Id, CampaignId: campaign.Id, Spent: cost}, 1)
+	})
+	if len(clicked) != clicksLimit {
+		t.Errorf("added clicks = %d, want %d", len(clicked), clicksLimit)
+	}
+
+	var counts struct {
+		ImpressionsCount int `db:"impressions_count"`
+		ClicksCount      int `db:"clicks_count"`
+		Impressions      int `db:"impressions"`
+		Clicks           int `db:"clicks"`
+	}
+	err := db.Get(&counts, `SELECT impressions_count, clicks_count,
+    (SELECT COUNT(*) FROM ad_impressions WHERE campaign_id = $1) AS impressions,
+    (SELECT COUNT(*) FROM ad_clicks WHERE campaign_id = $1) AS clicks
+FROM campaigns WHERE id = $1`, campaign.Id)
+	if err != nil {
+		t.Fatalf("get counts: %v", err)
+	}
+	if counts.ImpressionsCount != impressionsLimit || counts.Impressions != impressionsLimit {
+		t.Errorf("impressions_count = %d, impressions = %d, want %d", counts.ImpressionsCount, counts.Impressions, impressionsLimit)
+	}
+	if counts.ClicksCount != clicksLimit || counts.Clicks != clicksLimit {
+		t.Errorf("clicks_count = %d, clicks = %d, want %d", counts.ClicksCount, counts.Clicks, clicksLimit)
+	}
+}
+
+func TestCampaignRepo_ConcurrentClickDetection(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	repos := NewRepositories(db)
+	campaign, clients := addTestCampaign(t, db, 10)
+	for _, client := range clients {
+		impression := model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id}
+		if err := repos.Campaign.AddAdImpression(ctx, impression, 10); err != nil {
+			t.Fatalf("add impression: %v", err)
+		}
+	}
+
+	// the clients click from the same source at once, and only the first two are not flagged
+	source := "source-" + campaign.Id.String()
+	now := time.Now()
+	query := model.ClickActivityQuery{Source: source, WindowStart: now.Add(-time.Hour), HistoryStart: now.Add(-2 * time.Hour)}
+	detect := func(activity model.ClickActivity) string {
+		if activity.SourceClients+1 > 2 {
+			return model.ClickInvalidSharedSource
+		}
+		return ""
+	}
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query := query
+			query.ClientId = client.Id
+			click := model.AdClick{ClientId: client.Id, CampaignId: campaign.Id, CreatedAt: now, Source: source}
+			if _, err := repos.Campaign.AddAdClickWithDetection(ctx, click, 10, query, detect); err != nil {
+				t.Errorf("add click of client %s: %v", client.Id, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var counts struct {
+		Clicks      int `db:"clicks"`
+		NonBillable int `db:"non_billable"`
+	}
+	err := db.Get(&counts, `SELECT
+    (SELECT COUNT(*) FROM ad_clicks WHERE campaign_id = $1) AS clicks,
+    (SELECT COUNT(*) FROM ad_non_billable_clicks WHERE campaign_id = $1) AS non_billable`, campaign.Id)
+	if err != nil {
+		t.Fatalf("get counts: %v", err)
+	}
+	if counts.Clicks != 2 || counts.NonBillable != len(clients)-2 {
+		t.Errorf("clicks = %d, non-billable = %d, want 2 and %d", counts.Clicks, counts.NonBillable, len(clients)-2)
+	}
+}
+
+func TestCampaignRepo_ClickDetectionWithoutSource(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	repos := NewRepositories(db)
+	campaign, clients := addTestCampaign(t, db, 3)
+	for _, client := range clients {
+		impression := model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id}
+		if err := repos.Campaign.AddAdImpression(ctx, impression, 10); err != nil {
+			t.Fatalf("add impression: %v", err)
+		}
+	}
+
+	// clicks without a source are not counted as ones from the same source
+	now := time.Now()
+	for _, client := range clients {
+		query := model.ClickActivityQuery{ClientId: client.Id, WindowStart: now.Add(-time.Hour), HistoryStart: now.Add(-2 * time.Hour)}
+		click := model.AdClick{ClientId: client.Id, CampaignId: campaign.Id, CreatedAt: now}
+		reason, err := repos.Campaign.AddAdClickWithDetection(ctx, click, 10, query, func(activity model.ClickActivity) string {
+			if activity.SourceClients > 0 {
+				return model.ClickInvalidSharedSource
+			}
+			return ""
+		})
+		if err != nil || reason != "" {
+			t.Errorf("add click of client %s = %q, %v, want no reason", client.Id, reason, err)
+		}
+	}
+}
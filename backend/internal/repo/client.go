@@ -2,29 +2,30 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
 type ClientRepo struct {
-	db *sqlx.DB
+	db *DB
 }
 
-func (r *ClientRepo) GetById(id uuid.UUID) (client model.Client, err error) {
-	err = r.db.Get(&client, "SELECT * FROM clients WHERE id = $1", id)
+func (r *ClientRepo) GetById(ctx context.Context, id uuid.UUID) (client model.Client, err error) {
+	// ads are served right after clients are created, so the replica, which may lag, is never used
+	err = r.db.GetContext(ctx, &client, "SELECT * FROM clients WHERE id = $1", id)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = ErrNotFound
 	}
 	return
 }
 
-func (r *ClientRepo) GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Client, error) {
+func (r *ClientRepo) GetMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Client, error) {
 	var slice []model.Client
-	if err := r.db.Select(&slice, "SELECT * FROM clients WHERE id = ANY($1::uuid[])", pq.Array(ids)); err != nil {
+	if err := r.db.Reader(ctx).SelectContext(ctx, &slice, "SELECT * FROM clients WHERE id = ANY($1::uuid[])", pq.Array(ids)); err != nil {
 		return nil, err
 	}
 
@@ -35,13 +36,13 @@ func (r *ClientRepo) GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Client, error
 	return clients, nil
 }
 
-func (r *ClientRepo) UpsertMany(clients []model.Client) error {
-	tx, err := r.db.Beginx()
+func (r *ClientRepo) UpsertMany(ctx context.Context, clients []model.Client) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin transaction: %w", err)
 	}
 
-	stmt, err := tx.Prepare(`INSERT INTO clients (id, login, age, location, gender) VALUES ($1, $2, $3, $4, $5) 
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO clients (id, login, age, location, gender) VALUES ($1, $2, $3, $4, $5) 
                                     ON CONFLICT (id) DO UPDATE SET (login, age, location, gender) = ($2, $3, $4, $5)`)
 	if err != nil {
 		return fmt.Errorf("prepare statement: %w", err)
@@ -50,7 +51,7 @@ func (r *ClientRepo) UpsertMany(clients []model.Client) error {
 		_ = stmt.Close()
 	}(stmt)
 	for _, client := range clients {
-		_, err = stmt.Exec(client.Id, client.Login, client.Age, client.Location, client.Gender)
+		_, err = stmt.ExecContext(ctx, client.Id, client.Login, client.Age, client.Location, client.Gender)
 		if err != nil {
 			return fmt.Errorf("exec statement: %w", err)
 		}
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+)
+
+// DB is the primary database with an optional read replica.
+// Methods of the embedded *sqlx.DB are executed on the primary, so writes never go to the replica.
+type DB struct {
+	*sqlx.DB
+	replica *sqlx.DB
+}
+
+// NewDB creates DB. replica may be nil, then all queries are executed on the primary.
+func NewDB(primary, replica *sqlx.DB) *DB {
+	return &DB{primary, replica}
+}
+
+// Reader returns the database for a read-only query: the replica if it is configured and ctx is marked by
+// WithReplica, otherwise the primary. The replica may lag behind, so it must not be used for reads
+// which have to see writes made just before, like checks preceding updates.
+func (db *DB) Reader(ctx context.Context) *sqlx.DB {
+	if db.replica != nil && ctx.Value(replicaKey{}) != nil {
+		return db.replica
+	}
+	return db.DB
+}
+
+type replicaKey struct{}
+
+// WithReplica marks ctx of a read-only request, so that its queries which tolerate lag are routed to the replica.
+func WithReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaKey{}, true)
+}
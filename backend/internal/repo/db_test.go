@@ -0,0 +1,30 @@
+package repo
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"testing"
+)
+
+func TestDB_Reader(t *testing.T) {
+	// sqlx.Open does not connect
+	primary, _ := sqlx.Open("postgres", "host=primary")
+	replica, _ := sqlx.Open("postgres", "host=replica")
+	readOnly := WithReplica(context.Background())
+
+	db := NewDB(primary, replica)
+	if got := db.Reader(context.Background()); got != primary {
+		t.Errorf("Reader() of unmarked context = %p, want primary", got)
+	}
+	if got := db.Reader(readOnly); got != replica {
+		t.Errorf("Reader() of read-only context = %p, want replica", got)
+	}
+	if db.DB != primary {
+		t.Errorf("DB = %p, want primary", db.DB)
+	}
+
+	db = NewDB(primary, nil)
+	if got := db.Reader(readOnly); got != primary {
+		t.Errorf("Reader() without replica = %p, want primary", got)
+	}
+}
@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"github.com/google/uuid"
+)
+
+type AdvertiserRepo struct {
+	s *store
+}
+
+func (r *AdvertiserRepo) GetById(_ context.Context, id uuid.UUID) (model.Advertiser, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	adv, ok := r.s.advertisers[id]
+	if !ok {
+		return model.Advertiser{}, repo.ErrNotFound
+	}
+	return adv, nil
+}
+
+func (r *AdvertiserRepo) GetMany(_ context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Advertiser, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	advs := make(map[uuid.UUID]model.Advertiser)
+	for _, id := range ids {
+		if adv, ok := r.s.advertisers[id]; ok {
+			advs[id] = adv
+		}
+	}
+	return advs, nil
+}
+
+func (r *AdvertiserRepo) UpsertMany(_ context.Context, advertisers []model.Advertiser) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, adv := range advertisers {
+		r.s.advertisers[adv.Id] = adv
+	}
+	return nil
+}
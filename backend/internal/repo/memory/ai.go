@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+type AiRepo struct {
+	s *store
+}
+
+func (r *AiRepo) AddTask(_ context.Context, task model.AiTask) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.aiTasks[task.Id]; ok {
+		return fmt.Errorf("%w: ai task %s already exists", ErrConstraint, task.Id)
+	}
+	r.s.aiTasks[task.Id] = task
+	r.s.aiTaskIds = append(r.s.aiTaskIds, task.Id)
+	return nil
+}
+
+func (r *AiRepo) GetTask(_ context.Context, id uuid.UUID) (model.AiTask, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	task, ok := r.s.aiTasks[id]
+	if !ok {
+		return model.AiTask{}, repo.ErrNotFound
+	}
+	return task, nil
+}
+
+func (r *AiRepo) GetIncompleteTasks(_ context.Context) ([]model.AiTask, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	tasks := make([]model.AiTask, 0)
+	for _, id := range r.s.aiTaskIds {
+		if _, ok := r.s.aiResults[id]; !ok {
+			tasks = append(tasks, r.s.aiTasks[id])
+		}
+	}
+	return tasks, nil
+}
+
+func (r *AiRepo) AddResult(_ context.Context, result model.AiTaskResult) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.aiTasks[result.TaskId]; !ok {
+		return fmt.Errorf("%w: ai task %s does not exist", ErrConstraint, result.TaskId)
+	}
+	if _, ok := r.s.aiResults[result.TaskId]; ok {
+		return fmt.Errorf("%w: result of ai task %s already exists", ErrConstraint, result.TaskId)
+	}
+	r.s.aiResults[result.TaskId] = result
+	return nil
+}
+
+func (r *AiRepo) GetResult(_ context.Context, taskId uuid.UUID) (model.AiTaskResult, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, ok := r.s.aiResults[taskId]
+	if !ok {
+		return model.AiTaskResult{}, repo.ErrNotFound
+	}
+	return res, nil
+}
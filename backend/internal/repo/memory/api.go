@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"context"
+	"slices"
+	"time"
+)
+
+type ApiRepo struct {
+	s *store
+}
+
+func (r *ApiRepo) AddRequest(_ context.Context, request model.ApiRequest) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.apiRequests = append(r.s.apiRequests, request)
+	return nil
+}
+
+func (r *ApiRepo) DeleteRequestsBefore(_ context.Context, before time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	n := len(r.s.apiRequests)
+	r.s.apiRequests = slices.DeleteFunc(r.s.apiRequests, func(request model.ApiRequest) bool {
+		return request.CreatedAt.Before(before)
+	})
+	return int64(n - len(r.s.apiRequests)), nil
+}
@@ -0,0 +1,535 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"sort"
+	"time"
+)
+
+type CampaignRepo struct {
+	s *store
+}
+
+func (r *CampaignRepo) Add(_ context.Context, campaign model.Campaign) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.campaigns[campaign.Id]; ok {
+		return fmt.Errorf("%w: campaign %s already exists", ErrConstraint, campaign.Id)
+	}
+	if _, ok := r.s.advertisers[campaign.AdvertiserId]; !ok {
+		return fmt.Errorf("%w: advertiser %s does not exist", ErrConstraint, campaign.AdvertiserId)
+	}
+	if err := r.checkModerationTask(campaign); err != nil {
+		return err
+	}
+
+	if campaign.CreatedAt.IsZero() {
+		campaign.CreatedAt = time.Now()
+	}
+	campaign.ModerationResult = nil
+	r.s.campaigns[campaign.Id] = cloneCampaign(campaign)
+	r.s.campaignIds = append(r.s.campaignIds, campaign.Id)
+	return nil
+}
+
+func (r *CampaignRepo) GetById(_ context.Context, id uuid.UUID) (model.Campaign, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	campaign, ok := r.s.campaigns[id]
+	if !ok {
+		return model.Campaign{}, repo.ErrNotFound
+	}
+	return r.withModeration(campaign)
+}
+
+func (r *CampaignRepo) GetList(_ context.Context, advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.paginate(size, page, func(campaign model.Campaign) bool {
+		return campaign.AdvertiserId == advertiserId
+	})
+}
+
+// Update overwrites the campaign if its version in the store equals to campaign.Version,
+// and increments the version. If the versions differ, repo.ErrVersionConflict is returned.
+func (r *CampaignRepo) Update(_ context.Context, campaign model.Campaign) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.campaigns[campaign.Id]
+	if !ok {
+		return repo.ErrNotFound
+	}
+	if stored.Version != campaign.Version {
+		return repo.ErrVersionConflict
+	}
+	if err := r.checkModerationTask(campaign); err != nil {
+		return err
+	}
+
+	stored.CampaignCreateRequest = campaign.CampaignCreateRequest
+	stored.ImagePath = campaign.ImagePath
+	stored.ModerationTaskId = campaign.ModerationTaskId
+	stored.Version++
+	r.s.campaigns[campaign.Id] = cloneCampaign(stored)
+	return nil
+}
+
+func (r *CampaignRepo) SetModerationTask(_ context.Context, id uuid.UUID, version int, taskId uuid.UUID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.campaigns[id]
+	if !ok {
+		return repo.ErrNotFound
+	}
+	if stored.Version != version {
+		return repo.ErrVersionConflict
+	}
+	stored.ModerationTaskId = &taskId
+	if err := r.checkModerationTask(stored); err != nil {
+		return err
+	}
+	stored.Version++
+	r.s.campaigns[id] = cloneCampaign(stored)
+	return nil
+}
+
+// Delete removes the campaign with its impressions, clicks and stats if its version in the store equals to version.
+// If the versions differ, repo.ErrVersionConflict is returned.
+func (r *CampaignRepo) Delete(_ context.Context, id uuid.UUID, version int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.campaigns[id]
+	if !ok {
+		return repo.ErrNotFound
+	}
+	if stored.Version != version {
+		return repo.ErrVersionConflict
+	}
+
+	delete(r.s.campaigns, id)
+	r.s.campaignIds = slices.DeleteFunc(r.s.campaignIds, func(campaignId uuid.UUID) bool {
+		return campaignId == id
+	})
+	for key := range r.s.impressions {
+		if key.campaignId == id {
+			delete(r.s.impressions, key)
+		}
+	}
+	for key := range r.s.clicks {
+		if key.campaignId == id {
+			delete(r.s.clicks, key)
+		}
+	}
+	r.s.nonBillableClicks = slices.DeleteFunc(r.s.nonBillableClicks, func(click model.NonBillableClick) bool {
+		return click.CampaignId == id
+	})
+	for key := range r.s.dailyStats {
+		if key.campaignId == id {
+			delete(r.s.dailyStats, key)
+		}
+	}
+	return nil
+}
+
+func (r *CampaignRepo) GetModerationFailed(_ context.Context, size int, page int) ([]model.Campaign, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.paginate(size, page, func(campaign model.Campaign) bool {
+		return r.moderationFailed(campaign)
+	})
+}
+
+// moderationFailed mirrors `moderation_result->>'acceptable' = 'false'` condition.
+func (r *CampaignRepo) moderationFailed(campaign model.Campaign) bool {
+	if campaign.ModerationTaskId == nil {
+		return false
+	}
+	result, ok := r.s.aiResults[*campaign.ModerationTaskId]
+	if !ok {
+		return false
+	}
+	var answer map[string]any
+	if err := json.Unmarshal([]byte(result.Answer), &answer); err != nil {
+		return false
+	}
+	acceptable, ok := answer["acceptable"]
+	return ok && (acceptable == false || acceptable == "false")
+}
+
+// GetAdCandidates fetches campaigns that could be a candidate for an ad for the specified client.
+// The criteria are the same as in repo.CampaignRepo.GetAdCandidates.
+// The result is ordered by the date of creation in ascending order.
+func (r *CampaignRepo) GetAdCandidates(_ context.Context, clientId uuid.UUID, limitsThreshold float64) ([]model.AdCandidate, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	candidates := make([]model.AdCandidate, 0)
+	client, ok := r.s.clients[clientId]
+	if !ok {
+		return candidates, nil
+	}
+
+	impressionsCount := make(map[uuid.UUID]int)
+	for key := range r.s.impressions {
+		impressionsCount[key.campaignId]++
+	}
+	clicksCount := make(map[uuid.UUID]int)
+	for key := range r.s.clicks {
+		clicksCount[key.campaignId]++
+	}
+
+	currentDate := r.s.settings.CurrentDate
+	for _, campaign := range r.sortedCampaigns() {
+		if *campaign.StartDate > currentDate || currentDate > *campaign.EndDate || !campaign.Matches(client) {
+			continue
+		}
+
+		impressionsLimit := *campaign.ImpressionsLimit
+		impressions := impressionsCount[campaign.Id]
+		if impressionsLimit <= 0 || (float64(impressions)+1)/float64(impressionsLimit) > limitsThreshold {
+			continue
+		}
+		if repo.ClicksExhausted(clicksCount[campaign.Id], *campaign.ClicksLimit, limitsThreshold) {
+			continue
+		}
+
+		key := adKey{clientId, campaign.Id}
+		_, viewed := r.s.impressions[key]
+		_, clicked := r.s.clicks[key]
+		candidates = append(candidates, model.AdCandidate{
+			Ad: model.Ad{
+				Id:           campaign.Id,
+				Title:        campaign.AdTitle,
+				Text:         campaign.AdText,
+				AdvertiserId: campaign.AdvertiserId,
+				ImagePath:    campaign.ImagePath,
+			},
+			MlScore:           r.s.mlScores[mlScoreKey{clientId, campaign.AdvertiserId}],
+			CostPerImpression: *campaign.CostPerImpression,
+			ImpressionsCount:  impressions,
+			ImpressionsLimit:  impressionsLimit,
+			Viewed:            viewed,
+			CostPerClick:      *campaign.CostPerClick,
+			ClicksCount:       clicksCount[campaign.Id],
+			ClicksLimit:       *campaign.ClicksLimit,
+			Clicked:           clicked,
+		})
+	}
+	return candidates, nil
+}
+
+// GetActiveCampaigns fetches campaigns which are active on the date with the counts of their impressions and clicks.
+// The result is ordered by the date of creation in ascending order.
+func (r *CampaignRepo) GetActiveCampaigns(_ context.Context, date int) ([]model.ActiveCampaign, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	impressionsCount := make(map[uuid.UUID]int)
+	for key := range r.s.impressions {
+		impressionsCount[key.campaignId]++
+	}
+	clicksCount := make(map[uuid.UUID]int)
+	for key := range r.s.clicks {
+		clicksCount[key.campaignId]++
+	}
+
+	campaigns := make([]model.ActiveCampaign, 0)
+	for _, campaign := range r.sortedCampaigns() {
+		if *campaign.StartDate > date || date > *campaign.EndDate {
+			continue
+		}
+		campaigns = append(campaigns, model.ActiveCampaign{
+			AdCandidate: model.AdCandidate{
+				Ad: model.Ad{
+					Id:           campaign.Id,
+					Title:        campaign.AdTitle,
+					Text:         campaign.AdText,
+					AdvertiserId: campaign.AdvertiserId,
+					ImagePath:    campaign.ImagePath,
+				},
+				CostPerImpression: *campaign.CostPerImpression,
+				ImpressionsCount:  impressionsCount[campaign.Id],
+				ImpressionsLimit:  *campaign.ImpressionsLimit,
+				CostPerClick:      *campaign.CostPerClick,
+				ClicksCount:       clicksCount[campaign.Id],
+				ClicksLimit:       *campaign.ClicksLimit,
+			},
+			CampaignTargeting: campaign.CampaignTargeting,
+		})
+	}
+	return campaigns, nil
+}
+
+// GetViewedAds fetches campaigns the ads of which the client has viewed, in no particular order.
+func (r *CampaignRepo) GetViewedAds(_ context.Context, clientId uuid.UUID) ([]model.ViewedAd, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	viewed := make([]model.ViewedAd, 0)
+	for key := range r.s.impressions {
+		if key.clientId == clientId {
+			_, clicked := r.s.clicks[key]
+			viewed = append(viewed, model.ViewedAd{CampaignId: key.campaignId, Clicked: clicked})
+		}
+	}
+	return viewed, nil
+}
+
+// AddAdImpression adds a record that the ad was viewed and counts it in the daily stats.
+// If the impression would exceed the impressions limit with limitsThreshold tolerance, repo.ErrLimitReached is returned.
+// This function is idempotent - if the record already exists, no error is returned and nothing is counted.
+func (r *CampaignRepo) AddAdImpression(_ context.Context, impression model.AdImpression, limitsThreshold float64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.clients[impression.ClientId]; !ok {
+		return fmt.Errorf("%w: client %s does not exist", ErrConstraint, impression.ClientId)
+	}
+	campaign, ok := r.s.campaigns[impression.CampaignId]
+	if !ok {
+		return repo.ErrNotFound
+	}
+
+	key := adKey{impression.ClientId, impression.CampaignId}
+	if _, ok := r.s.impressions[key]; !ok {
+		if !repo.WithinLimit(r.campaignTotals(campaign.Id).ImpressionsCount, *campaign.ImpressionsLimit, limitsThreshold) {
+			return repo.ErrLimitReached
+		}
+		r.s.impressions[key] = impression
+		day := r.s.dailyStats[statsKey{impression.CampaignId, impression.Date}]
+		day.ImpressionsCount++
+		day.SpentImpressions += impression.Spent
+		r.s.dailyStats[statsKey{impression.CampaignId, impression.Date}] = day
+	}
+	return nil
+}
+
+func (r *CampaignRepo) GetAdImpression(_ context.Context, clientId uuid.UUID, campaignId uuid.UUID) (model.AdImpression, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	impression, ok := r.s.impressions[adKey{clientId, campaignId}]
+	if !ok {
+		return model.AdImpression{}, repo.ErrNotFound
+	}
+	return impression, nil
+}
+
+// AddAdClick adds a record that the ad was clicked. The ad must be viewed by the client before.
+// It is counted in the daily stats.
+// If the click would exceed the clicks limit with limitsThreshold tolerance, repo.ErrLimitReached is returned.
+// This function is idempotent - if the record already exists, no error is returned and nothing is counted.
+func (r *CampaignRepo) AddAdClick(_ context.Context, click model.AdClick, limitsThreshold float64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.addAdClick(click, limitsThreshold)
+}
+
+func (r *CampaignRepo) addAdClick(click model.AdClick, limitsThreshold float64) error {
+	key := adKey{click.ClientId, click.CampaignId}
+	if _, ok := r.s.impressions[key]; !ok {
+		return fmt.Errorf("%w: impression of campaign %s by client %s does not exist",
+			ErrConstraint, click.CampaignId, click.ClientId)
+	}
+	if _, ok := r.s.clicks[key]; !ok {
+		campaign := r.s.campaigns[click.CampaignId]
+		if !repo.WithinLimit(r.campaignTotals(campaign.Id).ClicksCount, *campaign.ClicksLimit, limitsThreshold) {
+			return repo.ErrLimitReached
+		}
+		r.s.clicks[key] = click
+		day := r.s.dailyStats[statsKey{click.CampaignId, click.Date}]
+		day.ClicksCount++
+		day.SpentClicks += click.Spent
+		r.s.dailyStats[statsKey{click.CampaignId, click.Date}] = day
+	}
+	return nil
+}
+
+// AddNonBillableClick adds a record of the rejected click and counts it in the daily stats.
+// Repeated clicks of the client on the campaign with the same reason on the same date are recorded and counted once.
+func (r *CampaignRepo) AddNonBillableClick(_ context.Context, click model.NonBillableClick) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.addNonBillableClick(click)
+}
+
+func (r *CampaignRepo) addNonBillableClick(click model.NonBillableClick) error {
+	if _, ok := r.s.clients[click.ClientId]; !ok {
+		return fmt.Errorf("%w: client %s does not exist", ErrConstraint, click.ClientId)
+	}
+	if _, ok := r.s.campaigns[click.CampaignId]; !ok {
+		return fmt.Errorf("%w: campaign %s does not exist", ErrConstraint, click.CampaignId)
+	}
+
+	repeated := slices.ContainsFunc(r.s.nonBillableClicks, func(c model.NonBillableClick) bool {
+		return c.ClientId == click.ClientId && c.CampaignId == click.CampaignId && c.Reason == click.Reason && c.Date == click.Date
+	})
+	if repeated {
+		return nil
+	}
+	r.s.nonBillableClicks = append(r.s.nonBillableClicks, click)
+	day := r.s.dailyStats[statsKey{click.CampaignId, click.Date}]
+	day.NonBillableClicksCount++
+	r.s.dailyStats[statsKey{click.CampaignId, click.Date}] = day
+	return nil
+}
+
+// AddAdClickWithDetection locks the store until the click is recorded, so concurrent clicks are counted in the activity.
+func (r *CampaignRepo) AddAdClickWithDetection(_ context.Context, click model.AdClick, limitsThreshold float64,
+	query model.ClickActivityQuery, detect func(model.ClickActivity) string) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.clicks[adKey{click.ClientId, click.CampaignId}]; ok {
+		return "", nil
+	}
+	if reason := detect(r.clickActivity(query)); reason != "" {
+		return reason, r.addNonBillableClick(model.NonBillableClick{
+			ClientId:   click.ClientId,
+			CampaignId: click.CampaignId,
+			Reason:     reason,
+			Date:       click.Date,
+			CreatedAt:  click.CreatedAt,
+			Source:     click.Source,
+		})
+	}
+	return "", r.addAdClick(click, limitsThreshold)
+}
+
+// clickActivity counts billable and non-billable clicks of the client and of other clients from the source.
+// Clicks without a source are not counted as ones from the same source.
+func (r *CampaignRepo) clickActivity(query model.ClickActivityQuery) model.ClickActivity {
+	var activity model.ClickActivity
+	sourceClients := make(map[uuid.UUID]bool)
+	count := func(clientId uuid.UUID, source string, createdAt time.Time) {
+		switch {
+		case clientId == query.ClientId && !createdAt.Before(query.WindowStart):
+			activity.ClientClicks++
+		case clientId == query.ClientId && !createdAt.Before(query.HistoryStart):
+			activity.ClientHistoryClicks++
+		case query.Source != "" && source == query.Source && !createdAt.Before(query.WindowStart):
+			sourceClients[clientId] = true
+		}
+	}
+	for _, click := range r.s.clicks {
+		count(click.ClientId, click.Source, click.CreatedAt)
+	}
+	for _, click := range r.s.nonBillableClicks {
+		count(click.ClientId, click.Source, click.CreatedAt)
+	}
+	activity.SourceClients = len(sourceClients)
+	return activity
+}
+
+// campaignTotals sums the daily stats of the campaign, like the limits check in repo.CampaignRepo.
+func (r *CampaignRepo) campaignTotals(campaignId uuid.UUID) model.CampaignStats {
+	var totals model.CampaignStats
+	for key, day := range r.s.dailyStats {
+		if key.campaignId == campaignId {
+			totals.ImpressionsCount += day.ImpressionsCount
+			totals.ClicksCount += day.ClicksCount
+		}
+	}
+	return totals
+}
+
+func (r *CampaignRepo) checkModerationTask(campaign model.Campaign) error {
+	if campaign.ModerationTaskId == nil {
+		return nil
+	}
+	if _, ok := r.s.aiTasks[*campaign.ModerationTaskId]; !ok {
+		return fmt.Errorf("%w: ai task %s does not exist", ErrConstraint, *campaign.ModerationTaskId)
+	}
+	return nil
+}
+
+// sortedCampaigns returns all campaigns ordered by created_at.
+func (r *CampaignRepo) sortedCampaigns() []model.Campaign {
+	campaigns := make([]model.Campaign, len(r.s.campaignIds))
+	for i, id := range r.s.campaignIds {
+		campaigns[i] = r.s.campaigns[id]
+	}
+	sort.SliceStable(campaigns, func(i, j int) bool {
+		return campaigns[i].CreatedAt.Before(campaigns[j].CreatedAt)
+	})
+	return campaigns
+}
+
+// paginate returns the page of campaigns ordered by created_at which satisfy filter,
+// with moderation results attached.
+func (r *CampaignRepo) paginate(size, page int, filter func(model.Campaign) bool) ([]model.Campaign, error) {
+	offset := (page - 1) * size
+	if size < 0 || offset < 0 {
+		return nil, fmt.Errorf("invalid pagination: size %d, page %d", size, page)
+	}
+
+	campaigns := make([]model.Campaign, 0)
+	for _, campaign := range r.sortedCampaigns() {
+		if len(campaigns) == size {
+			break
+		}
+		if !filter(campaign) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		withModeration, err := r.withModeration(campaign)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, withModeration)
+	}
+	return campaigns, nil
+}
+
+// withModeration returns a copy of the campaign with the moderation result attached, as campaigns_moderation view does.
+func (r *CampaignRepo) withModeration(campaign model.Campaign) (model.Campaign, error) {
+	campaign = cloneCampaign(campaign)
+	if campaign.ModerationTaskId == nil {
+		return campaign, nil
+	}
+	result, ok := r.s.aiResults[*campaign.ModerationTaskId]
+	if !ok {
+		return campaign, nil
+	}
+
+	campaign.ModerationResult = &model.AiModerationResult{}
+	if err := json.Unmarshal([]byte(result.Answer), campaign.ModerationResult); err != nil {
+		return model.Campaign{}, fmt.Errorf("unmarshal moderation result of campaign %s: %w", campaign.Id, err)
+	}
+	return campaign, nil
+}
+
+func cloneCampaign(c model.Campaign) model.Campaign {
+	c.ImpressionsLimit = clonePtr(c.ImpressionsLimit)
+	c.ClicksLimit = clonePtr(c.ClicksLimit)
+	c.CostPerImpression = clonePtr(c.CostPerImpression)
+	c.CostPerClick = clonePtr(c.CostPerClick)
+	c.StartDate = clonePtr(c.StartDate)
+	c.EndDate = clonePtr(c.EndDate)
+	c.Gender = clonePtr(c.Gender)
+	c.AgeFrom = clonePtr(c.AgeFrom)
+	c.AgeTo = clonePtr(c.AgeTo)
+	c.Location = clonePtr(c.Location)
+	c.ModerationTaskId = clonePtr(c.ModerationTaskId)
+	c.ModerationResult = clonePtr(c.ModerationResult)
+	return c
+}
@@ -0,0 +1,378 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// limitsThreshold enforces the limits strictly.
+const limitsThreshold = 1.0
+
+type fixture struct {
+	repos      *repo.Repositories
+	advertiser model.Advertiser
+	client     model.Client
+}
+
+func newFixture(t *testing.T) fixture {
+	ctx := context.Background()
+	f := fixture{
+		repos:      NewRepositories(),
+		advertiser: model.Advertiser{Id: uuid.New(), Name: "adv"},
+		client:     model.Client{Id: uuid.New(), Login: "user", Age: ptr(25), Location: "Moscow", Gender: "MALE"},
+	}
+	require.NoError(t, f.repos.Advertiser.UpsertMany(ctx, []model.Advertiser{f.advertiser}))
+	require.NoError(t, f.repos.Client.UpsertMany(ctx, []model.Client{f.client}))
+	return f
+}
+
+// addCampaign adds a campaign active on days [0; 10] with 10 impressions and 5 clicks limits.
+func (f fixture) addCampaign(t *testing.T, modify func(*model.Campaign)) model.Campaign {
+	campaign := model.Campaign{
+		Id:           uuid.New(),
+		CreatedAt:    time.Now(),
+		AdvertiserId: f.advertiser.Id,
+		CampaignCreateRequest: model.CampaignCreateRequest{
+			ImpressionsLimit:  ptr(10),
+			ClicksLimit:       ptr(5),
+			CostPerImpression: ptr(1.0),
+			CostPerClick:      ptr(10.0),
+			AdTitle:           "title",
+			AdText:            "text",
+			StartDate:         ptr(0),
+			EndDate:           ptr(10),
+		},
+		Version: 1,
+	}
+	if modify != nil {
+		modify(&campaign)
+	}
+	require.NoError(t, f.repos.Campaign.Add(context.Background(), campaign))
+	return campaign
+}
+
+func TestCampaignRepo_Add(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, nil)
+
+	got, err := f.repos.Campaign.GetById(ctx, campaign.Id)
+	require.NoError(t, err)
+	assert.Equal(t, campaign, got)
+
+	// stored data can't be modified through pointers
+	*got.StartDate = 5
+	got, _ = f.repos.Campaign.GetById(ctx, campaign.Id)
+	assert.Equal(t, 0, *got.StartDate)
+
+	assert.ErrorIs(t, f.repos.Campaign.Add(ctx, campaign), ErrConstraint)
+	campaign.Id, campaign.AdvertiserId = uuid.New(), uuid.New()
+	assert.ErrorIs(t, f.repos.Campaign.Add(ctx, campaign), ErrConstraint)
+
+	_, err = f.repos.Campaign.GetById(ctx, uuid.New())
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+}
+
+func TestCampaignRepo_UpdateDelete(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, nil)
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: f.client.Id, CampaignId: campaign.Id}, limitsThreshold))
+
+	campaign.AdTitle = "new title"
+	require.NoError(t, f.repos.Campaign.Update(ctx, campaign))
+	got, _ := f.repos.Campaign.GetById(ctx, campaign.Id)
+	assert.Equal(t, "new title", got.AdTitle)
+	assert.Equal(t, 2, got.Version)
+
+	// campaign.Version is stale now
+	assert.ErrorIs(t, f.repos.Campaign.Update(ctx, campaign), repo.ErrVersionConflict)
+	assert.ErrorIs(t, f.repos.Campaign.Delete(ctx, campaign.Id, 1), repo.ErrVersionConflict)
+
+	require.NoError(t, f.repos.Campaign.Delete(ctx, campaign.Id, 2))
+	assert.ErrorIs(t, f.repos.Campaign.Delete(ctx, campaign.Id, 2), repo.ErrNotFound)
+	campaign.Version = 2
+	assert.ErrorIs(t, f.repos.Campaign.Update(ctx, campaign), repo.ErrNotFound)
+
+	_, err := f.repos.Campaign.GetAdImpression(ctx, f.client.Id, campaign.Id)
+	assert.ErrorIs(t, err, repo.ErrNotFound, "impressions must be deleted with the campaign")
+}
+
+func TestCampaignRepo_GetList(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	now := time.Now()
+	second := f.addCampaign(t, func(c *model.Campaign) { c.CreatedAt = now.Add(time.Second) })
+	first := f.addCampaign(t, func(c *model.Campaign) { c.CreatedAt = now })
+	third := f.addCampaign(t, func(c *model.Campaign) { c.CreatedAt = now.Add(2 * time.Second) })
+
+	got, err := f.repos.Campaign.GetList(ctx, f.advertiser.Id, 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Campaign{first, second}, got)
+
+	got, err = f.repos.Campaign.GetList(ctx, f.advertiser.Id, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []model.Campaign{third}, got)
+
+	got, err = f.repos.Campaign.GetList(ctx, uuid.New(), 2, 1)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	_, err = f.repos.Campaign.GetList(ctx, f.advertiser.Id, 2, 0)
+	assert.Error(t, err)
+}
+
+func TestCampaignRepo_GetModerationFailed(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+
+	moderated := func(answer string) func(*model.Campaign) {
+		task := model.AiTask{Id: uuid.New(), Type: model.AiTaskTypeModeration}
+		require.NoError(t, f.repos.Ai.AddTask(ctx, task))
+		if answer != "" {
+			require.NoError(t, f.repos.Ai.AddResult(ctx, model.AiTaskResult{TaskId: task.Id, Answer: answer}))
+		}
+		return func(c *model.Campaign) {
+			c.ModerationTaskId = &task.Id
+		}
+	}
+
+	failed := f.addCampaign(t, moderated(`{"acceptable": false, "reason": "obscene"}`))
+	f.addCampaign(t, moderated(`{"acceptable": true, "reason": ""}`))
+	f.addCampaign(t, moderated(""))
+	f.addCampaign(t, nil)
+
+	got, err := f.repos.Campaign.GetModerationFailed(ctx, 10, 1)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, failed.Id, got[0].Id)
+	assert.Equal(t, &model.AiModerationResult{Acceptable: false, Reason: "obscene"}, got[0].ModerationResult)
+
+	unknownTask := failed
+	unknownTask.Id, unknownTask.ModerationTaskId = uuid.New(), ptr(uuid.New())
+	assert.ErrorIs(t, f.repos.Campaign.Add(ctx, unknownTask), ErrConstraint)
+}
+
+func TestCampaignRepo_GetAdCandidates(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	_, err := f.repos.Settings.Update(ctx, func(s *model.Settings) error {
+		s.CurrentDate = 5
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.repos.MlScore.Upsert(ctx, model.MlScore{ClientId: f.client.Id, AdvertiserId: f.advertiser.Id, Score: ptr(42)}))
+
+	now := time.Now()
+	matching := []model.Campaign{
+		f.addCampaign(t, func(c *model.Campaign) { c.CreatedAt = now }),
+		f.addCampaign(t, func(c *model.Campaign) {
+			c.CreatedAt = now.Add(time.Second)
+			c.Gender, c.AgeFrom, c.AgeTo, c.Location = ptr("MALE"), ptr(25), ptr(25), ptr("Moscow")
+		}),
+		f.addCampaign(t, func(c *model.Campaign) {
+			c.CreatedAt = now.Add(2 * time.Second)
+			c.Gender = ptr("ALL")
+		}),
+	}
+	// not matching campaigns
+	f.addCampaign(t, func(c *model.Campaign) { c.StartDate = ptr(6) })
+	f.addCampaign(t, func(c *model.Campaign) { c.EndDate = ptr(4) })
+	f.addCampaign(t, func(c *model.Campaign) { c.Gender = ptr("FEMALE") })
+	f.addCampaign(t, func(c *model.Campaign) { c.AgeFrom = ptr(26) })
+	f.addCampaign(t, func(c *model.Campaign) { c.AgeTo = ptr(24) })
+	f.addCampaign(t, func(c *model.Campaign) { c.Location = ptr("Paris") })
+	f.addCampaign(t, func(c *model.Campaign) { c.ImpressionsLimit = ptr(0) })
+
+	other := model.Client{Id: uuid.New(), Login: "other", Age: ptr(30), Location: "Paris", Gender: "FEMALE"}
+	require.NoError(t, f.repos.Client.UpsertMany(ctx, []model.Client{other}))
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: f.client.Id, CampaignId: matching[0].Id, Spent: 1, Date: 5}, limitsThreshold))
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: f.client.Id, CampaignId: matching[0].Id, Spent: 10, Date: 5}, limitsThreshold))
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: other.Id, CampaignId: matching[0].Id, Spent: 1, Date: 5}, limitsThreshold))
+
+	got, err := f.repos.Campaign.GetAdCandidates(ctx, f.client.Id, 1.0)
+	require.NoError(t, err)
+	require.Len(t, got, len(matching))
+	for i, candidate := range got {
+		assert.Equal(t, matching[i].Id, candidate.Id)
+		assert.Equal(t, 42, candidate.MlScore)
+		assert.Equal(t, 10, candidate.ImpressionsLimit)
+		assert.Equal(t, 5, candidate.ClicksLimit)
+	}
+	assert.Equal(t, 2, got[0].ImpressionsCount)
+	assert.Equal(t, 1, got[0].ClicksCount)
+	assert.True(t, got[0].Viewed)
+	assert.True(t, got[0].Clicked)
+	assert.False(t, got[1].Viewed)
+
+	// (2 + 1) / 10 > 0.25, so the first campaign is excluded
+	got, err = f.repos.Campaign.GetAdCandidates(ctx, f.client.Id, 0.25)
+	require.NoError(t, err)
+	assert.Len(t, got, len(matching)-1)
+
+	got, err = f.repos.Campaign.GetAdCandidates(ctx, uuid.New(), 1.0)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestCampaignRepo_AdImpressionsAndClicks(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, nil)
+
+	click := model.AdClick{ClientId: f.client.Id, CampaignId: campaign.Id, Spent: 10, Date: 1}
+	assert.ErrorIs(t, f.repos.Campaign.AddAdClick(ctx, click, limitsThreshold), ErrConstraint, "click requires impression")
+
+	impression := model.AdImpression{ClientId: f.client.Id, CampaignId: campaign.Id, Spent: 1, Date: 1}
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, impression, limitsThreshold))
+	// idempotent, the first record is kept
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: f.client.Id, CampaignId: campaign.Id, Spent: 2, Date: 2}, limitsThreshold))
+	got, err := f.repos.Campaign.GetAdImpression(ctx, f.client.Id, campaign.Id)
+	require.NoError(t, err)
+	assert.Equal(t, impression, got)
+
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, click, limitsThreshold))
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, click, limitsThreshold))
+
+	assert.ErrorIs(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: uuid.New(), CampaignId: campaign.Id}, limitsThreshold), ErrConstraint)
+	assert.ErrorIs(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: f.client.Id, CampaignId: uuid.New()}, limitsThreshold), repo.ErrNotFound)
+}
+
+func TestCampaignRepo_AdLimits(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, nil)
+	clients := make([]model.Client, 12)
+	for i := range clients {
+		clients[i] = model.Client{Id: uuid.New(), Login: "user", Location: "Moscow", Gender: "MALE"}
+	}
+	require.NoError(t, f.repos.Client.UpsertMany(ctx, clients))
+
+	for i, client := range clients {
+		err := f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id}, limitsThreshold)
+		if i < 10 {
+			require.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, repo.ErrLimitReached)
+		}
+	}
+	// a repeated impression does not need capacity
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: clients[0].Id, CampaignId: campaign.Id}, limitsThreshold))
+	// the tolerance allows to exceed the limit
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: clients[10].Id, CampaignId: campaign.Id}, 1.1))
+
+	for i, client := range clients[:6] {
+		err := f.repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: client.Id, CampaignId: campaign.Id}, limitsThreshold)
+		if i < 5 {
+			require.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, repo.ErrLimitReached)
+		}
+	}
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: clients[0].Id, CampaignId: campaign.Id}, limitsThreshold))
+}
+
+func TestCampaignRepo_NonBillableClicks(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, func(c *model.Campaign) { c.ClicksLimit = ptr(1) })
+	impressionsOnly := f.addCampaign(t, func(c *model.Campaign) { c.ClicksLimit = ptr(0) })
+
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: f.client.Id, CampaignId: campaign.Id, Date: 1}, limitsThreshold))
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: f.client.Id, CampaignId: campaign.Id, Date: 1}, limitsThreshold))
+	// the campaign has got all clicks, while the one with zero clicks limit is shown for impressions
+	candidates, err := f.repos.Campaign.GetAdCandidates(ctx, f.client.Id, limitsThreshold)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, impressionsOnly.Id, candidates[0].Id)
+
+	click := model.NonBillableClick{ClientId: f.client.Id, CampaignId: campaign.Id, Reason: model.ClickRejectedLimitReached, Date: 1}
+	require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+	// a repeated click is counted once, while a click with another reason is counted separately
+	require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+	click.Reason = model.ClickInvalidTooSoon
+	require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+	require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+	assert.ErrorIs(t, f.repos.Campaign.AddNonBillableClick(ctx, model.NonBillableClick{ClientId: uuid.New(), CampaignId: campaign.Id}), ErrConstraint)
+
+	filter := model.StatsFilter{AdvertiserId: f.advertiser.Id, CampaignId: campaign.Id}
+	rows, err := getRows(ctx, f.repos.Stats, filter)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, 1, rows[0].ClicksCount)
+	assert.Equal(t, 2, rows[0].NonBillableClicksCount)
+	assert.Zero(t, rows[0].SpentClicks)
+
+	filter.ByClient = true
+	rows, err = getRows(ctx, f.repos.Stats, filter)
+	require.NoError(t, err)
+	assert.Len(t, rows, 4)
+
+	require.NoError(t, f.repos.Campaign.Delete(ctx, campaign.Id, campaign.Version))
+	rows, err = getRows(ctx, f.repos.Stats, filter)
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestCampaignRepo_AddAdClickWithDetection(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, nil)
+	other := model.Client{Id: uuid.New(), Login: "other"}
+	require.NoError(t, f.repos.Client.UpsertMany(ctx, []model.Client{other}))
+
+	now := time.Now()
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: f.client.Id, CampaignId: campaign.Id}, limitsThreshold))
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: other.Id, CampaignId: campaign.Id}, limitsThreshold))
+	nonBillable := []model.NonBillableClick{
+		{ClientId: f.client.Id, CampaignId: campaign.Id, CreatedAt: now.Add(-2 * time.Hour), Source: "a"},
+		// too old to be counted
+		{ClientId: f.client.Id, CampaignId: campaign.Id, CreatedAt: now.Add(-48 * time.Hour), Source: "a", Date: 1},
+		{ClientId: f.client.Id, CampaignId: campaign.Id, CreatedAt: now, Source: "a", Date: 2},
+		{ClientId: other.Id, CampaignId: campaign.Id, CreatedAt: now, Source: "a"},
+		{ClientId: other.Id, CampaignId: campaign.Id, CreatedAt: now.Add(-2 * time.Hour), Source: "b", Date: 1},
+		{ClientId: other.Id, CampaignId: campaign.Id, CreatedAt: now, Date: 2},
+	}
+	for _, click := range nonBillable {
+		require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+	}
+
+	addClick := func(clientId uuid.UUID, source string, reason string) model.ClickActivity {
+		t.Helper()
+		var activity model.ClickActivity
+		query := model.ClickActivityQuery{ClientId: clientId, Source: source, WindowStart: now.Add(-time.Hour), HistoryStart: now.Add(-24 * time.Hour)}
+		click := model.AdClick{ClientId: clientId, CampaignId: campaign.Id, CreatedAt: now, Source: source}
+		got, err := f.repos.Campaign.AddAdClickWithDetection(ctx, click, limitsThreshold, query, func(a model.ClickActivity) string {
+			activity = a
+			return reason
+		})
+		require.NoError(t, err)
+		assert.Equal(t, reason, got)
+		return activity
+	}
+
+	// flagged clicks are added as non-billable
+	activity := addClick(f.client.Id, "a", model.ClickInvalidSharedSource)
+	assert.Equal(t, model.ClickActivity{ClientClicks: 1, ClientHistoryClicks: 1, SourceClients: 1}, activity)
+	// clicks without a source are not counted as ones from the same source
+	activity = addClick(f.client.Id, "", "")
+	assert.Equal(t, model.ClickActivity{ClientClicks: 2, ClientHistoryClicks: 1}, activity)
+	stats, err := f.repos.Stats.GetStatsForDate(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.ClicksCount)
+	assert.Equal(t, 3, stats.NonBillableClicksCount)
+
+	// repeated clicks are not checked
+	assert.Equal(t, model.ClickActivity{}, addClick(f.client.Id, "a", ""))
+}
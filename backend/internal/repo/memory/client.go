@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"github.com/google/uuid"
+)
+
+type ClientRepo struct {
+	s *store
+}
+
+func (r *ClientRepo) GetById(_ context.Context, id uuid.UUID) (model.Client, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	client, ok := r.s.clients[id]
+	if !ok {
+		return model.Client{}, repo.ErrNotFound
+	}
+	return cloneClient(client), nil
+}
+
+func (r *ClientRepo) GetMany(_ context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Client, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	clients := make(map[uuid.UUID]model.Client)
+	for _, id := range ids {
+		if client, ok := r.s.clients[id]; ok {
+			clients[id] = cloneClient(client)
+		}
+	}
+	return clients, nil
+}
+
+func (r *ClientRepo) UpsertMany(_ context.Context, clients []model.Client) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, client := range clients {
+		r.s.clients[client.Id] = cloneClient(client)
+	}
+	return nil
+}
+
+func cloneClient(client model.Client) model.Client {
+	client.Age = clonePtr(client.Age)
+	return client
+}
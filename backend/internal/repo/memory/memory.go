@@ -0,0 +1,124 @@
+// Package memory implements repo interfaces on top of in-memory data structures.
+// It mirrors semantics of the PostgreSQL implementations, including foreign key and primary key
+// constraints, so that the server can run without a database in tests and demos.
+// All the data is lost when the process exits.
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"errors"
+	"github.com/google/uuid"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// ErrConstraint is returned when a write would violate a primary or foreign key constraint.
+var ErrConstraint = errors.New("constraint violation")
+
+type adKey struct {
+	clientId   uuid.UUID
+	campaignId uuid.UUID
+}
+
+type mlScoreKey struct {
+	clientId     uuid.UUID
+	advertiserId uuid.UUID
+}
+
+// statsKey identifies a row of the daily stats rollup.
+type statsKey struct {
+	campaignId uuid.UUID
+	date       int
+}
+
+// store holds all the tables. A single lock guards all of them, so every repo method is atomic.
+type store struct {
+	mu sync.RWMutex
+	tables
+
+	apiRequests []model.ApiRequest
+	// snapshot is saved by SandboxRepo; nil if the sandbox is not active
+	snapshot *tables
+}
+
+// tables is the simulated state, which is saved and restored by SandboxRepo.
+type tables struct {
+	advertisers map[uuid.UUID]model.Advertiser
+	clients     map[uuid.UUID]model.Client
+	mlScores    map[mlScoreKey]int
+	// campaignIds keeps the order of insertion, which breaks ties between campaigns with equal created_at
+	campaignIds []uuid.UUID
+	campaigns   map[uuid.UUID]model.Campaign
+	impressions map[adKey]model.AdImpression
+	clicks      map[adKey]model.AdClick
+	// nonBillableClicks keeps the order of insertion
+	nonBillableClicks []model.NonBillableClick
+	// dailyStats is the rollup of impressions and clicks, maintained on each insert; conversion is not set
+	dailyStats map[statsKey]model.CampaignStats
+	aiTaskIds  []uuid.UUID
+	aiTasks    map[uuid.UUID]model.AiTask
+	aiResults  map[uuid.UUID]model.AiTaskResult
+	settings   model.Settings
+	// schedulerJobs maps job name to the last processed day
+	schedulerJobs map[string]int
+}
+
+// NewRepositories creates all repositories sharing a single empty in-memory store.
+func NewRepositories() *repo.Repositories {
+	s := &store{tables: tables{
+		advertisers: make(map[uuid.UUID]model.Advertiser),
+		clients:     make(map[uuid.UUID]model.Client),
+		mlScores:    make(map[mlScoreKey]int),
+		campaigns:   make(map[uuid.UUID]model.Campaign),
+		impressions: make(map[adKey]model.AdImpression),
+		clicks:      make(map[adKey]model.AdClick),
+		dailyStats:  make(map[statsKey]model.CampaignStats),
+		aiTasks:     make(map[uuid.UUID]model.AiTask),
+		aiResults:   make(map[uuid.UUID]model.AiTaskResult),
+
+		schedulerJobs: make(map[string]int),
+	}}
+	return &repo.Repositories{
+		Advertiser: &AdvertiserRepo{s},
+		Ai:         &AiRepo{s},
+		Api:        &ApiRepo{s},
+		Client:     &ClientRepo{s},
+		Campaign:   &CampaignRepo{s},
+		MlScore:    &MlScoreRepo{s},
+		Sandbox:    &SandboxRepo{s},
+		Scheduler:  &SchedulerRepo{s: s},
+		Settings:   &SettingsRepo{s},
+		Stats:      &StatsRepo{s},
+	}
+}
+
+// clone copies the tables. Stored values are never modified in place, so copying maps and slices is enough.
+func (t *tables) clone() *tables {
+	return &tables{
+		advertisers:       maps.Clone(t.advertisers),
+		clients:           maps.Clone(t.clients),
+		mlScores:          maps.Clone(t.mlScores),
+		campaignIds:       slices.Clone(t.campaignIds),
+		campaigns:         maps.Clone(t.campaigns),
+		impressions:       maps.Clone(t.impressions),
+		clicks:            maps.Clone(t.clicks),
+		nonBillableClicks: slices.Clone(t.nonBillableClicks),
+		dailyStats:        maps.Clone(t.dailyStats),
+		aiTaskIds:         slices.Clone(t.aiTaskIds),
+		aiTasks:           maps.Clone(t.aiTasks),
+		aiResults:         maps.Clone(t.aiResults),
+		settings:          t.settings,
+		schedulerJobs:     maps.Clone(t.schedulerJobs),
+	}
+}
+
+// clonePtr copies the value behind p, so that callers can't modify the stored data through pointers.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
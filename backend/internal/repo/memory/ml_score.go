@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+type MlScoreRepo struct {
+	s *store
+}
+
+func (r *MlScoreRepo) Upsert(_ context.Context, score model.MlScore) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.clients[score.ClientId]; !ok {
+		return fmt.Errorf("%w: client %s does not exist", ErrConstraint, score.ClientId)
+	}
+	if _, ok := r.s.advertisers[score.AdvertiserId]; !ok {
+		return fmt.Errorf("%w: advertiser %s does not exist", ErrConstraint, score.AdvertiserId)
+	}
+	if score.Score == nil {
+		return fmt.Errorf("%w: score must not be null", ErrConstraint)
+	}
+
+	r.s.mlScores[mlScoreKey{score.ClientId, score.AdvertiserId}] = *score.Score
+	return nil
+}
+
+func (r *MlScoreRepo) GetByClient(_ context.Context, clientId uuid.UUID) ([]model.MlScore, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	scores := make([]model.MlScore, 0)
+	for key, score := range r.s.mlScores {
+		if key.clientId == clientId {
+			scores = append(scores, model.MlScore{ClientId: clientId, AdvertiserId: key.advertiserId, Score: &score})
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"backend/internal/repo"
+	"context"
+)
+
+type SandboxRepo struct {
+	s *store
+}
+
+func (r *SandboxRepo) Begin(_ context.Context) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.s.snapshot != nil {
+		return repo.ErrSandboxActive
+	}
+	r.s.snapshot = r.s.tables.clone()
+	r.s.settings.Sandbox = true
+	return nil
+}
+
+func (r *SandboxRepo) Discard(_ context.Context) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.s.snapshot == nil {
+		return repo.ErrSandboxInactive
+	}
+	r.s.tables = *r.s.snapshot
+	r.s.snapshot = nil
+	return nil
+}
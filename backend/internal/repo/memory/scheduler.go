@@ -0,0 +1,56 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+type SchedulerRepo struct {
+	s *store
+	// runMu is held while a job runs. The job uses other repos, so the store lock can't be held meanwhile.
+	runMu sync.Mutex
+}
+
+func (r *SchedulerRepo) GetProgress(_ context.Context) (map[string]int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return maps.Clone(r.s.schedulerJobs), nil
+}
+
+func (r *SchedulerRepo) RunNextDay(ctx context.Context, job string, upTo int,
+	run func(ctx context.Context, day int) error) (bool, error) {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	r.s.mu.Lock()
+	lastDay, ok := r.s.schedulerJobs[job]
+	if !ok {
+		lastDay = upTo
+		r.s.schedulerJobs[job] = lastDay
+	}
+	r.s.mu.Unlock()
+	if lastDay >= upTo {
+		return false, nil
+	}
+
+	if err := run(ctx, lastDay+1); err != nil {
+		return false, err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	// the progress may have been rewound or restored from a snapshot meanwhile, which takes precedence
+	if r.s.schedulerJobs[job] == lastDay {
+		r.s.schedulerJobs[job] = lastDay + 1
+	}
+	return true, nil
+}
+
+func (r *SchedulerRepo) Rewind(_ context.Context, day int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for job, lastDay := range r.s.schedulerJobs {
+		r.s.schedulerJobs[job] = min(lastDay, day)
+	}
+	return nil
+}
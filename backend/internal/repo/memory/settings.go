@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+type SettingsRepo struct {
+	s *store
+}
+
+func (r *SettingsRepo) Get(_ context.Context) (model.Settings, error) {
+	return r.GetCached(), nil
+}
+
+// GetCached returns the same as Get, as there is no database to be cached.
+func (r *SettingsRepo) GetCached() model.Settings {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return r.s.settings
+}
+
+// Refresh does nothing, as the settings are never cached.
+func (r *SettingsRepo) Refresh(_ context.Context) error {
+	return nil
+}
+
+// Update applies modify to the current settings and saves them atomically.
+// If modify returns an error, nothing is saved and the error is returned as is.
+func (r *SettingsRepo) Update(_ context.Context, modify func(*model.Settings) error) (model.Settings, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	settings := r.s.settings
+	if err := modify(&settings); err != nil {
+		return model.Settings{}, err
+	}
+	r.s.settings = settings
+	return settings, nil
+}
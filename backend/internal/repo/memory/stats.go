@@ -0,0 +1,259 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+)
+
+type StatsRepo struct {
+	s *store
+}
+
+// EachRow passes stats of each campaign for each day to f, or of each event if filter.ByClient is set.
+// The rows are selected under the lock and passed to f after it is released.
+func (r *StatsRepo) EachRow(_ context.Context, filter model.StatsFilter, f func(row model.StatsRow) error) error {
+	for _, row := range r.rows(filter) {
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *StatsRepo) rows(filter model.StatsFilter) []model.StatsRow {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	rows := make([]model.StatsRow, 0)
+	if !filter.ByClient {
+		for key, day := range r.s.dailyStats {
+			if r.matches(filter, key.campaignId, key.date) {
+				rows = append(rows, r.row(key.campaignId, key.date, nil, day))
+			}
+		}
+		return rows
+	}
+
+	for key, impression := range r.s.impressions {
+		if r.matches(filter, key.campaignId, impression.Date) {
+			client := r.s.clients[key.clientId]
+			rows = append(rows, r.row(key.campaignId, impression.Date, &client, model.CampaignStats{
+				ImpressionsCount: 1,
+				SpentImpressions: impression.Spent,
+			}))
+		}
+	}
+	for key, click := range r.s.clicks {
+		if r.matches(filter, key.campaignId, click.Date) {
+			client := r.s.clients[key.clientId]
+			rows = append(rows, r.row(key.campaignId, click.Date, &client, model.CampaignStats{
+				ClicksCount: 1,
+				SpentClicks: click.Spent,
+			}))
+		}
+	}
+	for _, click := range r.s.nonBillableClicks {
+		if r.matches(filter, click.CampaignId, click.Date) {
+			client := r.s.clients[click.ClientId]
+			rows = append(rows, r.row(click.CampaignId, click.Date, &client, model.CampaignStats{
+				NonBillableClicksCount: 1,
+			}))
+		}
+	}
+	return rows
+}
+
+// GetStatsForDate aggregates impressions and clicks of all campaigns made on the given date.
+func (r *StatsRepo) GetStatsForDate(_ context.Context, date int) (model.CampaignStats, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var stats model.CampaignStats
+	for key, day := range r.s.dailyStats {
+		if key.date == date {
+			stats = addStats(stats, day)
+		}
+	}
+	stats.Date = &date
+	return withConversion(stats), nil
+}
+
+// GetBreakdown returns the totals of all campaigns of the advertiser and a page of campaigns sorted by req.SortBy.
+func (r *StatsRepo) GetBreakdown(_ context.Context, advertiserId uuid.UUID, req model.StatsBreakdownRequest) (model.StatsBreakdown, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	offset := (req.Page - 1) * req.Size
+	if req.Size < 0 || offset < 0 {
+		return model.StatsBreakdown{}, fmt.Errorf("invalid pagination: size %d, page %d", req.Size, req.Page)
+	}
+
+	filter := model.StatsFilter{AdvertiserId: advertiserId, StatsPeriod: req.StatsPeriod}
+	byCampaign := make(map[uuid.UUID]model.CampaignStats)
+	for key, day := range r.s.dailyStats {
+		if r.matches(filter, key.campaignId, key.date) {
+			byCampaign[key.campaignId] = addStats(byCampaign[key.campaignId], day)
+		}
+	}
+
+	var total model.CampaignStats
+	items := make([]model.CampaignStatsItem, 0)
+	// sorted by creation, which breaks ties of the sort below
+	for _, campaign := range (&CampaignRepo{r.s}).sortedCampaigns() {
+		if campaign.AdvertiserId != advertiserId {
+			continue
+		}
+		stats := byCampaign[campaign.Id]
+		total = addStats(total, stats)
+		items = append(items, model.CampaignStatsItem{CampaignId: campaign.Id, AdTitle: campaign.AdTitle, CampaignStats: withConversion(stats)})
+	}
+
+	slices.SortStableFunc(items, func(a, b model.CampaignStatsItem) int {
+		var c int
+		switch req.SortBy {
+		case model.StatsSortConversion:
+			c = cmp.Compare(a.Conversion, b.Conversion)
+		case model.StatsSortImpressionsCount:
+			c = cmp.Compare(a.ImpressionsCount, b.ImpressionsCount)
+		default:
+			c = cmp.Compare(a.SpentTotal, b.SpentTotal)
+		}
+		if req.Order != "asc" {
+			c = -c
+		}
+		return c
+	})
+
+	offset = min(offset, len(items))
+	return model.StatsBreakdown{
+		Total:          withConversion(total),
+		Campaigns:      items[offset:min(offset+req.Size, len(items))],
+		CampaignsCount: len(items),
+	}, nil
+}
+
+// Rebuild recomputes the rollup from the raw events.
+func (r *StatsRepo) Rebuild(_ context.Context) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	dailyStats := make(map[statsKey]model.CampaignStats)
+	for key, impression := range r.s.impressions {
+		day := dailyStats[statsKey{key.campaignId, impression.Date}]
+		day.ImpressionsCount++
+		day.SpentImpressions += impression.Spent
+		dailyStats[statsKey{key.campaignId, impression.Date}] = day
+	}
+	for key, click := range r.s.clicks {
+		day := dailyStats[statsKey{key.campaignId, click.Date}]
+		day.ClicksCount++
+		day.SpentClicks += click.Spent
+		dailyStats[statsKey{key.campaignId, click.Date}] = day
+	}
+	for _, click := range r.s.nonBillableClicks {
+		day := dailyStats[statsKey{click.CampaignId, click.Date}]
+		day.NonBillableClicksCount++
+		dailyStats[statsKey{click.CampaignId, click.Date}] = day
+	}
+	r.s.dailyStats = dailyStats
+	return nil
+}
+
+// GetNonBillableClicks returns a page of the clicks selected by filter, from the most recent.
+func (r *StatsRepo) GetNonBillableClicks(_ context.Context, filter model.NonBillableClicksFilter, size, page int) ([]model.NonBillableClick, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	offset := (page - 1) * size
+	if size < 0 || offset < 0 {
+		return nil, fmt.Errorf("invalid pagination: size %d, page %d", size, page)
+	}
+	clicks := make([]model.NonBillableClick, 0)
+	// clicks are stored in order of insertion, so the most recent ones are at the end
+	for _, click := range slices.Backward(r.s.nonBillableClicks) {
+		if len(clicks) == size {
+			break
+		}
+		if !matchesNonBillable(filter, click) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		clicks = append(clicks, click)
+	}
+	return clicks, nil
+}
+
+// CountNonBillableClicks counts the clicks selected by filter by reason.
+func (r *StatsRepo) CountNonBillableClicks(_ context.Context, filter model.NonBillableClicksFilter) (map[string]int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, click := range r.s.nonBillableClicks {
+		if matchesNonBillable(filter, click) {
+			counts[click.Reason]++
+		}
+	}
+	return counts, nil
+}
+
+func matchesNonBillable(filter model.NonBillableClicksFilter, click model.NonBillableClick) bool {
+	return click.CampaignId == filter.CampaignId && slices.Contains(filter.Reasons, click.Reason) &&
+		(filter.From == nil || click.Date >= *filter.From) &&
+		(filter.To == nil || click.Date <= *filter.To)
+}
+
+func (r *StatsRepo) matches(filter model.StatsFilter, campaignId uuid.UUID, date int) bool {
+	campaign := r.s.campaigns[campaignId]
+	return campaign.AdvertiserId == filter.AdvertiserId &&
+		(filter.CampaignId == uuid.Nil || campaignId == filter.CampaignId) &&
+		(filter.From == nil || date >= *filter.From) &&
+		(filter.To == nil || date <= *filter.To)
+}
+
+func (r *StatsRepo) row(campaignId uuid.UUID, date int, client *model.Client, stats model.CampaignStats) model.StatsRow {
+	campaign := r.s.campaigns[campaignId]
+	row := model.StatsRow{
+		CampaignId:             campaignId,
+		Date:                   date,
+		AdTitle:                campaign.AdTitle,
+		AdText:                 campaign.AdText,
+		ImagePath:              campaign.ImagePath,
+		ImpressionsCount:       stats.ImpressionsCount,
+		ClicksCount:            stats.ClicksCount,
+		NonBillableClicksCount: stats.NonBillableClicksCount,
+		SpentImpressions:       stats.SpentImpressions,
+		SpentClicks:            stats.SpentClicks,
+	}
+	if client != nil {
+		row.Gender = &client.Gender
+		row.Age = clonePtr(client.Age)
+		row.Location = &client.Location
+	}
+	return row
+}
+
+// addStats sums counters of a and b, including the total spent.
+func addStats(a, b model.CampaignStats) model.CampaignStats {
+	a.ImpressionsCount += b.ImpressionsCount
+	a.ClicksCount += b.ClicksCount
+	a.NonBillableClicksCount += b.NonBillableClicksCount
+	a.SpentImpressions += b.SpentImpressions
+	a.SpentClicks += b.SpentClicks
+	a.SpentTotal = a.SpentImpressions + a.SpentClicks
+	return a
+}
+
+func withConversion(stats model.CampaignStats) model.CampaignStats {
+	if stats.ImpressionsCount > 0 {
+		stats.Conversion = float64(stats.ClicksCount) / float64(stats.ImpressionsCount) * 100
+	}
+	return stats
+}
@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsRepo(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	first := f.addCampaign(t, nil)
+	second := f.addCampaign(t, nil)
+
+	clients := make([]model.Client, 4)
+	for i := range clients {
+		clients[i] = model.Client{Id: uuid.New(), Login: "user", Age: ptr(20), Location: "Moscow", Gender: "FEMALE"}
+	}
+	require.NoError(t, f.repos.Client.UpsertMany(ctx, clients))
+
+	// first campaign: 3 impressions on days 1, 1, 2 and a click on day 3
+	for i, date := range []int{1, 1, 2} {
+		require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: clients[i].Id, CampaignId: first.Id, Spent: 1, Date: date}, limitsThreshold))
+	}
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: clients[0].Id, CampaignId: first.Id, Spent: 10, Date: 3}, limitsThreshold))
+	// second campaign: an impression and a click on day 2
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: clients[3].Id, CampaignId: second.Id, Spent: 2, Date: 2}, limitsThreshold))
+	require.NoError(t, f.repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: clients[3].Id, CampaignId: second.Id, Spent: 20, Date: 2}, limitsThreshold))
+
+	rows, err := getRows(ctx, f.repos.Stats, model.StatsFilter{AdvertiserId: f.advertiser.Id, CampaignId: first.Id})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []model.StatsRow{
+		statsRow(first, 1, model.CampaignStats{ImpressionsCount: 2, SpentImpressions: 2}),
+		statsRow(first, 2, model.CampaignStats{ImpressionsCount: 1, SpentImpressions: 1}),
+		statsRow(first, 3, model.CampaignStats{ClicksCount: 1, SpentClicks: 10}),
+	}, rows)
+
+	rows, err = getRows(ctx, f.repos.Stats, model.StatsFilter{AdvertiserId: f.advertiser.Id, StatsPeriod: model.StatsPeriod{From: ptr(2), To: ptr(2)}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []model.StatsRow{
+		statsRow(first, 2, model.CampaignStats{ImpressionsCount: 1, SpentImpressions: 1}),
+		statsRow(second, 2, model.CampaignStats{ImpressionsCount: 1, ClicksCount: 1, SpentImpressions: 2, SpentClicks: 20}),
+	}, rows)
+
+	// split by clients, each event is a separate row
+	rows, err = getRows(ctx, f.repos.Stats, model.StatsFilter{AdvertiserId: f.advertiser.Id, StatsPeriod: model.StatsPeriod{From: ptr(3)}, ByClient: true})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "FEMALE", *rows[0].Gender)
+	assert.Equal(t, 20, *rows[0].Age)
+	assert.Equal(t, "Moscow", *rows[0].Location)
+	assert.Equal(t, 1, rows[0].ClicksCount)
+
+	rows, err = getRows(ctx, f.repos.Stats, model.StatsFilter{AdvertiserId: uuid.New()})
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+
+	// repeated events are not counted twice
+	require.NoError(t, f.repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: clients[0].Id, CampaignId: first.Id, Spent: 1, Date: 5}, limitsThreshold))
+	day, err := f.repos.Stats.GetStatsForDate(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignStats{ImpressionsCount: 2, ClicksCount: 1, Conversion: 50, SpentImpressions: 3, SpentClicks: 20, SpentTotal: 23, Date: ptr(2)}, day)
+
+	// the rollup rebuilt from raw events is the same, including non-billable clicks
+	require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, model.NonBillableClick{ClientId: clients[1].Id, CampaignId: first.Id, Reason: model.ClickRejectedNotViewed, Date: 5}))
+	filter := model.StatsFilter{AdvertiserId: f.advertiser.Id}
+	rows, err = getRows(ctx, f.repos.Stats, filter)
+	require.NoError(t, err)
+	require.NoError(t, f.repos.Stats.Rebuild(ctx))
+	rebuilt, err := getRows(ctx, f.repos.Stats, filter)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, rows, rebuilt)
+	assert.Contains(t, rebuilt, statsRow(first, 5, model.CampaignStats{NonBillableClicksCount: 1}))
+	assert.Len(t, rebuilt, 5)
+
+	// stats are deleted with the campaign
+	require.NoError(t, f.repos.Campaign.Delete(ctx, second.Id, second.Version))
+	rows, err = getRows(ctx, f.repos.Stats, filter)
+	require.NoError(t, err)
+	assert.Len(t, rows, 4)
+}
+
+func TestStatsRepo_NonBillableClicks(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	campaign := f.addCampaign(t, nil)
+	other := f.addCampaign(t, nil)
+
+	reasons := []string{model.ClickInvalidTooSoon, model.ClickInvalidRateSpike, model.ClickRejectedNotViewed, model.ClickInvalidTooSoon}
+	for i, reason := range reasons {
+		click := model.NonBillableClick{ClientId: f.client.Id, CampaignId: campaign.Id, Reason: reason, Date: i}
+		require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+	}
+	click := model.NonBillableClick{ClientId: f.client.Id, CampaignId: other.Id, Reason: model.ClickInvalidTooSoon}
+	require.NoError(t, f.repos.Campaign.AddNonBillableClick(ctx, click))
+
+	filter := model.NonBillableClicksFilter{CampaignId: campaign.Id, Reasons: model.InvalidTrafficReasons}
+	counts, err := f.repos.Stats.CountNonBillableClicks(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{model.ClickInvalidTooSoon: 2, model.ClickInvalidRateSpike: 1}, counts)
+
+	// the most recent clicks come first
+	clicks, err := f.repos.Stats.GetNonBillableClicks(ctx, filter, 2, 1)
+	require.NoError(t, err)
+	require.Len(t, clicks, 2)
+	assert.Equal(t, 3, clicks[0].Date)
+	assert.Equal(t, 1, clicks[1].Date)
+	clicks, err = f.repos.Stats.GetNonBillableClicks(ctx, filter, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, clicks, 1)
+	assert.Equal(t, 0, clicks[0].Date)
+
+	filter.From = ptr(1)
+	filter.To = ptr(2)
+	counts, err = f.repos.Stats.CountNonBillableClicks(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{model.ClickInvalidRateSpike: 1}, counts)
+}
+
+func statsRow(campaign model.Campaign, date int, stats model.CampaignStats) model.StatsRow {
+	return model.StatsRow{
+		CampaignId:       campaign.Id,
+		Date:             date,
+		AdTitle:          campaign.AdTitle,
+		AdText:           campaign.AdText,
+		ImpressionsCount: stats.ImpressionsCount,
+		ClicksCount:      stats.ClicksCount,
+		SpentImpressions: stats.SpentImpressions,
+		SpentClicks:      stats.SpentClicks,
+
+		NonBillableClicksCount: stats.NonBillableClicksCount,
+	}
+}
+
+// getRows collects the rows passed by EachRow.
+func getRows(ctx context.Context, stats repo.Stats, filter model.StatsFilter) ([]model.StatsRow, error) {
+	rows := make([]model.StatsRow, 0)
+	err := stats.EachRow(ctx, filter, func(row model.StatsRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
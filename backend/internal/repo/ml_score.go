@@ -2,6 +2,8 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -9,9 +11,15 @@ type MlScoreRepo struct {
 	db *sqlx.DB
 }
 
-func (r *MlScoreRepo) Upsert(s model.MlScore) (err error) {
-	_, err = r.db.Exec(`INSERT INTO ml_scores (client_id, advertiser_id, score) VALUES ($1, $2, $3)
+func (r *MlScoreRepo) Upsert(ctx context.Context, s model.MlScore) (err error) {
+	_, err = r.db.ExecContext(ctx, `INSERT INTO ml_scores (client_id, advertiser_id, score) VALUES ($1, $2, $3)
 							  ON CONFLICT (client_id, advertiser_id) DO UPDATE SET score = $3`,
 		s.ClientId, s.AdvertiserId, s.Score)
 	return
 }
+
+func (r *MlScoreRepo) GetByClient(ctx context.Context, clientId uuid.UUID) ([]model.MlScore, error) {
+	scores := make([]model.MlScore, 0)
+	err := r.db.SelectContext(ctx, &scores, `SELECT client_id, advertiser_id, score FROM ml_scores WHERE client_id = $1`, clientId)
+	return scores, err
+}
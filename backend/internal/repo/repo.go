@@ -2,57 +2,116 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
+	"time"
 )
 
 type Advertiser interface {
-	GetById(id uuid.UUID) (model.Advertiser, error)
-	GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Advertiser, error)
-	UpsertMany(advertisers []model.Advertiser) error
+	GetById(ctx context.Context, id uuid.UUID) (model.Advertiser, error)
+	GetMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Advertiser, error)
+	UpsertMany(ctx context.Context, advertisers []model.Advertiser) error
 }
 
 type Ai interface {
-	AddTask(task model.AiTask) error
-	GetTask(id uuid.UUID) (model.AiTask, error)
-	GetIncompleteTasks() ([]model.AiTask, error)
-	AddResult(result model.AiTaskResult) error
-	GetResult(taskId uuid.UUID) (model.AiTaskResult, error)
+	AddTask(ctx context.Context, task model.AiTask) error
+	GetTask(ctx context.Context, id uuid.UUID) (model.AiTask, error)
+	GetIncompleteTasks(ctx context.Context) ([]model.AiTask, error)
+	AddResult(ctx context.Context, result model.AiTaskResult) error
+	GetResult(ctx context.Context, taskId uuid.UUID) (model.AiTaskResult, error)
 }
 
 type Api interface {
-	AddRequest(endpoint string, durationMs float64) error
+	AddRequest(ctx context.Context, request model.ApiRequest) error
+	// DeleteRequestsBefore deletes requests logged before the given time and returns their number.
+	DeleteRequestsBefore(ctx context.Context, before time.Time) (int64, error)
 }
 
 type Client interface {
-	GetById(id uuid.UUID) (model.Client, error)
-	GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Client, error)
-	UpsertMany(clients []model.Client) error
+	GetById(ctx context.Context, id uuid.UUID) (model.Client, error)
+	GetMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Client, error)
+	UpsertMany(ctx context.Context, clients []model.Client) error
 }
 
 type Campaign interface {
-	Add(campaign model.Campaign) error
-	GetList(advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error)
-	GetById(id uuid.UUID) (model.Campaign, error)
-	Update(campaign model.Campaign) error
-	Delete(id uuid.UUID) error
-	GetStats(advertiserId uuid.UUID, campaignId uuid.UUID) (model.CampaignStats, error)
-	GetStatsDaily(advertiserId uuid.UUID, campaignId uuid.UUID) ([]model.CampaignStats, error)
-	GetModerationFailed(size int, page int) ([]model.Campaign, error)
-	GetAdCandidates(clientId uuid.UUID, limitsThreshold float64) ([]model.AdCandidate, error)
-	AddAdImpression(impression model.AdImpression) error
-	GetAdImpression(clientId, campaignId uuid.UUID) (model.AdImpression, error)
-	AddAdClick(click model.AdClick) error
+	Add(ctx context.Context, campaign model.Campaign) error
+	GetList(ctx context.Context, advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error)
+	GetById(ctx context.Context, id uuid.UUID) (model.Campaign, error)
+	Update(ctx context.Context, campaign model.Campaign) error
+	// SetModerationTask sets the moderation task of the campaign if its version equals to version, and
+	// increments the version, so that the ETag of the campaign changes. If the versions differ,
+	// ErrVersionConflict is returned.
+	SetModerationTask(ctx context.Context, id uuid.UUID, version int, taskId uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID, version int) error
+	GetModerationFailed(ctx context.Context, size int, page int) ([]model.Campaign, error)
+	GetAdCandidates(ctx context.Context, clientId uuid.UUID, limitsThreshold float64) ([]model.AdCandidate, error)
+	// GetActiveCampaigns and GetViewedAds return the data GetAdCandidates selects from, so that candidates
+	// can be selected in memory from campaigns shared by all clients.
+	GetActiveCampaigns(ctx context.Context, date int) ([]model.ActiveCampaign, error)
+	GetViewedAds(ctx context.Context, clientId uuid.UUID) ([]model.ViewedAd, error)
+	// AddAdImpression and AddAdClick reserve capacity of the campaign atomically: concurrent calls
+	// do not exceed its limits with limitsThreshold tolerance, see WithinLimit.
+	AddAdImpression(ctx context.Context, impression model.AdImpression, limitsThreshold float64) error
+	GetAdImpression(ctx context.Context, clientId, campaignId uuid.UUID) (model.AdImpression, error)
+	AddAdClick(ctx context.Context, click model.AdClick, limitsThreshold float64) error
+	// AddAdClickWithDetection adds the click like AddAdClick, unless detect returns a reason of flagging it
+	// by the click activity selected by query; then the click is added as non-billable with the reason, which
+	// is returned. Repeated clicks are not checked. Concurrent calls for the same client or source are serialized,
+	// so that the activity includes the clicks added by each other.
+	AddAdClickWithDetection(ctx context.Context, click model.AdClick, limitsThreshold float64,
+		query model.ClickActivityQuery, detect func(model.ClickActivity) string) (string, error)
+	AddNonBillableClick(ctx context.Context, click model.NonBillableClick) error
 }
 
 type MlScore interface {
-	Upsert(score model.MlScore) error
+	Upsert(ctx context.Context, score model.MlScore) error
+	GetByClient(ctx context.Context, clientId uuid.UUID) ([]model.MlScore, error)
+}
+
+// Sandbox saves a snapshot of all the simulated state (including settings) and restores it later,
+// so that the date can be moved backwards without leaving inconsistent data behind.
+// The sandbox is active while a snapshot is kept, which is reflected in model.Settings.Sandbox.
+type Sandbox interface {
+	// Begin saves the snapshot and activates the sandbox. It fails with ErrSandboxActive if it is already active.
+	Begin(ctx context.Context) error
+	// Discard restores the snapshot, which also deactivates the sandbox.
+	// It fails with ErrSandboxInactive if there is no snapshot.
+	Discard(ctx context.Context) error
+}
+
+type Scheduler interface {
+	GetProgress(ctx context.Context) (map[string]int, error)
+	// RunNextDay claims the day following the last processed day of the job, if it is not after upTo,
+	// runs the job for it and records the progress. The claim holds until run returns, so concurrent
+	// calls, including ones from other instances, never run the job for the same day twice.
+	// A job which has never been run starts from upTo, so that the history is not replayed.
+	// Returns whether the job has been run.
+	RunNextDay(ctx context.Context, job string, upTo int, run func(ctx context.Context, day int) error) (bool, error)
+	// Rewind moves the progress of the jobs which are ahead of day back to it, so that the later days are run again.
+	Rewind(ctx context.Context, day int) error
+}
+
+type Stats interface {
+	// EachRow passes the rows selected by filter to f one by one, stopping at the first error returned by f.
+	EachRow(ctx context.Context, filter model.StatsFilter, f func(row model.StatsRow) error) error
+	GetStatsForDate(ctx context.Context, date int) (model.CampaignStats, error)
+	// GetBreakdown returns the totals of all campaigns of the advertiser in the period and a page of campaigns
+	// with their own totals, sorted by req.SortBy; campaigns with equal values are ordered by creation.
+	// Campaigns without stats in the period are included with zeros.
+	GetBreakdown(ctx context.Context, advertiserId uuid.UUID, req model.StatsBreakdownRequest) (model.StatsBreakdown, error)
+	// Rebuild recomputes the pre-aggregated stats from the recorded impressions and clicks.
+	Rebuild(ctx context.Context) error
+	// GetNonBillableClicks returns a page of the clicks ordered from the most recent.
+	GetNonBillableClicks(ctx context.Context, filter model.NonBillableClicksFilter, size, page int) ([]model.NonBillableClick, error)
+	// CountNonBillableClicks counts the clicks by reason.
+	CountNonBillableClicks(ctx context.Context, filter model.NonBillableClicksFilter) (map[string]int, error)
 }
 
 type Settings interface {
-	Get() (model.Settings, error)
+	Get(ctx context.Context) (model.Settings, error)
 	GetCached() model.Settings
-	Update(settings model.Settings) error
+	Refresh(ctx context.Context) error
+	Update(ctx context.Context, modify func(*model.Settings) error) (model.Settings, error)
 }
 
 type Repositories struct {
@@ -62,17 +121,25 @@ type Repositories struct {
 	Client     Client
 	Campaign   Campaign
 	MlScore    MlScore
+	Sandbox    Sandbox
+	Scheduler  Scheduler
 	Settings   Settings
+	Stats      Stats
 }
 
-func NewRepositories(db *sqlx.DB) *Repositories {
+// NewRepositories creates repositories backed by db. Only the reads of advertisers, clients, campaigns
+// and stats may be routed to the read replica, see DB.Reader.
+func NewRepositories(db *DB) *Repositories {
 	return &Repositories{
 		Advertiser: &AdvertiserRepo{db},
-		Ai:         &AiRepo{db},
-		Api:        &ApiRepo{db},
+		Ai:         &AiRepo{db.DB},
+		Api:        &ApiRepo{db.DB},
 		Client:     &ClientRepo{db},
 		Campaign:   &CampaignRepo{db},
-		MlScore:    &MlScoreRepo{db},
-		Settings:   NewSettingsRepo(db),
+		MlScore:    &MlScoreRepo{db.DB},
+		Sandbox:    &SandboxRepo{db.DB},
+		Scheduler:  &SchedulerRepo{db.DB},
+		Settings:   NewSettingsRepo(db.DB),
+		Stats:      &StatsRepo{db},
 	}
 }
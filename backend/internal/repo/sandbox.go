@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"strings"
+)
+
+var (
+	ErrSandboxActive   = errors.New("sandbox is already active")
+	ErrSandboxInactive = errors.New("sandbox is not active")
+)
+
+// snapshotSchema keeps copies of snapshotTables while the sandbox is active.
+const snapshotSchema = "sandbox_snapshot"
+
+// snapshotTables are the tables saved by the sandbox, in order of foreign keys.
+// Requests log and migrations are not a part of the simulated state, so they are kept as is.
+var snapshotTables = []string{
+	"settings",
+	"clients",
+	"advertisers",
+	"ml_scores",
+	"ai_tasks",
+	"ai_task_results",
+	"campaigns",
+	"campaign_stats_daily",
+	"ad_impressions",
+	"ad_clicks",
+	"ad_non_billable_clicks",
+	"scheduler_jobs",
+}
+
+// SandboxRepo saves the snapshot as copies of tables in a separate schema. Both Begin and Discard
+// run in a single transaction with the settings row locked, so they are atomic and exclude each other
+// even between several instances. The schema must not be migrated while the sandbox is active,
+// as the snapshot keeps the old one; see SnapshotExists.
+type SandboxRepo struct {
+	db *sqlx.DB
+}
+
+// SnapshotExists reports whether the sandbox is active. Unlike the flag in settings,
+// it can be checked on any schema version, so it is used to refuse migrations while the sandbox is active.
+func SnapshotExists(ctx context.Context, db *sqlx.DB) (bool, error) {
+	var exists bool
+	err := db.GetContext(ctx, &exists, `SELECT EXISTS (SELECT FROM pg_namespace WHERE nspname = $1)`, snapshotSchema)
+	return exists, err
+}
+
+func (r *SandboxRepo) Begin(ctx context.Context) error {
+	return r.inTx(ctx, false, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DROP SCHEMA IF EXISTS `+snapshotSchema+` CASCADE`); err != nil {
+			return fmt.Errorf("drop stale snapshot: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `CREATE SCHEMA `+snapshotSchema); err != nil {
+			return fmt.Errorf("create snapshot schema: %w", err)
+		}
+		for _, table := range snapshotTables {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %s.%s AS TABLE %s`, snapshotSchema, table, table))
+			if err != nil {
+				return fmt.Errorf("snapshot %s: %w", table, err)
+			}
+		}
+		// the flag is set after the copy, so that it is cleared when the snapshot is restored
+		_, err := tx.ExecContext(ctx, `UPDATE settings SET sandbox = TRUE WHERE id > 0`)
+		return err
+	})
+}
+
+func (r *SandboxRepo) Discard(ctx context.Context) error {
+	return r.inTx(ctx, true, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `TRUNCATE `+strings.Join(snapshotTables, ", ")); err != nil {
+			return fmt.Errorf("truncate tables: %w", err)
+		}
+		for _, table := range snapshotTables {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s SELECT * FROM %s.%s`, table, snapshotSchema, table))
+			if err != nil {
+				return fmt.Errorf("restore %s: %w", table, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DROP SCHEMA `+snapshotSchema+` CASCADE`); err != nil {
+			return fmt.Errorf("drop snapshot: %w", err)
+		}
+		return nil
+	})
+}
+
+// inTx locks the settings row, checks that the sandbox state equals to active, runs f and
+// notifies other instances about the change of settings.
+func (r *SandboxRepo) inTx(ctx context.Context, active bool, f func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var sandbox bool
+	if err := tx.GetContext(ctx, &sandbox, `SELECT sandbox FROM settings LIMIT 1 FOR UPDATE`); err != nil {
+		return fmt.Errorf("lock settings: %w", err)
+	}
+	if sandbox != active {
+		if sandbox {
+			return ErrSandboxActive
+		}
+		return ErrSandboxInactive
+	}
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, settingsChannel); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
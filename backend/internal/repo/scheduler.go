@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+)
+
+type SchedulerRepo struct {
+	db *sqlx.DB
+}
+
+// GetProgress returns the last processed day of each job which has ever been run.
+func (r *SchedulerRepo) GetProgress(ctx context.Context) (map[string]int, error) {
+	var rows []struct {
+		Name    string `db:"name"`
+		LastDay int    `db:"last_day"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, `SELECT name, last_day FROM scheduler_jobs`); err != nil {
+		return nil, err
+	}
+
+	progress := make(map[string]int, len(rows))
+	for _, row := range rows {
+		progress[row.Name] = row.LastDay
+	}
+	return progress, nil
+}
+
+// RunNextDay locks the row of the job for the duration of run, and advances last_day in the same transaction,
+// so the day is either done and recorded, or left to the next call.
+func (r *SchedulerRepo) RunNextDay(ctx context.Context, job string, upTo int,
+	run func(ctx context.Context, day int) error) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO scheduler_jobs (name, last_day) VALUES ($1, $2)
+						ON CONFLICT (name) DO NOTHING`, job, upTo)
+	if err != nil {
+		return false, err
+	}
+	var lastDay int
+	err = tx.GetContext(ctx, &lastDay, `SELECT last_day FROM scheduler_jobs WHERE name = $1 FOR UPDATE`, job)
+	if err != nil {
+		return false, err
+	}
+	if lastDay >= upTo {
+		return false, tx.Commit()
+	}
+
+	if err := run(ctx, lastDay+1); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE scheduler_jobs SET last_day = $2 WHERE name = $1`, job, lastDay+1)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (r *SchedulerRepo) Rewind(ctx context.Context, day int) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE scheduler_jobs SET last_day = $1 WHERE last_day > $1`, day)
+	return err
+}
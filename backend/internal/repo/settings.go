@@ -2,36 +2,153 @@ package repo
 
 import (
 	"backend/internal/model"
+	"context"
+	"fmt"
 	"github.com/jmoiron/sqlx"
-	"log"
+	"github.com/lib/pq"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
 )
 
+// settingsChannel is a channel of PostgreSQL notifications sent on each update of settings.
+const settingsChannel = "settings_changed"
+
+// SettingsRepo keeps the settings cached in memory, so that GetCached is cheap enough for hot paths.
+// The cache is updated on each Update and Refresh; see ListenSettings for keeping it consistent
+// between several instances of the backend.
 type SettingsRepo struct {
-	db     *sqlx.DB
+	db *sqlx.DB
+
+	mu     sync.RWMutex
 	cached model.Settings
+	// syncMu serializes loading of the settings with storing them in the cache, so that a Refresh which has read
+	// the settings before an Update cannot overwrite the cache with them after the Update.
+	syncMu sync.Mutex
 }
 
 func NewSettingsRepo(db *sqlx.DB) *SettingsRepo {
 	r := &SettingsRepo{db: db}
-	settings, err := r.Get()
-	if err != nil {
-		log.Fatalf("get settings: %s\n", err)
+	if err := r.Refresh(context.Background()); err != nil {
+		slog.Error("get settings", "error", err)
+		os.Exit(1)
 	}
-	r.cached = settings
 	return r
 }
 
-func (r *SettingsRepo) Get() (s model.Settings, err error) {
-	err = r.db.Get(&s, `SELECT "current_date" FROM settings LIMIT 1`)
+func (r *SettingsRepo) Get(ctx context.Context) (s model.Settings, err error) {
+	err = r.db.GetContext(ctx, &s, `SELECT "current_date", moderation_enabled, sandbox FROM settings LIMIT 1`)
 	return
 }
 
 func (r *SettingsRepo) GetCached() model.Settings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.cached
 }
 
-func (r *SettingsRepo) Update(settings model.Settings) error {
-	_, err := r.db.Exec(`UPDATE settings SET "current_date" = $1 WHERE id > 0`, settings.CurrentDate)
+// Refresh reloads the cached settings from the database.
+func (r *SettingsRepo) Refresh(ctx context.Context) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	settings, err := r.Get(ctx)
+	if err != nil {
+		return err
+	}
+	r.setCached(settings)
+	return nil
+}
+
+// Update applies modify to the current settings and saves them. The settings row is locked during
+// the update, so concurrent updates (even from other instances) are never lost.
+// If modify returns an error, nothing is saved and the error is returned as is.
+// Other instances are notified about the change through settingsChannel.
+func (r *SettingsRepo) Update(ctx context.Context, modify func(*model.Settings) error) (model.Settings, error) {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return model.Settings{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var settings model.Settings
+	err = tx.GetContext(ctx, &settings, `SELECT "current_date", moderation_enabled, sandbox FROM settings LIMIT 1 FOR UPDATE`)
+	if err != nil {
+		return model.Settings{}, fmt.Errorf("lock settings: %w", err)
+	}
+	if err := modify(&settings); err != nil {
+		return model.Settings{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE settings SET "current_date" = $1, moderation_enabled = $2, sandbox = $3 WHERE id > 0`,
+		settings.CurrentDate, settings.ModerationEnabled, settings.Sandbox)
+	if err != nil {
+		return model.Settings{}, fmt.Errorf("update settings: %w", err)
+	}
+	// the notification is delivered only when the transaction is committed
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, settingsChannel); err != nil {
+		return model.Settings{}, fmt.Errorf("notify: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return model.Settings{}, fmt.Errorf("commit: %w", err)
+	}
+
+	r.setCached(settings)
+	return settings, nil
+}
+
+func (r *SettingsRepo) setCached(settings model.Settings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.cached = settings
-	return err
+}
+
+// ListenSettings keeps the cache of settings consistent with updates made by other instances of the backend.
+// It refreshes the settings on each PostgreSQL notification about an update, and also every refreshInterval
+// (if it is not zero) in case a notification is lost. It blocks until ctx is done.
+func ListenSettings(ctx context.Context, dsn string, settings Settings, refreshInterval time.Duration, log *slog.Logger) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("settings listener", "error", err)
+		}
+	})
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	if err := listener.Listen(settingsChannel); err != nil {
+		log.Error("listen for settings changes, falling back to periodic refresh", "channel", settingsChannel, "error", err)
+	}
+	refreshLoop(ctx, settings, listener.NotificationChannel(), refreshInterval, log)
+}
+
+// refreshLoop refreshes settings on each notification and every refreshInterval, until ctx is done.
+// The listener sends nil notification after reconnect, when notifications may have been missed,
+// so the settings are refreshed in this case too.
+func refreshLoop(ctx context.Context, settings Settings, notifications <-chan *pq.Notification, refreshInterval time.Duration, log *slog.Logger) {
+	var tick <-chan time.Time
+	if refreshInterval > 0 {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notifications:
+		case <-tick:
+		}
+
+		if err := settings.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.ErrorContext(ctx, "refresh settings", "error", err)
+		}
+	}
 }
@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// countingSettings counts calls of Refresh.
+type countingSettings struct {
+	Settings
+	refreshed atomic.Int32
+}
+
+func (s *countingSettings) Refresh(_ context.Context) error {
+	s.refreshed.Add(1)
+	return nil
+}
+
+func TestRefreshLoop(t *testing.T) {
+	settings := &countingSettings{}
+	notifications := make(chan *pq.Notification)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		refreshLoop(ctx, settings, notifications, 0, slog.Default())
+		close(done)
+	}()
+
+	notifications <- &pq.Notification{Channel: settingsChannel}
+	// nil is sent by listener after reconnect
+	notifications <- nil
+	cancel()
+	<-done
+	if got := settings.refreshed.Load(); got != 2 {
+		t.Errorf("refreshLoop() refreshed %d times, want 2", got)
+	}
+}
+
+func TestRefreshLoop_Interval(t *testing.T) {
+	settings := &countingSettings{}
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+
+	refreshLoop(ctx, settings, nil, 10*time.Millisecond, slog.Default())
+	if got := settings.refreshed.Load(); got < 3 {
+		t.Errorf("refreshLoop() refreshed %d times, want at least 3", got)
+	}
+}
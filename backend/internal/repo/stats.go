@@ -0,0 +1,311 @@
+package repo
+
+import (
+	"backend/internal/model"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// StatsRepo reads stats from the campaign_stats_daily rollup table, which is updated by CampaignRepo
+// in the same statement as each impression or click is recorded.
+type StatsRepo struct {
+	db *DB
+}
+
+// statsColumns aggregates rows of campaign_stats_daily; conversion is computed by withConversion.
+const statsColumns = `
+    COALESCE(SUM(s.impressions_count), 0) AS impressions_count,
+    COALESCE(SUM(s.clicks_count), 0) AS clicks_count,
+    COALESCE(SUM(s.non_billable_clicks_count), 0) AS non_billable_clicks_count,
+    COALESCE(SUM(s.spent_impressions), 0) AS spent_impressions,
+    COALESCE(SUM(s.spent_clicks), 0) AS spent_clicks,
+    COALESCE(SUM(s.spent_impressions), 0) + COALESCE(SUM(s.spent_clicks), 0) AS spent_total`
+
+// EachRow passes stats of each campaign for each day to f, split by client attributes if filter.ByClient is set.
+// Rows are read from the rollup, unless they must be split by clients: then raw events are aggregated.
+// Rows are passed as they are read from the database, so f holds the connection and should not block.
+func (r *StatsRepo) EachRow(ctx context.Context, filter model.StatsFilter, f func(row model.StatsRow) error) error {
+	where := []string{"c.advertiser_id = $1"}
+	args := []any{filter.AdvertiserId}
+	// I wanted to omit advertiserId when it equals uuid.Nil, but because their ids are set
+	// by the API caller (and it's possible to set an ID that equals to uuid.Nil), there is a rare case
+	// when the query will be incorrect.
+	if filter.CampaignId != uuid.Nil {
+		args = append(args, filter.CampaignId)
+		where = append(where, fmt.Sprintf("c.id = $%d", len(args)))
+	}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		where = append(where, fmt.Sprintf("s.date >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		where = append(where, fmt.Sprintf("s.date <= $%d", len(args)))
+	}
+
+	query := `SELECT s.campaign_id, s.date, c.ad_title, c.ad_text, c.image_path,
+    s.impressions_count, s.clicks_count, s.non_billable_clicks_count, s.spent_impressions, s.spent_clicks
+FROM campaign_stats_daily s
+JOIN campaigns c ON s.campaign_id = c.id
+WHERE %s`
+	if filter.ByClient {
+		query = `SELECT s.campaign_id, s.date, cl.gender, cl.age, cl.location, c.ad_title, c.ad_text, c.image_path,
+    SUM(s.impressions_count) AS impressions_count, SUM(s.clicks_count) AS clicks_count,
+    SUM(s.non_billable_clicks_count) AS non_billable_clicks_count,
+    SUM(s.spent_impressions) AS spent_impressions, SUM(s.spent_clicks) AS spent_clicks
+FROM (
+    SELECT client_id, campaign_id, date, 1 AS impressions_count, 0 AS clicks_count, 0 AS non_billable_clicks_count,
+        spent AS spent_impressions, 0 AS spent_clicks
+    FROM ad_impressions
+    UNION ALL
+    SELECT client_id, campaign_id, date, 0, 1, 0, 0, spent FROM ad_clicks
+    UNION ALL
+    SELECT client_id, campaign_id, date, 0, 0, 1, 0, 0 FROM ad_non_billable_clicks
+) s
+JOIN campaigns c ON s.campaign_id = c.id
+JOIN clients cl ON s.client_id = cl.id
+WHERE %s
+GROUP BY s.campaign_id, s.date, cl.gender, cl.age, cl.location, c.id`
+	}
+
+	rows, err := r.db.Reader(ctx).QueryxContext(ctx, fmt.Sprintf(query, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row model.StatsRow
+		if err := rows.StructScan(&row); err != nil {
+			return err
+		}
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetStatsForDate aggregates impressions and clicks of all campaigns made on the given date.
+func (r *StatsRepo) GetStatsForDate(ctx context.Context, date int) (model.CampaignStats, error) {
+	var stats model.CampaignStats
+	err := r.db.Reader(ctx).GetContext(ctx, &stats, `SELECT `+statsColumns+`
+FROM campaign_stats_daily s
+WHERE s.date = $1`, date)
+	stats.Date = &date
+	return withConversion(stats), err
+}
+
+// breakdownSortColumns maps model.StatsBreakdownRequest.SortBy to columns of the breakdown query.
+var breakdownSortColumns = map[string]string{
+	model.StatsSortSpentTotal:       "spent_total",
+	model.StatsSortConversion:       "conversion",
+	model.StatsSortImpressionsCount: "impressions_count",
+}
+
+// GetBreakdown sorts and paginates campaigns in a single query. The number of campaigns and the totals are
+// computed by window functions over all of them, and are joined to the page, so that they are returned
+// even if the page is past the end: then the only row has no campaign.
+func (r *StatsRepo) GetBreakdown(ctx context.Context, advertiserId uuid.UUID, req model.StatsBreakdownRequest) (model.StatsBreakdown, error) {
+	// the period is applied to the join rather than to WHERE, to keep campaigns without stats
+	var period strings.Builder
+	args := []any{advertiserId}
+	if req.From != nil {
+		args = append(args, *req.From)
+		fmt.Fprintf(&period, " AND s.date >= $%d", len(args))
+	}
+	if req.To != nil {
+		args = append(args, *req.To)
+		fmt.Fprintf(&period, " AND s.date <= $%d", len(args))
+	}
+	args = append(args, req.Size, (req.Page-1)*req.Size)
+
+	sortColumn, ok := breakdownSortColumns[req.SortBy]
+	if !ok {
+		sortColumn = breakdownSortColumns[model.StatsSortSpentTotal]
+	}
+	order := "DESC"
+	if req.Order == "asc" {
+		order = "ASC"
+	}
+	orderBy := func(table string) string {
+		return fmt.Sprintf("%[1]s.%[2]s %[3]s, %[1]s.created_at, %[1]s.campaign_id", table, sortColumn, order)
+	}
+
+	var rows []struct {
+		CampaignId             uuid.NullUUID `db:"campaign_id"`
+		AdTitle                string        `db:"ad_title"`
+		ImpressionsCount       int           `db:"impressions_count"`
+		ClicksCount            int           `db:"clicks_count"`
+		NonBillableClicksCount int           `db:"non_billable_clicks_count"`
+		SpentImpressions       float64       `db:"spent_impressions"`
+		SpentClicks            float64       `db:"spent_clicks"`
+
+		CampaignsCount              int     `db:"campaigns_count"`
+		TotalImpressionsCount       int     `db:"total_impressions_count"`
+		TotalClicksCount            int     `db:"total_clicks_count"`
+		TotalNonBillableClicksCount int     `db:"total_non_billable_clicks_count"`
+		TotalSpentImpressions       float64 `db:"total_spent_impressions"`
+		TotalSpentClicks            float64 `db:"total_spent_clicks"`
+	}
+	err := r.db.Reader(ctx).SelectContext(ctx, &rows, fmt.Sprintf(`WITH campaigns_stats AS (
+    SELECT c.id AS campaign_id, c.ad_title, c.created_at,`+statsColumns+`
+    FROM campaigns c
+    LEFT JOIN campaign_stats_daily s ON s.campaign_id = c.id%s
+    WHERE c.advertiser_id = $1
+    GROUP BY c.id
+), ranked AS (
+    SELECT *,
+        CASE WHEN impressions_count > 0 THEN clicks_count::float8 / impressions_count * 100 ELSE 0 END AS conversion,
+        COUNT(*) OVER () AS campaigns_count,
+        SUM(impressions_count) OVER () AS total_impressions_count,
+        SUM(clicks_count) OVER () AS total_clicks_count,
+        SUM(non_billable_clicks_count) OVER () AS total_non_billable_clicks_count,
+        SUM(spent_impressions) OVER () AS total_spent_impressions,
+        SUM(spent_clicks) OVER () AS total_spent_clicks
+    FROM campaigns_stats
+)
+SELECT t.*, p.campaign_id, COALESCE(p.ad_title, '') AS ad_title,
+    COALESCE(p.impressions_count, 0) AS impressions_count, COALESCE(p.clicks_count, 0) AS clicks_count,
+    COALESCE(p.non_billable_clicks_count, 0) AS non_billable_clicks_count,
+    COALESCE(p.spent_impressions, 0) AS spent_impressions, COALESCE(p.spent_clicks, 0) AS spent_clicks
+FROM (
+    SELECT campaigns_count, total_impressions_count, total_clicks_count, total_non_billable_clicks_count,
+        total_spent_impressions, total_spent_clicks
+    FROM ranked
+    LIMIT 1
+) t
+LEFT JOIN (SELECT * FROM ranked r ORDER BY %s LIMIT $%d OFFSET $%d) p ON TRUE
+ORDER BY %s`, period.String(), orderBy("r"), len(args)-1, len(args), orderBy("p")), args...)
+	if err != nil {
+		return model.StatsBreakdown{}, err
+	}
+
+	breakdown := model.StatsBreakdown{Campaigns: make([]model.CampaignStatsItem, 0, len(rows))}
+	for _, row := range rows {
+		breakdown.CampaignsCount = row.CampaignsCount
+		breakdown.Total = withConversion(model.CampaignStats{
+			ImpressionsCount:       row.TotalImpressionsCount,
+			ClicksCount:            row.TotalClicksCount,
+			NonBillableClicksCount: row.TotalNonBillableClicksCount,
+			SpentImpressions:       row.TotalSpentImpressions,
+			SpentClicks:            row.TotalSpentClicks,
+			SpentTotal:             row.TotalSpentImpressions + row.TotalSpentClicks,
+		})
+		if !row.CampaignId.Valid {
+			continue
+		}
+		breakdown.Campaigns = append(breakdown.Campaigns, model.CampaignStatsItem{
+			CampaignId: row.CampaignId.UUID,
+			AdTitle:    row.AdTitle,
+			CampaignStats: withConversion(model.CampaignStats{
+				ImpressionsCount:       row.ImpressionsCount,
+				ClicksCount:            row.ClicksCount,
+				NonBillableClicksCount: row.NonBillableClicksCount,
+				SpentImpressions:       row.SpentImpressions,
+				SpentClicks:            row.SpentClicks,
+				SpentTotal:             row.SpentImpressions + row.SpentClicks,
+			}),
+		})
+	}
+	return breakdown, nil
+}
+
+// Rebuild recomputes the rollup from the raw events. Writes of events are blocked while it runs,
+// so that none of them is lost or counted twice.
+func (r *StatsRepo) Rebuild(ctx context.Context) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE ad_impressions, ad_clicks, ad_non_billable_clicks IN SHARE MODE`); err != nil {
+		return fmt.Errorf("lock events: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM campaign_stats_daily`); err != nil {
+		return fmt.Errorf("clear rollup: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO campaign_stats_daily (campaign_id, date, impressions_count, clicks_count, non_billable_clicks_count,
+    spent_impressions, spent_clicks)
+SELECT campaign_id, date, SUM(impressions_count), SUM(clicks_count), SUM(non_billable_clicks_count),
+    SUM(spent_impressions), SUM(spent_clicks)
+FROM (
+    SELECT campaign_id, date, 1 AS impressions_count, 0 AS clicks_count, 0 AS non_billable_clicks_count,
+        spent AS spent_impressions, 0 AS spent_clicks
+    FROM ad_impressions
+    UNION ALL
+    SELECT campaign_id, date, 0, 1, 0, 0, spent FROM ad_clicks
+    UNION ALL
+    SELECT campaign_id, date, 0, 0, 1, 0, 0 FROM ad_non_billable_clicks
+) events
+GROUP BY campaign_id, date`)
+	if err != nil {
+		return fmt.Errorf("fill rollup: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// GetNonBillableClicks returns a page of the clicks selected by filter, from the most recent.
+func (r *StatsRepo) GetNonBillableClicks(ctx context.Context, filter model.NonBillableClicksFilter, size, page int) ([]model.NonBillableClick, error) {
+	where, args := nonBillableClicksWhere(filter)
+	args = append(args, size, (page-1)*size)
+	clicks := make([]model.NonBillableClick, 0)
+	err := r.db.Reader(ctx).SelectContext(ctx, &clicks, fmt.Sprintf(`SELECT client_id, campaign_id, reason, date, created_at, source
+FROM ad_non_billable_clicks
+WHERE %s
+ORDER BY created_at DESC, id DESC
+LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+	return clicks, err
+}
+
+// CountNonBillableClicks counts the clicks selected by filter by reason.
+func (r *StatsRepo) CountNonBillableClicks(ctx context.Context, filter model.NonBillableClicksFilter) (map[string]int, error) {
+	where, args := nonBillableClicksWhere(filter)
+	var rows []struct {
+		Reason string `db:"reason"`
+		Count  int    `db:"count"`
+	}
+	err := r.db.Reader(ctx).SelectContext(ctx, &rows, fmt.Sprintf(`SELECT reason, COUNT(*) AS "count"
+FROM ad_non_billable_clicks
+WHERE %s
+GROUP BY reason`, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Reason] = row.Count
+	}
+	return counts, nil
+}
+
+func nonBillableClicksWhere(filter model.NonBillableClicksFilter) (string, []any) {
+	where := []string{"campaign_id = $1", "reason = ANY($2::text[])"}
+	args := []any{filter.CampaignId, pq.Array(filter.Reasons)}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		where = append(where, fmt.Sprintf("date >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		where = append(where, fmt.Sprintf("date <= $%d", len(args)))
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// withConversion sets the conversion of clicks to impressions, in percents.
+func withConversion(stats model.CampaignStats) model.CampaignStats {
+	if stats.ImpressionsCount > 0 {
+		stats.Conversion = float64(stats.ClicksCount) / float64(stats.ImpressionsCount) * 100
+	}
+	return stats
+}
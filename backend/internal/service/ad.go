@@ -1,51 +1,82 @@
 package service
 
 import (
+	"backend/config"
+	"backend/internal/metrics"
 	"backend/internal/model"
 	"backend/internal/repo"
 	"backend/pkg/floatutil"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"log/slog"
 	"slices"
+	"time"
 )
 
 type AdService struct {
 	campaignRepo repo.Campaign
+	clientRepo   repo.Client
+	mlScoreRepo  repo.MlScore
 	settingsRepo repo.Settings
+	config       config.Ads
+	weights      rankingWeights
+	cache        *adCache
+	log          *slog.Logger
 }
 
-const (
-	revenueViewWeight  = 0.5 / (0.5 + 0.25) * 0.5
-	revenueClickWeight = 0.5 / (0.5 + 0.25) * 0.5
-	mlScoreWeight      = 0.25 / (0.5 + 0.25)
-)
+// NewAdService creates AdService with the cache configured by config.CacheTtl. Writes to campaigns, clients and
+// ML scores made by other services must go through the repositories wrapped by wrapRepos to invalidate it.
+func NewAdService(campaignRepo repo.Campaign, clientRepo repo.Client, mlScoreRepo repo.MlScore, settingsRepo repo.Settings,
+	config config.Ads, log *slog.Logger) *AdService {
+	return &AdService{campaignRepo, clientRepo, mlScoreRepo, settingsRepo, config, newRankingWeights(config.Ranking),
+		newAdCache(config.CacheTtl), log}
+}
+
+// wrapRepos returns a copy of repos with repositories which invalidate the cache of the service on writes.
+func (s *AdService) wrapRepos(repos *repo.Repositories) *repo.Repositories {
+	wrapped := *repos
+	wrapped.Campaign = &adCacheCampaignRepo{repos.Campaign, s.cache}
+	wrapped.Client = &adCacheClientRepo{repos.Client, s.cache}
+	wrapped.MlScore = &adCacheMlScoreRepo{repos.MlScore, s.cache}
+	wrapped.Sandbox = &adCacheSandboxRepo{repos.Sandbox, s.cache}
+	return &wrapped
+}
 
-const limitsThreshold = 1.04
+// rankingWeights are the weights of config.Ranking normalized to sum up to 1.
+type rankingWeights struct {
+	impression, click, mlScore float64
+}
+
+func newRankingWeights(r config.Ranking) rankingWeights {
+	sum := r.ImpressionRevenue + r.ClickRevenue + r.MlScore
+	return rankingWeights{r.ImpressionRevenue / sum, r.ClickRevenue / sum, r.MlScore / sum}
+}
 
-func compareAdCandidates(a, b model.AdCandidate) int {
+func (w rankingWeights) compare(a, b model.AdCandidate) int {
 	A := 0.0
 	B := 0.0
 
 	if a.Viewed && !b.Viewed {
-		B += revenueViewWeight
+		B += w.impression
 	} else if !a.Viewed && b.Viewed {
-		A += revenueViewWeight
+		A += w.impression
 	} else if !a.Viewed && !b.Viewed {
-		A += floatutil.Norm(a.CostPerImpression, b.CostPerImpression) * revenueViewWeight
-		B += floatutil.Norm(b.CostPerImpression, a.CostPerImpression) * revenueViewWeight
+		A += floatutil.Norm(a.CostPerImpression, b.CostPerImpression) * w.impression
+		B += floatutil.Norm(b.CostPerImpression, a.CostPerImpression) * w.impression
 	}
 
 	if a.Clicked && !b.Clicked {
-		B += revenueViewWeight
+		B += w.click
 	} else if !a.Clicked && b.Clicked {
-		A += revenueViewWeight
+		A += w.click
 	} else if !a.Clicked && !b.Clicked {
-		A += floatutil.Norm(a.CostPerClick, b.CostPerClick) * revenueClickWeight
-		B += floatutil.Norm(b.CostPerClick, a.CostPerClick) * revenueClickWeight
+		A += floatutil.Norm(a.CostPerClick, b.CostPerClick) * w.click
+		B += floatutil.Norm(b.CostPerClick, a.CostPerClick) * w.click
 	}
 
-	A += floatutil.Norm(float64(a.MlScore), float64(b.MlScore)) * mlScoreWeight
-	B += floatutil.Norm(float64(b.MlScore), float64(a.MlScore)) * mlScoreWeight
+	A += floatutil.Norm(float64(a.MlScore), float64(b.MlScore)) * w.mlScore
+	B += floatutil.Norm(float64(b.MlScore), float64(a.MlScore)) * w.mlScore
 
 	if A < B {
 		return -1
@@ -55,70 +86,233 @@ func compareAdCandidates(a, b model.AdCandidate) int {
 	return 0
 }
 
-// chooseAdCandidate chooses the most suitable ad for user to display. If no ad is suitable,
-// the last return value is false. It modifies the input slice.
-func chooseAdCandidate(candidates []model.AdCandidate) (model.AdCandidate, bool) {
-	if len(candidates) == 0 {
-		return model.AdCandidate{}, false
+// rankAdCandidates sorts candidates from the most suitable ad for user to display to the least suitable one.
+func (w rankingWeights) rankAdCandidates(candidates []model.AdCandidate) {
+	slices.SortFunc(candidates, func(a, b model.AdCandidate) int {
+		return w.compare(b, a)
+	})
+}
+
+func (s *AdService) GetAd(ctx context.Context, client model.Client) (model.Ad, error) {
+	ad, err := s.getAd(ctx, client)
+	switch {
+	case err == nil:
+		metrics.AdServes.WithLabelValues(metrics.OutcomeServed).Inc()
+		s.log.DebugContext(ctx, "ad served", "campaign_id", ad.Id)
+	case repo.IsNotFound(err):
+		metrics.AdServes.WithLabelValues(metrics.OutcomeNotFound).Inc()
+		s.log.DebugContext(ctx, "no ad for client")
+	default:
+		metrics.AdServes.WithLabelValues(metrics.OutcomeError).Inc()
 	}
+	return ad, err
+}
 
-	slices.SortFunc(candidates, compareAdCandidates)
-	return candidates[len(candidates)-1], true
+// GetClient returns the client an ad is requested for. It is served from the cache of the service together
+// with the other data of the client which ad candidates depend on, so it may be behind by config.CacheTtl.
+func (s *AdService) GetClient(ctx context.Context, id uuid.UUID) (model.Client, error) {
+	if !s.cache.enabled() {
+		return s.clientRepo.GetById(ctx, id)
+	}
+	state, _, err := s.getClientState(ctx, id)
+	if err != nil {
+		return model.Client{}, err
+	}
+	return state.client, nil
 }
 
-func (s *AdService) GetAd(client model.Client) (model.Ad, error) {
-	candidates, err := s.campaignRepo.GetAdCandidates(client.Id, limitsThreshold)
+func (s *AdService) getClientState(ctx context.Context, id uuid.UUID) (*clientAdState, bool, error) {
+	if state, ok := s.cache.getClient(id); ok {
+		return state, true, nil
+	}
+	generation := s.cache.currentGeneration()
+	client, err := s.clientRepo.GetById(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	viewed, err := s.campaignRepo.GetViewedAds(ctx, id)
 	if err != nil {
-		return model.Ad{}, fmt.Errorf("get ad candidates: %w", err)
+		return nil, false, fmt.Errorf("get viewed ads: %w", err)
 	}
+	scores, err := s.mlScoreRepo.GetByClient(ctx, id)
+	if err != nil {
+		return nil, false, fmt.Errorf("get ml scores: %w", err)
+	}
+	state := newClientAdState(client, viewed, scores)
+	s.cache.putClient(generation, state)
+	return state, false, nil
+}
 
-	candidate, ok := chooseAdCandidate(candidates)
-	if !ok {
-		return model.Ad{}, repo.ErrNotFound
+func (s *AdService) getActiveCampaigns(ctx context.Context, date int) ([]model.ActiveCampaign, bool, error) {
+	if campaigns, ok := s.cache.getCampaigns(date); ok {
+		return campaigns, true, nil
+	}
+	generation := s.cache.currentGeneration()
+	campaigns, err := s.campaignRepo.GetActiveCampaigns(ctx, date)
+	if err != nil {
+		return nil, false, fmt.Errorf("get active campaigns: %w", err)
 	}
+	s.cache.putCampaigns(generation, date, campaigns)
+	return campaigns, false, nil
+}
 
-	currentDate := s.settingsRepo.GetCached().CurrentDate
+// getCachedAdCandidates selects ad candidates of the client from the cached active campaigns and data of the client,
+// loading what is not cached. Without the cache, the candidates are selected by the database.
+func (s *AdService) getCachedAdCandidates(ctx context.Context, clientId uuid.UUID, date int) ([]model.AdCandidate, bool, error) {
+	if !s.cache.enabled() {
+		candidates, err := s.campaignRepo.GetAdCandidates(ctx, clientId, s.config.LimitsThreshold)
+		if err != nil {
+			return nil, false, fmt.Errorf("get ad candidates: %w", err)
+		}
+		return candidates, false, nil
+	}
+	state, clientCached, err := s.getClientState(ctx, clientId)
+	if err != nil {
+		return nil, false, fmt.Errorf("get client: %w", err)
+	}
+	campaigns, campaignsCached, err := s.getActiveCampaigns(ctx, date)
+	if err != nil {
+		return nil, false, err
+	}
+	cached := clientCached && campaignsCached
+	if cached {
+		metrics.AdCacheRequests.WithLabelValues(metrics.CacheHit).Inc()
+	} else {
+		metrics.AdCacheRequests.WithLabelValues(metrics.CacheMiss).Inc()
+	}
+	return s.cache.selectCandidates(campaigns, state, s.config.LimitsThreshold), cached, nil
+}
 
-	if err := s.campaignRepo.AddAdImpression(model.AdImpression{
-		ClientId:   client.Id,
-		CampaignId: candidate.Id,
-		Spent:      candidate.CostPerImpression,
-		Date:       currentDate,
-	}); err != nil {
-		return model.Ad{}, fmt.Errorf("add impression: %w", err)
+func (s *AdService) getAd(ctx context.Context, client model.Client) (model.Ad, error) {
+	currentDate := s.settingsRepo.GetCached().CurrentDate
+	candidates, cached, err := s.getCachedAdCandidates(ctx, client.Id, currentDate)
+	if err != nil {
+		return model.Ad{}, err
 	}
+	metrics.AdCandidates.Observe(float64(len(candidates)))
+	s.log.DebugContext(ctx, "found ad candidates", "count", len(candidates), "cached", cached)
 
-	return candidate.Ad, nil
+	// the capacity of the campaign is reserved with the impression, so concurrent requests may take
+	// the last impressions of the best candidate after it was selected; then the next one is shown
+	s.weights.rankAdCandidates(candidates)
+	for _, candidate := range candidates {
+		err := s.campaignRepo.AddAdImpression(ctx, model.AdImpression{
+			ClientId:   client.Id,
+			CampaignId: candidate.Id,
+			Spent:      candidate.CostPerImpression,
+			Date:       currentDate,
+			CreatedAt:  time.Now(),
+		}, s.config.LimitsThreshold)
+		switch {
+		case err == nil:
+			s.cache.addImpression(client.Id, candidate)
+			return candidate.Ad, nil
+		case repo.IsLimitReached(err):
+			s.log.DebugContext(ctx, "impressions limit reached", "campaign_id", candidate.Id)
+			s.cache.exhaust(candidate.Id)
+		case repo.IsNotFound(err):
+			// the campaign has been deleted after the candidates were loaded, possibly through another instance
+			s.cache.invalidateCampaigns()
+		default:
+			return model.Ad{}, fmt.Errorf("add impression: %w", err)
+		}
+	}
+	return model.Ad{}, repo.ErrNotFound
 }
 
-func (s *AdService) GetAdCandidates(client model.Client) ([]model.AdCandidate, error) {
-	candidates, err := s.campaignRepo.GetAdCandidates(client.Id, limitsThreshold)
+func (s *AdService) GetAdCandidates(ctx context.Context, client model.Client) ([]model.AdCandidate, error) {
+	candidates, err := s.campaignRepo.GetAdCandidates(ctx, client.Id, s.config.LimitsThreshold)
 	if err != nil {
 		return nil, fmt.Errorf("get ad candidates: %w", err)
 	}
 	return candidates, nil
 }
 
-func (s *AdService) IsAdViewed(clientId, campaignId uuid.UUID) (bool, error) {
-	_, err := s.campaignRepo.GetAdImpression(clientId, campaignId)
-	if err != nil {
-		if repo.IsNotFound(err) {
-			return false, nil
-		}
-		return false, fmt.Errorf("get ad impression: %w", err)
-	}
-	return true, nil
+// ClickRejectedError is returned by ClickAd when the click does not satisfy the acceptance rules.
+type ClickRejectedError struct {
+	// Reason is one of model.ClickRejected* constants.
+	Reason string
+}
+
+func (e *ClickRejectedError) Error() string {
+	return "click rejected: " + e.Reason
 }
 
-func (s *AdService) ClickAd(client model.Client, campaign model.Campaign) error {
-	err := s.campaignRepo.AddAdClick(model.AdClick{
+// ClickAd charges the click of the client on the ad of the campaign if the click is accepted: the client has seen
+// the ad, the campaign is active, not more than config.ClickAttributionWindow days have passed since the impression,
+// and the clicks limit is not reached. Otherwise the click is recorded as non-billable, unless the client has not
+// seen the ad, and ClickRejectedError is returned. Clicks flagged as invalid traffic are also recorded
+// as non-billable, but no error is returned.
+// Repeated clicks of the client are charged once and are not checked for invalid traffic.
+// Source is the IP address the click was made from.
+func (s *AdService) ClickAd(ctx context.Context, client model.Client, campaign model.Campaign, source string) error {
+	click := model.AdClick{
 		ClientId:   client.Id,
 		CampaignId: campaign.Id,
 		Spent:      *campaign.CostPerClick,
 		Date:       s.settingsRepo.GetCached().CurrentDate,
+		CreatedAt:  time.Now(),
+		Source:     source,
+	}
+	reason, impression, err := s.checkClick(ctx, click, campaign)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		reason, err = s.addClick(ctx, click, impression)
+		switch {
+		case err == nil && reason == "":
+			metrics.AdClicks.WithLabelValues(metrics.ClickAccepted).Inc()
+			s.cache.addClick(client.Id, campaign.Id)
+			return nil
+		case err == nil:
+			// the flagged click has been added as non-billable
+			metrics.AdClicks.WithLabelValues(reason).Inc()
+			s.log.InfoContext(ctx, "click flagged as invalid traffic", "reason", reason, "source", source)
+			return nil
+		case repo.IsLimitReached(err):
+			reason = model.ClickRejectedLimitReached
+		default:
+			return fmt.Errorf("add click: %w", err)
+		}
+	}
+
+	metrics.AdClicks.WithLabelValues(reason).Inc()
+	s.log.DebugContext(ctx, "click rejected", "reason", reason)
+	if reason == model.ClickRejectedNotViewed {
+		// anyone can send such clicks for any campaign, so they are only counted in metrics
+		return &ClickRejectedError{reason}
+	}
+	err = s.campaignRepo.AddNonBillableClick(ctx, model.NonBillableClick{
+		ClientId:   click.ClientId,
+		CampaignId: click.CampaignId,
+		Reason:     reason,
+		Date:       click.Date,
+		CreatedAt:  click.CreatedAt,
+		Source:     click.Source,
 	})
 	if err != nil {
-		return fmt.Errorf("add click: %w", err)
+		return fmt.Errorf("add non-billable click: %w", err)
+	}
+	return &ClickRejectedError{reason}
+}
+
+// checkClick returns the reason of rejecting the click, or an empty string if it is accepted by the rules
+// which do not depend on the clicks limit and the click activity, together with the impression of the ad.
+func (s *AdService) checkClick(ctx context.Context, click model.AdClick, campaign model.Campaign) (string, model.AdImpression, error) {
+	// impressions are deleted together with the campaign, so an impression of a deleted campaign never matches
+	impression, err := s.campaignRepo.GetAdImpression(ctx, click.ClientId, click.CampaignId)
+	if repo.IsNotFound(err) {
+		return model.ClickRejectedNotViewed, impression, nil
+	}
+	if err != nil {
+		return "", impression, fmt.Errorf("get ad impression: %w", err)
+	}
+	if click.Date < *campaign.StartDate || click.Date > *campaign.EndDate {
+		return model.ClickRejectedCampaignNotActive, impression, nil
+	}
+	if click.Date-impression.Date > s.config.ClickAttributionWindow {
+		return model.ClickRejectedAttributionExpired, impression, nil
 	}
-	return nil
+	return "", impression, nil
 }
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// adCache keeps the campaigns active on the current date and the data of recently served clients in memory
+// for a short time, so that ad candidates are selected without querying the database. The campaigns are shared
+// by all clients, so a client seen for the first time only needs lookups of its own data, see clientAdState.
+// The entries are invalidated by writes made through this instance, see the repositories below; writes made
+// by other instances become visible after ttl at most.
+//
+// Counts of impressions and clicks of the campaigns are running counters, incremented on impressions and clicks
+// served by this instance, so that the limits are checked against up-to-date counts. They are replaced by counts
+// from the database, which include impressions and clicks of other instances, when the campaigns are loaded again.
+//
+// Each invalidation increments generation. Data loaded from the database is put only if the generation has not
+// changed since the load started, as the load may have read the data from before the write.
+// Zero ttl disables the cache.
+type adCache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	generation uint64
+	campaigns  cacheEntry[activeCampaigns]
+	counters   map[uuid.UUID]adCounters
+	clients    map[uuid.UUID]cacheEntry[*clientAdState]
+	nextSweep  time.Time
+}
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+type activeCampaigns struct {
+	date int
+	list []model.ActiveCampaign
+}
+
+type adCounters struct {
+	impressions, clicks int
+	// exhausted is set when an impression was rejected because of the limit, as the counts may be behind
+	exhausted bool
+}
+
+// clientAdState is the data of the client which its ad candidates depend on besides campaigns.
+type clientAdState struct {
+	client model.Client
+	// mlScores are the ML scores of the client by advertiser id
+	mlScores map[uuid.UUID]int
+	// viewed and clicked are the ids of campaigns the ads of which the client has viewed and clicked
+	viewed, clicked map[uuid.UUID]bool
+}
+
+func newClientAdState(client model.Client, viewed []model.ViewedAd, scores []model.MlScore) *clientAdState {
+	state := &clientAdState{
+		client:   client,
+		mlScores: make(map[uuid.UUID]int, len(scores)),
+		viewed:   make(map[uuid.UUID]bool, len(viewed)),
+		clicked:  make(map[uuid.UUID]bool),
+	}
+	for _, score := range scores {
+		state.mlScores[score.AdvertiserId] = *score.Score
+	}
+	for _, ad := range viewed {
+		state.viewed[ad.CampaignId] = true
+		state.clicked[ad.CampaignId] = ad.Clicked
+	}
+	return state
+}
+
+func newAdCache(ttl time.Duration) *adCache {
+	return &adCache{
+		ttl:      ttl,
+		counters: make(map[uuid.UUID]adCounters),
+		clients:  make(map[uuid.UUID]cacheEntry[*clientAdState]),
+	}
+}
+
+func (c *adCache) enabled() bool {
+	return c.ttl > 0
+}
+
+// currentGeneration must be read before loading the data which is put into the cache afterwards.
+func (c *adCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// getCampaigns returns the cached campaigns active on the date. The result must not be modified.
+func (c *adCache) getCampaigns(date int) ([]model.ActiveCampaign, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.campaigns
+	if entry.value.date != date || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value.list, true
+}
+
+// putCampaigns caches campaigns loaded for the date, unless the cache has been invalidated since generation.
+// Their counts replace the running counters.
+func (c *adCache) putCampaigns(generation uint64, date int, campaigns []model.ActiveCampaign) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	c.campaigns = cacheEntry[activeCampaigns]{activeCampaigns{date, campaigns}, time.Now().Add(c.ttl)}
+	clear(c.counters)
+	for _, campaign := range campaigns {
+		c.counters[campaign.Id] = adCounters{impressions: campaign.ImpressionsCount, clicks: campaign.ClicksCount}
+	}
+}
+
+func (c *adCache) getClient(id uuid.UUID) (*clientAdState, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.clients[id]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// putClient caches the state of the client, unless the cache has been invalidated since generation.
+func (c *adCache) putClient(generation uint64, state *clientAdState) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	c.clients[state.client.Id] = cacheEntry[*clientAdState]{state, time.Now().Add(c.ttl)}
+	c.sweep()
+}
+
+// selectCandidates selects ad candidates of the client from the campaigns with the running counters applied,
+// by the same criteria as repo.Campaign.GetAdCandidates. The order of the campaigns is kept.
+func (c *adCache) selectCandidates(campaigns []model.ActiveCampaign, state *clientAdState, limitsThreshold float64) []model.AdCandidate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	candidates := make([]model.AdCandidate, 0)
+	for _, campaign := range campaigns {
+		candidate := campaign.AdCandidate
+		if counters, ok := c.counters[candidate.Id]; ok {
+			if counters.exhausted {
+				continue
+			}
+			candidate.ImpressionsCount = counters.impressions
+			candidate.ClicksCount = counters.clicks
+		}
+		if !campaign.Matches(state.client) ||
+			!repo.WithinLimit(candidate.ImpressionsCount, candidate.ImpressionsLimit, limitsThreshold) ||
+			repo.ClicksExhausted(candidate.ClicksCount, candidate.ClicksLimit, limitsThreshold) {
+			continue
+		}
+		candidate.MlScore = state.mlScores[candidate.AdvertiserId]
+		candidate.Viewed = state.viewed[candidate.Id]
+		candidate.Clicked = state.clicked[candidate.Id]
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// addImpression counts the impression of the candidate shown to the client, unless the client has already seen it.
+func (c *adCache) addImpression(clientId uuid.UUID, candidate model.AdCandidate) {
+	if !c.enabled() || candidate.Viewed {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.clients[clientId]; ok {
+		if entry.value.viewed[candidate.Id] {
+			return
+		}
+		entry.value.viewed[candidate.Id] = true
+	}
+	if counters, ok := c.counters[candidate.Id]; ok {
+		counters.impressions++
+		c.counters[candidate.Id] = counters
+	}
+}
+
+// exhaust excludes the campaign from candidates until the campaigns are loaded again.
+func (c *adCache) exhaust(campaignId uuid.UUID) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counters := c.counters[campaignId]
+	counters.exhausted = true
+	c.counters[campaignId] = counters
+}
+
+// addClick counts the click of the client if its state is cached. Otherwise it is not known whether the client
+// has already clicked the ad, and the count is updated when the campaigns are loaded next time.
+func (c *adCache) addClick(clientId, campaignId uuid.UUID) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.clients[clientId]
+	if !ok || entry.value.clicked[campaignId] {
+		return
+	}
+	entry.value.clicked[campaignId] = true
+	if counters, ok := c.counters[campaignId]; ok {
+		counters.clicks++
+		c.counters[campaignId] = counters
+	}
+}
+
+// invalidateClient drops the state of the client, after the client or its ML scores have changed.
+func (c *adCache) invalidateClient(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.clients, id)
+}
+
+// invalidateCampaigns drops the campaigns, after any campaign has changed. They are loaded once
+// for all clients on the next request.
+func (c *adCache) invalidateCampaigns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.campaigns = cacheEntry[activeCampaigns]{}
+	clear(c.counters)
+}
+
+// invalidateAll drops all entries, after the whole state has been replaced.
+func (c *adCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.campaigns = cacheEntry[activeCampaigns]{}
+	clear(c.counters)
+	clear(c.clients)
+}
+
+// sweep removes expired entries once in ttl, so that entries of clients which do not return are not kept forever.
+// It must be called with c.mu locked.
+func (c *adCache) sweep() {
+	now := time.Now()
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+	for id, entry := range c.clients {
+		if now.After(entry.expires) {
+			delete(c.clients, id)
+		}
+	}
+}
+
+// The repositories below invalidate adCache on writes, so that services making the writes do not need to know about it.
+
+type adCacheCampaignRepo struct {
+	repo.Campaign
+	cache *adCache
+}
+
+func (r *adCacheCampaignRepo) Add(ctx context.Context, campaign model.Campaign) error {
+	defer r.cache.invalidateCampaigns()
+	return r.Campaign.Add(ctx, campaign)
+}
+
+func (r *adCacheCampaignRepo) Update(ctx context.Context, campaign model.Campaign) error {
+	defer r.cache.invalidateCampaigns()
+	return r.Campaign.Update(ctx, campaign)
+}
+
+func (r *adCacheCampaignRepo) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	defer r.cache.invalidateCampaigns()
+	return r.Campaign.Delete(ctx, id, version)
+}
+
+type adCacheClientRepo struct {
+	repo.Client
+	cache *adCache
+}
+
+func (r *adCacheClientRepo) UpsertMany(ctx context.Context, clients []model.Client) error {
+	defer func() {
+		for _, client := range clients {
+			r.cache.invalidateClient(client.Id)
+		}
+	}()
+	return r.Client.UpsertMany(ctx, clients)
+}
+
+type adCacheMlScoreRepo struct {
+	repo.MlScore
+	cache *adCache
+}
+
+func (r *adCacheMlScoreRepo) Upsert(ctx context.Context, score model.MlScore) error {
+	defer r.cache.invalidateClient(score.ClientId)
+	return r.MlScore.Upsert(ctx, score)
+}
+
+type adCacheSandboxRepo struct {
+	repo.Sandbox
+	cache *adCache
+}
+
+func (r *adCacheSandboxRepo) Discard(ctx context.Context) error {
+	defer r.cache.invalidateAll()
+	return r.Sandbox.Discard(ctx)
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"backend/config"
+	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/internal/repo/memory"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdCache_Candidates(t *testing.T) {
+	cache := newAdCache(time.Minute)
+	female := "FEMALE"
+	campaign := model.ActiveCampaign{AdCandidate: model.AdCandidate{Ad: model.Ad{Id: uuid.New(), AdvertiserId: uuid.New()},
+		ImpressionsCount: 1, ImpressionsLimit: 3, ClicksLimit: 2}}
+	targeted := model.ActiveCampaign{AdCandidate: model.AdCandidate{Ad: model.Ad{Id: uuid.New()}, ImpressionsLimit: 3},
+		CampaignTargeting: model.CampaignTargeting{Gender: &female}}
+	score := 1
+	client := newClientAdState(model.Client{Id: uuid.New(), Gender: "MALE"}, nil,
+		[]model.MlScore{{AdvertiserId: campaign.AdvertiserId, Score: &score}})
+	other := newClientAdState(model.Client{Id: uuid.New(), Gender: "FEMALE"}, []model.ViewedAd{{CampaignId: targeted.Id}}, nil)
+
+	_, ok := cache.getCampaigns(1)
+	assert.False(t, ok)
+	cache.putCampaigns(cache.currentGeneration(), 1, []model.ActiveCampaign{campaign, targeted})
+	cache.putClient(cache.currentGeneration(), client)
+	cache.putClient(cache.currentGeneration(), other)
+	campaigns, ok := cache.getCampaigns(1)
+	require.True(t, ok)
+	// candidates depend on the current date
+	_, ok = cache.getCampaigns(2)
+	assert.False(t, ok)
+
+	got := cache.selectCandidates(campaigns, client, 1)
+	require.Len(t, got, 1)
+	assert.Equal(t, campaign.Id, got[0].Id)
+	assert.Equal(t, 1, got[0].MlScore)
+	got = cache.selectCandidates(campaigns, other, 1)
+	require.Len(t, got, 2)
+	assert.True(t, got[1].Viewed)
+
+	// the impression is counted for all clients, and the client has seen the ad
+	cache.addImpression(client.client.Id, got[0])
+	got = cache.selectCandidates(campaigns, other, 1)
+	assert.Equal(t, 2, got[0].ImpressionsCount)
+	assert.False(t, got[0].Viewed)
+	got = cache.selectCandidates(campaigns, client, 1)
+	assert.True(t, got[0].Viewed)
+	// repeated impressions are not counted, like in the database
+	cache.addImpression(client.client.Id, model.AdCandidate{Ad: campaign.Ad})
+	got = cache.selectCandidates(campaigns, client, 1)
+	assert.Equal(t, 2, got[0].ImpressionsCount)
+
+	cache.addClick(client.client.Id, campaign.Id)
+	cache.addClick(client.client.Id, campaign.Id)
+	got = cache.selectCandidates(campaigns, other, 1)
+	assert.Equal(t, 1, got[0].ClicksCount)
+	assert.False(t, got[0].Clicked)
+
+	// the campaign reaches the limit
+	cache.addImpression(other.client.Id, got[0])
+	assert.Empty(t, cache.selectCandidates(campaigns, client, 1))
+	// another campaign is exhausted through another instance
+	cache.exhaust(targeted.Id)
+	assert.Empty(t, cache.selectCandidates(campaigns, other, 1))
+}
+
+func TestAdCache_Invalidate(t *testing.T) {
+	cache := newAdCache(time.Minute)
+	client := newClientAdState(model.Client{Id: uuid.New()}, nil, nil)
+	fill := func() {
+		cache.putClient(cache.currentGeneration(), client)
+		cache.putCampaigns(cache.currentGeneration(), 0, []model.ActiveCampaign{{}})
+	}
+
+	fill()
+	cache.invalidateClient(client.client.Id)
+	_, ok := cache.getClient(client.client.Id)
+	assert.False(t, ok)
+	_, ok = cache.getCampaigns(0)
+	assert.True(t, ok)
+
+	fill()
+	cache.invalidateCampaigns()
+	_, ok = cache.getClient(client.client.Id)
+	assert.True(t, ok)
+	_, ok = cache.getCampaigns(0)
+	assert.False(t, ok)
+
+	fill()
+	cache.invalidateAll()
+	_, ok = cache.getClient(client.client.Id)
+	assert.False(t, ok)
+	_, ok = cache.getCampaigns(0)
+	assert.False(t, ok)
+}
+
+func TestAdCache_Generation(t *testing.T) {
+	cache := newAdCache(time.Minute)
+	client := newClientAdState(model.Client{Id: uuid.New()}, nil, nil)
+
+	// the data was loaded before the invalidation, so it may be stale
+	generation := cache.currentGeneration()
+	cache.invalidateCampaigns()
+	cache.putCampaigns(generation, 0, nil)
+	cache.putClient(generation, client)
+	_, ok := cache.getCampaigns(0)
+	assert.False(t, ok)
+	_, ok = cache.getClient(client.client.Id)
+	assert.False(t, ok)
+
+	generation = cache.currentGeneration()
+	cache.putCampaigns(generation, 0, nil)
+	cache.putClient(generation, client)
+	_, ok = cache.getCampaigns(0)
+	assert.True(t, ok)
+	_, ok = cache.getClient(client.client.Id)
+	assert.True(t, ok)
+}
+
+func TestAdCache_Expiration(t *testing.T) {
+	cache := newAdCache(10 * time.Millisecond)
+	client := newClientAdState(model.Client{Id: uuid.New()}, nil, nil)
+	cache.putClient(0, client)
+	cache.putCampaigns(0, 0, nil)
+	time.Sleep(20 * time.Millisecond)
+
+	_, ok := cache.getClient(client.client.Id)
+	assert.False(t, ok)
+	_, ok = cache.getCampaigns(0)
+	assert.False(t, ok)
+
+	// expired entries are removed on the next write
+	cache.putClient(0, newClientAdState(model.Client{Id: uuid.New()}, nil, nil))
+	assert.Len(t, cache.clients, 1)
+
+	disabled := newAdCache(0)
+	disabled.putClient(0, client)
+	_, ok = disabled.getClient(client.client.Id)
+	assert.False(t, ok)
+}
+
+// countingCampaignRepo counts queries of the campaigns ad candidates are selected from.
+type countingCampaignRepo struct {
+	repo.Campaign
+	campaignQueries int
+}
+
+func (r *countingCampaignRepo) GetActiveCampaigns(ctx context.Context, date int) ([]model.ActiveCampaign, error) {
+	r.campaignQueries++
+	return r.Campaign.GetActiveCampaigns(ctx, date)
+}
+
+func TestAdService_GetAd_Cache(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	campaignRepo := &countingCampaignRepo{Campaign: repos.Campaign}
+	service := NewAdService(campaignRepo, repos.Client, repos.MlScore, repos.Settings, config.DefaultAds, slog.Default())
+	wrapped := service.wrapRepos(repos)
+
+	client := model.Client{Id: uuid.New(), Login: "user", Location: "Moscow", Gender: "MALE"}
+	otherClient := model.Client{Id: uuid.New(), Login: "other", Location: "Moscow", Gender: "FEMALE"}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, wrapped.Client.UpsertMany(ctx, []model.Client{client, otherClient}))
+	require.NoError(t, wrapped.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	addCampaign := func(costPerImpression float64) model.Campaign {
+		return newTestCampaign(t, wrapped, advertiser, func(c *model.Campaign) { c.CostPerImpression = ptr(costPerImpression) })
+	}
+	cheap := addCampaign(1)
+
+	for range 3 {
+		ad, err := service.GetAd(ctx, client)
+		require.NoError(t, err)
+		assert.Equal(t, cheap.Id, ad.Id)
+	}
+	// the campaigns are shared by all clients
+	ad, err := service.GetAd(ctx, otherClient)
+	require.NoError(t, err)
+	assert.Equal(t, cheap.Id, ad.Id)
+	assert.Equal(t, 1, campaignRepo.campaignQueries)
+
+	// a new campaign is a candidate immediately
+	expensive := addCampaign(10)
+	ad, err = service.GetAd(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, expensive.Id, ad.Id)
+	assert.Equal(t, 2, campaignRepo.campaignQueries)
+
+	// so is a change of ML score, which does not drop the campaigns
+	score := 5
+	require.NoError(t, wrapped.MlScore.Upsert(ctx, model.MlScore{ClientId: client.Id, AdvertiserId: advertiser.Id, Score: &score}))
+	_, ok := service.cache.getClient(client.Id)
+	assert.False(t, ok)
+	_, ok = service.cache.getCampaigns(0)
+	assert.True(t, ok)
+
+	// only ad serving reads clients from the cache
+	got, err := service.GetClient(ctx, client.Id)
+	require.NoError(t, err)
+	assert.Equal(t, client, got)
+	renamed := client
+	renamed.Login = "renamed"
+	require.NoError(t, repos.Client.UpsertMany(ctx, []model.Client{renamed}))
+	got, err = service.GetClient(ctx, client.Id)
+	require.NoError(t, err)
+	assert.Equal(t, client, got)
+	got, err = wrapped.Client.GetById(ctx, client.Id)
+	require.NoError(t, err)
+	assert.Equal(t, renamed, got)
+}
+
+func TestAdService_CachedCandidatesMatchRepo(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	adsConfig := config.DefaultAds
+	cached := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+	adsConfig.CacheTtl = 0
+	uncached := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+
+	age := 30
+	clients := []model.Client{
+		{Id: uuid.New(), Login: "a", Age: &age, Location: "Moscow", Gender: "MALE"},
+		{Id: uuid.New(), Login: "b", Location: "Kazan", Gender: "FEMALE"},
+	}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, clients))
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	score := 7
+	require.NoError(t, repos.MlScore.Upsert(ctx, model.MlScore{ClientId: clients[0].Id, AdvertiserId: advertiser.Id, Score: &score}))
+
+	male, moscow, ageFrom, ageTo := "MALE", "Moscow", 18, 25
+	for i, targeting := range []model.CampaignTargeting{
+		{},
+		{Gender: &male},
+		{Location: &moscow, AgeFrom: &ageFrom},
+		{AgeTo: &ageTo},
+	} {
+		newTestCampaign(t, repos, advertiser, func(c *model.Campaign) {
+			c.CreatedAt = time.Now().Add(time.Duration(i) * time.Second)
+			c.ImpressionsLimit = ptr(2)
+			c.ClicksLimit = ptr(1)
+			c.CostPerImpression = ptr(float64(i + 1))
+			c.CampaignTargeting = targeting
+		})
+	}
+
+	for range 3 {
+		for _, client := range clients {
+			want, _, err := uncached.getCachedAdCandidates(ctx, client.Id, 0)
+			require.NoError(t, err)
+			got, _, err := cached.getCachedAdCandidates(ctx, client.Id, 0)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			if _, err := cached.GetAd(ctx, client); !repo.IsNotFound(err) {
+				require.NoError(t, err)
+			}
+		}
+	}
+}
@@ -1,10 +1,47 @@
 package service
 
 import (
+	"backend/config"
 	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/internal/repo/memory"
+	"context"
+	"log/slog"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// newTestCampaign adds a campaign of the advertiser active on days [0; 5] with 10 impressions and 10 clicks limits,
+// each costing 1, after modify changes it.
+func newTestCampaign(t *testing.T, repos *repo.Repositories, advertiser model.Advertiser, modify func(*model.Campaign)) model.Campaign {
+	t.Helper()
+	campaign := model.Campaign{
+		Id:           uuid.New(),
+		CreatedAt:    time.Now(),
+		AdvertiserId: advertiser.Id,
+		CampaignCreateRequest: model.CampaignCreateRequest{
+			ImpressionsLimit:  ptr(10),
+			ClicksLimit:       ptr(10),
+			CostPerImpression: ptr(1.0),
+			CostPerClick:      ptr(1.0),
+			AdTitle:           "title",
+			AdText:            "text",
+			StartDate:         ptr(0),
+			EndDate:           ptr(5),
+		},
+		Version: 1,
+	}
+	if modify != nil {
+		modify(&campaign)
+	}
+	require.NoError(t, repos.Campaign.Add(context.Background(), campaign))
+	return campaign
+}
+
 const (
 	wantA     = 1
 	wantB     = -1
@@ -68,9 +105,131 @@ func TestCompareAdCandidates(t *testing.T) {
 			wantA,
 		},
 	}
+	weights := newRankingWeights(config.DefaultAds.Ranking)
 	for _, tt := range tests {
-		if got := compareAdCandidates(tt.a, tt.b); got != tt.want {
-			t.Errorf("%s: compareAdCandidates(%v, %v) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+		if got := weights.compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: compare(%v, %v) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
 		}
 	}
 }
+
+func TestRankingWeights(t *testing.T) {
+	cheap := model.AdCandidate{CostPerImpression: 1, MlScore: 100}
+	relevant := model.AdCandidate{CostPerImpression: 10, MlScore: 1}
+
+	revenueOnly := newRankingWeights(config.Ranking{ImpressionRevenue: 1})
+	assert.Equal(t, wantB, revenueOnly.compare(cheap, relevant))
+
+	// only the ratio of weights matters
+	mlOnly := newRankingWeights(config.Ranking{MlScore: 5})
+	assert.Equal(t, rankingWeights{mlScore: 1}, mlOnly)
+	candidates := []model.AdCandidate{relevant, cheap}
+	mlOnly.rankAdCandidates(candidates)
+	assert.Equal(t, []model.AdCandidate{cheap, relevant}, candidates)
+}
+
+func TestAdService_GetAd(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	adsConfig := config.DefaultAds
+	// the ad is clicked right after the impression
+	adsConfig.InvalidTraffic.Enabled = false
+	service := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+
+	age := 20
+	client := model.Client{Id: uuid.New(), Login: "user", Age: &age, Location: "Moscow", Gender: "MALE"}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, []model.Client{client}))
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	require.NoError(t, (&SettingsService{repos.Settings, repos.Scheduler}).SetDate(ctx, 3))
+
+	addCampaign := func(costPerImpression float64) model.Campaign {
+		return newTestCampaign(t, repos, advertiser, func(c *model.Campaign) {
+			c.ImpressionsLimit = ptr(1)
+			c.ClicksLimit = ptr(1)
+			c.CostPerImpression = ptr(costPerImpression)
+		})
+	}
+	cheap := addCampaign(1)
+	expensive := addCampaign(10)
+
+	// the most expensive ad is shown first, and is not shown again when the impressions limit is reached
+	for _, want := range []model.Campaign{expensive, cheap} {
+		ad, err := service.GetAd(ctx, client)
+		require.NoError(t, err)
+		assert.Equal(t, want.Id, ad.Id)
+
+		impression, err := repos.Campaign.GetAdImpression(ctx, client.Id, want.Id)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), impression.CreatedAt, time.Minute)
+		impression.CreatedAt = time.Time{}
+		assert.Equal(t, model.AdImpression{ClientId: client.Id, CampaignId: want.Id, Spent: *want.CostPerImpression, Date: 3}, impression)
+	}
+
+	_, err := service.GetAd(ctx, client)
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+
+	require.NoError(t, service.ClickAd(ctx, client, cheap, "192.0.2.1"))
+	stats, err := (&StatsService{repos.Stats, slog.Default()}).GetStatsAdvertiser(ctx, advertiser, model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignStats{
+		ImpressionsCount: 2,
+		ClicksCount:      1,
+		Conversion:       50,
+		SpentImpressions: 11,
+		SpentClicks:      1,
+		SpentTotal:       12,
+	}, stats)
+}
+
+func TestAdService_ClickAd(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	adsConfig := config.DefaultAds
+	adsConfig.ClickAttributionWindow = 2
+	service := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+	settings := &SettingsService{repos.Settings, repos.Scheduler}
+
+	clients := make([]model.Client, 3)
+	for i := range clients {
+		clients[i] = model.Client{Id: uuid.New(), Login: "user", Location: "Moscow", Gender: "MALE"}
+	}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, clients))
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	campaign := newTestCampaign(t, repos, advertiser, func(c *model.Campaign) {
+		c.ClicksLimit = ptr(1)
+		c.CostPerClick = ptr(5.0)
+	})
+
+	assertRejected := func(client model.Client, reason string) {
+		t.Helper()
+		var rejected *ClickRejectedError
+		require.ErrorAs(t, service.ClickAd(ctx, client, campaign, "192.0.2.1"), &rejected)
+		assert.Equal(t, reason, rejected.Reason)
+	}
+
+	assertRejected(clients[0], model.ClickRejectedNotViewed)
+
+	require.NoError(t, settings.SetDate(ctx, 1))
+	for _, client := range clients {
+		impression := model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id, Spent: *campaign.CostPerImpression, Date: 1}
+		require.NoError(t, repos.Campaign.AddAdImpression(ctx, impression, adsConfig.LimitsThreshold))
+	}
+	require.NoError(t, service.ClickAd(ctx, clients[0], campaign, "192.0.2.1"))
+	// a repeated click is charged once
+	require.NoError(t, service.ClickAd(ctx, clients[0], campaign, "192.0.2.1"))
+	assertRejected(clients[1], model.ClickRejectedLimitReached)
+
+	require.NoError(t, settings.SetDate(ctx, 4))
+	assertRejected(clients[2], model.ClickRejectedAttributionExpired)
+	require.NoError(t, settings.SetDate(ctx, 6))
+	assertRejected(clients[2], model.ClickRejectedCampaignNotActive)
+
+	stats, err := (&StatsService{repos.Stats, slog.Default()}).GetStatsCampaign(ctx, campaign, model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.ClicksCount)
+	// clicks without an impression are not recorded
+	assert.Equal(t, 3, stats.NonBillableClicksCount)
+	assert.Equal(t, *campaign.CostPerClick, stats.SpentClicks)
+}
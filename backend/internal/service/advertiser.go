@@ -4,6 +4,7 @@ import (
 	"backend/internal/model"
 	"backend/internal/repo"
 	"backend/pkg/sliceutil"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 )
@@ -14,12 +15,12 @@ type AdvertiserService struct {
 	mlScoreRepo    repo.MlScore
 }
 
-func (s *AdvertiserService) GetById(id uuid.UUID) (model.Advertiser, error) {
-	return s.advertiserRepo.GetById(id)
+func (s *AdvertiserService) GetById(ctx context.Context, id uuid.UUID) (model.Advertiser, error) {
+	return s.advertiserRepo.GetById(ctx, id)
 }
 
-func (s *AdvertiserService) AddBulk(advertisers []model.Advertiser) ([]model.Advertiser, error) {
-	err := s.advertiserRepo.UpsertMany(advertisers)
+func (s *AdvertiserService) AddBulk(ctx context.Context, advertisers []model.Advertiser) ([]model.Advertiser, error) {
+	err := s.advertiserRepo.UpsertMany(ctx, advertisers)
 	if err != nil {
 		return nil, fmt.Errorf("add bulk advertisers: %w", err)
 	}
@@ -33,7 +34,7 @@ func (s *AdvertiserService) AddBulk(advertisers []model.Advertiser) ([]model.Adv
 	}
 	ids = sliceutil.DeduplicateLast(ids)
 
-	resultMap, err := s.advertiserRepo.GetMany(ids)
+	resultMap, err := s.advertiserRepo.GetMany(ctx, ids)
 	if err != nil {
 		return nil, fmt.Errorf("get added bulk advertisers: %w", err)
 	}
@@ -46,18 +47,18 @@ func (s *AdvertiserService) AddBulk(advertisers []model.Advertiser) ([]model.Adv
 	return result, nil
 }
 
-func (s *AdvertiserService) AddMlScore(score model.MlScore) error {
+func (s *AdvertiserService) AddMlScore(ctx context.Context, score model.MlScore) error {
 	// if some of the entities don't exist, ErrNotFound will be embedded in returned error
-	_, err := s.advertiserRepo.GetById(score.AdvertiserId)
+	_, err := s.advertiserRepo.GetById(ctx, score.AdvertiserId)
 	if err != nil {
 		return fmt.Errorf("get advertiser by id: %w", err)
 	}
-	_, err = s.clientRepo.GetById(score.ClientId)
+	_, err = s.clientRepo.GetById(ctx, score.ClientId)
 	if err != nil {
 		return fmt.Errorf("get client by id: %w", err)
 	}
 
-	err = s.mlScoreRepo.Upsert(score)
+	err = s.mlScoreRepo.Upsert(ctx, score)
 	if err != nil {
 		return fmt.Errorf("upsert mlScore: %w", err)
 	}
@@ -3,6 +3,7 @@ package service
 import (
 	"backend/internal/model"
 	"backend/internal/repo"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
@@ -15,8 +16,8 @@ type AiService struct {
 	ollamaSvc *OllamaService
 }
 
-func (s *AiService) GetTask(id uuid.UUID) (model.AiTaskResponse, error) {
-	task, err := s.aiRepo.GetTask(id)
+func (s *AiService) GetTask(ctx context.Context, id uuid.UUID) (model.AiTaskResponse, error) {
+	task, err := s.aiRepo.GetTask(ctx, id)
 	if err != nil {
 		return model.AiTaskResponse{}, fmt.Errorf("get aiTask: %w", err)
 	}
@@ -26,7 +27,7 @@ func (s *AiService) GetTask(id uuid.UUID) (model.AiTaskResponse, error) {
 		CreatedAt: task.CreatedAt,
 	}
 
-	res, err := s.aiRepo.GetResult(task.Id)
+	res, err := s.aiRepo.GetResult(ctx, task.Id)
 	if err != nil {
 		if !repo.IsNotFound(err) {
 			return model.AiTaskResponse{}, fmt.Errorf("get aiTaskResult: %w", err)
@@ -69,7 +70,7 @@ const suggestTextPrompt = `
 `
 
 // SubmitSuggestText creates an AiTask to generate a list of suggestions. Returns ID of the task.
-func (s *AiService) SubmitSuggestText(advertiserName, adTitle, comment string) (uuid.UUID, error) {
+func (s *AiService) SubmitSuggestText(ctx context.Context, advertiserName, adTitle, comment string) (uuid.UUID, error) {
 	if comment == "" {
 		comment = "-"
 	}
@@ -81,11 +82,11 @@ func (s *AiService) SubmitSuggestText(advertiserName, adTitle, comment string) (
 		Format:    `{"type": "array", "items": {"type": "string"}}`,
 	}
 
-	err := s.aiRepo.AddTask(task)
+	err := s.aiRepo.AddTask(ctx, task)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("add aiTask for suggestions: %w", err)
 	}
-	s.ollamaSvc.SubmitTask(task)
+	s.ollamaSvc.SubmitTask(ctx, task)
 
 	return task.Id, nil
 }
@@ -117,7 +118,7 @@ Respond in JSON using the following format:
 `
 
 // SubmitModeration creates an AiTask to moderate ad title and ad text. Returns ID of the task.
-func (s *AiService) SubmitModeration(adTitle, adText string) (uuid.UUID, error) {
+func (s *AiService) SubmitModeration(ctx context.Context, adTitle, adText string) (uuid.UUID, error) {
 	task := model.AiTask{
 		Id:        uuid.New(),
 		CreatedAt: time.Now(),
@@ -126,11 +127,11 @@ func (s *AiService) SubmitModeration(adTitle, adText string) (uuid.UUID, error)
 		Format:    `{"type": "object", "properties": {"acceptable": {"type": "boolean"}, "reason": {"type": "string"}}}`,
 	}
 
-	err := s.aiRepo.AddTask(task)
+	err := s.aiRepo.AddTask(ctx, task)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("add aiTask for moderation: %w", err)
 	}
-	s.ollamaSvc.SubmitTask(task)
+	s.ollamaSvc.SubmitTask(ctx, task)
 
 	return task.Id, nil
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"backend/internal/model"
 	"backend/internal/repo"
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -16,27 +17,27 @@ type MockAiRepo struct {
 	mock.Mock
 }
 
-func (r *MockAiRepo) AddTask(task model.AiTask) error {
+func (r *MockAiRepo) AddTask(_ context.Context, task model.AiTask) error {
 	args := r.Called(task)
 	return args.Error(0)
 }
 
-func (r *MockAiRepo) GetTask(id uuid.UUID) (model.AiTask, error) {
+func (r *MockAiRepo) GetTask(_ context.Context, id uuid.UUID) (model.AiTask, error) {
 	args := r.Called(id)
 	return args.Get(0).(model.AiTask), args.Error(1)
 }
 
-func (r *MockAiRepo) GetIncompleteTasks() ([]model.AiTask, error) {
+func (r *MockAiRepo) GetIncompleteTasks(_ context.Context) ([]model.AiTask, error) {
 	args := r.Called()
 	return args.Get(0).([]model.AiTask), args.Error(1)
 }
 
-func (r *MockAiRepo) AddResult(result model.AiTaskResult) error {
+func (r *MockAiRepo) AddResult(_ context.Context, result model.AiTaskResult) error {
 	args := r.Called(result)
 	return args.Error(0)
 }
 
-func (r *MockAiRepo) GetResult(taskId uuid.UUID) (model.AiTaskResult, error) {
+func (r *MockAiRepo) GetResult(_ context.Context, taskId uuid.UUID) (model.AiTaskResult, error) {
 	args := r.Called(taskId)
 	return args.Get(0).(model.AiTaskResult), args.Error(1)
 }
@@ -60,24 +61,24 @@ func TestGetTask(t *testing.T) {
 
 	mockRepo.On("GetTask", uuid.Nil).Return(model.AiTask{}, repo.ErrNotFound)
 
-	resp, err := service.GetTask(taskID)
+	resp, err := service.GetTask(context.Background(), taskID)
 	assert.NoError(t, err)
 	assert.Equal(t, taskID, resp.Id)
 	assert.True(t, resp.Completed)
 	assert.Equal(t, resp.Suggestions, []string{"text 1", "text 2", "text 3"})
 
-	resp, err = service.GetTask(task2ID)
+	resp, err = service.GetTask(context.Background(), task2ID)
 	assert.NoError(t, err)
 	assert.Equal(t, task2ID, resp.Id)
 	assert.True(t, resp.Completed)
 	assert.Equal(t, resp.Moderation, &model.AiModerationResult{Acceptable: true, Reason: ""})
 
-	resp, err = service.GetTask(task3ID)
+	resp, err = service.GetTask(context.Background(), task3ID)
 	assert.NoError(t, err)
 	assert.Equal(t, task3ID, resp.Id)
 	assert.False(t, resp.Completed)
 
-	resp, err = service.GetTask(uuid.Nil)
+	resp, err = service.GetTask(context.Background(), uuid.Nil)
 	assert.Error(t, err)
 
 	mockRepo.AssertExpectations(t)
@@ -88,11 +89,11 @@ func TestSubmitSuggestText(t *testing.T) {
 	service := &AiService{aiRepo: mockRepo, ollamaSvc: &OllamaService{}}
 
 	mockRepo.On("AddTask", mock.Anything).Return(nil).Once()
-	_, err := service.SubmitSuggestText("name", "title", "")
+	_, err := service.SubmitSuggestText(context.Background(), "name", "title", "")
 	assert.NoError(t, err)
 
 	mockRepo.On("AddTask", mock.Anything).Return(errors.New("error")).Once()
-	_, err = service.SubmitSuggestText("name", "title", "")
+	_, err = service.SubmitSuggestText(context.Background(), "name", "title", "")
 	assert.Error(t, err)
 
 	mockRepo.AssertExpectations(t)
@@ -103,11 +104,11 @@ func TestSubmitModeration(t *testing.T) {
 	service := &AiService{aiRepo: mockRepo, ollamaSvc: &OllamaService{}}
 
 	mockRepo.On("AddTask", mock.Anything).Return(nil).Once()
-	_, err := service.SubmitModeration("title", "text")
+	_, err := service.SubmitModeration(context.Background(), "title", "text")
 	assert.NoError(t, err)
 
 	mockRepo.On("AddTask", mock.Anything).Return(errors.New("error")).Once()
-	_, err = service.SubmitModeration("title", "text")
+	_, err = service.SubmitModeration(context.Background(), "title", "text")
 	assert.Error(t, err)
 
 	mockRepo.AssertExpectations(t)
@@ -1,41 +1,126 @@
 package service
 
 import (
+	"backend/config"
+	"backend/internal/metrics"
+	"backend/internal/model"
 	"backend/internal/repo"
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
 	"time"
 )
 
-type requestInfo struct {
-	endpoint string
-	duration time.Duration
-}
+// monitoringEndpoints are polled by monitoring, so they are not logged.
+var monitoringEndpoints = []string{"GET /ping", "GET /healthz", "GET /readyz"}
+
+// retentionInterval is how often expired requests are deleted.
+const retentionInterval = time.Hour
 
 type ApiService struct {
 	apiRepo repo.Api
-	queue   chan requestInfo
+	config  config.RequestsLog
+	log     *slog.Logger
+	queue   chan model.ApiRequest
+	done    chan struct{}
+
+	// mu guards closing of queue, so that LogRequest never sends to a closed channel.
+	mu     sync.RWMutex
+	closed bool
 }
 
-func NewApiService(apiRepo repo.Api) *ApiService {
-	s := &ApiService{apiRepo, make(chan requestInfo, 5000)}
+func NewApiService(apiRepo repo.Api, config config.RequestsLog, log *slog.Logger) *ApiService {
+	s := &ApiService{apiRepo: apiRepo, config: config, log: log, queue: make(chan model.ApiRequest, config.QueueSize), done: make(chan struct{})}
 	go s.worker()
 	return s
 }
 
 // worker reads requests from queue and adds them to database.
 func (s *ApiService) worker() {
+	defer close(s.done)
 	for r := range s.queue {
-		err := s.apiRepo.AddRequest(r.endpoint, float64(r.duration)/float64(time.Millisecond))
-		if err != nil {
-			log.Printf("requests logger middleware: add request: %s\n", err)
+		if err := s.apiRepo.AddRequest(context.Background(), r); err != nil {
+			s.log.Error("add request to requests log", "endpoint", r.Endpoint, "error", err)
 		}
 	}
 }
 
-// LogRequest adds request to database. This function is non-blocking.
-func (s *ApiService) LogRequest(method, path string, duration time.Duration) {
-	if path == "/ping" {
+// LogRequest adds request to database. Requests without errors are sampled with the configured rate,
+// and request.SampleRate is set accordingly. This function is non-blocking: if the queue is full,
+// the request is dropped and counted in metrics.ApiRequestsDropped. Requests logged after Shutdown are dropped.
+func (s *ApiService) LogRequest(request model.ApiRequest) {
+	if slices.Contains(monitoringEndpoints, request.Endpoint) {
 		return
 	}
-	s.queue <- requestInfo{method + " " + path, duration}
+
+	request.SampleRate = 1
+	if request.Status < 500 && request.Error == nil {
+		if rand.Float64() >= s.config.SampleRate {
+			return
+		}
+		request.SampleRate = s.config.SampleRate
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- request:
+	default:
+		metrics.ApiRequestsDropped.Inc()
+	}
+}
+
+// DeleteExpired deletes requests older than the configured retention. It does nothing if retention is zero.
+func (s *ApiService) DeleteExpired(ctx context.Context) error {
+	if s.config.Retention == 0 {
+		return nil
+	}
+	n, err := s.apiRepo.DeleteRequestsBefore(ctx, time.Now().Add(-s.config.Retention))
+	if err != nil {
+		return fmt.Errorf("delete requests: %w", err)
+	}
+	if n > 0 {
+		s.log.InfoContext(ctx, "deleted expired requests", "count", n, "retention", s.config.Retention.String())
+	}
+	return nil
+}
+
+// RunRetention calls DeleteExpired every retentionInterval until ctx is done.
+func (s *ApiService) RunRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+			s.log.ErrorContext(ctx, "delete expired requests", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops accepting new requests and waits until the queued ones are written to database.
+// If ctx is done earlier, returns an error with the number of requests which were not written.
+func (s *ApiService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flush requests queue: %w, %d requests were not written", ctx.Err(), len(s.queue))
+	}
 }
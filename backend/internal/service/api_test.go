@@ -0,0 +1,118 @@
+package service
+
+import (
+	"backend/config"
+	"backend/internal/metrics"
+	"backend/internal/model"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockApiRepo struct {
+	mock.Mock
+}
+
+func (r *MockApiRepo) AddRequest(_ context.Context, request model.ApiRequest) error {
+	args := r.Called(request)
+	return args.Error(0)
+}
+
+func (r *MockApiRepo) DeleteRequestsBefore(_ context.Context, before time.Time) (int64, error) {
+	args := r.Called(before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+var logAll = config.RequestsLog{Enabled: true, SampleRate: 1, QueueSize: 10}
+
+func TestApiService_Shutdown(t *testing.T) {
+	request := model.ApiRequest{Endpoint: "GET /ads", DurationMs: 5, Status: 200, SampleRate: 1}
+	mockRepo := new(MockApiRepo)
+	mockRepo.On("AddRequest", request).Return(nil).After(10 * time.Millisecond).Times(3)
+	service := NewApiService(mockRepo, logAll, slog.Default())
+
+	for range 3 {
+		service.LogRequest(request)
+	}
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ping", DurationMs: 1, Status: 200})
+
+	err := service.Shutdown(context.Background())
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+
+	// requests after shutdown are dropped
+	service.LogRequest(request)
+	mockRepo.AssertNumberOfCalls(t, "AddRequest", 3)
+	assert.NoError(t, service.Shutdown(context.Background()))
+}
+
+func TestApiService_ShutdownTimeout(t *testing.T) {
+	mockRepo := new(MockApiRepo)
+	mockRepo.On("AddRequest", mock.Anything).Return(nil).After(100 * time.Millisecond)
+	service := NewApiService(mockRepo, logAll, slog.Default())
+
+	for range 3 {
+		service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", DurationMs: 5, Status: 200})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := service.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestApiService_LogRequestQueueFull(t *testing.T) {
+	// no worker reads the queue, so it stays full after the first request
+	service := &ApiService{config: logAll, queue: make(chan model.ApiRequest, 1)}
+	dropped := testutil.ToFloat64(metrics.ApiRequestsDropped)
+
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 200})
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 200})
+
+	assert.Len(t, service.queue, 1)
+	assert.Equal(t, dropped+1, testutil.ToFloat64(metrics.ApiRequestsDropped))
+}
+
+func TestApiService_LogRequestSampling(t *testing.T) {
+	service := &ApiService{config: config.RequestsLog{Enabled: true, SampleRate: 0}, queue: make(chan model.ApiRequest, 10)}
+	message := "get ad candidates: connection refused"
+
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 200})
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 404})
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 500, Error: &message})
+	service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 499, Error: &message})
+
+	// only requests with errors are logged, and each of them stands for itself
+	assert.Len(t, service.queue, 2)
+	for range 2 {
+		assert.Equal(t, 1.0, (<-service.queue).SampleRate)
+	}
+
+	service.config.SampleRate = 0.5
+	for range 100 {
+		service.LogRequest(model.ApiRequest{Endpoint: "GET /ads", Status: 200})
+		if len(service.queue) > 0 {
+			assert.Equal(t, 0.5, (<-service.queue).SampleRate)
+		}
+	}
+}
+
+func TestApiService_DeleteExpired(t *testing.T) {
+	mockRepo := new(MockApiRepo)
+	mockRepo.On("DeleteRequestsBefore", mock.MatchedBy(func(before time.Time) bool {
+		return time.Since(before) >= time.Hour && time.Since(before) < time.Hour+time.Minute
+	})).Return(int64(2), nil).Once()
+	service := &ApiService{apiRepo: mockRepo, config: config.RequestsLog{Enabled: true, Retention: time.Hour}, log: slog.Default()}
+
+	assert.NoError(t, service.DeleteExpired(context.Background()))
+
+	// zero retention keeps requests forever
+	service.config.Retention = 0
+	assert.NoError(t, service.DeleteExpired(context.Background()))
+	mockRepo.AssertExpectations(t)
+}
@@ -3,8 +3,10 @@ package service
 import (
 	"backend/internal/model"
 	"backend/internal/repo"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"log/slog"
 	"time"
 )
 
@@ -12,12 +14,13 @@ type CampaignService struct {
 	campaignRepo repo.Campaign
 	aiSvc        *AiService
 	settingsSvc  *SettingsService
+	log          *slog.Logger
 }
 
-func (s *CampaignService) Create(advertiserId uuid.UUID, req model.CampaignCreateRequest) (model.Campaign, error) {
+func (s *CampaignService) Create(ctx context.Context, advertiserId uuid.UUID, req model.CampaignCreateRequest) (model.Campaign, error) {
 	var taskId *uuid.UUID
 	if s.settingsSvc.ModerationEnabled() {
-		taskIdRaw, err := s.aiSvc.SubmitModeration(req.AdTitle, req.AdText)
+		taskIdRaw, err := s.aiSvc.SubmitModeration(ctx, req.AdTitle, req.AdText)
 		if err != nil {
 			return model.Campaign{}, fmt.Errorf("submit moderation task: %w", err)
 		}
@@ -30,55 +33,81 @@ func (s *CampaignService) Create(advertiserId uuid.UUID, req model.CampaignCreat
 		AdvertiserId:          advertiserId,
 		CampaignCreateRequest: req,
 		ModerationTaskId:      taskId,
+		Version:               1,
 	}
-	if err := s.campaignRepo.Add(campaign); err != nil {
+	if err := s.campaignRepo.Add(ctx, campaign); err != nil {
 		return model.Campaign{}, fmt.Errorf("create campaign: %w", err)
 	}
 	return campaign, nil
 }
 
-func (s *CampaignService) GetById(id uuid.UUID) (model.Campaign, error) {
-	campaign, err := s.campaignRepo.GetById(id)
+func (s *CampaignService) GetById(ctx context.Context, id uuid.UUID) (model.Campaign, error) {
+	campaign, err := s.campaignRepo.GetById(ctx, id)
 	if err != nil {
 		return model.Campaign{}, fmt.Errorf("get campaign by id: %w", err)
 	}
 	return campaign, nil
 }
 
-func (s *CampaignService) GetPaginated(advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error) {
-	campaigns, err := s.campaignRepo.GetList(advertiserId, size, page)
+func (s *CampaignService) GetPaginated(ctx context.Context, advertiserId uuid.UUID, size int, page int) ([]model.Campaign, error) {
+	campaigns, err := s.campaignRepo.GetList(ctx, advertiserId, size, page)
 	if err != nil {
 		return nil, fmt.Errorf("get campaign list: %w", err)
 	}
 	return campaigns, nil
 }
 
-func (s *CampaignService) Update(campaign *model.Campaign, req model.CampaignCreateRequest) error {
-	if (campaign.AdTitle != req.AdTitle || campaign.AdText != req.AdText) && s.settingsSvc.ModerationEnabled() {
-		taskId, err := s.aiSvc.SubmitModeration(req.AdTitle, req.AdText)
-		if err != nil {
-			return fmt.Errorf("submit moderation task: %w", err)
-		}
-		campaign.ModerationTaskId = &taskId
+// Update applies req to the campaign. The update succeeds only if campaign.Version is up-to-date,
+// otherwise repo.ErrVersionConflict is returned. On success, campaign.Version is set to the stored version.
+// Changed title or text are moderated again; the moderation task is submitted only after the update succeeds,
+// so that a conflicting update does not leave an orphaned task. The update is kept if the submission fails:
+// the failure is logged and the campaign is left without a moderation task until its title or text change.
+func (s *CampaignService) Update(ctx context.Context, campaign *model.Campaign, req model.CampaignCreateRequest) error {
+	moderate := (campaign.AdTitle != req.AdTitle || campaign.AdText != req.AdText) && s.settingsSvc.ModerationEnabled()
+	if moderate {
+		campaign.ModerationTaskId = nil
 		campaign.ModerationResult = nil
 	}
 	campaign.CampaignCreateRequest = req
 
-	if err := s.campaignRepo.Update(*campaign); err != nil {
+	if err := s.campaignRepo.Update(ctx, *campaign); err != nil {
 		return fmt.Errorf("update campaign: %w", err)
 	}
+	campaign.Version++
+	if !moderate {
+		return nil
+	}
+
+	taskId, err := s.aiSvc.SubmitModeration(ctx, req.AdTitle, req.AdText)
+	if err != nil {
+		s.log.ErrorContext(ctx, "submit moderation task", "campaign_id", campaign.Id, "error", err)
+		return nil
+	}
+	err = s.campaignRepo.SetModerationTask(ctx, campaign.Id, campaign.Version, taskId)
+	if repo.IsVersionConflict(err) {
+		// a newer update has submitted its own moderation task
+		return nil
+	}
+	if err != nil {
+		s.log.ErrorContext(ctx, "set moderation task", "campaign_id", campaign.Id, "error", err)
+		return nil
+	}
+	campaign.ModerationTaskId = &taskId
+	campaign.Version++
 	return nil
 }
 
-func (s *CampaignService) Delete(id uuid.UUID) error {
-	if err := s.campaignRepo.Delete(id); err != nil {
+// Delete removes the campaign if its version equals to the given one,
+// otherwise repo.ErrVersionConflict is returned.
+func (s *CampaignService) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	if err := s.campaignRepo.Delete(ctx, id, version); err != nil {
 		return fmt.Errorf("delete campaign: %w", err)
 	}
 	return nil
 }
 
-func (s *CampaignService) GetModerationFailed(size int, page int) ([]model.Campaign, error) {
-	campaigns, err := s.campaignRepo.GetModerationFailed(size, page)
+func (s *CampaignService) GetModerationFailed(ctx context.Context, size int, page int) ([]model.Campaign, error) {
+	campaigns, err := s.campaignRepo.GetModerationFailed(ctx, size, page)
 	if err != nil {
 		return nil, fmt.Errorf("get campaign list with failed moderation: %w", err)
 	}
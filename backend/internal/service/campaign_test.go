@@ -0,0 +1,87 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/internal/repo/memory"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCampaignService_Update_Moderation(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	settingsSvc := &SettingsService{repos.Settings, repos.Scheduler}
+	require.NoError(t, settingsSvc.SetModerationEnabled(ctx, true))
+	aiRepo := &failingAiRepo{Ai: repos.Ai}
+	service := &CampaignService{repos.Campaign, &AiService{aiRepo, &OllamaService{}}, settingsSvc, slog.Default()}
+
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	req := model.CampaignCreateRequest{
+		ImpressionsLimit:  ptr(10),
+		ClicksLimit:       ptr(1),
+		CostPerImpression: ptr(1.0),
+		CostPerClick:      ptr(1.0),
+		AdTitle:           "title",
+		AdText:            "text",
+		StartDate:         ptr(0),
+		EndDate:           ptr(5),
+	}
+	campaign, err := service.Create(ctx, advertiser.Id, req)
+	require.NoError(t, err)
+	assertTasks := func(want int) {
+		t.Helper()
+		tasks, err := repos.Ai.GetIncompleteTasks(ctx)
+		require.NoError(t, err)
+		assert.Len(t, tasks, want)
+	}
+	assertTasks(1)
+
+	// a stale version is rejected before moderation is submitted
+	stale := campaign
+	stale.Version--
+	req.AdTitle = "new title"
+	assert.True(t, repo.IsVersionConflict(service.Update(ctx, &stale, req)))
+	assertTasks(1)
+
+	require.NoError(t, service.Update(ctx, &campaign, req))
+	assertTasks(2)
+	stored, err := repos.Campaign.GetById(ctx, campaign.Id)
+	require.NoError(t, err)
+	assert.Equal(t, campaign.Version, stored.Version)
+	assert.Equal(t, campaign.ModerationTaskId, stored.ModerationTaskId)
+	assert.Equal(t, "new title", stored.AdTitle)
+	// setting the moderation task changes the ETag
+	assert.Equal(t, 3, stored.Version)
+
+	// the update is kept when the moderation can't be submitted
+	aiRepo.fail = true
+	req.AdText = "new text"
+	require.NoError(t, service.Update(ctx, &campaign, req))
+	assertTasks(2)
+	stored, err = repos.Campaign.GetById(ctx, campaign.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 4, campaign.Version)
+	assert.Equal(t, campaign.Version, stored.Version)
+	assert.Nil(t, stored.ModerationTaskId)
+	assert.Equal(t, "new text", stored.AdText)
+}
+
+type failingAiRepo struct {
+	repo.Ai
+	fail bool
+}
+
+func (r *failingAiRepo) AddTask(ctx context.Context, task model.AiTask) error {
+	if r.fail {
+		return errors.New("unavailable")
+	}
+	return r.Ai.AddTask(ctx, task)
+}
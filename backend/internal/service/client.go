@@ -4,6 +4,7 @@ import (
 	"backend/internal/model"
 	"backend/internal/repo"
 	"backend/pkg/sliceutil"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 )
@@ -12,12 +13,12 @@ type ClientService struct {
 	clientRepo repo.Client
 }
 
-func (s *ClientService) GetById(id uuid.UUID) (model.Client, error) {
-	return s.clientRepo.GetById(id)
+func (s *ClientService) GetById(ctx context.Context, id uuid.UUID) (model.Client, error) {
+	return s.clientRepo.GetById(ctx, id)
 }
 
-func (s *ClientService) AddBulk(clients []model.Client) ([]model.Client, error) {
-	err := s.clientRepo.UpsertMany(clients)
+func (s *ClientService) AddBulk(ctx context.Context, clients []model.Client) ([]model.Client, error) {
+	err := s.clientRepo.UpsertMany(ctx, clients)
 	if err != nil {
 		return nil, fmt.Errorf("add bulk clients: %w", err)
 	}
@@ -28,7 +29,7 @@ func (s *ClientService) AddBulk(clients []model.Client) ([]model.Client, error)
 	}
 	ids = sliceutil.DeduplicateLast(ids)
 
-	resultMap, err := s.clientRepo.GetMany(ids)
+	resultMap, err := s.clientRepo.GetMany(ctx, ids)
 	if err != nil {
 		return nil, fmt.Errorf("get added bulk clients: %w", err)
 	}
@@ -3,6 +3,7 @@ package service
 import (
 	"backend/internal/model"
 	"backend/internal/repo"
+	"context"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"testing"
@@ -12,17 +13,17 @@ type MockClientRepo struct {
 	mock.Mock
 }
 
-func (m *MockClientRepo) GetById(id uuid.UUID) (model.Client, error) {
+func (m *MockClientRepo) GetById(_ context.Context, id uuid.UUID) (model.Client, error) {
 	args := m.Called(id)
 	return args.Get(0).(model.Client), args.Error(1)
 }
 
-func (m *MockClientRepo) GetMany(ids []uuid.UUID) (map[uuid.UUID]model.Client, error) {
+func (m *MockClientRepo) GetMany(_ context.Context, ids []uuid.UUID) (map[uuid.UUID]model.Client, error) {
 	args := m.Called(ids)
 	return args.Get(0).(map[uuid.UUID]model.Client), args.Error(1)
 }
 
-func (m *MockClientRepo) UpsertMany(clients []model.Client) error {
+func (m *MockClientRepo) UpsertMany(_ context.Context, clients []model.Client) error {
 	args := m.Called(clients)
 	return args.Error(0)
 }
@@ -37,12 +38,12 @@ func TestClientService_GetById(t *testing.T) {
 	cRepo.On("GetById", clientId).Return(client, nil)
 	cRepo.On("GetById", uuid.Nil).Return(model.Client{}, repo.ErrNotFound)
 
-	got, err := cService.GetById(clientId)
+	got, err := cService.GetById(context.Background(), clientId)
 	if got != client || err != nil {
 		t.Errorf("GetById() got = %v %v, want %v nil", got, err, client)
 	}
 
-	got, err = cService.GetById(uuid.Nil)
+	got, err = cService.GetById(context.Background(), uuid.Nil)
 	if (got != model.Client{}) || !repo.IsNotFound(err) {
 		t.Errorf("GetById() got = %v %v, want {} ErrNoRows", got, err)
 	}
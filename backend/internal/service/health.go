@@ -0,0 +1,92 @@
+package service
+
+import (
+	"backend/internal/model"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout limits each check, so that a hanging dependency is reported as failed instead of hanging readiness probes.
+const healthCheckTimeout = 2 * time.Second
+
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// HealthService checks the dependencies of the backend for readiness probes.
+type HealthService struct {
+	checks []healthCheck
+}
+
+// Register adds a check of the component. The backend is not ready while a critical check fails;
+// failures of other checks are only reported. Register must not be called concurrently with Check.
+func (s *HealthService) Register(name string, critical bool, check func(ctx context.Context) error) {
+	s.checks = append(s.checks, healthCheck{name, critical, check})
+}
+
+// Check runs all checks concurrently and reports the status and latency of each component.
+func (s *HealthService) Check(ctx context.Context) model.Health {
+	health := model.Health{Status: model.HealthOk, Components: make(map[string]model.ComponentHealth, len(s.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range s.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			component := runHealthCheck(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			health.Components[c.name] = component
+			switch {
+			case component.Status == model.HealthOk:
+			case c.critical:
+				health.Status = model.HealthFail
+			case health.Status == model.HealthOk:
+				health.Status = model.HealthDegraded
+			}
+		}()
+	}
+	wg.Wait()
+	return health
+}
+
+func runHealthCheck(ctx context.Context, c healthCheck) model.ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+	component := model.ComponentHealth{
+		Status:    model.HealthOk,
+		Critical:  c.critical,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		component.Status = model.HealthFail
+		component.Error = err.Error()
+	}
+	return component
+}
+
+// checkDirWritable creates and removes a file in dir.
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		_ = os.Remove(name)
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("remove file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"backend/internal/model"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthService_Check(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused") }
+
+	type testCase struct {
+		name       string
+		register   func(s *HealthService)
+		wantStatus string
+	}
+	tests := []testCase{
+		{"no checks", func(s *HealthService) {}, model.HealthOk},
+		{"all ok", func(s *HealthService) {
+			s.Register("database", true, ok)
+			s.Register("llm", false, ok)
+		}, model.HealthOk},
+		{"non-critical fails", func(s *HealthService) {
+			s.Register("database", true, ok)
+			s.Register("llm", false, fail)
+		}, model.HealthDegraded},
+		{"critical fails", func(s *HealthService) {
+			s.Register("database", true, fail)
+			s.Register("llm", false, fail)
+		}, model.HealthFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &HealthService{}
+			tt.register(s)
+			health := s.Check(context.Background())
+			assert.Equal(t, tt.wantStatus, health.Status)
+			assert.Len(t, health.Components, len(s.checks))
+		})
+	}
+}
+
+func TestHealthService_CheckComponent(t *testing.T) {
+	s := &HealthService{}
+	s.Register("database", true, func(ctx context.Context) error {
+		// a hanging dependency is cut off by the timeout
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	health := s.Check(context.Background())
+	component := health.Components["database"]
+	assert.Equal(t, model.HealthFail, component.Status)
+	assert.True(t, component.Critical)
+	assert.Equal(t, context.DeadlineExceeded.Error(), component.Error)
+	assert.GreaterOrEqual(t, component.LatencyMs, float64(healthCheckTimeout.Milliseconds()))
+}
+
+func TestCheckDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, checkDirWritable(dir))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.Error(t, checkDirWritable(filepath.Join(dir, "missing")))
+}
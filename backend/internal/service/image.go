@@ -3,6 +3,7 @@ package service
 import (
 	"backend/internal/model"
 	"backend/internal/repo"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"io"
@@ -12,12 +13,18 @@ import (
 )
 
 type ImageService struct {
-	campaignRepo repo.Campaign
-	mediaBaseUrl string
-	mediaFsPath  string
+	campaignRepo  repo.Campaign
+	mediaBaseUrl  string
+	mediaFsPath   string
+	maxUploadSize int64
 }
 
-func (s *ImageService) AddCampaignImage(campaign model.Campaign, file *multipart.FileHeader) (model.Campaign, error) {
+// MaxUploadSize is the limit of the size of uploaded images, in bytes.
+func (s *ImageService) MaxUploadSize() int64 {
+	return s.maxUploadSize
+}
+
+func (s *ImageService) AddCampaignImage(ctx context.Context, campaign model.Campaign, file *multipart.FileHeader) (model.Campaign, error) {
 	if !strings.Contains(file.Filename, ".") {
 		return model.Campaign{}, fmt.Errorf("filename without extension: %s", file.Filename)
 	}
@@ -34,7 +41,8 @@ func (s *ImageService) AddCampaignImage(campaign model.Campaign, file *multipart
 		_ = src.Close()
 	}(src)
 
-	out, err := os.Create(s.mediaFsPath + "/" + filename)
+	outPath := s.mediaFsPath + "/" + filename
+	out, err := os.Create(outPath)
 	if err != nil {
 		return model.Campaign{}, fmt.Errorf("open destination file: %w", err)
 	}
@@ -47,16 +55,20 @@ func (s *ImageService) AddCampaignImage(campaign model.Campaign, file *multipart
 		return model.Campaign{}, fmt.Errorf("write destination file: %w", err)
 	}
 
-	if err := s.campaignRepo.Update(campaign); err != nil {
+	if err := s.campaignRepo.Update(ctx, campaign); err != nil {
+		// the file is not referenced by any campaign
+		_ = os.Remove(outPath)
 		return model.Campaign{}, fmt.Errorf("update campaign: %w", err)
 	}
+	campaign.Version++
 	return campaign, nil
 }
 
-func (s *ImageService) DeleteCampaignImage(campaign model.Campaign) (model.Campaign, error) {
+func (s *ImageService) DeleteCampaignImage(ctx context.Context, campaign model.Campaign) (model.Campaign, error) {
 	campaign.ImagePath = ""
-	if err := s.campaignRepo.Update(campaign); err != nil {
+	if err := s.campaignRepo.Update(ctx, campaign); err != nil {
 		return model.Campaign{}, fmt.Errorf("update campaign: %w", err)
 	}
+	campaign.Version++
 	return campaign, nil
 }
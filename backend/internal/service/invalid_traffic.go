@@ -0,0 +1,48 @@
+package service
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+// addClick adds the accepted click, checking it for invalid traffic if the detection is enabled. It returns
+// the reason of flagging the click, which is then added as non-billable, or an empty string.
+// The click activity is read in the same transaction as the click is added, so that concurrent clicks
+// of the client or from the source are counted.
+func (s *AdService) addClick(ctx context.Context, click model.AdClick, impression model.AdImpression) (string, error) {
+	config := s.config.InvalidTraffic
+	if !config.Enabled {
+		return "", s.campaignRepo.AddAdClick(ctx, click, s.config.LimitsThreshold)
+	}
+	query := model.ClickActivityQuery{
+		ClientId:     click.ClientId,
+		Source:       click.Source,
+		WindowStart:  click.CreatedAt.Add(-config.Window),
+		HistoryStart: click.CreatedAt.Add(-config.History),
+	}
+	return s.campaignRepo.AddAdClickWithDetection(ctx, click, s.config.LimitsThreshold, query, func(activity model.ClickActivity) string {
+		return s.detectInvalidTraffic(click, impression, activity)
+	})
+}
+
+// detectInvalidTraffic returns the reason of flagging the click as made by a bot or a click farm, or an empty string.
+// The click is flagged if it is made too soon after the impression, if the client clicks much more often than usual,
+// or if too many clients click from the same source.
+func (s *AdService) detectInvalidTraffic(click model.AdClick, impression model.AdImpression, activity model.ClickActivity) string {
+	config := s.config.InvalidTraffic
+	if click.CreatedAt.Sub(impression.CreatedAt) < config.MinClickDelay {
+		return model.ClickInvalidTooSoon
+	}
+
+	// the click itself is counted too
+	clicks := activity.ClientClicks + 1
+	// the number of clicks the client usually makes in a period of config.Window
+	usual := float64(activity.ClientHistoryClicks) * float64(config.Window) / float64(config.History-config.Window)
+	if clicks >= config.RateMinClicks && float64(clicks) > config.RateFactor*usual {
+		return model.ClickInvalidRateSpike
+	}
+	if click.Source != "" && activity.SourceClients+1 > config.MaxClientsPerSource {
+		return model.ClickInvalidSharedSource
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"backend/config"
+	"backend/internal/model"
+	"backend/internal/repo/memory"
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdService_InvalidTraffic(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	adsConfig := config.DefaultAds
+	adsConfig.InvalidTraffic = config.InvalidTraffic{
+		Enabled:             true,
+		MinClickDelay:       time.Minute,
+		Window:              time.Hour,
+		History:             24 * time.Hour,
+		RateFactor:          2,
+		RateMinClicks:       3,
+		MaxClientsPerSource: 2,
+	}
+	service := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+	statsService := &StatsService{repos.Stats, slog.Default()}
+
+	clients := make([]model.Client, 5)
+	for i := range clients {
+		clients[i] = model.Client{Id: uuid.New(), Login: "user", Location: "Moscow", Gender: "MALE"}
+	}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, clients))
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	campaigns := make([]model.Campaign, 4)
+	for i := range campaigns {
+		campaigns[i] = newTestCampaign(t, repos, advertiser, func(c *model.Campaign) {
+			c.ImpressionsLimit = ptr(100)
+			c.ClicksLimit = ptr(100)
+			c.CostPerClick = ptr(5.0)
+		})
+	}
+
+	now := time.Now()
+	view := func(client model.Client, campaign model.Campaign, createdAt time.Time) {
+		t.Helper()
+		impression := model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id, Spent: 1, CreatedAt: createdAt}
+		require.NoError(t, repos.Campaign.AddAdImpression(ctx, impression, adsConfig.LimitsThreshold))
+	}
+	click := func(client model.Client, campaign model.Campaign, source string) {
+		t.Helper()
+		// flagged clicks are not distinguishable from accepted ones by the caller
+		require.NoError(t, service.ClickAd(ctx, client, campaign, source))
+	}
+	assertReport := func(campaign model.Campaign, want map[string]int) {
+		t.Helper()
+		report, err := statsService.GetInvalidTrafficReport(ctx, campaign, model.InvalidTrafficRequest{Size: 10, Page: 1})
+		require.NoError(t, err)
+		assert.Equal(t, want, report.ByReason)
+		assert.Len(t, report.Clicks, report.Total)
+	}
+
+	// the click is made right after the impression
+	view(clients[0], campaigns[0], now)
+	click(clients[0], campaigns[0], "198.51.100.1")
+
+	// the client has never clicked before, and suddenly clicks on three ads
+	for _, campaign := range campaigns[:3] {
+		view(clients[1], campaign, now.Add(-2*time.Hour))
+		click(clients[1], campaign, "198.51.100.2")
+	}
+	// the client usually makes two clicks an hour, so three clicks are fine
+	for i := range 46 {
+		// repeated clicks on the same date are recorded once
+		history := model.NonBillableClick{ClientId: clients[2].Id, CampaignId: campaigns[3].Id, Reason: model.ClickRejectedCampaignNotActive,
+			Date: i, CreatedAt: now.Add(-12 * time.Hour), Source: "198.51.100.3"}
+		require.NoError(t, repos.Campaign.AddNonBillableClick(ctx, history))
+	}
+	for _, campaign := range campaigns[:3] {
+		view(clients[2], campaign, now.Add(-2*time.Hour))
+		click(clients[2], campaign, "198.51.100.3")
+	}
+
+	// the third client clicking from the same source is flagged
+	view(clients[3], campaigns[0], now.Add(-2*time.Hour))
+	click(clients[3], campaigns[0], "203.0.113.1")
+	view(clients[4], campaigns[0], now.Add(-2*time.Hour))
+	click(clients[4], campaigns[0], "203.0.113.1")
+	view(clients[0], campaigns[1], now.Add(-2*time.Hour))
+	click(clients[0], campaigns[1], "203.0.113.1")
+
+	assertReport(campaigns[0], map[string]int{model.ClickInvalidTooSoon: 1})
+	assertReport(campaigns[1], map[string]int{model.ClickInvalidSharedSource: 1})
+	assertReport(campaigns[2], map[string]int{model.ClickInvalidRateSpike: 1})
+	// rejected clicks are not invalid traffic
+	assertReport(campaigns[3], map[string]int{})
+
+	report, err := statsService.GetInvalidTrafficReport(ctx, campaigns[0], model.InvalidTrafficRequest{Size: 10, Page: 1})
+	require.NoError(t, err)
+	require.Len(t, report.Clicks, 1)
+	assert.Equal(t, clients[0].Id, report.Clicks[0].ClientId)
+	assert.Equal(t, "198.51.100.1", report.Clicks[0].Source)
+
+	// flagged clicks are not charged
+	stats, err := statsService.GetStatsCampaign(ctx, campaigns[0], model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.ClicksCount)
+	assert.Equal(t, 1, stats.NonBillableClicksCount)
+	assert.Equal(t, 20.0, stats.SpentClicks)
+
+	// the detection can be disabled
+	adsConfig.InvalidTraffic.Enabled = false
+	service = NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+	view(clients[0], campaigns[2], now)
+	click(clients[0], campaigns[2], "198.51.100.1")
+	assertReport(campaigns[2], map[string]int{model.ClickInvalidRateSpike: 1})
+}
+
+func TestAdService_InvalidTraffic_RepeatedClick(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	adsConfig := config.DefaultAds
+	adsConfig.InvalidTraffic.MaxClientsPerSource = 1
+	service := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+
+	clients := []model.Client{
+		{Id: uuid.New(), Login: "user", Location: "Moscow", Gender: "MALE"},
+		{Id: uuid.New(), Login: "other", Location: "Moscow", Gender: "MALE"},
+	}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, clients))
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	campaign := newTestCampaign(t, repos, advertiser, nil)
+	for _, client := range clients {
+		impression := model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id, CreatedAt: time.Now().Add(-time.Hour)}
+		require.NoError(t, repos.Campaign.AddAdImpression(ctx, impression, adsConfig.LimitsThreshold))
+	}
+
+	require.NoError(t, service.ClickAd(ctx, clients[0], campaign, "198.51.100.1"))
+	require.NoError(t, service.ClickAd(ctx, clients[1], campaign, "198.51.100.1"))
+	// the source is shared now, but the repeated click has already been charged and is not flagged
+	require.NoError(t, service.ClickAd(ctx, clients[0], campaign, "198.51.100.1"))
+
+	stats, err := (&StatsService{repos.Stats, slog.Default()}).GetStatsCampaign(ctx, campaign, model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.ClicksCount)
+	assert.Equal(t, 1, stats.NonBillableClicksCount)
+}
+
+func TestAdService_InvalidTraffic_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	adsConfig := config.DefaultAds
+	adsConfig.InvalidTraffic.MaxClientsPerSource = 3
+	service := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, adsConfig, slog.Default())
+
+	clients := make([]model.Client, 10)
+	for i := range clients {
+		clients[i] = model.Client{Id: uuid.New(), Login: "user", Location: "Moscow", Gender: "MALE"}
+	}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, clients))
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	campaign := newTestCampaign(t, repos, advertiser, nil)
+	for _, client := range clients {
+		impression := model.AdImpression{ClientId: client.Id, CampaignId: campaign.Id, CreatedAt: time.Now().Add(-time.Hour)}
+		require.NoError(t, repos.Campaign.AddAdImpression(ctx, impression, adsConfig.LimitsThreshold))
+	}
+
+	// the clients click from the same source at once, and each click sees the ones added before it
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, service.ClickAd(ctx, client, campaign, "203.0.113.1"))
+		}()
+	}
+	wg.Wait()
+
+	report, err := (&StatsService{repos.Stats, slog.Default()}).GetInvalidTrafficReport(ctx, campaign, model.InvalidTrafficRequest{Size: 10, Page: 1})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{model.ClickInvalidSharedSource: 7}, report.ByReason)
+	stats, err := (&StatsService{repos.Stats, slog.Default()}).GetStatsCampaign(ctx, campaign, model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.ClicksCount)
+}
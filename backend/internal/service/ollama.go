@@ -2,15 +2,23 @@ package service
 
 import (
 	"backend/config"
+	"backend/internal/metrics"
 	"backend/internal/model"
 	"backend/internal/repo"
+	"backend/internal/tracing"
+	"backend/pkg/requestid"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/ollama/ollama/api"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,58 +26,172 @@ type OllamaService struct {
 	model            string
 	client           *api.Client
 	aiRepo           repo.Ai
+	log              *slog.Logger
 	enabled          bool
-	suggestionsQueue chan model.AiTask
-	otherQueue       chan model.AiTask
+	suggestionsQueue chan queuedTask
+	otherQueue       chan queuedTask
+
+	// pulled is set when the model is pulled and tasks are being run.
+	pulled atomic.Bool
+
+	// ctx is cancelled to interrupt tasks in progress when shutdown grace period is over.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// tasksCtx is derived from ctx and is replaced by CancelTasks; tasks submitted under a cancelled one are dropped.
+	tasksMu     sync.Mutex
+	tasksCtx    context.Context
+	cancelTasks context.CancelFunc
+	// stopping is closed on shutdown, so that workers stop taking new tasks.
+	stopping chan struct{}
+	wg       sync.WaitGroup
 }
 
-func NewOllamaService(env config.Environment, aiRepo repo.Ai) (*OllamaService, error) {
-	urlParsed, err := url.Parse(env.OllamaHost)
+func NewOllamaService(env config.Environment, aiRepo repo.Ai, log *slog.Logger) (*OllamaService, error) {
+	urlParsed, err := url.Parse(env.Ollama.Host)
 	if err != nil {
 		return nil, fmt.Errorf("parse ollama url: %w", err)
 	}
 
 	s := &OllamaService{
-		model:            env.OllamaModel,
+		model:            env.Ollama.Model,
 		client:           api.NewClient(urlParsed, http.DefaultClient),
 		aiRepo:           aiRepo,
+		log:              log,
 		enabled:          !env.RunningInCI,
-		suggestionsQueue: make(chan model.AiTask, 1000),
-		otherQueue:       make(chan model.AiTask, 5000),
+		suggestionsQueue: make(chan queuedTask, env.Ollama.SuggestionsQueueSize),
+		otherQueue:       make(chan queuedTask, env.Ollama.QueueSize),
+		stopping:         make(chan struct{}),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.tasksCtx, s.cancelTasks = context.WithCancel(s.ctx)
 	if s.enabled {
+		s.wg.Add(1)
 		go s.init()
 	}
 
 	return s, nil
 }
 
-func (s *OllamaService) SubmitTask(task model.AiTask) {
+// queuedTask is a task waiting in a queue. Its span is linked to the span which submitted the task,
+// as the task outlives the request; its log records carry the ID of that request.
+type queuedTask struct {
+	model.AiTask
+	submittedAt time.Time
+	submitter   trace.SpanContext
+	requestId   string
+	// ctx is the tasksCtx of the service at the moment of submission
+	ctx context.Context
+}
+
+// SubmitTask queues the task to be run in background. ctx is only used to link the span
+// and the log records of the task to the request.
+func (s *OllamaService) SubmitTask(ctx context.Context, task model.AiTask) {
 	if !s.enabled {
 		return
 	}
+	queued := queuedTask{
+		AiTask:      task,
+		submittedAt: time.Now(),
+		submitter:   trace.SpanContextFromContext(ctx),
+		requestId:   requestid.FromContext(ctx),
+		ctx:         s.currentTasksCtx(),
+	}
 
-	queue := s.otherQueue
+	queue, name := s.otherQueue, otherQueueName
 	if task.Type == model.AiTaskTypeSuggest {
-		queue = s.suggestionsQueue
+		queue, name = s.suggestionsQueue, suggestionsQueueName
 	}
 
 	select {
-	case queue <- task:
+	case queue <- queued:
+		metrics.AiQueueDepth.WithLabelValues(name).Set(float64(len(queue)))
 	default:
 		go func() {
-			queue <- task
+			queue <- queued
+			metrics.AiQueueDepth.WithLabelValues(name).Set(float64(len(queue)))
 		}()
 	}
 }
 
+// CancelTasks drops the queued tasks and interrupts the ones in progress, then resubmits the incomplete tasks
+// stored in database. It is called when the stored data is replaced by a sandbox snapshot, so that the tasks
+// of the discarded state don't write their results into the restored one.
+func (s *OllamaService) CancelTasks(ctx context.Context) error {
+	if !s.enabled {
+		return nil
+	}
+	s.tasksMu.Lock()
+	s.cancelTasks()
+	s.tasksCtx, s.cancelTasks = context.WithCancel(s.ctx)
+	s.tasksMu.Unlock()
+
+	tasks, err := s.aiRepo.GetIncompleteTasks(ctx)
+	if err != nil {
+		return fmt.Errorf("get incomplete tasks: %w", err)
+	}
+	for _, task := range tasks {
+		s.SubmitTask(ctx, task)
+	}
+	return nil
+}
+
+func (s *OllamaService) currentTasksCtx() context.Context {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	return s.tasksCtx
+}
+
+// Shutdown stops taking new tasks and waits until the tasks in progress are done.
+// If ctx is done earlier, the tasks in progress are interrupted. As every task is stored
+// in database before it is submitted, tasks without results are resumed on the next start.
+func (s *OllamaService) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return fmt.Errorf("wait for ai tasks: %w, tasks in progress were interrupted", ctx.Err())
+	}
+}
+
+// Enabled reports whether AI tasks are run. They are not run in CI.
+func (s *OllamaService) Enabled() bool {
+	return s.enabled
+}
+
+// CheckModel returns an error until the model is pulled; tasks submitted before that wait in queues.
+func (s *OllamaService) CheckModel(context.Context) error {
+	if !s.pulled.Load() {
+		return fmt.Errorf("model %s is not pulled yet", s.model)
+	}
+	return nil
+}
+
+// pullRetryInterval is the pause between attempts to pull the model, e.g. while Ollama is starting.
+const pullRetryInterval = 3 * time.Second
+
 func (s *OllamaService) init() {
-	tasks, err := s.aiRepo.GetIncompleteTasks()
+	defer s.wg.Done()
+
+	tasks, err := s.aiRepo.GetIncompleteTasks(s.ctx)
 	if err != nil {
-		log.Printf("ollama service: failed to get incomplete tasks: %s\n", err)
+		s.log.Error("get incomplete tasks", "error", err)
 	} else {
 		for _, task := range tasks {
-			s.SubmitTask(task)
+			s.SubmitTask(s.ctx, task)
 		}
 	}
 
@@ -83,32 +205,83 @@ func (s *OllamaService) init() {
 		// Sometimes, the download speed becomes too slow after several minutes. Restarting
 		// the download resolves the issue. Ollama caches the downloaded files, so it does not
 		// disrupt the progress.
-		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(2*time.Minute))
+		ctx, cancel := context.WithDeadline(s.ctx, time.Now().Add(2*time.Minute))
 		err := s.client.Pull(ctx, req, callback)
 		cancel()
 		if err == nil {
 			break
 		}
+		if s.isStopping() {
+			s.log.Info("shutting down before model is pulled", "model", s.model)
+			return
+		}
 
-		log.Printf("ollama service: failed to pull model %s: %s, retrying\n", s.model, err)
-		<-time.After(3)
+		s.log.Warn("pull model failed, retrying", "model", s.model, "error", err)
+		select {
+		case <-s.stopping:
+		case <-time.After(pullRetryInterval):
+		}
 	}
 
-	log.Printf("ollama service: initialized after %s\n", time.Since(start))
+	s.log.Info("initialized", "model", s.model, "duration", time.Since(start).String())
+	s.pulled.Store(true)
 
-	go s.worker(s.suggestionsQueue)
-	go s.worker(s.otherQueue)
+	s.wg.Add(2)
+	go s.worker(s.suggestionsQueue, suggestionsQueueName)
+	go s.worker(s.otherQueue, otherQueueName)
 }
 
-func (s *OllamaService) worker(queue <-chan model.AiTask) {
-	for task := range queue {
-		s.runTask(task)
+// Names of the queues in metrics.
+const (
+	suggestionsQueueName = "suggestions"
+	otherQueueName       = "other"
+)
+
+func (s *OllamaService) worker(queue <-chan queuedTask, name string) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopping:
+			return
+		case task := <-queue:
+			metrics.AiQueueDepth.WithLabelValues(name).Set(float64(len(queue)))
+			// the task is kept in database, so it is resumed on the next start
+			if s.isStopping() {
+				return
+			}
+			if task.ctx.Err() != nil {
+				continue
+			}
+			s.runTask(task)
+		}
+	}
+}
+
+func (s *OllamaService) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
 	}
 }
 
 const systemPrompt = "You are a helpful assistant. Always respond in Russian. Current date: %s"
 
-func (s *OllamaService) runTask(task model.AiTask) {
+func (s *OllamaService) runTask(task queuedTask) {
+	ctx, span := tracing.Tracer().Start(task.ctx, "ai.task",
+		trace.WithTimestamp(task.submittedAt),
+		trace.WithLinks(trace.Link{SpanContext: task.submitter}),
+		trace.WithAttributes(attribute.String("ai.task.id", task.Id.String()), attribute.String("ai.task.type", string(task.Type))),
+	)
+	defer span.End()
+	if task.requestId != "" {
+		ctx = requestid.NewContext(ctx, task.requestId)
+	}
+	log := s.log.With("task_id", task.Id, "task_type", task.Type)
+	_, wait := tracing.Tracer().Start(ctx, "ai.queue.wait", trace.WithTimestamp(task.submittedAt))
+	wait.End()
+
 	answer := ""
 	req := &api.GenerateRequest{
 		Model:  s.model,
@@ -122,30 +295,60 @@ func (s *OllamaService) runTask(task model.AiTask) {
 		return nil
 	}
 
-	log.Printf("ollama service: started working on task %s\n", task.Id)
+	log.InfoContext(ctx, "task started")
+	start := time.Now()
+	observe := func(result string) {
+		metrics.AiTaskDuration.WithLabelValues(string(task.Type), result).Observe(time.Since(start).Seconds())
+	}
 	for i := range 5 {
-		err := s.client.Generate(context.Background(), req, callback)
+		generateCtx, generate := tracing.Tracer().Start(ctx, "ollama.generate",
+			trace.WithAttributes(attribute.String("ollama.model", s.model), attribute.Int("ollama.attempt", i+1)))
+		err := s.client.Generate(generateCtx, req, callback)
+		if err != nil {
+			generate.RecordError(err)
+			generate.SetStatus(codes.Error, err.Error())
+		}
+		generate.End()
 		if err == nil {
 			break
 		}
 
+		if s.ctx.Err() != nil {
+			log.InfoContext(ctx, "task interrupted by shutdown, it will be resumed on next start")
+			observe(metrics.AiTaskInterrupted)
+			return
+		}
+		if task.ctx.Err() != nil {
+			log.InfoContext(ctx, "task cancelled, as the stored data has been replaced")
+			observe(metrics.AiTaskInterrupted)
+			return
+		}
+
 		if i == 4 {
-			log.Printf("OllamaService.runTask: failed to generate: %s (task %s) after 5 attempts\n", err, task.Id)
+			log.ErrorContext(ctx, "generate failed after 5 attempts", "error", err)
+			observe(metrics.AiTaskFailed)
+			span.SetStatus(codes.Error, "failed after 5 attempts")
 			return
 		}
 
-		log.Printf("OllamaService.runTask: failed to generate: %s (task %s), retrying\n", err, task.Id)
+		log.WarnContext(ctx, "generate failed, retrying", "attempt", i+1, "error", err)
 		<-time.After(3)
 	}
 
-	log.Printf("ollama service: task %s done\n", task.Id)
+	if task.ctx.Err() != nil {
+		log.InfoContext(ctx, "task cancelled, as the stored data has been replaced")
+		observe(metrics.AiTaskInterrupted)
+		return
+	}
+	log.InfoContext(ctx, "task done", "duration", time.Since(start).String())
+	observe(metrics.AiTaskDone)
 
-	err := s.aiRepo.AddResult(model.AiTaskResult{
+	err := s.aiRepo.AddResult(context.Background(), model.AiTaskResult{
 		TaskId:    task.Id,
 		CreatedAt: time.Now(),
 		Answer:    answer,
 	})
 	if err != nil {
-		log.Printf("ollama service: failed to save result for task %s: %s\n", task.Id, err)
+		log.ErrorContext(ctx, "save task result", "error", err)
 	}
 }
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"backend/config"
+	"backend/internal/model"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestOllamaService creates OllamaService with running workers, skipping the model pull.
+func newTestOllamaService(t *testing.T, handler http.HandlerFunc, aiRepo *MockAiRepo) *OllamaService {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	s, err := NewOllamaService(config.Environment{Ollama: config.Ollama{Host: srv.URL, SuggestionsQueueSize: 10, QueueSize: 10}, RunningInCI: true}, aiRepo, slog.Default())
+	assert.NoError(t, err)
+	s.enabled = true
+	s.wg.Add(2)
+	go s.worker(s.suggestionsQueue, suggestionsQueueName)
+	go s.worker(s.otherQueue, otherQueueName)
+	return s
+}
+
+func TestOllamaService_ShutdownCompletesTask(t *testing.T) {
+	mockRepo := new(MockAiRepo)
+	task := model.AiTask{Id: uuid.New(), Type: model.AiTaskTypeSuggest}
+	mockRepo.On("AddResult", mock.MatchedBy(func(r model.AiTaskResult) bool {
+		return r.TaskId == task.Id && r.Answer == `["text"]`
+	})).Return(nil)
+
+	started := make(chan struct{})
+	s := newTestOllamaService(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"response": "[\"text\"]", "done": true}`))
+	}, mockRepo)
+
+	s.SubmitTask(context.Background(), task)
+	<-started
+
+	err := s.Shutdown(context.Background())
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOllamaService_ShutdownInterruptsTask(t *testing.T) {
+	mockRepo := new(MockAiRepo)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s := newTestOllamaService(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}, mockRepo)
+
+	s.SubmitTask(context.Background(), model.AiTask{Id: uuid.New(), Type: model.AiTaskTypeModeration})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// the task is left without result, so it is resumed on the next start
+	mockRepo.AssertNotCalled(t, "AddResult", mock.Anything)
+}
+
+func TestOllamaService_CancelTasks(t *testing.T) {
+	stale := model.AiTask{Id: uuid.New(), Type: model.AiTaskTypeModeration}
+	restored := model.AiTask{Id: uuid.New(), Type: model.AiTaskTypeModeration}
+	mockRepo := new(MockAiRepo)
+	mockRepo.On("GetIncompleteTasks").Return([]model.AiTask{restored}, nil)
+	done := make(chan struct{})
+	mockRepo.On("AddResult", mock.MatchedBy(func(r model.AiTaskResult) bool {
+		return r.TaskId == restored.Id
+	})).Return(nil).Run(func(mock.Arguments) {
+		close(done)
+	})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	var requests atomic.Int32
+	s := newTestOllamaService(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// the first task hangs until it is cancelled
+			close(started)
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"response": "{}", "done": true}`))
+	}, mockRepo)
+
+	s.SubmitTask(context.Background(), stale)
+	<-started
+	require.NoError(t, s.CancelTasks(context.Background()))
+
+	<-done
+	require.NoError(t, s.Shutdown(context.Background()))
+	// the cancelled task never writes its result
+	mockRepo.AssertNotCalled(t, "AddResult", mock.MatchedBy(func(r model.AiTaskResult) bool {
+		return r.TaskId == stale.Id
+	}))
+}
+
+func TestOllamaService_ShutdownDisabled(t *testing.T) {
+	s, err := NewOllamaService(config.Environment{RunningInCI: true}, new(MockAiRepo), slog.Default())
+	assert.NoError(t, err)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestOllamaService_CheckModel(t *testing.T) {
+	s := &OllamaService{model: "gemma2:2b"}
+	assert.EqualError(t, s.CheckModel(context.Background()), "model gemma2:2b is not pulled yet")
+	s.pulled.Store(true)
+	assert.NoError(t, s.CheckModel(context.Background()))
+}
+
+func TestOllamaService_TaskSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	})
+
+	done := make(chan struct{})
+	mockRepo := new(MockAiRepo)
+	mockRepo.On("AddResult", mock.Anything).Return(nil).Run(func(mock.Arguments) { close(done) })
+	s := newTestOllamaService(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"response": "[]", "done": true}`))
+	}, mockRepo)
+
+	ctx, submitter := otel.Tracer("test").Start(context.Background(), "request")
+	s.SubmitTask(ctx, model.AiTask{Id: uuid.New(), Type: model.AiTaskTypeSuggest})
+	submitter.End()
+	<-done
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	task := spans["ai.task"]
+	require.NotNil(t, task)
+	require.Len(t, task.Links(), 1)
+	assert.Equal(t, submitter.SpanContext().TraceID(), task.Links()[0].SpanContext.TraceID())
+	for _, name := range []string{"ai.queue.wait", "ollama.generate"} {
+		require.NotNil(t, spans[name], name)
+		assert.Equal(t, task.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+	}
+}
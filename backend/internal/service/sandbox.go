@@ -0,0 +1,55 @@
+package service
+
+import (
+	"backend/internal/repo"
+	"context"
+	"fmt"
+)
+
+// SandboxService allows what-if testing: while the sandbox is active, the date can be moved backwards,
+// and on discard all the simulated state is restored to the moment when the sandbox was started.
+type SandboxService struct {
+	sandboxRepo   repo.Sandbox
+	settingsRepo  repo.Settings
+	schedulerRepo repo.Scheduler
+	ollamaSvc     *OllamaService
+}
+
+func (s *SandboxService) Active() bool {
+	return s.settingsRepo.GetCached().Sandbox
+}
+
+// Start saves the snapshot. It fails with repo.ErrSandboxActive if the sandbox is already active.
+func (s *SandboxService) Start(ctx context.Context) error {
+	if err := s.sandboxRepo.Begin(ctx); err != nil {
+		return fmt.Errorf("begin sandbox: %w", err)
+	}
+	return s.refresh(ctx)
+}
+
+// Discard restores the snapshot. It fails with repo.ErrSandboxInactive if the sandbox is not active.
+// AI tasks of the discarded state are cancelled, and the scheduled jobs are rewound to the restored date.
+func (s *SandboxService) Discard(ctx context.Context) error {
+	if err := s.sandboxRepo.Discard(ctx); err != nil {
+		return fmt.Errorf("discard sandbox: %w", err)
+	}
+	if err := s.refresh(ctx); err != nil {
+		return err
+	}
+	if err := s.ollamaSvc.CancelTasks(ctx); err != nil {
+		return fmt.Errorf("cancel ai tasks: %w", err)
+	}
+	// a job which was running during the discard may have recorded a day after the restored date
+	if err := s.schedulerRepo.Rewind(ctx, s.settingsRepo.GetCached().CurrentDate); err != nil {
+		return fmt.Errorf("rewind scheduled jobs: %w", err)
+	}
+	return nil
+}
+
+// refresh updates the cached settings, which are changed by the sandbox repo bypassing the settings repo.
+func (s *SandboxService) refresh(ctx context.Context) error {
+	if err := s.settingsRepo.Refresh(ctx); err != nil {
+		return fmt.Errorf("refresh settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repo"
+	"backend/internal/repo/memory"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxService(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	settingsSvc := &SettingsService{repos.Settings, repos.Scheduler}
+	sandboxSvc := &SandboxService{repos.Sandbox, repos.Settings, repos.Scheduler, &OllamaService{}}
+
+	require.NoError(t, settingsSvc.SetDate(ctx, 5))
+	require.ErrorIs(t, settingsSvc.SetDate(ctx, 4), ErrDateBackwards)
+	require.ErrorIs(t, sandboxSvc.Discard(ctx), repo.ErrSandboxInactive)
+
+	require.NoError(t, sandboxSvc.Start(ctx))
+	require.True(t, sandboxSvc.Active())
+	require.ErrorIs(t, sandboxSvc.Start(ctx), repo.ErrSandboxActive)
+
+	// the date can be rewound and the data changed inside the sandbox
+	require.NoError(t, settingsSvc.SetDate(ctx, 1))
+	require.NoError(t, settingsSvc.SetModerationEnabled(ctx, true))
+	age := 20
+	client := model.Client{Id: uuid.New(), Login: "user", Age: &age, Location: "Moscow", Gender: "MALE"}
+	require.NoError(t, repos.Client.UpsertMany(ctx, []model.Client{client}))
+
+	require.NoError(t, sandboxSvc.Discard(ctx))
+	require.False(t, sandboxSvc.Active())
+	require.Equal(t, 5, settingsSvc.Date())
+	require.False(t, settingsSvc.ModerationEnabled())
+	_, err := repos.Client.GetById(ctx, client.Id)
+	require.True(t, repo.IsNotFound(err))
+
+	require.ErrorIs(t, settingsSvc.SetDate(ctx, 4), ErrDateBackwards)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"backend/internal/repo"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// DayJob is run by SchedulerService once for each simulated day, when the day begins.
+// It must be idempotent: if the process crashes after the job is done but before
+// it is recorded, the job is run for the same day again.
+type DayJob func(ctx context.Context, day int) error
+
+type scheduledJob struct {
+	name string
+	run  DayJob
+}
+
+// SchedulerService runs jobs on simulated time: when the current date advances, every registered
+// job is run for each day that has begun since its last run, in order of days. So if the date
+// jumps from 3 to 6, jobs are run for days 4, 5 and 6. The progress of jobs is stored in
+// the database, so the days missed because of a crash are caught up on the next run.
+// Each day is claimed in the database while it runs, so instances sharing the database don't run it twice.
+type SchedulerService struct {
+	schedulerRepo repo.Scheduler
+	settingsRepo  repo.Settings
+	log           *slog.Logger
+
+	mu   sync.Mutex
+	jobs []scheduledJob
+	// trigger wakes up Run; it has room for one pending run, which covers all the triggers made meanwhile
+	trigger chan struct{}
+}
+
+func NewSchedulerService(schedulerRepo repo.Scheduler, settingsRepo repo.Settings, log *slog.Logger) *SchedulerService {
+	return &SchedulerService{
+		schedulerRepo: schedulerRepo,
+		settingsRepo:  settingsRepo,
+		log:           log,
+		trigger:       make(chan struct{}, 1),
+	}
+}
+
+// Register adds a job. Jobs are run in order of registration.
+// A job that has never been run starts from the day following the current date when it is first run,
+// so that the history is not replayed.
+func (s *SchedulerService) Register(name string, job DayJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, scheduledJob{name, job})
+}
+
+// Run catches up the days missed because of a crash, then runs due jobs on each Trigger until ctx is done.
+// Failed jobs are logged and retried on the next run.
+func (s *SchedulerService) Run(ctx context.Context) {
+	for {
+		if err := s.RunDue(ctx); err != nil && ctx.Err() == nil {
+			s.log.ErrorContext(ctx, "run scheduled jobs", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.trigger:
+		}
+	}
+}
+
+// Trigger makes Run run due jobs in background. It doesn't block.
+func (s *SchedulerService) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// RunDue runs all jobs for the days which have begun since their last run, up to the current date.
+// If a job fails for some day, its later days are postponed until the next run, while other jobs go on.
+func (s *SchedulerService) RunDue(ctx context.Context) error {
+	s.mu.Lock()
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	currentDate := s.settingsRepo.GetCached().CurrentDate
+	var errs []error
+	for _, job := range jobs {
+		run := func(ctx context.Context, day int) error {
+			if err := job.run(ctx, day); err != nil {
+				return fmt.Errorf("day %d: %w", day, err)
+			}
+			return nil
+		}
+		for {
+			ran, err := s.schedulerRepo.RunNextDay(ctx, job.name, currentDate, run)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("run job %s: %w", job.name, err))
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
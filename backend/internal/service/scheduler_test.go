@@ -0,0 +1,172 @@
+package service
+
+import (
+	"backend/internal/repo/memory"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerService_RunDue(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	settingsSvc := &SettingsService{repos.Settings, repos.Scheduler}
+	scheduler := NewSchedulerService(repos.Scheduler, repos.Settings, slog.Default())
+
+	var days []int
+	failOn := -1
+	scheduler.Register("job", func(_ context.Context, day int) error {
+		if day == failOn {
+			return errors.New("fail")
+		}
+		days = append(days, day)
+		return nil
+	})
+
+	require.NoError(t, settingsSvc.SetDate(ctx, 2))
+	// the history is not replayed for a new job
+	require.NoError(t, scheduler.RunDue(ctx))
+	require.Empty(t, days)
+
+	// skipped days are caught up in order
+	require.NoError(t, settingsSvc.SetDate(ctx, 5))
+	require.NoError(t, scheduler.RunDue(ctx))
+	require.Equal(t, []int{3, 4, 5}, days)
+
+	// nothing is run twice
+	require.NoError(t, scheduler.RunDue(ctx))
+	require.Equal(t, []int{3, 4, 5}, days)
+
+	// a failed day postpones the later ones until the next run
+	failOn = 7
+	require.NoError(t, settingsSvc.SetDate(ctx, 8))
+	require.Error(t, scheduler.RunDue(ctx))
+	require.Equal(t, []int{3, 4, 5, 6}, days)
+
+	failOn = -1
+	require.NoError(t, scheduler.RunDue(ctx))
+	require.Equal(t, []int{3, 4, 5, 6, 7, 8}, days)
+}
+
+func TestSchedulerService_FailingJobDoesNotBlockOthers(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	scheduler := NewSchedulerService(repos.Scheduler, repos.Settings, slog.Default())
+
+	var ran []int
+	scheduler.Register("failing", func(context.Context, int) error {
+		return errors.New("fail")
+	})
+	scheduler.Register("ok", func(_ context.Context, day int) error {
+		ran = append(ran, day)
+		return nil
+	})
+	require.NoError(t, scheduler.RunDue(ctx))
+
+	require.NoError(t, (&SettingsService{repos.Settings, repos.Scheduler}).SetDate(ctx, 1))
+	require.Error(t, scheduler.RunDue(ctx))
+	require.Equal(t, []int{1}, ran)
+
+	progress, err := repos.Scheduler.GetProgress(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"failing": 0, "ok": 1}, progress)
+}
+
+func TestSchedulerService_ConcurrentRunsDontRepeatDays(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	settingsSvc := &SettingsService{repos.Settings, repos.Scheduler}
+
+	var mu sync.Mutex
+	var days []int
+	job := func(_ context.Context, day int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		days = append(days, day)
+		return nil
+	}
+	// instances sharing the database have their own services
+	schedulers := make([]*SchedulerService, 4)
+	for i := range schedulers {
+		schedulers[i] = NewSchedulerService(repos.Scheduler, repos.Settings, slog.Default())
+		schedulers[i].Register("job", job)
+	}
+	require.NoError(t, schedulers[0].RunDue(ctx))
+	require.NoError(t, settingsSvc.SetDate(ctx, 10))
+
+	var wg sync.WaitGroup
+	for _, scheduler := range schedulers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, scheduler.RunDue(ctx))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, days)
+}
+
+func TestSchedulerService_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repos := memory.NewRepositories()
+	scheduler := NewSchedulerService(repos.Scheduler, repos.Settings, slog.Default())
+
+	ran := make(chan int, 10)
+	scheduler.Register("job", func(_ context.Context, day int) error {
+		ran <- day
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		progress, err := repos.Scheduler.GetProgress(ctx)
+		return err == nil && len(progress) == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, (&SettingsService{repos.Settings, repos.Scheduler}).SetDate(ctx, 2))
+	scheduler.Trigger()
+	require.Equal(t, 1, <-ran)
+	require.Equal(t, 2, <-ran)
+
+	cancel()
+	<-done
+}
+
+func TestSchedulerService_SandboxRewind(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	settingsSvc := &SettingsService{repos.Settings, repos.Scheduler}
+	sandboxSvc := &SandboxService{repos.Sandbox, repos.Settings, repos.Scheduler, &OllamaService{}}
+	scheduler := NewSchedulerService(repos.Scheduler, repos.Settings, slog.Default())
+
+	var days []int
+	scheduler.Register("job", func(_ context.Context, day int) error {
+		days = append(days, day)
+		return nil
+	})
+	require.NoError(t, settingsSvc.SetDate(ctx, 5))
+	require.NoError(t, scheduler.RunDue(ctx))
+	require.NoError(t, sandboxSvc.Start(ctx))
+
+	require.NoError(t, settingsSvc.SetDate(ctx, 8))
+	require.NoError(t, scheduler.RunDue(ctx))
+	// the replayed days are run again
+	require.NoError(t, settingsSvc.SetDate(ctx, 6))
+	require.NoError(t, settingsSvc.SetDate(ctx, 8))
+	require.NoError(t, scheduler.RunDue(ctx))
+	require.Equal(t, []int{6, 7, 8, 7, 8}, days)
+
+	require.NoError(t, sandboxSvc.Discard(ctx))
+	progress, err := repos.Scheduler.GetProgress(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"job": 5}, progress)
+}
@@ -2,8 +2,12 @@ package service
 
 import (
 	"backend/config"
+	"backend/internal/logging"
 	"backend/internal/repo"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 )
 
 type Services struct {
@@ -13,29 +17,73 @@ type Services struct {
 	Api        *ApiService
 	Campaign   *CampaignService
 	Client     *ClientService
+	Health     *HealthService
 	Image      *ImageService
 	Ollama     *OllamaService
+	Sandbox    *SandboxService
+	Scheduler  *SchedulerService
 	Settings   *SettingsService
 	Stats      *StatsService
 }
 
-func NewServices(repos *repo.Repositories, env config.Environment) (*Services, error) {
-	settingsSvc := &SettingsService{repos.Settings}
-	ollamaSvc, err := NewOllamaService(env, repos.Ai)
+// NewServices creates services which log with logger, each under its own subsystem.
+func NewServices(repos *repo.Repositories, env config.Environment, logger *slog.Logger) (*Services, error) {
+	adSvc := NewAdService(repos.Campaign, repos.Client, repos.MlScore, repos.Settings, env.Ads, logging.For(logger, logging.SubsystemAds))
+	repos = adSvc.wrapRepos(repos)
+
+	settingsSvc := &SettingsService{repos.Settings, repos.Scheduler}
+	ollamaSvc, err := NewOllamaService(env, repos.Ai, logging.For(logger, logging.SubsystemAi))
 	if err != nil {
 		return nil, fmt.Errorf("create ollama service: %w", err)
 	}
 	aiSvc := &AiService{repos.Ai, ollamaSvc}
+	statsSvc := &StatsService{repos.Stats, logging.For(logger, logging.SubsystemStats)}
+
+	// checks of the database are registered by the caller, as services do not access it directly
+	healthSvc := &HealthService{}
+	healthSvc.Register("media", true, func(context.Context) error {
+		return checkDirWritable(env.Media.FsPath)
+	})
+	if ollamaSvc.Enabled() {
+		// AI tasks are run in background, so the backend is usable while the model is being pulled
+		healthSvc.Register("llm", false, ollamaSvc.CheckModel)
+	}
+
+	schedulerSvc := NewSchedulerService(repos.Scheduler, repos.Settings, logging.For(logger, logging.SubsystemApp))
+	// campaigns end and stats are rolled up without jobs, as ads are selected and stats are written by date
+	schedulerSvc.Register("daily_report", statsSvc.ReportDay)
+
 	return &Services{
-		Ad:         &AdService{repos.Campaign, repos.Settings},
+		Ad:         adSvc,
 		Advertiser: &AdvertiserService{repos.Advertiser, repos.Client, repos.MlScore},
 		Ai:         aiSvc,
-		Api:        NewApiService(repos.Api),
-		Campaign:   &CampaignService{repos.Campaign, aiSvc, settingsSvc},
+		Api:        NewApiService(repos.Api, env.RequestsLog, logging.For(logger, logging.SubsystemHttp)),
+		Campaign:   &CampaignService{repos.Campaign, aiSvc, settingsSvc, logging.For(logger, logging.SubsystemAi)},
 		Client:     &ClientService{repos.Client},
-		Image:      &ImageService{campaignRepo: repos.Campaign, mediaFsPath: env.MediaFsPath, mediaBaseUrl: env.MediaBaseUrl},
-		Ollama:     ollamaSvc,
-		Settings:   settingsSvc,
-		Stats:      &StatsService{repos.Campaign},
+		Health:     healthSvc,
+		Image: &ImageService{
+			campaignRepo:  repos.Campaign,
+			mediaFsPath:   env.Media.FsPath,
+			mediaBaseUrl:  env.Media.BaseUrl,
+			maxUploadSize: env.Media.MaxUploadSize,
+		},
+		Ollama:    ollamaSvc,
+		Sandbox:   &SandboxService{repos.Sandbox, repos.Settings, repos.Scheduler, ollamaSvc},
+		Scheduler: schedulerSvc,
+		Settings:  settingsSvc,
+		Stats:     statsSvc,
 	}, nil
 }
+
+// Shutdown waits for background work of services: requests log is flushed to database,
+// and AI tasks in progress are either completed or left to be resumed on the next start.
+func (s *Services) Shutdown(ctx context.Context) error {
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- s.Api.Shutdown(ctx)
+	}()
+	go func() {
+		errCh <- s.Ollama.Shutdown(ctx)
+	}()
+	return errors.Join(<-errCh, <-errCh)
+}
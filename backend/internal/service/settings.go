@@ -1,24 +1,46 @@
 package service
 
 import (
+	"backend/internal/model"
 	"backend/internal/repo"
+	"context"
+	"errors"
 	"fmt"
 )
 
+// ErrDateBackwards is returned when the date is moved backwards outside of the sandbox.
+var ErrDateBackwards = errors.New("date can't be moved backwards outside of sandbox")
+
 type SettingsService struct {
-	settingsRepo repo.Settings
+	settingsRepo  repo.Settings
+	schedulerRepo repo.Scheduler
 }
 
 func (s *SettingsService) Date() int {
 	return s.settingsRepo.GetCached().CurrentDate
 }
 
-func (s *SettingsService) SetDate(date int) error {
-	settings := s.settingsRepo.GetCached()
-	settings.CurrentDate = date
-	if err := s.settingsRepo.Update(settings); err != nil {
+// SetDate updates the current date. The date can only be moved backwards while the sandbox is active,
+// otherwise ErrDateBackwards is returned: impressions and clicks of the later days would appear in the future.
+// When the date is moved backwards, scheduled jobs are rewound too, so they are run again for the replayed days.
+func (s *SettingsService) SetDate(ctx context.Context, date int) error {
+	rewound := false
+	_, err := s.settingsRepo.Update(ctx, func(settings *model.Settings) error {
+		if date < settings.CurrentDate && !settings.Sandbox {
+			return ErrDateBackwards
+		}
+		rewound = date < settings.CurrentDate
+		settings.CurrentDate = date
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("update settings: %w", err)
 	}
+	if rewound {
+		if err := s.schedulerRepo.Rewind(ctx, date); err != nil {
+			return fmt.Errorf("rewind scheduled jobs: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -26,10 +48,12 @@ func (s *SettingsService) ModerationEnabled() bool {
 	return s.settingsRepo.GetCached().ModerationEnabled
 }
 
-func (s *SettingsService) SetModerationEnabled(enabled bool) error {
-	settings := s.settingsRepo.GetCached()
-	settings.ModerationEnabled = enabled
-	if err := s.settingsRepo.Update(settings); err != nil {
+func (s *SettingsService) SetModerationEnabled(ctx context.Context, enabled bool) error {
+	_, err := s.settingsRepo.Update(ctx, func(settings *model.Settings) error {
+		settings.ModerationEnabled = enabled
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("update settings: %w", err)
 	}
 	return nil
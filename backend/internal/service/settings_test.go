@@ -0,0 +1,39 @@
+package service
+
+import (
+	"backend/internal/repo/memory"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsService_ConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	service := &SettingsService{repos.Settings, repos.Scheduler}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for date := 1; date <= 100; date++ {
+			assert.NoError(t, service.SetDate(ctx, date))
+			_ = service.ModerationEnabled()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 101 {
+			assert.NoError(t, service.SetModerationEnabled(ctx, i%2 == 0))
+			_ = service.Date()
+		}
+	}()
+	wg.Wait()
+
+	// neither update is lost
+	require.Equal(t, 100, service.Date())
+	require.True(t, service.ModerationEnabled())
+}
@@ -3,42 +3,311 @@ package service
 import (
 	"backend/internal/model"
 	"backend/internal/repo"
+	"cmp"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"log/slog"
+	"slices"
+	"strconv"
 )
 
+// ageBuckets are lower bounds of age buckets, in ascending order.
+var ageBuckets = []int{0, 18, 25, 35, 45, 55, 65}
+
+// StatsService aggregates stats rows returned by the repository into the groups requested by API callers.
 type StatsService struct {
-	campaignRepo repo.Campaign
+	statsRepo repo.Stats
+	log       *slog.Logger
 }
 
-func (s *StatsService) GetStatsCampaign(campaign model.Campaign) (model.CampaignStats, error) {
-	stats, err := s.campaignRepo.GetStats(campaign.AdvertiserId, campaign.Id)
+func (s *StatsService) GetStatsCampaign(ctx context.Context, campaign model.Campaign, period model.StatsPeriod) (model.CampaignStats, error) {
+	stats, err := s.total(ctx, model.StatsFilter{AdvertiserId: campaign.AdvertiserId, CampaignId: campaign.Id, StatsPeriod: period})
 	if err != nil {
 		return model.CampaignStats{}, fmt.Errorf("get stats (for campaign): %w", err)
 	}
 	return stats, nil
 }
 
-func (s *StatsService) GetStatsCampaignDaily(campaign model.Campaign) ([]model.CampaignStats, error) {
-	stats, err := s.campaignRepo.GetStatsDaily(campaign.AdvertiserId, campaign.Id)
+func (s *StatsService) GetStatsCampaignDaily(ctx context.Context, campaign model.Campaign, period model.StatsPeriod) ([]model.CampaignStats, error) {
+	stats, err := s.daily(ctx, model.StatsFilter{AdvertiserId: campaign.AdvertiserId, CampaignId: campaign.Id, StatsPeriod: period})
 	if err != nil {
 		return nil, fmt.Errorf("get stats daily (for campaign): %w", err)
 	}
 	return stats, nil
 }
 
-func (s *StatsService) GetStatsAdvertiser(advertiser model.Advertiser) (model.CampaignStats, error) {
-	stats, err := s.campaignRepo.GetStats(advertiser.Id, uuid.Nil)
+func (s *StatsService) GetStatsCampaignGrouped(ctx context.Context, campaign model.Campaign, req model.StatsRequest) (StatsGroups, error) {
+	filter := model.StatsFilter{AdvertiserId: campaign.AdvertiserId, CampaignId: campaign.Id, StatsPeriod: req.StatsPeriod}
+	stats, err := s.grouped(ctx, filter, req.GroupBy)
+	if err != nil {
+		return StatsGroups{}, fmt.Errorf("get stats grouped (for campaign): %w", err)
+	}
+	return stats, nil
+}
+
+func (s *StatsService) GetStatsAdvertiser(ctx context.Context, advertiser model.Advertiser, period model.StatsPeriod) (model.CampaignStats, error) {
+	stats, err := s.total(ctx, model.StatsFilter{AdvertiserId: advertiser.Id, StatsPeriod: period})
 	if err != nil {
 		return model.CampaignStats{}, fmt.Errorf("get stats (for advertiser): %w", err)
 	}
 	return stats, nil
 }
 
-func (s *StatsService) GetStatsAdvertiserDaily(advertiser model.Advertiser) ([]model.CampaignStats, error) {
-	stats, err := s.campaignRepo.GetStatsDaily(advertiser.Id, uuid.Nil)
+func (s *StatsService) GetStatsAdvertiserDaily(ctx context.Context, advertiser model.Advertiser, period model.StatsPeriod) ([]model.CampaignStats, error) {
+	stats, err := s.daily(ctx, model.StatsFilter{AdvertiserId: advertiser.Id, StatsPeriod: period})
 	if err != nil {
 		return nil, fmt.Errorf("get stats daily (for advertiser): %w", err)
 	}
 	return stats, nil
 }
+
+func (s *StatsService) GetStatsAdvertiserGrouped(ctx context.Context, advertiser model.Advertiser, req model.StatsRequest) (StatsGroups, error) {
+	stats, err := s.grouped(ctx, model.StatsFilter{AdvertiserId: advertiser.Id, StatsPeriod: req.StatsPeriod}, req.GroupBy)
+	if err != nil {
+		return StatsGroups{}, fmt.Errorf("get stats grouped (for advertiser): %w", err)
+	}
+	return stats, nil
+}
+
+// GetStatsAdvertiserBreakdown returns the totals of all campaigns of the advertiser and a page of campaigns
+// with their own totals, sorted by req.SortBy. Campaigns without impressions in the period are included with zeros.
+func (s *StatsService) GetStatsAdvertiserBreakdown(ctx context.Context, advertiser model.Advertiser, req model.StatsBreakdownRequest) (model.StatsBreakdown, error) {
+	breakdown, err := s.statsRepo.GetBreakdown(ctx, advertiser.Id, req)
+	if err != nil {
+		return model.StatsBreakdown{}, fmt.Errorf("get stats breakdown: %w", err)
+	}
+	return breakdown, nil
+}
+
+// GetInvalidTrafficReport lists clicks on the ad of the campaign flagged as invalid traffic in the period.
+func (s *StatsService) GetInvalidTrafficReport(ctx context.Context, campaign model.Campaign, req model.InvalidTrafficRequest) (model.InvalidTrafficReport, error) {
+	filter := model.NonBillableClicksFilter{CampaignId: campaign.Id, Reasons: model.InvalidTrafficReasons, StatsPeriod: req.StatsPeriod}
+	byReason, err := s.statsRepo.CountNonBillableClicks(ctx, filter)
+	if err != nil {
+		return model.InvalidTrafficReport{}, fmt.Errorf("count non-billable clicks: %w", err)
+	}
+	clicks, err := s.statsRepo.GetNonBillableClicks(ctx, filter, req.Size, req.Page)
+	if err != nil {
+		return model.InvalidTrafficReport{}, fmt.Errorf("get non-billable clicks: %w", err)
+	}
+
+	report := model.InvalidTrafficReport{ByReason: byReason, Clicks: clicks}
+	for _, count := range byReason {
+		report.Total += count
+	}
+	return report, nil
+}
+
+// ReportDay is a DayJob which logs the platform-wide stats of the day preceding the given one.
+func (s *StatsService) ReportDay(ctx context.Context, day int) error {
+	if day == 0 {
+		return nil
+	}
+	stats, err := s.statsRepo.GetStatsForDate(ctx, day-1)
+	if err != nil {
+		return fmt.Errorf("get stats for date: %w", err)
+	}
+	s.log.InfoContext(ctx, "day report", "day", day-1,
+		"impressions", stats.ImpressionsCount, "clicks", stats.ClicksCount, "spent", stats.SpentTotal)
+	return nil
+}
+
+func (s *StatsService) total(ctx context.Context, filter model.StatsFilter) (model.CampaignStats, error) {
+	groups, err := s.grouped(ctx, filter, nil)
+	if err != nil || len(groups.keys) == 0 {
+		return model.CampaignStats{}, err
+	}
+	return groups.All()[0].CampaignStats, nil
+}
+
+func (s *StatsService) daily(ctx context.Context, filter model.StatsFilter) ([]model.CampaignStats, error) {
+	groups, err := s.grouped(ctx, filter, []string{model.StatsByDate})
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]model.CampaignStats, 0, len(groups.keys))
+	_ = groups.Each(func(group model.StatsGroup) error {
+		stats = append(stats, group.CampaignStats)
+		return nil
+	})
+	return stats, nil
+}
+
+// groupKey holds values of the dimensions a row is grouped by; other fields are zero.
+type groupKey struct {
+	date       int
+	campaignId uuid.UUID
+	gender     string
+	ageBucket  int // index in ageBuckets
+	location   string
+	creative   model.Creative
+}
+
+// StatsGroups are stats aggregated by dimensions. They are passed out one by one in order of the dimensions,
+// so that an export writes them without copying into a slice.
+type StatsGroups struct {
+	keys   []groupKey
+	groups map[groupKey]*model.StatsGroup
+}
+
+// Each passes the groups to f in order, stopping at the first error returned by f.
+func (g StatsGroups) Each(f func(group model.StatsGroup) error) error {
+	for _, key := range g.keys {
+		if err := f(*g.groups[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// All returns the groups in order.
+func (g StatsGroups) All() []model.StatsGroup {
+	res := make([]model.StatsGroup, 0, len(g.keys))
+	_ = g.Each(func(group model.StatsGroup) error {
+		res = append(res, group)
+		return nil
+	})
+	return res
+}
+
+// grouped aggregates rows selected by filter into groups by dimensions from model.StatsDimensions,
+// sorted by the dimensions in the given order. Without dimensions, a single group of all rows is returned,
+// or none if there are no rows. Rows are aggregated as they are read, so only the groups are kept in memory.
+func (s *StatsService) grouped(ctx context.Context, filter model.StatsFilter, dimensions []string) (StatsGroups, error) {
+	dimensions = uniqueDimensions(dimensions)
+	for _, dimension := range dimensions {
+		switch dimension {
+		case model.StatsByGender, model.StatsByAgeBucket, model.StatsByLocation:
+			filter.ByClient = true
+		}
+	}
+
+	res := StatsGroups{keys: make([]groupKey, 0), groups: make(map[groupKey]*model.StatsGroup)}
+	err := s.statsRepo.EachRow(ctx, filter, func(row model.StatsRow) error {
+		key := rowKey(row, dimensions)
+		group, ok := res.groups[key]
+		if !ok {
+			group = newGroup(key, dimensions)
+			res.groups[key] = group
+			res.keys = append(res.keys, key)
+		}
+		group.ImpressionsCount += row.ImpressionsCount
+		group.ClicksCount += row.ClicksCount
+		group.NonBillableClicksCount += row.NonBillableClicksCount
+		group.SpentImpressions += row.SpentImpressions
+		group.SpentClicks += row.SpentClicks
+		return nil
+	})
+	if err != nil {
+		return StatsGroups{}, fmt.Errorf("get stats rows: %w", err)
+	}
+
+	slices.SortFunc(res.keys, func(a, b groupKey) int {
+		return compareKeys(a, b, dimensions)
+	})
+	for _, group := range res.groups {
+		group.SpentTotal = group.SpentImpressions + group.SpentClicks
+		if group.ImpressionsCount > 0 {
+			group.Conversion = float64(group.ClicksCount) / float64(group.ImpressionsCount) * 100
+		}
+	}
+	return res, nil
+}
+
+func uniqueDimensions(dimensions []string) []string {
+	unique := make([]string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		if !slices.Contains(unique, dimension) {
+			unique = append(unique, dimension)
+		}
+	}
+	return unique
+}
+
+func rowKey(row model.StatsRow, dimensions []string) groupKey {
+	var key groupKey
+	for _, dimension := range dimensions {
+		switch dimension {
+		case model.StatsByDate:
+			key.date = row.Date
+		case model.StatsByCampaign:
+			key.campaignId = row.CampaignId
+		case model.StatsByGender:
+			key.gender = *row.Gender
+		case model.StatsByAgeBucket:
+			key.ageBucket = ageBucketIndex(*row.Age)
+		case model.StatsByLocation:
+			key.location = *row.Location
+		case model.StatsByCreative:
+			key.creative = model.Creative{AdTitle: row.AdTitle, AdText: row.AdText, ImagePath: row.ImagePath}
+		}
+	}
+	return key
+}
+
+func newGroup(key groupKey, dimensions []string) *model.StatsGroup {
+	group := &model.StatsGroup{}
+	for _, dimension := range dimensions {
+		switch dimension {
+		case model.StatsByDate:
+			group.Date = &key.date
+		case model.StatsByCampaign:
+			group.CampaignId = &key.campaignId
+		case model.StatsByGender:
+			group.Gender = &key.gender
+		case model.StatsByAgeBucket:
+			label := ageBucketLabel(key.ageBucket)
+			group.AgeBucket = &label
+		case model.StatsByLocation:
+			group.Location = &key.location
+		case model.StatsByCreative:
+			group.Creative = &key.creative
+		}
+	}
+	return group
+}
+
+func compareKeys(a, b groupKey, dimensions []string) int {
+	for _, dimension := range dimensions {
+		var c int
+		switch dimension {
+		case model.StatsByDate:
+			c = cmp.Compare(a.date, b.date)
+		case model.StatsByCampaign:
+			c = cmp.Compare(a.campaignId.String(), b.campaignId.String())
+		case model.StatsByGender:
+			c = cmp.Compare(a.gender, b.gender)
+		case model.StatsByAgeBucket:
+			c = cmp.Compare(a.ageBucket, b.ageBucket)
+		case model.StatsByLocation:
+			c = cmp.Compare(a.location, b.location)
+		case model.StatsByCreative:
+			c = cmp.Or(
+				cmp.Compare(a.creative.AdTitle, b.creative.AdTitle),
+				cmp.Compare(a.creative.AdText, b.creative.AdText),
+				cmp.Compare(a.creative.ImagePath, b.creative.ImagePath),
+			)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// ageBucketLabel returns the label of the i-th age bucket, such as "25-34" or "65+".
+func ageBucketLabel(i int) string {
+	if i == len(ageBuckets)-1 {
+		return strconv.Itoa(ageBuckets[i]) + "+"
+	}
+	return fmt.Sprintf("%d-%d", ageBuckets[i], ageBuckets[i+1]-1)
+}
+
+func ageBucketIndex(age int) int {
+	i, found := slices.BinarySearch(ageBuckets, age)
+	if !found {
+		i--
+	}
+	return i
+}
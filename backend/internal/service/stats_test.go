@@ -0,0 +1,212 @@
+package service
+
+import (
+	"backend/config"
+	"backend/internal/model"
+	"backend/internal/repo/memory"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockStatsRepo struct {
+	mock.Mock
+}
+
+func (m *MockStatsRepo) EachRow(_ context.Context, filter model.StatsFilter, f func(row model.StatsRow) error) error {
+	args := m.Called(filter)
+	for _, row := range args.Get(0).([]model.StatsRow) {
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockStatsRepo) GetStatsForDate(_ context.Context, date int) (model.CampaignStats, error) {
+	args := m.Called(date)
+	return args.Get(0).(model.CampaignStats), args.Error(1)
+}
+
+func (m *MockStatsRepo) GetBreakdown(_ context.Context, advertiserId uuid.UUID, req model.StatsBreakdownRequest) (model.StatsBreakdown, error) {
+	args := m.Called(advertiserId, req)
+	return args.Get(0).(model.StatsBreakdown), args.Error(1)
+}
+
+func (m *MockStatsRepo) Rebuild(_ context.Context) error {
+	return m.Called().Error(0)
+}
+
+func (m *MockStatsRepo) GetNonBillableClicks(_ context.Context, filter model.NonBillableClicksFilter, size, page int) ([]model.NonBillableClick, error) {
+	args := m.Called(filter, size, page)
+	return args.Get(0).([]model.NonBillableClick), args.Error(1)
+}
+
+func (m *MockStatsRepo) CountNonBillableClicks(_ context.Context, filter model.NonBillableClicksFilter) (map[string]int, error) {
+	args := m.Called(filter)
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestStatsService_Grouped(t *testing.T) {
+	ctx := context.Background()
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	first, second := uuid.New(), uuid.New()
+	// creatives are ordered by titles, so they must not depend on random ids
+	titles := map[uuid.UUID]string{first: "first", second: "second"}
+	row := func(campaignId uuid.UUID, date int, gender string, age int, location string, impressions, clicks int) model.StatsRow {
+		return model.StatsRow{
+			CampaignId:       campaignId,
+			Date:             date,
+			Gender:           &gender,
+			Age:              &age,
+			Location:         &location,
+			AdTitle:          titles[campaignId],
+			ImpressionsCount: impressions,
+			ClicksCount:      clicks,
+			SpentImpressions: float64(impressions),
+			SpentClicks:      float64(clicks) * 10,
+		}
+	}
+
+	statsRepo := &MockStatsRepo{}
+	service := &StatsService{statsRepo, slog.Default()}
+	period := model.StatsPeriod{From: ptr(1), To: ptr(7)}
+	statsRepo.On("EachRow", model.StatsFilter{AdvertiserId: advertiser.Id, StatsPeriod: period, ByClient: true}).Return([]model.StatsRow{
+		row(first, 1, "MALE", 17, "Moscow", 1, 0),
+		row(first, 2, "FEMALE", 24, "Moscow", 1, 1),
+		row(second, 2, "MALE", 65, "Kazan", 2, 1),
+		row(second, 3, "MALE", 18, "Moscow", 1, 0),
+	}, nil)
+
+	grouped, err := service.GetStatsAdvertiserGrouped(ctx, advertiser, model.StatsRequest{
+		StatsPeriod: period,
+		GroupBy:     []string{model.StatsByLocation, model.StatsByAgeBucket, model.StatsByLocation},
+	})
+	require.NoError(t, err)
+	groups := grouped.All()
+	assert.Equal(t, []model.StatsGroup{
+		{Location: ptr("Kazan"), AgeBucket: ptr("65+"), CampaignStats: model.CampaignStats{
+			ImpressionsCount: 2, ClicksCount: 1, Conversion: 50, SpentImpressions: 2, SpentClicks: 10, SpentTotal: 12,
+		}},
+		{Location: ptr("Moscow"), AgeBucket: ptr("0-17"), CampaignStats: model.CampaignStats{
+			ImpressionsCount: 1, SpentImpressions: 1, SpentTotal: 1,
+		}},
+		{Location: ptr("Moscow"), AgeBucket: ptr("18-24"), CampaignStats: model.CampaignStats{
+			ImpressionsCount: 2, ClicksCount: 1, Conversion: 50, SpentImpressions: 2, SpentClicks: 10, SpentTotal: 12,
+		}},
+	}, groups)
+
+	// rows are not split by clients unless grouped by their attributes
+	statsRepo.On("EachRow", model.StatsFilter{AdvertiserId: advertiser.Id}).Return([]model.StatsRow{
+		row(first, 1, "MALE", 17, "Moscow", 1, 0),
+		row(second, 1, "MALE", 17, "Moscow", 3, 1),
+		row(first, 2, "MALE", 17, "Moscow", 1, 1),
+	}, nil)
+
+	grouped, err = service.GetStatsAdvertiserGrouped(ctx, advertiser, model.StatsRequest{GroupBy: []string{model.StatsByCreative, model.StatsByDate}})
+	require.NoError(t, err)
+	groups = grouped.All()
+	require.Len(t, groups, 3)
+	assert.Equal(t, &model.Creative{AdTitle: "first"}, groups[0].Creative)
+	assert.Equal(t, 1, *groups[0].Date)
+	assert.Equal(t, 2, *groups[1].Date)
+	assert.Nil(t, groups[0].CampaignId)
+
+	daily, err := service.GetStatsAdvertiserDaily(ctx, advertiser, model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, []model.CampaignStats{
+		{ImpressionsCount: 4, ClicksCount: 1, Conversion: 25, SpentImpressions: 4, SpentClicks: 10, SpentTotal: 14, Date: ptr(1)},
+		{ImpressionsCount: 1, ClicksCount: 1, Conversion: 100, SpentImpressions: 1, SpentClicks: 10, SpentTotal: 11, Date: ptr(2)},
+	}, daily)
+
+	total, err := service.GetStatsAdvertiser(ctx, advertiser, model.StatsPeriod{})
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignStats{ImpressionsCount: 5, ClicksCount: 2, Conversion: 40, SpentImpressions: 5, SpentClicks: 20, SpentTotal: 25}, total)
+
+	statsRepo.AssertExpectations(t)
+}
+
+func TestStatsService_GetStatsAdvertiserBreakdown(t *testing.T) {
+	ctx := context.Background()
+	repos := memory.NewRepositories()
+	service := &StatsService{repos.Stats, slog.Default()}
+	advertiser := model.Advertiser{Id: uuid.New(), Name: "adv"}
+	clients := []model.Client{{Id: uuid.New(), Login: "first"}, {Id: uuid.New(), Login: "second"}}
+	require.NoError(t, repos.Advertiser.UpsertMany(ctx, []model.Advertiser{advertiser}))
+	require.NoError(t, repos.Client.UpsertMany(ctx, clients))
+
+	addCampaign := func(title string) model.Campaign {
+		campaign := model.Campaign{
+			Id:           uuid.New(),
+			AdvertiserId: advertiser.Id,
+			// impressions and clicks are checked against the limits, which are NOT NULL in the database
+			CampaignCreateRequest: model.CampaignCreateRequest{AdTitle: title, ImpressionsLimit: ptr(10), ClicksLimit: ptr(10)},
+			Version:               1,
+		}
+		require.NoError(t, repos.Campaign.Add(ctx, campaign))
+		return campaign
+	}
+	first, second, idle := addCampaign("first"), addCampaign("second"), addCampaign("idle")
+	for _, client := range clients {
+		require.NoError(t, repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: client.Id, CampaignId: first.Id, Spent: 1, Date: 1}, config.DefaultAds.LimitsThreshold))
+		require.NoError(t, repos.Campaign.AddAdImpression(ctx, model.AdImpression{ClientId: client.Id, CampaignId: second.Id, Spent: 2, Date: 2}, config.DefaultAds.LimitsThreshold))
+	}
+	require.NoError(t, repos.Campaign.AddAdClick(ctx, model.AdClick{ClientId: clients[0].Id, CampaignId: first.Id, Spent: 5, Date: 2}, config.DefaultAds.LimitsThreshold))
+
+	breakdown, err := service.GetStatsAdvertiserBreakdown(ctx, advertiser, model.StatsBreakdownRequest{Size: 2, Page: 1})
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignStats{
+		ImpressionsCount: 4, ClicksCount: 1, Conversion: 25, SpentImpressions: 6, SpentClicks: 5, SpentTotal: 11,
+	}, breakdown.Total)
+	assert.Equal(t, 3, breakdown.CampaignsCount)
+	assert.Equal(t, []model.CampaignStatsItem{
+		{CampaignId: first.Id, AdTitle: "first", CampaignStats: model.CampaignStats{
+			ImpressionsCount: 2, ClicksCount: 1, Conversion: 50, SpentImpressions: 2, SpentClicks: 5, SpentTotal: 7,
+		}},
+		{CampaignId: second.Id, AdTitle: "second", CampaignStats: model.CampaignStats{
+			ImpressionsCount: 2, SpentImpressions: 4, SpentTotal: 4,
+		}},
+	}, breakdown.Campaigns)
+
+	// campaigns with equal values keep the order of creation
+	breakdown, err = service.GetStatsAdvertiserBreakdown(ctx, advertiser, model.StatsBreakdownRequest{
+		SortBy: model.StatsSortImpressionsCount, Order: "asc", Size: 2, Page: 2,
+	})
+	require.NoError(t, err)
+	require.Len(t, breakdown.Campaigns, 1)
+	assert.Equal(t, second.Id, breakdown.Campaigns[0].CampaignId)
+
+	// the totals are returned for a page past the end too
+	breakdown, err = service.GetStatsAdvertiserBreakdown(ctx, advertiser, model.StatsBreakdownRequest{Size: 2, Page: 3})
+	require.NoError(t, err)
+	assert.Empty(t, breakdown.Campaigns)
+	assert.Equal(t, 3, breakdown.CampaignsCount)
+	assert.Equal(t, 4, breakdown.Total.ImpressionsCount)
+
+	// the period applies to the stats, but not to the list of campaigns
+	breakdown, err = service.GetStatsAdvertiserBreakdown(ctx, advertiser, model.StatsBreakdownRequest{
+		StatsPeriod: model.StatsPeriod{To: ptr(1)}, SortBy: model.StatsSortConversion, Size: 10, Page: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, breakdown.Total.ImpressionsCount)
+	ids := make([]uuid.UUID, len(breakdown.Campaigns))
+	for i, item := range breakdown.Campaigns {
+		ids[i] = item.CampaignId
+	}
+	assert.Equal(t, []uuid.UUID{first.Id, second.Id, idle.Id}, ids)
+}
+
+func TestAgeBucketLabel(t *testing.T) {
+	for age, want := range map[int]string{0: "0-17", 17: "0-17", 18: "18-24", 34: "25-34", 64: "55-64", 65: "65+", 120: "65+"} {
+		assert.Equal(t, want, ageBucketLabel(ageBucketIndex(age)), "age %d", age)
+	}
+}
@@ -0,0 +1,101 @@
+// Package tracing configures OpenTelemetry tracing of HTTP requests, SQL queries and LLM calls.
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Exporters of spans, see Setup.
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+const serviceName = "backend"
+
+// Tracer creates spans of the backend. Before Setup, and if tracing is disabled, the spans are no-op.
+func Tracer() trace.Tracer {
+	return otel.Tracer(serviceName)
+}
+
+// Setup installs a global tracer provider which sends spans to the exporter: ExporterOTLP over HTTP,
+// configured by standard OTEL_EXPORTER_OTLP_* variables, or ExporterStdout which prints them to stderr for local debugging.
+// With ExporterNone, tracing stays disabled. The returned function flushes pending spans and must be called on exit.
+func Setup(ctx context.Context, exporter string) (func(ctx context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		// one line per span in stderr, so that spans don't break the line-based JSON logs in stdout
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s exporter: %w", exporter, err)
+	}
+
+	// attributes from OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, fmt.Errorf("create resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// SQLOptions make otelsql record the sanitized statement instead of the raw one, and skip spans of iterating rows.
+func SQLOptions() []otelsql.Option {
+	return []otelsql.Option{
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{DisableQuery: true, OmitRows: true, OmitConnResetSession: true}),
+		otelsql.WithAttributesGetter(func(_ context.Context, _ otelsql.Method, query string, _ []driver.NamedValue) []attribute.KeyValue {
+			if query == "" {
+				return nil
+			}
+			return []attribute.KeyValue{semconv.DBQueryText(SanitizeStatement(query))}
+		}),
+	}
+}
+
+var (
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// placeholders like $1 and digits in identifiers are not literals
+	numberLiteral = regexp.MustCompile(`(^|[^\w$.])\d+(?:\.\d+)?\b`)
+)
+
+// SanitizeStatement replaces string and number literals of a SQL statement with "?" and collapses whitespace.
+// Arguments passed as placeholders are not a part of the statement, so they are never recorded.
+func SanitizeStatement(query string) string {
+	query = stringLiteral.ReplaceAllString(query, "?")
+	query = numberLiteral.ReplaceAllString(query, "${1}?")
+	return strings.Join(strings.Fields(query), " ")
+}
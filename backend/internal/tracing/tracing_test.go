@@ -0,0 +1,21 @@
+package tracing
+
+import "testing"
+
+func TestSanitizeStatement(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM clients WHERE id = $1", "SELECT * FROM clients WHERE id = $1"},
+		{"SELECT *\n\t\tFROM campaigns\n\t\tLIMIT 10 OFFSET $2", "SELECT * FROM campaigns LIMIT ? OFFSET $2"},
+		{"UPDATE settings SET sandbox = TRUE WHERE name = 'it''s' AND score > 0.5", "UPDATE settings SET sandbox = TRUE WHERE name = ? AND score > ?"},
+		{"SELECT campaign_stats_daily.date FROM ad_impressions2", "SELECT campaign_stats_daily.date FROM ad_impressions2"},
+		{"SELECT $1::uuid[], 3*4", "SELECT $1::uuid[], ?*?"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeStatement(tt.query); got != tt.want {
+			t.Errorf("SanitizeStatement(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
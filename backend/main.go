@@ -3,52 +3,189 @@ package main
 import (
 	"backend/config"
 	"backend/internal/handler"
+	"backend/internal/logging"
 	"backend/internal/repo"
+	"backend/internal/repo/memory"
 	"backend/internal/service"
+	"backend/internal/tracing"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-func getDatabase(env config.Environment) (*sqlx.DB, error) {
-	return sqlx.Connect("postgres", env.BuildDsn())
+// getDatabase connects to the database and applies the pool settings; used for both the primary and the replica.
+func getDatabase(dsn string, cfg config.Postgres) (*sqlx.DB, error) {
+	sqlDB, err := otelsql.Open("postgres", dsn, tracing.SQLOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sqlDB, "postgres")
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// fatal logs the error and exits.
+func fatal(log *slog.Logger, msg string, err error) {
+	log.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 func main() {
-	env := config.LoadEnvironment()
-	if env.RunningInCI {
-		log.Println("Detected CI environment. Requests logging and AI service will be disabled")
+	env, err := config.LoadEnvironment()
+	if err != nil {
+		log.Fatalf("load environment: %s\n", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		// prints the effective configuration, after defaults, the file and environment variables are applied
+		out, err := env.Redacted().YAML()
+		if err != nil {
+			log.Fatalf("format config: %s\n", err)
+		}
+		fmt.Print(string(out))
+		return
+	}
+	logger, err := logging.New(os.Stdout, env.Logging)
+	if err != nil {
+		log.Fatalf("set up logging: %s\n", err)
 	}
+	// also used by log package and by packages without an injected logger
+	slog.SetDefault(logger)
+	appLog := logging.For(logger, logging.SubsystemApp)
 
-	db, err := getDatabase(env)
+	if env.RunningInCI {
+		appLog.Info("detected CI environment, AI service will be disabled")
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), env.TracingExporter)
 	if err != nil {
-		log.Fatalf("connect to database: %s\n", err)
+		fatal(appLog, "set up tracing", err)
 	}
 
-	repos := repo.NewRepositories(db)
-	services, err := service.NewServices(repos, env)
+	var db, replica *sqlx.DB
+	var repos *repo.Repositories
+	switch env.StorageBackend {
+	case config.StorageMemory:
+		if len(os.Args) > 1 && (os.Args[1] == "migrate" || os.Args[1] == "stats") {
+			fatal(appLog, "run command", fmt.Errorf("%s: not supported by %s storage backend", os.Args[1], env.StorageBackend))
+		}
+		appLog.Warn("using in-memory storage, all data will be lost on exit")
+		repos = memory.NewRepositories()
+	default:
+		db, err = getDatabase(env.BuildDsn(), env.Postgres)
+		if err != nil {
+			fatal(appLog, "connect to database", err)
+		}
+
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			if err := runMigrateCommand(context.Background(), db, os.Args[2:]); err != nil {
+				fatal(appLog, "migrate", err)
+			}
+			return
+		}
+
+		if err := prepareDatabase(context.Background(), db, env.AutoMigrate); err != nil {
+			fatal(appLog, "prepare database", err)
+		}
+		if dsn := env.BuildReplicaDsn(); dsn != "" {
+			replica, err = getDatabase(dsn, env.Postgres)
+			if err != nil {
+				fatal(appLog, "connect to read replica", err)
+			}
+			appLog.Info("routing reads of GET requests to read replica", "host", env.Postgres.ReplicaHost)
+		}
+		repos = repo.NewRepositories(repo.NewDB(db, replica))
+
+		if len(os.Args) > 1 && os.Args[1] == "stats" {
+			if err := runStatsCommand(context.Background(), repos.Stats, os.Args[2:]); err != nil {
+				fatal(appLog, "stats", err)
+			}
+			return
+		}
+	}
+
+	// stops background jobs which are not drained on shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	if db != nil {
+		go repo.ListenSettings(bgCtx, env.BuildDsn(), repos.Settings, env.SettingsRefreshInterval, logging.For(logger, logging.SubsystemRepo))
+	}
+
+	services, err := service.NewServices(repos, env, logger)
 	if err != nil {
-		log.Fatalf("create services: %s\n", err)
+		fatal(appLog, "create services", err)
+	}
+	if db != nil {
+		migrator, err := newMigrator(db)
+		if err != nil {
+			fatal(appLog, "create migrator", err)
+		}
+		services.Health.Register("database", true, db.PingContext)
+		services.Health.Register("migrations", true, migrator.Check)
+		if replica != nil {
+			services.Health.Register("database_replica", true, replica.PingContext)
+		}
+	}
+	go services.Scheduler.Run(bgCtx)
+	if env.RequestsLog.Enabled && env.RequestsLog.Retention > 0 {
+		go services.Api.RunRetention(bgCtx)
+	}
+	h := handler.NewHandler(services, logger)
+	srv := &http.Server{
+		Addr:    env.ServerAddress,
+		Handler: h.GetRouter(env),
 	}
-	h := handler.NewHandler(services)
-	router := h.GetRouter(env)
 
 	go func() {
-		err := router.Run(env.ServerAddress)
+		appLog.Info("listening", "address", env.ServerAddress)
+		err := srv.ListenAndServe()
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("run server: %s\n", err)
+			fatal(appLog, "run server", err)
 		}
 	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
 	sig := <-quit
+	signal.Stop(quit)
+
+	appLog.Info("received signal, shutting down", "signal", sig.String(), "grace_period", env.ShutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), env.ShutdownTimeout)
+	defer cancel()
 
-	fmt.Printf("Received signal: %s\n", sig)
+	// stop accepting requests first, so that no new work is submitted to services
+	if err := srv.Shutdown(ctx); err != nil {
+		appLog.Error("shutdown server", "error", err)
+	}
+	if err := services.Shutdown(ctx); err != nil {
+		appLog.Error("shutdown services", "error", err)
+	}
+	stopBackground()
+	if err := shutdownTracing(ctx); err != nil {
+		appLog.Error("flush traces", "error", err)
+	}
+	if db != nil {
+		if err := db.Close(); err != nil {
+			appLog.Error("close database", "error", err)
+		}
+	}
+	if replica != nil {
+		if err := replica.Close(); err != nil {
+			appLog.Error("close read replica", "error", err)
+		}
+	}
+	appLog.Info("shutdown complete")
 }
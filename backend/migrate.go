@@ -0,0 +1,128 @@
+package main
+
+import (
+	"backend/internal/repo"
+	"backend/migrations"
+	"backend/pkg/migrate"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"log/slog"
+	"strconv"
+)
+
+const migrateUsage = `usage: application migrate [command]
+
+commands:
+  up           apply all pending migrations (default)
+  down [N]     revert N last migrations (default 1)
+  to VERSION   migrate up or down to the given version
+  status       print current and latest versions`
+
+func newMigrator(db *sqlx.DB) (*migrate.Migrator, error) {
+	m, err := migrate.New(db.DB, migrations.FS)
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+	// databases created before migrations were introduced
+	m.SetLegacyTable("campaigns")
+	return m, nil
+}
+
+// prepareDatabase migrates the database on startup if autoMigrate is set,
+// and makes sure that its schema version matches the binary.
+func prepareDatabase(ctx context.Context, db *sqlx.DB, autoMigrate bool) error {
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate {
+		if err := m.Check(ctx); errors.Is(err, migrate.ErrDatabaseBehind) {
+			if err := checkSandbox(ctx, db); err != nil {
+				return err
+			}
+		}
+		applied, err := m.Up(ctx)
+		logMigrations("applied", applied)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.Check(ctx)
+	if errors.Is(err, migrate.ErrDatabaseBehind) {
+		return fmt.Errorf("%w, run `application migrate up`", err)
+	}
+	return err
+}
+
+// runMigrateCommand implements `migrate` subcommand.
+func runMigrateCommand(ctx context.Context, db *sqlx.DB, args []string) error {
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if command != "status" {
+		if err := checkSandbox(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	var done []migrate.Migration
+	switch {
+	case command == "up" && len(args) <= 1:
+		done, err = m.Up(ctx)
+		logMigrations("applied", done)
+	case command == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations: %s\n%s", args[1], migrateUsage)
+			}
+		}
+		done, err = m.Down(ctx, steps)
+		logMigrations("reverted", done)
+	case command == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version: %s\n%s", args[1], migrateUsage)
+		}
+		done, err = m.To(ctx, version)
+		logMigrations("executed", done)
+	case command == "status" && len(args) == 1:
+		var version int
+		version, err = m.Current(ctx)
+		if err == nil {
+			slog.Info("migrations status", "current_version", version, "latest_version", m.Latest())
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return err
+}
+
+// checkSandbox refuses to change the schema while the sandbox is active: its snapshot keeps the old schema,
+// so it could not be restored.
+func checkSandbox(ctx context.Context, db *sqlx.DB) error {
+	active, err := repo.SnapshotExists(ctx, db)
+	if err != nil {
+		return fmt.Errorf("check sandbox: %w", err)
+	}
+	if active {
+		return errors.New("sandbox is active, discard it with DELETE /time/sandbox before migrating")
+	}
+	return nil
+}
+
+func logMigrations(action string, done []migrate.Migration) {
+	for _, migration := range done {
+		slog.Info("migration", "action", action, "version", migration.Version, "name", migration.Name)
+	}
+}
@@ -0,0 +1,13 @@
+DROP TABLE ad_clicks;
+DROP TABLE ad_impressions;
+DROP VIEW campaigns_moderation;
+DROP TABLE campaigns;
+DROP TABLE ml_scores;
+DROP TABLE advertisers;
+DROP TABLE clients;
+DROP TYPE targeting_gender;
+DROP TYPE gender;
+DROP TABLE ai_task_results;
+DROP TABLE ai_tasks;
+DROP TABLE settings;
+DROP TABLE api_requests;
@@ -0,0 +1,11 @@
+DROP VIEW campaigns_moderation;
+
+ALTER TABLE campaigns DROP COLUMN version;
+
+CREATE VIEW campaigns_moderation AS
+    SELECT
+        c.*,
+        r.answer AS moderation_result
+    FROM campaigns c
+    JOIN advertisers a ON c.advertiser_id = a.id
+    LEFT JOIN ai_task_results r on c.moderation_task_id = r.task_id;
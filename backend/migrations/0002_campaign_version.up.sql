@@ -0,0 +1,13 @@
+-- the view selects c.*, so it has to be recreated to include the new column
+DROP VIEW campaigns_moderation;
+
+-- IF NOT EXISTS: databases created by the former init script (schemas/backend.sql) already have this column
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE VIEW campaigns_moderation AS
+    SELECT
+        c.*,
+        r.answer AS moderation_result
+    FROM campaigns c
+    JOIN advertisers a ON c.advertiser_id = a.id
+    LEFT JOIN ai_task_results r on c.moderation_task_id = r.task_id;
@@ -0,0 +1,5 @@
+-- the last simulated day processed by each job of the scheduler
+CREATE TABLE scheduler_jobs (
+    name TEXT PRIMARY KEY,
+    last_day INT NOT NULL
+);
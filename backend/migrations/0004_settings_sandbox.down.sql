@@ -0,0 +1,2 @@
+DROP SCHEMA IF EXISTS sandbox_snapshot CASCADE;
+ALTER TABLE settings DROP COLUMN sandbox;
@@ -0,0 +1,2 @@
+-- set while a snapshot of the data is kept in the sandbox_snapshot schema
+ALTER TABLE settings ADD COLUMN sandbox BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,21 @@
+-- per-campaign per-day rollup of ad_impressions and ad_clicks, maintained on each insert of an event
+CREATE TABLE campaign_stats_daily (
+    campaign_id UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    date INT NOT NULL,
+    impressions_count INT NOT NULL DEFAULT 0,
+    clicks_count INT NOT NULL DEFAULT 0,
+    spent_impressions FLOAT NOT NULL DEFAULT 0,
+    spent_clicks FLOAT NOT NULL DEFAULT 0,
+    PRIMARY KEY (campaign_id, date)
+);
+
+-- backfill from the events recorded before; the same as `./application stats rebuild`
+INSERT INTO campaign_stats_daily (campaign_id, date, impressions_count, clicks_count, spent_impressions, spent_clicks)
+SELECT campaign_id, date, SUM(impressions_count), SUM(clicks_count), SUM(spent_impressions), SUM(spent_clicks)
+FROM (
+    SELECT campaign_id, date, 1 AS impressions_count, 0 AS clicks_count, spent AS spent_impressions, 0 AS spent_clicks
+    FROM ad_impressions
+    UNION ALL
+    SELECT campaign_id, date, 0, 1, 0, spent FROM ad_clicks
+) events
+GROUP BY campaign_id, date;
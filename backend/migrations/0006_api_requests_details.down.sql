@@ -0,0 +1,10 @@
+DROP INDEX api_requests_created_at_idx;
+
+ALTER TABLE api_requests
+    DROP COLUMN status,
+    DROP COLUMN response_size,
+    DROP COLUMN advertiser_id,
+    DROP COLUMN campaign_id,
+    DROP COLUMN client_id,
+    DROP COLUMN error,
+    DROP COLUMN sample_rate;
@@ -0,0 +1,12 @@
+-- ids are not foreign keys, so that the log outlives deleted campaigns
+ALTER TABLE api_requests
+    ADD COLUMN status INT,
+    ADD COLUMN response_size INT,
+    ADD COLUMN advertiser_id UUID,
+    ADD COLUMN campaign_id UUID,
+    ADD COLUMN client_id UUID,
+    ADD COLUMN error TEXT,
+    ADD COLUMN sample_rate REAL NOT NULL DEFAULT 1;
+
+-- for the retention cleanup and time-based dashboards
+CREATE INDEX api_requests_created_at_idx ON api_requests (created_at);
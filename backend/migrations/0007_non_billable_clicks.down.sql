@@ -0,0 +1,3 @@
+ALTER TABLE campaign_stats_daily DROP COLUMN non_billable_clicks_count;
+
+DROP TABLE ad_non_billable_clicks;
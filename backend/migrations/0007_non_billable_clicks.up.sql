@@ -0,0 +1,14 @@
+-- clicks rejected by the acceptance rules; they are not charged, but are counted in stats.
+-- Unlike ad_clicks, a client may make several of them, and they do not need an impression
+CREATE TABLE ad_non_billable_clicks (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    campaign_id UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    date INT NOT NULL
+);
+
+CREATE INDEX ad_non_billable_clicks_campaign_id_date_index ON ad_non_billable_clicks (campaign_id, date);
+
+ALTER TABLE campaign_stats_daily ADD COLUMN non_billable_clicks_count INT NOT NULL DEFAULT 0;
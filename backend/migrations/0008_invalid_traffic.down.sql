@@ -0,0 +1,12 @@
+DROP INDEX ad_non_billable_clicks_source_created_at_index;
+DROP INDEX ad_non_billable_clicks_client_id_created_at_index;
+DROP INDEX ad_clicks_source_created_at_index;
+DROP INDEX ad_clicks_client_id_created_at_index;
+
+ALTER TABLE ad_non_billable_clicks DROP COLUMN source;
+
+ALTER TABLE ad_clicks
+    DROP COLUMN created_at,
+    DROP COLUMN source;
+
+ALTER TABLE ad_impressions DROP COLUMN created_at;
@@ -0,0 +1,16 @@
+-- times and sources of events, for detection of invalid traffic. Events recorded before get the epoch,
+-- so that they are outside of any detection window
+ALTER TABLE ad_impressions ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT 'epoch';
+ALTER TABLE ad_impressions ALTER COLUMN created_at SET DEFAULT NOW();
+
+ALTER TABLE ad_clicks
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT 'epoch',
+    ADD COLUMN source TEXT NOT NULL DEFAULT '';
+ALTER TABLE ad_clicks ALTER COLUMN created_at SET DEFAULT NOW();
+
+ALTER TABLE ad_non_billable_clicks ADD COLUMN source TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX ad_clicks_client_id_created_at_index ON ad_clicks (client_id, created_at);
+CREATE INDEX ad_clicks_source_created_at_index ON ad_clicks (source, created_at);
+CREATE INDEX ad_non_billable_clicks_client_id_created_at_index ON ad_non_billable_clicks (client_id, created_at);
+CREATE INDEX ad_non_billable_clicks_source_created_at_index ON ad_non_billable_clicks (source, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE campaigns
+    DROP COLUMN impressions_count,
+    DROP COLUMN clicks_count;
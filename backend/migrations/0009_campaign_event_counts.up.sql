@@ -0,0 +1,9 @@
+-- running counts of impressions and clicks, updated together with the events under the lock of the campaign row,
+-- so that the limits are checked against the count which includes all committed events
+ALTER TABLE campaigns
+    ADD COLUMN impressions_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN clicks_count INT NOT NULL DEFAULT 0;
+
+UPDATE campaigns c SET
+    impressions_count = (SELECT COUNT(*) FROM ad_impressions ai WHERE ai.campaign_id = c.id),
+    clicks_count = (SELECT COUNT(*) FROM ad_clicks ac WHERE ac.campaign_id = c.id);
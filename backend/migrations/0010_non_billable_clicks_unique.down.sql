@@ -0,0 +1 @@
+DROP INDEX ad_non_billable_clicks_client_id_campaign_id_reason_date_index;
@@ -0,0 +1,13 @@
+-- a rejected click is recorded once per client, campaign, reason and date, so that repeated clicks
+-- do not grow the table; clicks without an impression are not recorded at all
+DELETE FROM ad_non_billable_clicks WHERE reason = 'ad_not_viewed';
+DELETE FROM ad_non_billable_clicks a USING ad_non_billable_clicks b
+WHERE a.client_id = b.client_id AND a.campaign_id = b.campaign_id AND a.reason = b.reason AND a.date = b.date
+  AND a.id > b.id;
+
+UPDATE campaign_stats_daily s SET non_billable_clicks_count = (
+    SELECT COUNT(*) FROM ad_non_billable_clicks n WHERE n.campaign_id = s.campaign_id AND n.date = s.date
+);
+
+CREATE UNIQUE INDEX ad_non_billable_clicks_client_id_campaign_id_reason_date_index
+    ON ad_non_billable_clicks (client_id, campaign_id, reason, date);
@@ -0,0 +1,10 @@
+// Package migrations contains SQL migrations of the database schema, embedded into the binary.
+//
+// Each migration is a pair of files NNNN_name.up.sql and NNNN_name.down.sql, where NNNN is the version.
+// Versions start from 1 and have no gaps. Applied migrations must never be edited; add a new one instead.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,16 @@
+package migrations
+
+import (
+	"backend/pkg/migrate"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	migrations, err := migrate.Load(FS)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Errorf("Load() returned no migrations")
+	}
+}
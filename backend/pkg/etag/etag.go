@@ -0,0 +1,26 @@
+package etag
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format builds a strong entity tag from the version of an entity.
+// Example: Format(3) => `"3"`.
+func Format(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// Match reports whether If-Match header value matches the entity with given version.
+// The header may contain either "*" or a comma-separated list of entity tags.
+// Weak entity tags never match, as If-Match requires the strong comparison (RFC 9110, section 13.1.1).
+func Match(header string, version int) bool {
+	want := Format(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package etag
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	if got := Format(0); got != `"0"` {
+		t.Errorf("Format(0) = %v, want \"0\"", got)
+	}
+	if got := Format(42); got != `"42"` {
+		t.Errorf("Format(42) = %v, want \"42\"", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	type testCase struct {
+		header  string
+		version int
+		want    bool
+	}
+	tests := []testCase{
+		{``, 1, false},
+		{`*`, 1, true},
+		{`"1"`, 1, true},
+		{`"2"`, 1, false},
+		{`1`, 1, false},
+		{`W/"1"`, 1, false},
+		{`"3", "1"`, 1, true},
+		{`"3","2"`, 1, false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.header, tt.version); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.header, tt.version, got, tt.want)
+		}
+	}
+}
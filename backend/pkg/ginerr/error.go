@@ -1,24 +1,130 @@
 package ginerr
 
 import (
+	"backend/pkg/requestid"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"log/slog"
 	"net/http"
 )
 
-type ErrorResp struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+// ContentType is the media type of Problem responses.
+const ContentType = "application/problem+json"
+
+// CodeValidationFailed is the code of Problem returned when the request is malformed.
+const CodeValidationFailed = "validation_failed"
+
+// CodeInternal is the code of Problem returned when the server fails to handle the request.
+const CodeInternal = "internal_error"
+
+// CodeTimeout is the code of Problem returned when the request is not handled before its deadline.
+const CodeTimeout = "request_timeout"
+
+// StatusClientClosedRequest is a non-standard status code (introduced by nginx)
+// used when the client has closed the connection before the request was handled.
+const StatusClientClosedRequest = 499
+
+// Problem is an error response in format of RFC 7807 (Problem Details for HTTP APIs).
+type Problem struct {
+	// Type is always "about:blank", meaning that the problem has no additional semantics beyond the status code.
+	Type   string `json:"type" example:"about:blank"`
+	Title  string `json:"title" example:"Bad Request"`
+	Status int    `json:"status" example:"400"`
+	Detail string `json:"detail,omitempty" example:"request validation failed"`
+	// Code is a stable machine-readable identifier of the problem, e.g. "validation_failed" or "client_not_found".
+	Code string `json:"code" example:"validation_failed"`
+	// Errors lists every violation when Code is "validation_failed".
+	Errors []Violation `json:"errors,omitempty"`
+	// RequestId identifies the request in server logs.
+	RequestId string `json:"request_id,omitempty" example:"7f0e5d3a-45e1-4b8e-9b8e-1d2c3b4a5f60"`
+}
+
+// Violation describes a single invalid field of the request.
+type Violation struct {
+	// Field is a path to the field in JSON body (like "targeting.gender" or "[2].client_id"),
+	// or a name of query or path parameter. It is empty if the violation relates to the whole request.
+	Field string `json:"field" example:"targeting.gender"`
+	// Code is a stable machine-readable identifier of the violated rule, e.g. "required", "gte" or "uuid".
+	Code    string `json:"code" example:"oneof"`
+	Message string `json:"message" example:"must be one of: MALE FEMALE ALL"`
+}
+
+// New builds a Problem with given status, code and detail.
+func New(status int, code, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Invalid builds a Problem with 400 status code, listing the violations.
+func Invalid(violations ...Violation) Problem {
+	p := New(http.StatusBadRequest, CodeValidationFailed, "request validation failed")
+	p.Errors = violations
+	return p
 }
 
-// Build is a utility function to build error response.
-// It returns JSON in format {"status": "error", "message": message}.
-func Build(message string) ErrorResp {
-	return ErrorResp{Status: "error", Message: message}
+// Render writes the problem to response and aborts the request chain.
+// If the request context carries a request ID, it is included in the problem.
+func Render(c *gin.Context, p Problem) {
+	if p.RequestId == "" && c.Request != nil {
+		p.RequestId = requestid.FromContext(c.Request.Context())
+	}
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// Abort is a shorthand for Render(c, New(status, code, detail)).
+func Abort(c *gin.Context, status int, code, detail string) {
+	Render(c, New(status, code, detail))
+}
+
+// AbortInvalid is a shorthand for Render(c, Invalid(violations...)).
+func AbortInvalid(c *gin.Context, violations ...Violation) {
+	Render(c, Invalid(violations...))
+}
+
+// AbortBinding deals with an error returned by c.ShouldBind* methods,
+// rendering a Problem with all violations found in err.
+func AbortBinding(c *gin.Context, err error) {
+	AbortInvalid(c, Violations(err)...)
 }
 
 // Handle500 deals with server error.
-// It is equivalent to c.Error(err) followed by c.JSON(500, Build(err.Error())) call.
+// The error is attached to the context with c.Error(err) and logged along with the request ID.
+// The client receives only a generic message with the request ID, as err may contain sensitive details.
+//
+// If the request context is done (the deadline is exceeded or the client has gone),
+// 504 or 499 is returned instead of 500, respectively, and the error is logged as a warning.
 func Handle500(c *gin.Context, err error) {
 	_ = c.Error(err)
-	c.JSON(http.StatusInternalServerError, Build(err.Error()))
+
+	// the request ID is added to the record by the default logger, see backend/internal/logging
+	ctx, method, path := context.Background(), "-", "-"
+	if c.Request != nil {
+		ctx, method, path = c.Request.Context(), c.Request.Method, c.Request.URL.Path
+	}
+
+	ctxErr := err
+	if ctx.Err() != nil {
+		ctxErr = ctx.Err()
+	}
+	switch {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		slog.WarnContext(ctx, "request deadline exceeded", "method", method, "path", path, "error", err)
+		Abort(c, http.StatusGatewayTimeout, CodeTimeout, "request was not handled in time")
+		return
+	case errors.Is(ctxErr, context.Canceled):
+		slog.WarnContext(ctx, "request canceled by client", "method", method, "path", path, "error", err)
+		c.AbortWithStatus(StatusClientClosedRequest)
+		return
+	}
+
+	slog.ErrorContext(ctx, "internal error", "method", method, "path", path, "error", err)
+
+	Abort(c, http.StatusInternalServerError, CodeInternal, "internal server error, report request_id to the administrator")
 }
@@ -1,16 +1,101 @@
 package ginerr
 
 import (
+	"backend/pkg/requestid"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
-func TestBuild(t *testing.T) {
-	if !reflect.DeepEqual(Build(""), ErrorResp{Status: "error", Message: ""}) {
-		t.Error("Build() failed on empty string")
+func TestNew(t *testing.T) {
+	want := Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "client not found", Code: "client_not_found"}
+	if got := New(404, "client_not_found", "client not found"); !reflect.DeepEqual(got, want) {
+		t.Errorf("New() = %v, want %v", got, want)
 	}
+}
+
+func TestInvalid(t *testing.T) {
+	v := Violation{Field: "client_id", Code: "uuid", Message: "must be a valid UUID"}
+	got := Invalid(v)
+	if got.Status != 400 || got.Code != CodeValidationFailed || !reflect.DeepEqual(got.Errors, []Violation{v}) {
+		t.Errorf("Invalid() = %v", got)
+	}
+}
+
+func TestHandle500(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req := httptest.NewRequest("GET", "/", nil)
+	c.Request = req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+
+	Handle500(c, errors.New("open destination file: /mnt/media/secret.png"))
+
+	if w.Code != 500 {
+		t.Errorf("Handle500() status = %v, want 500", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Handle500() Content-Type = %v, want %v", got, ContentType)
+	}
+	var body Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != CodeInternal || body.Status != 500 {
+		t.Errorf("Handle500() body = %s (%v)", w.Body.String(), err)
+	}
+	if body.RequestId != "req-1" {
+		t.Errorf("Handle500() request_id = %v, want req-1", body.RequestId)
+	}
+	if strings.Contains(w.Body.String(), "/mnt/media") {
+		t.Errorf("Handle500() leaks the error: %s", w.Body.String())
+	}
+	if !c.IsAborted() || len(c.Errors) != 1 {
+		t.Errorf("Handle500() must abort and attach the error")
+	}
+}
+
+func TestHandle500_ContextDone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	tests := []struct {
+		name       string
+		err        error
+		cancel     bool
+		wantStatus int
+	}{
+		{"deadline exceeded", fmt.Errorf("get ads: %w", context.DeadlineExceeded), false, 504},
+		{"client has gone", errors.New("pq: canceling statement due to user request"), true, StatusClientClosedRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			} else {
+				defer cancel()
+			}
+			c.Request = httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+
+			Handle500(c, tt.err)
 
-	if !reflect.DeepEqual(Build("not found"), ErrorResp{Status: "error", Message: "not found"}) {
-		t.Error("Build() failed on not found")
+			if c.Writer.Status() != tt.wantStatus || !c.IsAborted() {
+				t.Errorf("Handle500() status = %v, want %v", c.Writer.Status(), tt.wantStatus)
+			}
+			if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), tt.err.Error()) {
+				t.Errorf("Handle500() logged %q, want a warning with the error", logs.String())
+			}
+		})
 	}
 }
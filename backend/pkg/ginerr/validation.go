@@ -0,0 +1,212 @@
+package ginerr
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ElementError is an error of validation of a single element in the slice.
+type ElementError struct {
+	Index int
+	Err   error
+}
+
+func (e ElementError) Error() string {
+	return fmt.Sprintf("[%d]: %s", e.Index, e.Err)
+}
+
+func (e ElementError) Unwrap() error {
+	return e.Err
+}
+
+// structValidator is the default gin validator which keeps indices of invalid slice elements,
+// so they can be reported in Violation.Field.
+type structValidator struct {
+	binding.StructValidator
+}
+
+func (v structValidator) ValidateStruct(obj any) error {
+	value := reflect.ValueOf(obj)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return v.StructValidator.ValidateStruct(obj)
+	}
+
+	errs := make(binding.SliceValidationError, 0)
+	for i := 0; i < value.Len(); i++ {
+		if err := v.ValidateStruct(value.Index(i).Interface()); err != nil {
+			errs = append(errs, ElementError{Index: i, Err: err})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ConfigureBinding tunes the gin validator so that validation errors refer to fields by their names
+// in JSON body (or in query string), and slice elements keep their indices. It should be called once on startup.
+func ConfigureBinding() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(fieldName)
+	}
+	if _, ok := binding.Validator.(structValidator); !ok {
+		binding.Validator = structValidator{binding.Validator}
+	}
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// Violations converts an error returned by c.ShouldBind* methods into a list of violations.
+func Violations(err error) []Violation {
+	var sliceErr binding.SliceValidationError
+	if errors.As(err, &sliceErr) {
+		violations := make([]Violation, 0, len(sliceErr))
+		for _, elemErr := range sliceErr {
+			prefix := ""
+			var indexed ElementError
+			if errors.As(elemErr, &indexed) {
+				prefix = "[" + strconv.Itoa(indexed.Index) + "]"
+			}
+			for _, v := range Violations(elemErr) {
+				v.Field = joinPath(prefix, v.Field)
+				violations = append(violations, v)
+			}
+		}
+		return violations
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		violations := make([]Violation, len(validationErrs))
+		for i, fe := range validationErrs {
+			violations[i] = Violation{
+				Field:   fieldPath(fe),
+				Code:    fe.Tag(),
+				Message: ruleMessage(fe),
+			}
+		}
+		return violations
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []Violation{{
+			Field:   indexPath(typeErr.Field),
+			Code:    "invalid_type",
+			Message: "must be of type " + jsonType(typeErr.Type),
+		}}
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return []Violation{{Code: "invalid_json", Message: "request body must be a valid JSON"}}
+	}
+
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return []Violation{{Code: "invalid_type", Message: fmt.Sprintf("%q is not a valid number", numErr.Num)}}
+	}
+
+	if errors.Is(err, http.ErrMissingFile) {
+		return []Violation{{Code: "required", Message: "file is required"}}
+	}
+
+	return []Violation{{Code: "invalid", Message: err.Error()}}
+}
+
+// fieldPath returns path to the field, omitting the name of the root struct.
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
+
+// indexPath converts path from encoding/json errors (like "1.client_id") to the format of validation errors ("[1].client_id").
+func indexPath(path string) string {
+	res := ""
+	for i, part := range strings.Split(path, ".") {
+		switch {
+		case isIndex(part):
+			res += "[" + part + "]"
+		case i == 0:
+			res = part
+		default:
+			res = joinPath(res, part)
+		}
+	}
+	return res
+}
+
+func isIndex(part string) bool {
+	_, err := strconv.Atoi(part)
+	return err == nil
+}
+
+func joinPath(prefix, path string) string {
+	if path == "" || strings.HasPrefix(path, "[") {
+		return prefix + path
+	}
+	return prefix + "." + path
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	case "lte":
+		return "must be less than or equal to " + fe.Param()
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "uuid":
+		return "must be a valid UUID"
+	default:
+		return "failed on rule " + fe.Tag()
+	}
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+func jsonType(t reflect.Type) string {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
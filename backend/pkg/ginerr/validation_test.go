@@ -0,0 +1,87 @@
+package ginerr
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testTargeting struct {
+	Gender string `json:"gender" binding:"omitempty,oneof=MALE FEMALE"`
+}
+
+type testRequest struct {
+	Name          string `json:"name" binding:"required"`
+	Age           int    `json:"age" binding:"gte=0"`
+	testTargeting `json:"targeting"`
+}
+
+func bindJSON(t *testing.T, body string, obj any) error {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+	return c.ShouldBindJSON(obj)
+}
+
+func TestViolations(t *testing.T) {
+	ConfigureBinding()
+
+	type testCase struct {
+		name string
+		body string
+		obj  any
+		want []Violation
+	}
+	tests := []testCase{
+		{
+			"struct fields",
+			`{"age": -1, "targeting": {"gender": "X"}}`,
+			&testRequest{},
+			[]Violation{
+				{Field: "name", Code: "required", Message: "is required"},
+				{Field: "age", Code: "gte", Message: "must be greater than or equal to 0"},
+				{Field: "targeting.gender", Code: "oneof", Message: "must be one of: MALE FEMALE"},
+			},
+		},
+		{
+			"slice elements keep indices",
+			`[{"name": "a"}, {"name": ""}, {"name": "b", "age": -5}]`,
+			&[]testRequest{},
+			[]Violation{
+				{Field: "[1].name", Code: "required", Message: "is required"},
+				{Field: "[2].age", Code: "gte", Message: "must be greater than or equal to 0"},
+			},
+		},
+		{
+			"wrong type",
+			`{"name": "a", "targeting": {"gender": 42}}`,
+			&testRequest{},
+			[]Violation{{Field: "targeting.gender", Code: "invalid_type", Message: "must be of type string"}},
+		},
+		{
+			"wrong type in slice element",
+			`[{"name": "a"}, {"name": 42}]`,
+			&[]testRequest{},
+			[]Violation{{Field: "[1].name", Code: "invalid_type", Message: "must be of type string"}},
+		},
+		{
+			"malformed json",
+			`{"name": `,
+			&testRequest{},
+			[]Violation{{Code: "invalid_json", Message: "request body must be a valid JSON"}},
+		},
+	}
+	for _, tt := range tests {
+		err := bindJSON(t, tt.body, tt.obj)
+		if err == nil {
+			t.Errorf("%s: expected binding error", tt.name)
+			continue
+		}
+		if got := Violations(err); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Violations() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
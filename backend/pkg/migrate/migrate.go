@@ -0,0 +1,285 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// ErrDatabaseAhead is returned when the database has migrations applied which are unknown to the binary,
+// which means that the binary is older than the database schema.
+var ErrDatabaseAhead = errors.New("database schema is ahead of the binary")
+
+// ErrDatabaseBehind is returned by Check when some migrations are not applied yet.
+var ErrDatabaseBehind = errors.New("database schema is behind the binary")
+
+// lockId is a key of PostgreSQL advisory lock, which prevents concurrent migrations by several instances.
+const lockId = 7_202_501
+
+const createTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+)`
+
+// Migration is a single versioned change of the database schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads migrations from files named like "0001_init.up.sql" and "0001_init.down.sql" in the root of fsys.
+// Every migration must have both up and down files, and versions must start from 1 without gaps.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations dir: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNameRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, match[2])
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies migrations to PostgreSQL database and records them in schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	legacyTable string
+}
+
+// New creates Migrator with migrations loaded from fsys (see Load).
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// SetLegacyTable makes Migrator treat the first migration as applied, if the database has no
+// schema_migrations table but has legacyTable. It is used for databases created before migrations were introduced.
+func (m *Migrator) SetLegacyTable(legacyTable string) {
+	m.legacyTable = legacyTable
+}
+
+// Latest returns the version of the last known migration.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Current returns the version of the last applied migration, or 0 if nothing is applied.
+func (m *Migrator) Current(ctx context.Context) (version int, err error) {
+	err = m.withLock(ctx, func(conn *sql.Conn) (err error) {
+		version, err = current(ctx, conn)
+		return
+	})
+	return
+}
+
+// Check returns ErrDatabaseAhead or ErrDatabaseBehind if the database schema version differs from Latest.
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version > m.Latest():
+		return fmt.Errorf("%w: database version is %d, latest known is %d", ErrDatabaseAhead, version, m.Latest())
+	case version < m.Latest():
+		return fmt.Errorf("%w: database version is %d, latest known is %d", ErrDatabaseBehind, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies all pending migrations. Returns the list of applied ones.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the given number of last applied migrations. Returns the list of reverted ones.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	version, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.To(ctx, max(version-steps, 0))
+}
+
+// To migrates the database up or down to the given version. Each migration runs in its own transaction.
+// Returns the list of applied or reverted migrations, in order of execution.
+func (m *Migrator) To(ctx context.Context, target int) ([]Migration, error) {
+	if target < 0 || target > m.Latest() {
+		return nil, fmt.Errorf("unknown version %d, latest known is %d", target, m.Latest())
+	}
+
+	done := make([]Migration, 0)
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := current(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: database version is %d, latest known is %d", ErrDatabaseAhead, version, m.Latest())
+		}
+
+		for version < target {
+			migration := m.migrations[version]
+			err := execInTx(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+			version++
+		}
+
+		for version > target {
+			migration := m.migrations[version-1]
+			err := execInTx(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("revert migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+			version--
+		}
+		return nil
+	})
+	return done, err
+}
+
+// withLock runs f on a dedicated connection holding the advisory lock, after ensuring schema_migrations exists.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockId); err != nil {
+		return fmt.Errorf("acquire migrations lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockId)
+	}()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	var exists, legacy bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check schema_migrations: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	if m.legacyTable != "" && len(m.migrations) > 0 {
+		err = conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, m.legacyTable).Scan(&legacy)
+		if err != nil {
+			return fmt.Errorf("check legacy table: %w", err)
+		}
+	}
+
+	if !legacy {
+		_, err = conn.ExecContext(ctx, createTableQuery)
+		if err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+		return nil
+	}
+
+	first := m.migrations[0]
+	err = execInTx(ctx, conn, createTableQuery,
+		`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, first.Version, first.Name)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations for legacy database: %w", err)
+	}
+	return nil
+}
+
+func current(ctx context.Context, conn *sql.Conn) (version int, err error) {
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		err = fmt.Errorf("get schema version: %w", err)
+	}
+	return
+}
+
+// execInTx executes script and then recordQuery with args in a single transaction.
+func execInTx(ctx context.Context, conn *sql.Conn, script, recordQuery string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, recordQuery, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content)}
+	}
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0002_second.up.sql":   file("up 2"),
+				"0002_second.down.sql": file("down 2"),
+				"0001_init.up.sql":     file("up 1"),
+				"0001_init.down.sql":   file("down 1"),
+				"README.md":            file("ignored"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "init", Up: "up 1", Down: "down 1"},
+				{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+			},
+		},
+		{
+			name: "empty",
+			fsys: fstest.MapFS{},
+			want: []Migration{},
+		},
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql": file("up 1"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "gap in versions",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":    file("up 1"),
+				"0001_init.down.sql":  file("down 1"),
+				"0003_third.up.sql":   file("up 3"),
+				"0003_third.down.sql": file("down 3"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "different names",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":    file("up 1"),
+				"0001_other.down.sql": file("down 1"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package requestid
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// Header is the name of HTTP header used to pass request ID between services.
+const Header = "X-Request-ID"
+
+// maxLength limits the length of request IDs accepted from the outside.
+const maxLength = 128
+
+type contextKey struct{}
+
+// New generates a new random request ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether id can be used as a request ID: it must be non-empty, not longer
+// than 128 characters, and consist only of letters, digits, '-', '_' and '.'.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext extracts the request ID from ctx. It returns an empty string if ctx carries no ID.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,41 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	type testCase struct {
+		id   string
+		want bool
+	}
+	tests := []testCase{
+		{"", false},
+		{"7f0e5d3a-45e1-4b8e-9b8e-1d2c3b4a5f60", true},
+		{"abc_DEF.123", true},
+		{"with space", false},
+		{"new\nline", false},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+	if id := New(); !Valid(id) {
+		t.Errorf("Valid(New()) = false, want true")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != "" {
+		t.Errorf("FromContext() = %q, want empty string", got)
+	}
+	ctx := NewContext(context.Background(), "req-1")
+	if got := FromContext(ctx); got != "req-1" {
+		t.Errorf("FromContext() = %q, want req-1", got)
+	}
+}
@@ -0,0 +1,37 @@
+package tabular
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Write(row []any) error {
+	w.record = w.record[:0]
+	for _, v := range row {
+		c, err := toCell(v)
+		if err != nil {
+			return err
+		}
+		if !c.numeric && strings.ContainsAny(c.value[:min(len(c.value), 1)], "=+-@\t\r") {
+			// spreadsheets would evaluate such a string as a formula
+			c.value = "'" + c.value
+		}
+		w.record = append(w.record, c.value)
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,87 @@
+// Package tabular writes rows of cells as CSV or XLSX, streaming them to the underlying writer
+// without keeping previous rows in memory.
+package tabular
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+func (f Format) ContentType() string {
+	switch f {
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// Writer writes rows of cells. Supported cell types are strings, integers, floats, booleans, time.Time,
+// fmt.Stringer and pointers to them; nil pointers are written as empty cells.
+// Close must be called after the last row, it does not close the underlying writer.
+type Writer interface {
+	Write(row []any) error
+	Close() error
+}
+
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w), nil
+	case XLSX:
+		return newXLSXWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// cell is a value normalized for writing: either a string, or a number if numeric is true.
+type cell struct {
+	value   string
+	numeric bool
+}
+
+func toCell(v any) (cell, error) {
+	switch v := v.(type) {
+	case nil:
+		return cell{}, nil
+	case string:
+		return cell{value: v}, nil
+	case int:
+		return cell{value: strconv.Itoa(v), numeric: true}, nil
+	case int64:
+		return cell{value: strconv.FormatInt(v, 10), numeric: true}, nil
+	case float64:
+		return cell{value: strconv.FormatFloat(v, 'f', -1, 64), numeric: true}, nil
+	case bool:
+		return cell{value: strconv.FormatBool(v)}, nil
+	case time.Time:
+		return cell{value: v.Format(time.RFC3339)}, nil
+	case *string:
+		return derefCell(v)
+	case *int:
+		return derefCell(v)
+	case *float64:
+		return derefCell(v)
+	case fmt.Stringer:
+		return cell{value: v.String()}, nil
+	default:
+		return cell{}, fmt.Errorf("unsupported cell type %T", v)
+	}
+}
+
+func derefCell[T any](v *T) (cell, error) {
+	if v == nil {
+		return cell{}, nil
+	}
+	return toCell(*v)
+}
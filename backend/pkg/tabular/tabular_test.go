@@ -0,0 +1,82 @@
+package tabular
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVWriter(t *testing.T) {
+	id := uuid.MustParse("7d4f0b7a-33f1-4b7b-9a0e-6b1f3c1f2a10")
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, CSV)
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]any{"id", "title", "count", "limit", "conversion"}))
+	require.NoError(t, w.Write([]any{id, `say "hi", world`, 3, (*int)(nil), 12.5}))
+	require.NoError(t, w.Write([]any{id, "=1+1", -1, ptr(4), 0.0}))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, "id,title,count,limit,conversion\n"+
+		id.String()+`,"say ""hi"", world",3,,12.5`+"\n"+
+		id.String()+",'=1+1,-1,4,0\n", buf.String())
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, XLSX)
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]any{"title", "count"}))
+	require.NoError(t, w.Write([]any{"a < b & c", 42, nil}))
+	require.NoError(t, w.Close())
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	var names []string
+	var sheet []byte
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			sheet, err = io.ReadAll(rc)
+			require.NoError(t, err)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml",
+	}, names)
+
+	var parsed struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	require.NoError(t, xml.Unmarshal(sheet, &parsed))
+	require.Len(t, parsed.Rows, 2)
+	require.Len(t, parsed.Rows[1].Cells, 2)
+	assert.Equal(t, "A2", parsed.Rows[1].Cells[0].Ref)
+	assert.Equal(t, "a < b & c", parsed.Rows[1].Cells[0].Inline)
+	assert.Equal(t, "B2", parsed.Rows[1].Cells[1].Ref)
+	assert.Equal(t, "42", parsed.Rows[1].Cells[1].Value)
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, columnName(i), "column %d", i)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
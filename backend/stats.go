@@ -0,0 +1,28 @@
+package main
+
+import (
+	"backend/internal/repo"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const statsUsage = `usage: application stats [command]
+
+commands:
+  rebuild      recompute pre-aggregated daily stats from recorded impressions and clicks`
+
+// runStatsCommand implements `stats` subcommand.
+func runStatsCommand(ctx context.Context, stats repo.Stats, args []string) error {
+	if len(args) != 1 || args[0] != "rebuild" {
+		return errors.New(statsUsage)
+	}
+
+	start := time.Now()
+	if err := stats.Rebuild(ctx); err != nil {
+		return err
+	}
+	slog.Info("stats rebuilt", "duration", time.Since(start).String())
+	return nil
+}
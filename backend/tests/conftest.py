@@ -2,8 +2,36 @@ import logging
 from collections.abc import MutableMapping
 from typing import Any
 
+import pytest
+import requests
+
+BASE_URL = "http://localhost:8080"
+
+
+@pytest.fixture(scope="session", autouse=True)
+def sandbox():
+    # The tests move the date backwards, which is allowed only in the sandbox.
+    # Discarding it afterwards also removes all the data created by the tests.
+    # A sandbox started by someone else is left as is, so its snapshot is not lost.
+    resp = requests.post(f"{BASE_URL}/time/sandbox")
+    assert resp.status_code in (200, 409), resp.text
+    started = resp.status_code == 200
+
+    yield
+
+    if started:
+        resp = requests.delete(f"{BASE_URL}/time/sandbox")
+        assert resp.status_code == 200, resp.text
+
 
 def pytest_tavern_beta_before_every_request(request_args: MutableMapping):
+    # Campaign modifications require If-Match header. Most of the tests don't care
+    # about concurrent updates, so the check is skipped unless the test sets the header itself.
+    if request_args["method"] in ("PUT", "DELETE") and "/campaigns/" in request_args["url"]:
+        headers = request_args.setdefault("headers", {})
+        if not any(key.lower() == "if-match" for key in headers):
+            headers["If-Match"] = "*"
+
     message = f"Request: {request_args['method']} {request_args['url']}"
 
     params = request_args.get("params", None)
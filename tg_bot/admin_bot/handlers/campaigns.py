@@ -1,4 +1,5 @@
 import operator
+from http.client import PRECONDITION_FAILED
 from typing import Any, cast
 
 from aiogram import F, types
@@ -11,9 +12,10 @@ from aiogram_dialog.widgets.text import Const, Format, Jinja
 from admin_bot.misc import BACK, BACK_CANCEL, CANCEL
 from admin_bot.states import CampaignsSG, StatsSG
 from admin_bot.widgets.start_same_data import StartWithSameData
-from ads_api import AdvertiserApiClient
+from ads_api import AdvertiserApiClient, AdvertiserApiError
 
 AD_TEXT_SHOW_LIMIT = 64
+STALE_CAMPAIGN_MESSAGE = "Кампания была изменена, проверьте её и повторите."
 
 
 async def campaigns_getter(api: AdvertiserApiClient, **_: Any) -> dict[str, Any]:
@@ -26,8 +28,10 @@ async def campaign_getter(
     api: AdvertiserApiClient, dialog_manager: DialogManager, **_: Any
 ) -> dict[str, Any]:
     campaign_id = dialog_manager.start_data["campaign_id"]
+    campaign = await api.get_campaign_by_id(campaign_id)
+    dialog_manager.dialog_data["etag"] = campaign.etag
     return {
-        "campaign": await api.get_campaign_by_id(campaign_id),
+        "campaign": campaign,
         "current_date": await api.get_date(),
     }
 
@@ -43,7 +47,14 @@ async def confirm_delete_callback(
 ) -> None:
     campaign_id = manager.start_data["campaign_id"]
     api = cast(AdvertiserApiClient, manager.middleware_data["api"])
-    await api.delete_campaign(campaign_id)
+    try:
+        await api.delete_campaign(campaign_id, manager.dialog_data["etag"])
+    except AdvertiserApiError as e:
+        if e.status_code != PRECONDITION_FAILED:
+            raise
+        await call.answer("Кампания была изменена, проверьте её и повторите удаление.")
+        await manager.switch_to(CampaignsSG.show)
+        return
     await call.answer("Удалено.")
     await manager.done()
 
@@ -59,9 +70,20 @@ async def edit_field_handler(
         return
 
     api = cast(AdvertiserApiClient, manager.middleware_data["api"])
+    # the version shown to the admin, so that newer changes are not overwritten
+    etag = manager.start_data.get("etag")
+    if etag is None:
+        await message.answer(STALE_CAMPAIGN_MESSAGE)
+        await manager.done()
+        return
     campaign = await api.get_campaign_by_id(manager.start_data["campaign_id"])
     setattr(campaign, widget.widget_id, value)
-    await api.update_campaign(manager.start_data["campaign_id"], campaign)
+    try:
+        await api.update_campaign(manager.start_data["campaign_id"], campaign, etag)
+    except AdvertiserApiError as e:
+        if e.status_code != PRECONDITION_FAILED:
+            raise
+        await message.answer(STALE_CAMPAIGN_MESSAGE)
     await manager.done()
 
 
@@ -116,20 +138,30 @@ ID: <code>{{ campaign.campaign_id }}</code>
 """),
         Row(
             StartWithSameData(
-                Const("✏️ Название"), "edit_title", CampaignsSG.edit_title
+                Const("✏️ Название"),
+                "edit_title",
+                CampaignsSG.edit_title,
+                dialog_data_keys=["etag"],
+            ),
+            StartWithSameData(
+                Const("✏️ Текст"),
+                "edit_text",
+                CampaignsSG.edit_text,
+                dialog_data_keys=["etag"],
             ),
-            StartWithSameData(Const("✏️ Текст"), "edit_text", CampaignsSG.edit_text),
         ),
         Row(
             StartWithSameData(
                 Const("✏️ Цена просмотра"),
                 "edit_view_cost",
                 CampaignsSG.edit_view_cost,
+                dialog_data_keys=["etag"],
             ),
             StartWithSameData(
                 Const("✏️ Цена клика"),
                 "edit_click_cost",
                 CampaignsSG.edit_click_cost,
+                dialog_data_keys=["etag"],
             ),
         ),
         Row(
@@ -137,11 +169,13 @@ ID: <code>{{ campaign.campaign_id }}</code>
                 Const("✏️ Лимит просмотров"),
                 "edit_view_limit",
                 CampaignsSG.edit_view_limit,
+                dialog_data_keys=["etag"],
             ),
             StartWithSameData(
                 Const("✏️ Лимит кликов"),
                 "edit_click_limit",
                 CampaignsSG.edit_click_limit,
+                dialog_data_keys=["etag"],
             ),
             when=F["current_date"] < F["campaign"].start_date,
         ),
@@ -1,3 +1,4 @@
+from http import HTTPStatus
 from typing import Any, cast
 
 from aiogram import types
@@ -8,7 +9,7 @@ from aiogram_dialog.widgets.widget_event import ensure_event_processor
 
 from admin_bot.misc import CANCEL
 from admin_bot.states import ChangeDateSG
-from ads_api import AdvertiserApiClient
+from ads_api import AdvertiserApiClient, AdvertiserApiError
 
 
 @ensure_event_processor
@@ -19,7 +20,14 @@ async def date_handler(
     date: int,
 ) -> None:
     api = cast(AdvertiserApiClient, dialog_manager.middleware_data["api"])
-    await api.update_date(date)
+    try:
+        await api.update_date(date)
+    except AdvertiserApiError as e:
+        if e.status_code != HTTPStatus.CONFLICT:
+            raise
+        # the date can't be moved backwards outside of the sandbox
+        await message.answer("Дату нельзя сдвинуть назад. Введите дату не раньше текущей:")
+        return
     await message.answer("Обновлено.")
     await dialog_manager.done()
 
@@ -1,3 +1,6 @@
+from collections.abc import Sequence
+from typing import Any
+
 from aiogram.types import CallbackQuery
 from aiogram_dialog import DialogManager
 from aiogram_dialog.widgets.kbd import Button, Start
@@ -5,9 +8,16 @@ from aiogram_dialog.widgets.kbd import Button, Start
 
 class StartWithSameData(Start):
     """
-    Acts like Start, but copies start_data from the previous dialog.
+    Acts like Start, but copies start_data from the previous dialog,
+    and also the values of dialog_data_keys from its dialog_data.
     """
 
+    def __init__(
+        self, *args: Any, dialog_data_keys: Sequence[str] = (), **kwargs: Any
+    ) -> None:
+        super().__init__(*args, **kwargs)
+        self.dialog_data_keys = dialog_data_keys
+
     async def _on_click(
         self,
         callback: CallbackQuery,
@@ -18,4 +28,6 @@ class StartWithSameData(Start):
             await self.user_on_click(callback, self, manager)
 
         start_data = (manager.start_data or {}) | (self.start_data or {})
+        for key in self.dialog_data_keys:
+            start_data[key] = manager.dialog_data.get(key)
         await manager.start(self.state, start_data, self.mode)
@@ -2,6 +2,7 @@ import asyncio
 import json
 import typing
 import uuid
+from collections.abc import Mapping
 from http.client import BAD_REQUEST, NETWORK_AUTHENTICATION_REQUIRED
 from typing import Any
 
@@ -31,20 +32,31 @@ class AdvertiserApiClient:
     async def request(
         self, method: str, url: str, **kwargs: Any
     ) -> dict[str, Any] | str:
+        data, _ = await self.request_with_headers(method, url, **kwargs)
+        return data
+
+    async def request_with_headers(
+        self, method: str, url: str, **kwargs: Any
+    ) -> tuple[Any, Mapping[str, str]]:
         url = url.lstrip("/")
         async with self.session.request(method, url, **kwargs) as response:
             if BAD_REQUEST <= response.status <= NETWORK_AUTHENTICATION_REQUIRED:
                 try:
-                    data = await response.json()
-                    message = data["message"]
-                except (aiohttp.ContentTypeError, json.JSONDecodeError):
+                    # errors are returned in application/problem+json format (RFC 7807)
+                    data = await response.json(content_type=None)
+                    message = data.get("detail") or data["title"]
+                    for violation in data.get("errors", []):
+                        message += f"\n{violation['field']}: {violation['message']}"
+                    if "request_id" in data:
+                        message += f"\nrequest_id: {data['request_id']}"
+                except (aiohttp.ContentTypeError, json.JSONDecodeError, KeyError):
                     message = await response.text()
                 raise AdvertiserApiError(response.status, message)
 
             if response.content_type == "application/json":
-                return await response.json()
+                return await response.json(), response.headers
 
-            return await response.text()
+            return await response.text(), response.headers
 
     async def get(self, url: str, **params: Any) -> Any:
         return await self.request("GET", url, params=params)
@@ -52,11 +64,8 @@ class AdvertiserApiClient:
     async def post(self, url: str, data: Any) -> dict[str, Any]:
         return await self.request("POST", url, json=data)
 
-    async def put(self, url: str, data: Any) -> dict[str, Any]:
-        return await self.request("PUT", url, json=data)
-
-    async def delete(self, url: str) -> None:
-        await self.request("DELETE", url)
+    async def delete(self, url: str, etag: str) -> None:
+        await self.request("DELETE", url, headers={"If-Match": etag})
 
     async def get_advertiser(self) -> models.Advertiser:
         result = await self.get(f"/advertisers/{self.advertiser_id}")
@@ -88,22 +97,28 @@ class AdvertiserApiClient:
         return models.Campaign(**result)
 
     async def get_campaign_by_id(self, campaign_id: uuid.UUID) -> models.Campaign:
-        result = await self.get(
-            f"/advertisers/{self.advertiser_id}/campaigns/{campaign_id}"
+        result, headers = await self.request_with_headers(
+            "GET", f"/advertisers/{self.advertiser_id}/campaigns/{campaign_id}"
         )
-        return models.Campaign(**result)
+        return models.Campaign(**result, etag=headers.get("ETag"))
 
     async def update_campaign(
-        self, campaign_id: uuid.UUID, data: models.CampaignEditable
+        self, campaign_id: uuid.UUID, data: models.CampaignEditable, etag: str
     ) -> models.Campaign:
-        result = await self.put(
+        """Update the campaign. etag must be taken from get_campaign_by_id,
+        otherwise AdvertiserApiError with 412 status code is raised."""
+        result, headers = await self.request_with_headers(
+            "PUT",
             f"/advertisers/{self.advertiser_id}/campaigns/{campaign_id}",
-            data.model_dump(mode="json"),
+            json=data.model_dump(mode="json"),
+            headers={"If-Match": etag},
         )
-        return models.Campaign(**result)
+        return models.Campaign(**result, etag=headers.get("ETag"))
 
-    async def delete_campaign(self, campaign_id: str) -> None:
-        await self.delete(f"/advertisers/{self.advertiser_id}/campaigns/{campaign_id}")
+    async def delete_campaign(self, campaign_id: str, etag: str) -> None:
+        await self.delete(
+            f"/advertisers/{self.advertiser_id}/campaigns/{campaign_id}", etag
+        )
 
     async def ai_suggest_text(
         self, ad_title: str, comment: str | None = None
@@ -57,6 +57,8 @@ class Campaign(CampaignEditable):
     advertiser_id: str
     image_path: str
     moderation_result: CampaignModerationResult | None
+    # Version of the campaign from ETag header, required for updates
+    etag: str | None = Field(default=None, exclude=True)
 
     @property
     def ad_title_with_exclamation(self) -> str: